@@ -0,0 +1,491 @@
+package client
+
+import (
+	"context"
+	"net/url"
+
+	"github.com/RackHD/voyager-houston/model"
+)
+
+// Info returns the service information from GET /info
+func (c *Client) Info(ctx context.Context) (model.Houston, error) {
+	info := model.Houston{}
+	err := c.do(ctx, "GET", "/info", nil, nil, &info)
+	return info, err
+}
+
+// WhoAmI returns the identity Houston authenticated the client as
+func (c *Client) WhoAmI(ctx context.Context) (model.Identity, error) {
+	identity := model.Identity{}
+	err := c.do(ctx, "GET", "/whoami", nil, nil, &identity)
+	return identity, err
+}
+
+// Nodes lists the nodes known to the inventory service
+func (c *Client) Nodes(ctx context.Context) ([]model.Node, error) {
+	return c.SelectNodes(ctx, NodeFilter{})
+}
+
+// NodeFilter selects the nodes listed by SelectNodes. Empty fields match any node.
+type NodeFilter struct {
+	// Selector is a label selector such as "env=prod,role!=gpu"
+	Selector string
+	Site     string
+	Room     string
+	Rack     string
+}
+
+// SelectNodes lists the nodes matching filter
+func (c *Client) SelectNodes(ctx context.Context, filter NodeFilter) ([]model.Node, error) {
+	query := url.Values{}
+	for name, value := range map[string]string{"labelSelector": filter.Selector, "site": filter.Site, "room": filter.Room, "rack": filter.Rack} {
+		if value != "" {
+			query.Set(name, value)
+		}
+	}
+
+	nodes := []model.Node{}
+	err := c.do(ctx, "GET", "/nodes", query, nil, &nodes)
+	return nodes, err
+}
+
+// Labels returns the labels Houston stores on a node
+func (c *Client) Labels(ctx context.Context, node string) (model.Labels, error) {
+	labels := model.Labels{}
+	err := c.do(ctx, "GET", "/nodes/"+url.PathEscape(node)+"/labels", nil, nil, &labels)
+	return labels, err
+}
+
+// SetLabels replaces the labels Houston stores on a node
+func (c *Client) SetLabels(ctx context.Context, node string, labels model.Labels) (model.Labels, error) {
+	result := model.Labels{}
+	err := c.do(ctx, "PUT", "/nodes/"+url.PathEscape(node)+"/labels", nil, labels, &result)
+	return result, err
+}
+
+// PatchLabels sets the labels of a node that patch gives a value, and removes those set to nil
+func (c *Client) PatchLabels(ctx context.Context, node string, patch model.LabelsPatch) (model.Labels, error) {
+	result := model.Labels{}
+	err := c.do(ctx, "PATCH", "/nodes/"+url.PathEscape(node)+"/labels", nil, patch, &result)
+	return result, err
+}
+
+// DeleteLabels removes the given labels from a node, or all of them if keys is empty
+func (c *Client) DeleteLabels(ctx context.Context, node string, keys ...string) error {
+	query := url.Values{}
+	for _, key := range keys {
+		query.Add("key", key)
+	}
+	return c.do(ctx, "DELETE", "/nodes/"+url.PathEscape(node)+"/labels", query, nil, nil)
+}
+
+// Placement returns where a node is placed in a rack
+func (c *Client) Placement(ctx context.Context, node string) (model.PlacementEntity, error) {
+	placement := model.PlacementEntity{}
+	err := c.do(ctx, "GET", "/nodes/"+url.PathEscape(node)+"/placement", nil, nil, &placement)
+	return placement, err
+}
+
+// PlaceNode puts a node in a rack, or moves it. It fails with a 409 if
+// another node takes up any of the units.
+func (c *Client) PlaceNode(ctx context.Context, node string, request model.PlacementRequest) (model.PlacementEntity, error) {
+	placement := model.PlacementEntity{}
+	err := c.do(ctx, "PUT", "/nodes/"+url.PathEscape(node)+"/placement", nil, request, &placement)
+	return placement, err
+}
+
+// UnplaceNode takes a node out of its rack
+func (c *Client) UnplaceNode(ctx context.Context, node string) error {
+	return c.do(ctx, "DELETE", "/nodes/"+url.PathEscape(node)+"/placement", nil, nil, nil)
+}
+
+// PowerState reads the power state of a node from its BMC
+func (c *Client) PowerState(ctx context.Context, node string) (model.PowerResult, error) {
+	result := model.PowerResult{}
+	err := c.do(ctx, "GET", "/nodes/"+url.PathEscape(node)+"/power", nil, nil, &result)
+	return result, err
+}
+
+// Power runs a power action (on, off, reset or cycle) on a node
+func (c *Client) Power(ctx context.Context, node, action string) (model.PowerResult, error) {
+	result := model.PowerResult{}
+	err := c.do(ctx, "POST", "/nodes/"+url.PathEscape(node)+"/power", nil, model.PowerRequest{Action: action}, &result)
+	return result, err
+}
+
+// SetBootDevice sets the device (pxe, disk or bios) a node boots from, next time only unless persistent
+func (c *Client) SetBootDevice(ctx context.Context, node, device string, persistent bool) (model.BootDeviceResult, error) {
+	result := model.BootDeviceResult{}
+	request := model.BootDeviceRequest{Device: device, Persistent: persistent}
+	err := c.do(ctx, "POST", "/nodes/"+url.PathEscape(node)+"/boot-device", nil, request, &result)
+	return result, err
+}
+
+// NodeActions starts a job running an action on the nodes the request
+// selects. The job's result lists the outcome on each node.
+func (c *Client) NodeActions(ctx context.Context, request model.NodeActionsRequest) (model.JobEntity, error) {
+	job := model.JobEntity{}
+	err := c.do(ctx, "POST", "/node-actions", nil, request, &job)
+	return job, err
+}
+
+// Workflows lists the workflows run on a node, newest first, only those with
+// status unless it is empty
+func (c *Client) Workflows(ctx context.Context, node, status string) ([]model.WorkflowEntity, error) {
+	query := url.Values{}
+	if status != "" {
+		query.Set("status", status)
+	}
+
+	workflows := []model.WorkflowEntity{}
+	err := c.do(ctx, "GET", "/nodes/"+url.PathEscape(node)+"/workflows", query, nil, &workflows)
+	return workflows, err
+}
+
+// RunWorkflow runs a RackHD workflow on a node. It fails with a 409 if the node already runs one.
+func (c *Client) RunWorkflow(ctx context.Context, node string, request model.RunWorkflowRequest) (model.WorkflowEntity, error) {
+	workflow := model.WorkflowEntity{}
+	err := c.do(ctx, "POST", "/nodes/"+url.PathEscape(node)+"/workflows", nil, request, &workflow)
+	return workflow, err
+}
+
+// CancelWorkflow cancels the active workflow of a node
+func (c *Client) CancelWorkflow(ctx context.Context, node string) (model.WorkflowEntity, error) {
+	workflow := model.WorkflowEntity{}
+	err := c.do(ctx, "DELETE", "/nodes/"+url.PathEscape(node)+"/workflows/active", nil, nil, &workflow)
+	return workflow, err
+}
+
+// Sites lists the sites
+func (c *Client) Sites(ctx context.Context) ([]model.SiteEntity, error) {
+	sites := []model.SiteEntity{}
+	err := c.do(ctx, "GET", "/sites", nil, nil, &sites)
+	return sites, err
+}
+
+// CreateSite creates a site
+func (c *Client) CreateSite(ctx context.Context, request model.SiteRequest) (model.SiteEntity, error) {
+	site := model.SiteEntity{}
+	err := c.do(ctx, "POST", "/sites", nil, request, &site)
+	return site, err
+}
+
+// Rooms lists the rooms, only those of site unless it is empty
+func (c *Client) Rooms(ctx context.Context, site string) ([]model.RoomEntity, error) {
+	query := url.Values{}
+	if site != "" {
+		query.Set("site", site)
+	}
+
+	rooms := []model.RoomEntity{}
+	err := c.do(ctx, "GET", "/rooms", query, nil, &rooms)
+	return rooms, err
+}
+
+// CreateRoom creates a room in a site
+func (c *Client) CreateRoom(ctx context.Context, request model.RoomRequest) (model.RoomEntity, error) {
+	room := model.RoomEntity{}
+	err := c.do(ctx, "POST", "/rooms", nil, request, &room)
+	return room, err
+}
+
+// Racks lists the racks, only those of room unless it is empty
+func (c *Client) Racks(ctx context.Context, room string) ([]model.RackEntity, error) {
+	query := url.Values{}
+	if room != "" {
+		query.Set("room", room)
+	}
+
+	racks := []model.RackEntity{}
+	err := c.do(ctx, "GET", "/racks", query, nil, &racks)
+	return racks, err
+}
+
+// CreateRack creates a rack in a room
+func (c *Client) CreateRack(ctx context.Context, request model.RackRequest) (model.RackEntity, error) {
+	rack := model.RackEntity{}
+	err := c.do(ctx, "POST", "/racks", nil, request, &rack)
+	return rack, err
+}
+
+// RackPlacements lists the nodes placed in a rack, from the bottom up
+func (c *Client) RackPlacements(ctx context.Context, rack string) ([]model.PlacementEntity, error) {
+	placements := []model.PlacementEntity{}
+	err := c.do(ctx, "GET", "/racks/"+url.PathEscape(rack)+"/placements", nil, nil, &placements)
+	return placements, err
+}
+
+// Switches lists the switches known to inventory
+func (c *Client) Switches(ctx context.Context) ([]model.Switch, error) {
+	switches := []model.Switch{}
+	err := c.do(ctx, "GET", "/switches", nil, nil, &switches)
+	return switches, err
+}
+
+// SwitchPorts lists the ports of a switch
+func (c *Client) SwitchPorts(ctx context.Context, id string) ([]model.SwitchPort, error) {
+	ports := []model.SwitchPort{}
+	err := c.do(ctx, "GET", "/switches/"+url.PathEscape(id)+"/ports", nil, nil, &ports)
+	return ports, err
+}
+
+// PortNode returns the node NIC connected to a switch port
+func (c *Client) PortNode(ctx context.Context, id, port string) (model.ConnectionEntity, error) {
+	connection := model.ConnectionEntity{}
+	err := c.do(ctx, "GET", "/switches/"+url.PathEscape(id)+"/ports/"+url.PathEscape(port)+"/node", nil, nil, &connection)
+	return connection, err
+}
+
+// Connections lists the switch ports a node's NICs are connected to
+func (c *Client) Connections(ctx context.Context, node string) ([]model.ConnectionEntity, error) {
+	connections := []model.ConnectionEntity{}
+	err := c.do(ctx, "GET", "/nodes/"+url.PathEscape(node)+"/connections", nil, nil, &connections)
+	return connections, err
+}
+
+// Connect records that a NIC of a node is connected to a switch port
+func (c *Client) Connect(ctx context.Context, node, nic string, request model.ConnectionRequest) (model.ConnectionEntity, error) {
+	connection := model.ConnectionEntity{}
+	err := c.do(ctx, "PUT", "/nodes/"+url.PathEscape(node)+"/connections/"+url.PathEscape(nic), nil, request, &connection)
+	return connection, err
+}
+
+// Disconnect forgets what a NIC of a node is connected to
+func (c *Client) Disconnect(ctx context.Context, node, nic string) error {
+	return c.do(ctx, "DELETE", "/nodes/"+url.PathEscape(node)+"/connections/"+url.PathEscape(nic), nil, nil, nil)
+}
+
+// Pools lists the IP pools
+func (c *Client) Pools(ctx context.Context) ([]model.Pool, error) {
+	pools := []model.Pool{}
+	err := c.do(ctx, "GET", "/pools", nil, nil, &pools)
+	return pools, err
+}
+
+// Pool returns the IP pool with the given ID
+func (c *Client) Pool(ctx context.Context, id string) (model.Pool, error) {
+	pool := model.Pool{}
+	err := c.do(ctx, "GET", "/pools/"+url.PathEscape(id), nil, nil, &pool)
+	return pool, err
+}
+
+// PoolUsage counts the allocated, reserved and free addresses of a pool's subnets
+func (c *Client) PoolUsage(ctx context.Context, id string) (model.PoolUsage, error) {
+	usage := model.PoolUsage{}
+	err := c.do(ctx, "GET", "/pools/"+url.PathEscape(id)+"/usage", nil, nil, &usage)
+	return usage, err
+}
+
+// CreatePool creates an IP pool and returns its ID
+func (c *Client) CreatePool(ctx context.Context, request model.CreatePoolRequest) (string, error) {
+	created := model.Created{}
+	err := c.do(ctx, "POST", "/pools", nil, request, &created)
+	return created.ID, err
+}
+
+// DeletePool deletes an IP pool. It fails with a 409 if the pool still has subnets.
+func (c *Client) DeletePool(ctx context.Context, id string) error {
+	return c.do(ctx, "DELETE", "/pools/"+url.PathEscape(id), nil, nil, nil)
+}
+
+// Subnets lists the subnets, only those in pool unless it is empty
+func (c *Client) Subnets(ctx context.Context, pool string) ([]model.Subnet, error) {
+	query := url.Values{}
+	if pool != "" {
+		query.Set("pool", pool)
+	}
+
+	subnets := []model.Subnet{}
+	err := c.do(ctx, "GET", "/subnets", query, nil, &subnets)
+	return subnets, err
+}
+
+// Subnet returns the subnet with the given ID
+func (c *Client) Subnet(ctx context.Context, id string) (model.Subnet, error) {
+	subnet := model.Subnet{}
+	err := c.do(ctx, "GET", "/subnets/"+url.PathEscape(id), nil, nil, &subnet)
+	return subnet, err
+}
+
+// SubnetUsage counts the allocated, reserved and free addresses of a subnet
+func (c *Client) SubnetUsage(ctx context.Context, id string) (model.SubnetUsage, error) {
+	usage := model.SubnetUsage{}
+	err := c.do(ctx, "GET", "/subnets/"+url.PathEscape(id)+"/usage", nil, nil, &usage)
+	return usage, err
+}
+
+// CreateSubnet creates a subnet in a pool and returns its ID
+func (c *Client) CreateSubnet(ctx context.Context, request model.CreateSubnetRequest) (string, error) {
+	created := model.Created{}
+	err := c.do(ctx, "POST", "/subnets", nil, request, &created)
+	return created.ID, err
+}
+
+// DeleteSubnet deletes a subnet. It fails with a 409 if addresses are still leased from it.
+func (c *Client) DeleteSubnet(ctx context.Context, id string) error {
+	return c.do(ctx, "DELETE", "/subnets/"+url.PathEscape(id), nil, nil, nil)
+}
+
+// Reservations lists the reserved addresses of a subnet
+func (c *Client) Reservations(ctx context.Context, subnet string) ([]model.ReservationEntity, error) {
+	reservations := []model.ReservationEntity{}
+	err := c.do(ctx, "GET", "/subnets/"+url.PathEscape(subnet)+"/reservations", nil, nil, &reservations)
+	return reservations, err
+}
+
+// Reserve reserves an address of a subnet for the node with a MAC address, or for a node
+func (c *Client) Reserve(ctx context.Context, subnet string, request model.ReservationRequest) (model.ReservationEntity, error) {
+	reservation := model.ReservationEntity{}
+	err := c.do(ctx, "POST", "/subnets/"+url.PathEscape(subnet)+"/reservations", nil, request, &reservation)
+	return reservation, err
+}
+
+// Unreserve releases a reserved address of a subnet
+func (c *Client) Unreserve(ctx context.Context, subnet, address string) error {
+	return c.do(ctx, "DELETE", "/subnets/"+url.PathEscape(subnet)+"/reservations/"+url.PathEscape(address), nil, nil, nil)
+}
+
+// DHCPOptions returns the DHCP options of a subnet
+func (c *Client) DHCPOptions(ctx context.Context, subnet string) (model.DHCPOptions, error) {
+	options := model.DHCPOptions{}
+	err := c.do(ctx, "GET", "/subnets/"+url.PathEscape(subnet)+"/dhcp", nil, nil, &options)
+	return options, err
+}
+
+// SetDHCPOptions replaces the DHCP options of a subnet
+func (c *Client) SetDHCPOptions(ctx context.Context, subnet string, options model.DHCPOptions) (model.DHCPOptions, error) {
+	set := model.DHCPOptions{}
+	err := c.do(ctx, "PUT", "/subnets/"+url.PathEscape(subnet)+"/dhcp", nil, options, &set)
+	return set, err
+}
+
+// DHCPConfigFilter selects the config DHCPConfig renders. Empty fields take
+// Houston's defaults: dnsmasq, every pool, and every family dnsmasq serves.
+type DHCPConfigFilter struct {
+	Format string
+	Pool   string
+	Family string
+}
+
+// DHCPConfig renders a dnsmasq or ISC dhcpd config from Houston's IPAM data
+func (c *Client) DHCPConfig(ctx context.Context, filter DHCPConfigFilter) ([]byte, error) {
+	query := url.Values{}
+	if filter.Format != "" {
+		query.Set("format", filter.Format)
+	}
+	if filter.Pool != "" {
+		query.Set("pool", filter.Pool)
+	}
+	if filter.Family != "" {
+		query.Set("family", filter.Family)
+	}
+	var config []byte
+	err := c.do(ctx, "GET", "/dhcp/config", query, nil, &config)
+	return config, err
+}
+
+// Addresses lists address leases, filtered by subnet and node unless they are empty
+func (c *Client) Addresses(ctx context.Context, subnet, node string) ([]model.LeaseEntity, error) {
+	query := url.Values{}
+	if subnet != "" {
+		query.Set("subnet", subnet)
+	}
+	if node != "" {
+		query.Set("node", node)
+	}
+
+	leases := []model.LeaseEntity{}
+	err := c.do(ctx, "GET", "/addresses", query, nil, &leases)
+	return leases, err
+}
+
+// AllocateAddress leases an address in subnet to node
+func (c *Client) AllocateAddress(ctx context.Context, subnet, node string) (model.LeaseEntity, error) {
+	return c.LeaseAddress(ctx, model.AllocateAddressRequest{Subnet: subnet, Node: node})
+}
+
+// LeaseAddress leases an address as described by request, which may set a TTL
+func (c *Client) LeaseAddress(ctx context.Context, request model.AllocateAddressRequest) (model.LeaseEntity, error) {
+	lease := model.LeaseEntity{}
+	err := c.do(ctx, "POST", "/addresses", nil, request, &lease)
+	return lease, err
+}
+
+// AllocateNodeAddresses leases an address in each subnet of request to a
+// node, e.g. one IPv4 and one IPv6 subnet for dual-stack
+func (c *Client) AllocateNodeAddresses(ctx context.Context, node string, request model.AllocateAddressesRequest) ([]model.LeaseEntity, error) {
+	leases := []model.LeaseEntity{}
+	err := c.do(ctx, "POST", "/nodes/"+url.PathEscape(node)+"/addresses", nil, request, &leases)
+	return leases, err
+}
+
+// SweepLeases releases expired leases and leases of nodes gone from inventory,
+// or only reports them when dryRun is set
+func (c *Client) SweepLeases(ctx context.Context, dryRun bool) (model.SweepReport, error) {
+	method := "POST"
+	if dryRun {
+		method = "GET"
+	}
+	report := model.SweepReport{}
+	err := c.do(ctx, method, "/addresses/sweep", nil, nil, &report)
+	return report, err
+}
+
+// ExportIPAM returns every pool, subnet and lease as a versioned document
+func (c *Client) ExportIPAM(ctx context.Context) (model.IPAMExport, error) {
+	export := model.IPAMExport{}
+	err := c.do(ctx, "GET", "/ipam/export", nil, nil, &export)
+	return export, err
+}
+
+// ImportIPAM creates the pools, subnets and leases of an export that do not
+// exist yet, or only reports what it would do if dryRun is set
+func (c *Client) ImportIPAM(ctx context.Context, export model.IPAMExport, dryRun bool) (model.ImportReport, error) {
+	query := url.Values{}
+	if dryRun {
+		query.Set("dry_run", "true")
+	}
+	report := model.ImportReport{}
+	err := c.do(ctx, "POST", "/ipam/import", query, export, &report)
+	return report, err
+}
+
+// ReleaseAddress releases the lease with the given ID
+func (c *Client) ReleaseAddress(ctx context.Context, id string) error {
+	return c.do(ctx, "DELETE", "/addresses/"+url.PathEscape(id), nil, nil, nil)
+}
+
+// JobFilter selects the jobs listed by Jobs. Empty fields match any job.
+type JobFilter struct {
+	Kind   string
+	Status string
+	Actor  string
+}
+
+// Jobs lists jobs, newest first
+func (c *Client) Jobs(ctx context.Context, filter JobFilter) ([]model.JobEntity, error) {
+	query := url.Values{}
+	for name, value := range map[string]string{"kind": filter.Kind, "status": filter.Status, "actor": filter.Actor} {
+		if value != "" {
+			query.Set(name, value)
+		}
+	}
+
+	jobs := []model.JobEntity{}
+	err := c.do(ctx, "GET", "/jobs", query, nil, &jobs)
+	return jobs, err
+}
+
+// Job returns the job with the given ID
+func (c *Client) Job(ctx context.Context, id string) (model.JobEntity, error) {
+	job := model.JobEntity{}
+	err := c.do(ctx, "GET", "/jobs/"+url.PathEscape(id), nil, nil, &job)
+	return job, err
+}
+
+// CancelJob cancels a queued or running job. It fails with a 409 if the job has finished.
+func (c *Client) CancelJob(ctx context.Context, id string) (model.JobEntity, error) {
+	job := model.JobEntity{}
+	err := c.do(ctx, "DELETE", "/jobs/"+url.PathEscape(id), nil, nil, &job)
+	return job, err
+}
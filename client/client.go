@@ -0,0 +1,224 @@
+// Package client is a Go client for the Houston API
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// apiPrefix is where the version of the API this client speaks is mounted
+const apiPrefix = "/api/v1"
+
+// Default retry settings for New
+const (
+	DefaultMaxRetries = 3
+	DefaultRetryWait  = 500 * time.Millisecond
+	maxRetryWait      = 10 * time.Second
+)
+
+// Client calls the Houston API at Endpoint
+type Client struct {
+	// Endpoint is the scheme, host and port of Houston, e.g. https://houston:8080
+	Endpoint string
+	// HTTPClient sends the requests, e.g. one configured with client certificates
+	HTTPClient *http.Client
+
+	// APIKey is sent in the X-API-Key header if set
+	APIKey string
+	// Token is sent as a bearer token if set
+	Token string
+
+	// MaxRetries is how many times a request is retried after a 429, 502, 503
+	// or 504. POST and PATCH requests are only retried when Houston refused them
+	// before acting on them.
+	MaxRetries int
+	// RetryWait is the wait before the first retry, doubled for each one after
+	RetryWait time.Duration
+}
+
+// New returns a client for the Houston API at endpoint
+func New(endpoint string) *Client {
+	return &Client{
+		Endpoint:   strings.TrimSuffix(endpoint, "/"),
+		HTTPClient: http.DefaultClient,
+		MaxRetries: DefaultMaxRetries,
+		RetryWait:  DefaultRetryWait,
+	}
+}
+
+// APIError is an error response from Houston
+type APIError struct {
+	StatusCode int `json:"-"`
+	// Code is a stable, machine-readable error code, e.g. NOT_FOUND or UPSTREAM_TIMEOUT
+	Code    string      `json:"code"`
+	Message string      `json:"message"`
+	Details interface{} `json:"details,omitempty"`
+	// RequestID identifies the request in Houston's logs and audit log
+	RequestID string `json:"request_id,omitempty"`
+}
+
+func (e *APIError) Error() string {
+	if e.RequestID == "" {
+		return fmt.Sprintf("houston: %d %s: %s", e.StatusCode, e.Code, e.Message)
+	}
+	return fmt.Sprintf("houston: %d %s: %s (request %s)", e.StatusCode, e.Code, e.Message, e.RequestID)
+}
+
+// IsNotFound reports whether err is a 404 from Houston
+func IsNotFound(err error) bool {
+	apiErr, ok := err.(*APIError)
+	return ok && apiErr.StatusCode == http.StatusNotFound
+}
+
+// HasCode reports whether err is an error from Houston with the given code
+func HasCode(err error, code string) bool {
+	apiErr, ok := err.(*APIError)
+	return ok && apiErr.Code == code
+}
+
+// do sends a request to path, relative to the API prefix, and decodes the
+// JSON response into out unless it is nil. An out of type *[]byte gets the
+// response body as it is.
+func (c *Client) do(ctx context.Context, method, path string, query url.Values, in, out interface{}) error {
+	var body []byte
+	if in != nil {
+		var err error
+		if body, err = json.Marshal(in); err != nil {
+			return err
+		}
+	}
+
+	target := c.Endpoint + apiPrefix + path
+	if len(query) > 0 {
+		target += "?" + query.Encode()
+	}
+
+	wait := c.RetryWait
+	for attempt := 0; ; attempt++ {
+		resp, err := c.send(ctx, method, target, body)
+		if err != nil {
+			return err
+		}
+
+		if attempt < c.MaxRetries && retryable(method, resp) {
+			delay := retryAfter(resp, wait)
+			drain(resp)
+
+			select {
+			case <-time.After(delay):
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+			if wait *= 2; wait > maxRetryWait {
+				wait = maxRetryWait
+			}
+			continue
+		}
+
+		defer drain(resp)
+		if resp.StatusCode >= 400 {
+			return decodeError(resp)
+		}
+		if out == nil || resp.StatusCode == http.StatusNoContent {
+			return nil
+		}
+		if raw, ok := out.(*[]byte); ok {
+			*raw, err = ioutil.ReadAll(resp.Body)
+			return err
+		}
+		return json.NewDecoder(resp.Body).Decode(out)
+	}
+}
+
+func (c *Client) send(ctx context.Context, method, target string, body []byte) (*http.Response, error) {
+	var reader io.Reader
+	if body != nil {
+		reader = bytes.NewReader(body)
+	}
+
+	req, err := http.NewRequest(method, target, reader)
+	if err != nil {
+		return nil, err
+	}
+	req = req.WithContext(ctx)
+
+	req.Header.Set("Accept", "application/json")
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if c.APIKey != "" {
+		req.Header.Set("X-API-Key", c.APIKey)
+	}
+	if c.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.Token)
+	}
+
+	httpClient := c.HTTPClient
+	if httpClient == nil {
+		httpClient = http.DefaultClient
+	}
+	return httpClient.Do(req)
+}
+
+// retryable reports whether a request may be sent again after resp. A 502 or
+// 504 may mean Houston already acted on the request, so POST and PATCH
+// requests are only retried after a 429, or a 503 with Retry-After, which
+// Houston answers without acting.
+func retryable(method string, resp *http.Response) bool {
+	switch resp.StatusCode {
+	case http.StatusTooManyRequests:
+		return true
+	case http.StatusServiceUnavailable:
+		if resp.Header.Get("Retry-After") != "" {
+			return true
+		}
+	}
+
+	switch resp.StatusCode {
+	case http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return method != "POST" && method != "PATCH"
+	}
+	return false
+}
+
+// retryAfter returns the wait the server asked for, or wait if it did not ask
+func retryAfter(resp *http.Response, wait time.Duration) time.Duration {
+	if seconds, err := strconv.Atoi(resp.Header.Get("Retry-After")); err == nil && seconds >= 0 {
+		if delay := time.Duration(seconds) * time.Second; delay < maxRetryWait {
+			return delay
+		}
+		return maxRetryWait
+	}
+	return wait
+}
+
+func decodeError(resp *http.Response) error {
+	body := struct {
+		Error *APIError `json:"error"`
+	}{}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil || body.Error == nil {
+		// Not from Houston itself, e.g. a proxy in front of it
+		body.Error = &APIError{Message: http.StatusText(resp.StatusCode)}
+	}
+
+	body.Error.StatusCode = resp.StatusCode
+	if body.Error.RequestID == "" {
+		body.Error.RequestID = resp.Header.Get("X-Request-ID")
+	}
+	return body.Error
+}
+
+// drain reads the rest of the body so the connection can be reused, then closes it
+func drain(resp *http.Response) {
+	io.Copy(ioutil.Discard, resp.Body)
+	resp.Body.Close()
+}
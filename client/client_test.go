@@ -0,0 +1,152 @@
+package client_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"time"
+
+	. "github.com/RackHD/voyager-houston/client"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Client", func() {
+	var calls int32
+	var handler http.HandlerFunc
+	var houston *httptest.Server
+	var c *Client
+
+	BeforeEach(func() {
+		atomic.StoreInt32(&calls, 0)
+		houston = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			defer GinkgoRecover()
+			atomic.AddInt32(&calls, 1)
+			handler(w, r)
+		}))
+		c = New(houston.URL)
+		c.RetryWait = time.Millisecond
+	})
+
+	AfterEach(func() {
+		houston.Close()
+	})
+
+	It("UNIT should send the configured credentials", func() {
+		handler = func(w http.ResponseWriter, r *http.Request) {
+			Expect(r.URL.Path).To(Equal("/api/v1/info"))
+			Expect(r.Header.Get("X-API-Key")).To(Equal("key"))
+			Expect(r.Header.Get("Authorization")).To(Equal("Bearer token"))
+			w.Write([]byte(`{"name":"voyager"}`))
+		}
+		c.APIKey = "key"
+		c.Token = "token"
+
+		info, err := c.Info(context.Background())
+		Expect(err).ToNot(HaveOccurred())
+		Expect(info.Name).To(Equal("voyager"))
+	})
+
+	It("UNIT should return API errors with the status, message and request ID", func() {
+		handler = func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte(`{"error":{"code":"NOT_FOUND","message":"record not found","request_id":"req-1"}}`))
+		}
+
+		_, err := c.Pool(context.Background(), "missing")
+		Expect(err).To(HaveOccurred())
+		Expect(IsNotFound(err)).To(BeTrue())
+		Expect(HasCode(err, "NOT_FOUND")).To(BeTrue())
+
+		apiErr := err.(*APIError)
+		Expect(apiErr.Message).To(Equal("record not found"))
+		Expect(apiErr.RequestID).To(Equal("req-1"))
+	})
+
+	It("UNIT should retry on 429, 502, 503 and 504", func() {
+		statuses := []int{http.StatusTooManyRequests, http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout}
+		handler = func(w http.ResponseWriter, r *http.Request) {
+			if n := atomic.LoadInt32(&calls); int(n) <= len(statuses) {
+				w.WriteHeader(statuses[n-1])
+				return
+			}
+			w.Write([]byte(`[]`))
+		}
+		c.MaxRetries = len(statuses)
+
+		_, err := c.Pools(context.Background())
+		Expect(err).ToNot(HaveOccurred())
+		Expect(atomic.LoadInt32(&calls)).To(Equal(int32(5)))
+	})
+
+	It("UNIT should give up after MaxRetries", func() {
+		handler = func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+		c.MaxRetries = 2
+
+		_, err := c.Nodes(context.Background())
+		Expect(err).To(HaveOccurred())
+		Expect(err.(*APIError).StatusCode).To(Equal(http.StatusServiceUnavailable))
+		Expect(err.(*APIError).Message).To(Equal("Service Unavailable"))
+		Expect(atomic.LoadInt32(&calls)).To(Equal(int32(3)))
+	})
+
+	It("UNIT should not retry a POST that timed out upstream", func() {
+		handler = func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusGatewayTimeout)
+		}
+
+		_, err := c.AllocateAddress(context.Background(), "subnet", "node")
+		Expect(err).To(HaveOccurred())
+		Expect(atomic.LoadInt32(&calls)).To(Equal(int32(1)))
+	})
+
+	It("UNIT should not retry a POST answered with 502", func() {
+		handler = func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusBadGateway)
+		}
+
+		_, err := c.AllocateAddress(context.Background(), "subnet", "node")
+		Expect(err).To(HaveOccurred())
+		Expect(err.(*APIError).StatusCode).To(Equal(http.StatusBadGateway))
+		Expect(atomic.LoadInt32(&calls)).To(Equal(int32(1)))
+	})
+
+	It("UNIT should retry a POST Houston refused before acting on it", func() {
+		handler = func(w http.ResponseWriter, r *http.Request) {
+			switch atomic.LoadInt32(&calls) {
+			case 1:
+				w.Header().Set("Retry-After", "0")
+				w.WriteHeader(http.StatusTooManyRequests)
+			case 2:
+				w.Header().Set("Retry-After", "0")
+				w.WriteHeader(http.StatusServiceUnavailable)
+			default:
+				w.WriteHeader(http.StatusCreated)
+				w.Write([]byte(`{"id":"l1"}`))
+			}
+		}
+
+		_, err := c.AllocateAddress(context.Background(), "subnet", "node")
+		Expect(err).ToNot(HaveOccurred())
+		Expect(atomic.LoadInt32(&calls)).To(Equal(int32(3)))
+	})
+
+	It("UNIT should stop waiting when the context is done", func() {
+		handler = func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Retry-After", "5")
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+		defer cancel()
+
+		start := time.Now()
+		_, err := c.Nodes(ctx)
+		Expect(err).To(Equal(context.DeadlineExceeded))
+		Expect(time.Since(start)).To(BeNumerically("<", time.Second))
+	})
+})
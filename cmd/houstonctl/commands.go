@@ -0,0 +1,1179 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/RackHD/voyager-houston/client"
+	"github.com/RackHD/voyager-houston/model"
+)
+
+var commands = map[string]command{
+	"info": {
+		help: "Show service information",
+		run: func(ctl *ctl, flags *flag.FlagSet, args []string) error {
+			info, err := ctl.client.Info(ctl.ctx)
+			if err != nil {
+				return err
+			}
+			return ctl.print(info, func() table {
+				return table{header: []string{"NAME"}, rows: [][]string{{info.Name}}}
+			})
+		},
+	},
+
+	"whoami": {
+		help: "Show the identity and role Houston authenticates you as",
+		run: func(ctl *ctl, flags *flag.FlagSet, args []string) error {
+			identity, err := ctl.client.WhoAmI(ctl.ctx)
+			if err != nil {
+				return err
+			}
+			return ctl.print(identity, func() table {
+				return table{header: []string{"SUBJECT", "METHOD", "ROLE"}, rows: [][]string{{identity.Subject, identity.Method, identity.Role}}}
+			})
+		},
+	},
+
+	"nodes list": {
+		args: "[-l SELECTOR] [-site SITE] [-room ROOM] [-rack RACK]",
+		help: "List nodes known to the inventory service",
+		flags: func(flags *flag.FlagSet) {
+			flags.String("l", "", "Only list nodes matching this label selector, e.g. env=prod,role!=gpu")
+			flags.String("site", "", "Only list nodes placed in this site")
+			flags.String("room", "", "Only list nodes placed in this room")
+			flags.String("rack", "", "Only list nodes placed in this rack")
+		},
+		run: func(ctl *ctl, flags *flag.FlagSet, args []string) error {
+			filter := client.NodeFilter{Selector: value(flags, "l"), Site: value(flags, "site"), Room: value(flags, "room"), Rack: value(flags, "rack")}
+			nodes, err := ctl.client.SelectNodes(ctl.ctx, filter)
+			if err != nil {
+				return err
+			}
+			return ctl.print(nodes, func() table {
+				t := table{header: []string{"ID", "NAME", "TYPE"}}
+				for _, node := range nodes {
+					t.add(node.ID(), field(node, "name"), field(node, "type"))
+				}
+				return t
+			})
+		},
+	},
+
+	"nodes get": {
+		args: "ID",
+		help: "Show a node",
+		run: func(ctl *ctl, flags *flag.FlagSet, args []string) error {
+			if len(args) != 1 {
+				return errUsage
+			}
+
+			nodes, err := ctl.client.Nodes(ctl.ctx)
+			if err != nil {
+				return err
+			}
+			for _, node := range nodes {
+				if node.ID() == args[0] {
+					return ctl.print(node, func() table { return fieldTable(node) })
+				}
+			}
+			return fmt.Errorf("node %s not found", args[0])
+		},
+	},
+
+	"nodes label": {
+		args: "NODE KEY=VALUE|KEY-...",
+		help: "Set labels on a node, or remove them with KEY-",
+		run: func(ctl *ctl, flags *flag.FlagSet, args []string) error {
+			if len(args) < 2 {
+				return errUsage
+			}
+			patch := model.LabelsPatch{}
+			for _, arg := range args[1:] {
+				if parts := strings.SplitN(arg, "=", 2); len(parts) == 2 {
+					patch[parts[0]] = &parts[1]
+				} else if strings.HasSuffix(arg, "-") {
+					patch[strings.TrimSuffix(arg, "-")] = nil
+				} else {
+					return fmt.Errorf("invalid label %q, expected KEY=VALUE or KEY-", arg)
+				}
+			}
+
+			labels, err := ctl.client.PatchLabels(ctl.ctx, args[0], patch)
+			if err != nil {
+				return err
+			}
+			return ctl.print(labels, func() table { return labelTable(labels) })
+		},
+	},
+
+	"nodes place": {
+		args: "[-height U] NODE RACK POSITION",
+		help: "Place a node in a rack, from unit POSITION up",
+		flags: func(flags *flag.FlagSet) {
+			flags.Int("height", 1, "Rack units the node takes up")
+		},
+		run: func(ctl *ctl, flags *flag.FlagSet, args []string) error {
+			request := model.PlacementRequest{}
+			if len(args) != 3 {
+				return errUsage
+			}
+			if _, err := fmt.Sscan(args[2], &request.Position); err != nil {
+				return fmt.Errorf("invalid position %q", args[2])
+			}
+			request.Rack = args[1]
+			fmt.Sscan(value(flags, "height"), &request.Height)
+
+			placement, err := ctl.client.PlaceNode(ctl.ctx, args[0], request)
+			if err != nil {
+				return err
+			}
+			return ctl.print(placement, func() table { return placementTable(placement) })
+		},
+	},
+
+	"nodes connect": {
+		args: "NODE NIC SWITCH PORT",
+		help: "Record that a NIC of a node is cabled to a switch port",
+		run: func(ctl *ctl, flags *flag.FlagSet, args []string) error {
+			if len(args) != 4 {
+				return errUsage
+			}
+			request := model.ConnectionRequest{Switch: args[2], Port: args[3]}
+			connection, err := ctl.client.Connect(ctl.ctx, args[0], args[1], request)
+			if err != nil {
+				return err
+			}
+			return ctl.print(connection, func() table { return connectionTable(connection) })
+		},
+	},
+
+	"nodes disconnect": {
+		args: "NODE NIC",
+		help: "Forget what a NIC of a node is cabled to",
+		run: func(ctl *ctl, flags *flag.FlagSet, args []string) error {
+			if len(args) != 2 {
+				return errUsage
+			}
+			return ctl.client.Disconnect(ctl.ctx, args[0], args[1])
+		},
+	},
+
+	"nodes connections": {
+		args: "NODE",
+		help: "List the switch ports a node's NICs are cabled to",
+		run: func(ctl *ctl, flags *flag.FlagSet, args []string) error {
+			if len(args) != 1 {
+				return errUsage
+			}
+			connections, err := ctl.client.Connections(ctl.ctx, args[0])
+			if err != nil {
+				return err
+			}
+			return ctl.print(connections, func() table { return connectionTable(connections...) })
+		},
+	},
+
+	"nodes power": {
+		args: "NODE on|off|reset|cycle|status",
+		help: "Run a power action on a node through its BMC, or read its power state",
+		run: func(ctl *ctl, flags *flag.FlagSet, args []string) error {
+			if len(args) != 2 {
+				return errUsage
+			}
+			var result model.PowerResult
+			var err error
+			if args[1] == model.PowerStatus {
+				result, err = ctl.client.PowerState(ctl.ctx, args[0])
+			} else {
+				result, err = ctl.client.Power(ctl.ctx, args[0], args[1])
+			}
+			if err != nil {
+				return err
+			}
+			return ctl.print(result, func() table {
+				return table{header: []string{"NODE", "ACTION", "POWER"}, rows: [][]string{{result.Node, result.Action, result.PowerState}}}
+			})
+		},
+	},
+
+	"nodes boot-device": {
+		args: "[-persistent] NODE pxe|disk|bios",
+		help: "Set the device a node boots from next time, or every time with -persistent",
+		flags: func(flags *flag.FlagSet) {
+			flags.Bool("persistent", false, "Boot from the device every time")
+		},
+		run: func(ctl *ctl, flags *flag.FlagSet, args []string) error {
+			if len(args) != 2 {
+				return errUsage
+			}
+			result, err := ctl.client.SetBootDevice(ctl.ctx, args[0], args[1], value(flags, "persistent") == "true")
+			if err != nil {
+				return err
+			}
+			return ctl.print(result, func() table {
+				return table{header: []string{"NODE", "DEVICE", "PERSISTENT"}, rows: [][]string{{result.Node, result.Device, fmt.Sprint(result.Persistent)}}}
+			})
+		},
+	},
+
+	"nodes act": {
+		args: "[-ids ID,...] [-labels KEY=VALUE,...] [-parallelism N] [-stop-on-error] [-max-failures N] power ACTION|workflow GRAPH|allocate_address SUBNET|tag TAG...",
+		help: "Start a job running an action on the selected nodes",
+		flags: func(flags *flag.FlagSet) {
+			flags.String("ids", "", "Comma-separated IDs of the nodes")
+			flags.String("labels", "", "Comma-separated KEY=VALUE labels the nodes must have")
+			flags.Int("parallelism", 0, "Nodes to act on at once (default 8)")
+			flags.Bool("stop-on-error", false, "Skip the nodes not yet started after the first failure")
+			flags.Int("max-failures", 0, "Skip the nodes not yet started after this many failures")
+		},
+		run: func(ctl *ctl, flags *flag.FlagSet, args []string) error {
+			if len(args) < 2 {
+				return errUsage
+			}
+			request := model.NodeActionsRequest{
+				Selector:         model.NodeSelector{IDs: list(value(flags, "ids"))},
+				Action:           model.NodeAction{Type: args[0]},
+				StopOnFirstError: value(flags, "stop-on-error") == "true",
+			}
+			fmt.Sscan(value(flags, "parallelism"), &request.Parallelism)
+			fmt.Sscan(value(flags, "max-failures"), &request.MaxFailures)
+			for _, label := range list(value(flags, "labels")) {
+				parts := strings.SplitN(label, "=", 2)
+				if len(parts) != 2 {
+					return fmt.Errorf("invalid label %q, expected KEY=VALUE", label)
+				}
+				if request.Selector.Labels == nil {
+					request.Selector.Labels = map[string]string{}
+				}
+				request.Selector.Labels[parts[0]] = parts[1]
+			}
+
+			switch args[0] {
+			case model.PowerAction:
+				request.Action.Power = args[1]
+			case model.WorkflowAction:
+				request.Action.Workflow = &model.RunWorkflowRequest{Graph: args[1]}
+			case model.AllocateAction:
+				request.Action.Subnet = args[1]
+			case model.TagAction:
+				request.Action.Tags = args[1:]
+			default:
+				return errUsage
+			}
+
+			job, err := ctl.client.NodeActions(ctl.ctx, request)
+			if err != nil {
+				return err
+			}
+			return ctl.print(job, func() table { return jobTable(job) })
+		},
+	},
+
+	"workflows list": {
+		args: "[-status STATUS] NODE",
+		help: "List the workflows run on a node",
+		flags: func(flags *flag.FlagSet) {
+			flags.String("status", "", "Only list workflows with this status (pending|running|succeeded|failed|cancelled)")
+		},
+		run: func(ctl *ctl, flags *flag.FlagSet, args []string) error {
+			if len(args) != 1 {
+				return errUsage
+			}
+			workflows, err := ctl.client.Workflows(ctl.ctx, args[0], value(flags, "status"))
+			if err != nil {
+				return err
+			}
+			return ctl.print(workflows, func() table { return workflowTable(workflows...) })
+		},
+	},
+
+	"workflows run": {
+		args: "-graph GRAPH [-options JSON] NODE",
+		help: "Run a RackHD workflow on a node",
+		flags: func(flags *flag.FlagSet) {
+			flags.String("graph", "", "Name of the workflow graph, e.g. Graph.InstallCentOS")
+			flags.String("options", "", "Graph options as a JSON object")
+		},
+		run: func(ctl *ctl, flags *flag.FlagSet, args []string) error {
+			request := model.RunWorkflowRequest{Graph: value(flags, "graph")}
+			if request.Graph == "" || len(args) != 1 {
+				return errUsage
+			}
+			if options := value(flags, "options"); options != "" {
+				if err := json.Unmarshal([]byte(options), &request.Options); err != nil {
+					return fmt.Errorf("invalid -options: %s", err)
+				}
+			}
+			workflow, err := ctl.client.RunWorkflow(ctl.ctx, args[0], request)
+			if err != nil {
+				return err
+			}
+			return ctl.print(workflow, func() table { return workflowTable(workflow) })
+		},
+	},
+
+	"workflows cancel": {
+		args: "NODE",
+		help: "Cancel the active workflow of a node",
+		run: func(ctl *ctl, flags *flag.FlagSet, args []string) error {
+			if len(args) != 1 {
+				return errUsage
+			}
+			workflow, err := ctl.client.CancelWorkflow(ctl.ctx, args[0])
+			if err != nil {
+				return err
+			}
+			return ctl.print(workflow, func() table { return workflowTable(workflow) })
+		},
+	},
+
+	"sites list": {
+		help: "List sites",
+		run: func(ctl *ctl, flags *flag.FlagSet, args []string) error {
+			sites, err := ctl.client.Sites(ctl.ctx)
+			if err != nil {
+				return err
+			}
+			return ctl.print(sites, func() table {
+				t := table{header: []string{"ID", "NAME", "DESCRIPTION"}}
+				for _, site := range sites {
+					t.add(site.ID, site.Name, site.Description)
+				}
+				return t
+			})
+		},
+	},
+
+	"sites create": {
+		args: "-name NAME [-description TEXT]",
+		help: "Create a site",
+		flags: func(flags *flag.FlagSet) {
+			flags.String("name", "", "Site name")
+			flags.String("description", "", "Site description")
+		},
+		run: func(ctl *ctl, flags *flag.FlagSet, args []string) error {
+			request := model.SiteRequest{Name: value(flags, "name"), Description: value(flags, "description")}
+			if request.Name == "" || len(args) != 0 {
+				return errUsage
+			}
+			site, err := ctl.client.CreateSite(ctl.ctx, request)
+			if err != nil {
+				return err
+			}
+			return ctl.printID(site.ID)
+		},
+	},
+
+	"rooms list": {
+		args: "[-site SITE]",
+		help: "List rooms",
+		flags: func(flags *flag.FlagSet) {
+			flags.String("site", "", "Only list rooms in this site")
+		},
+		run: func(ctl *ctl, flags *flag.FlagSet, args []string) error {
+			rooms, err := ctl.client.Rooms(ctl.ctx, value(flags, "site"))
+			if err != nil {
+				return err
+			}
+			return ctl.print(rooms, func() table {
+				t := table{header: []string{"ID", "SITE", "NAME"}}
+				for _, room := range rooms {
+					t.add(room.ID, room.SiteID, room.Name)
+				}
+				return t
+			})
+		},
+	},
+
+	"rooms create": {
+		args: "-site SITE -name NAME",
+		help: "Create a room in a site",
+		flags: func(flags *flag.FlagSet) {
+			flags.String("site", "", "ID of the site")
+			flags.String("name", "", "Room name")
+		},
+		run: func(ctl *ctl, flags *flag.FlagSet, args []string) error {
+			request := model.RoomRequest{Site: value(flags, "site"), Name: value(flags, "name")}
+			if request.Site == "" || request.Name == "" || len(args) != 0 {
+				return errUsage
+			}
+			room, err := ctl.client.CreateRoom(ctl.ctx, request)
+			if err != nil {
+				return err
+			}
+			return ctl.printID(room.ID)
+		},
+	},
+
+	"racks list": {
+		args: "[-room ROOM]",
+		help: "List racks",
+		flags: func(flags *flag.FlagSet) {
+			flags.String("room", "", "Only list racks in this room")
+		},
+		run: func(ctl *ctl, flags *flag.FlagSet, args []string) error {
+			racks, err := ctl.client.Racks(ctl.ctx, value(flags, "room"))
+			if err != nil {
+				return err
+			}
+			return ctl.print(racks, func() table {
+				t := table{header: []string{"ID", "ROOM", "NAME", "HEIGHT"}}
+				for _, rack := range racks {
+					t.add(rack.ID, rack.RoomID, rack.Name, fmt.Sprintf("%dU", rack.Height))
+				}
+				return t
+			})
+		},
+	},
+
+	"racks show": {
+		args: "RACK",
+		help: "List the nodes in a rack, from the bottom up",
+		run: func(ctl *ctl, flags *flag.FlagSet, args []string) error {
+			if len(args) != 1 {
+				return errUsage
+			}
+			placements, err := ctl.client.RackPlacements(ctl.ctx, args[0])
+			if err != nil {
+				return err
+			}
+			return ctl.print(placements, func() table { return placementTable(placements...) })
+		},
+	},
+
+	"racks create": {
+		args: "-room ROOM -name NAME [-height U]",
+		help: "Create a rack in a room",
+		flags: func(flags *flag.FlagSet) {
+			flags.String("room", "", "ID of the room")
+			flags.String("name", "", "Rack name")
+			flags.Int("height", model.DefaultRackHeight, "Height in rack units")
+		},
+		run: func(ctl *ctl, flags *flag.FlagSet, args []string) error {
+			request := model.RackRequest{Room: value(flags, "room"), Name: value(flags, "name")}
+			if request.Room == "" || request.Name == "" || len(args) != 0 {
+				return errUsage
+			}
+			fmt.Sscan(value(flags, "height"), &request.Height)
+			rack, err := ctl.client.CreateRack(ctl.ctx, request)
+			if err != nil {
+				return err
+			}
+			return ctl.printID(rack.ID)
+		},
+	},
+
+	"switches list": {
+		help: "List switches known to the inventory service",
+		run: func(ctl *ctl, flags *flag.FlagSet, args []string) error {
+			switches, err := ctl.client.Switches(ctl.ctx)
+			if err != nil {
+				return err
+			}
+			return ctl.print(switches, func() table {
+				t := table{header: []string{"ID", "NAME"}}
+				for _, sw := range switches {
+					t.add(sw.ID(), field(model.Node(sw), "name"))
+				}
+				return t
+			})
+		},
+	},
+
+	"switches ports": {
+		args: "SWITCH",
+		help: "List the ports of a switch",
+		run: func(ctl *ctl, flags *flag.FlagSet, args []string) error {
+			if len(args) != 1 {
+				return errUsage
+			}
+			ports, err := ctl.client.SwitchPorts(ctl.ctx, args[0])
+			if err != nil {
+				return err
+			}
+			return ctl.print(ports, func() table {
+				t := table{header: []string{"NAME", "SPEED"}}
+				for _, port := range ports {
+					t.add(field(model.Node(port), "name"), field(model.Node(port), "speed"))
+				}
+				return t
+			})
+		},
+	},
+
+	"switches port-node": {
+		args: "SWITCH PORT",
+		help: "Show the node NIC connected to a switch port",
+		run: func(ctl *ctl, flags *flag.FlagSet, args []string) error {
+			if len(args) != 2 {
+				return errUsage
+			}
+			connection, err := ctl.client.PortNode(ctl.ctx, args[0], args[1])
+			if err != nil {
+				return err
+			}
+			return ctl.print(connection, func() table { return connectionTable(connection) })
+		},
+	},
+
+	"pools list": {
+		help: "List IP pools",
+		run: func(ctl *ctl, flags *flag.FlagSet, args []string) error {
+			pools, err := ctl.client.Pools(ctl.ctx)
+			if err != nil {
+				return err
+			}
+			return ctl.print(pools, func() table { return poolTable(pools...) })
+		},
+	},
+
+	"pools get": {
+		args: "ID",
+		help: "Show an IP pool",
+		run: func(ctl *ctl, flags *flag.FlagSet, args []string) error {
+			if len(args) != 1 {
+				return errUsage
+			}
+			pool, err := ctl.client.Pool(ctl.ctx, args[0])
+			if err != nil {
+				return err
+			}
+			return ctl.print(pool, func() table { return poolTable(pool) })
+		},
+	},
+
+	"pools usage": {
+		args: "ID",
+		help: "Count the allocated, reserved and free addresses of a pool's subnets",
+		run: func(ctl *ctl, flags *flag.FlagSet, args []string) error {
+			if len(args) != 1 {
+				return errUsage
+			}
+			usage, err := ctl.client.PoolUsage(ctl.ctx, args[0])
+			if err != nil {
+				return err
+			}
+			return ctl.print(usage, func() table {
+				t := usageTable(usage.Subnets...)
+				t.add("", usage.Pool, fmt.Sprint(usage.Total), fmt.Sprint(usage.Allocated), fmt.Sprint(usage.Reserved), fmt.Sprint(usage.Free), freeBlock(usage.Usage))
+				return t
+			})
+		},
+	},
+
+	"pools create": {
+		args: "-name NAME [-metadata METADATA] [-prefixes PREFIX[,PREFIX...]]",
+		help: "Create an IP pool",
+		flags: func(flags *flag.FlagSet) {
+			flags.String("name", "", "Pool name")
+			flags.String("metadata", "", "Metadata passed to the IPAM service")
+			flags.String("prefixes", "", "Comma-separated prefixes the pool's subnets must lie within")
+		},
+		run: func(ctl *ctl, flags *flag.FlagSet, args []string) error {
+			request := model.CreatePoolRequest{Name: value(flags, "name"), Metadata: value(flags, "metadata"), Prefixes: list(value(flags, "prefixes"))}
+			if request.Name == "" || len(args) != 0 {
+				return errUsage
+			}
+			id, err := ctl.client.CreatePool(ctl.ctx, request)
+			if err != nil {
+				return err
+			}
+			return ctl.printID(id)
+		},
+	},
+
+	"pools delete": {
+		args: "ID",
+		help: "Delete an IP pool that has no subnets",
+		run: func(ctl *ctl, flags *flag.FlagSet, args []string) error {
+			if len(args) != 1 {
+				return errUsage
+			}
+			if err := ctl.client.DeletePool(ctl.ctx, args[0]); err != nil {
+				return err
+			}
+			return ctl.printID(args[0])
+		},
+	},
+
+	"subnets list": {
+		args: "[-pool POOL]",
+		help: "List subnets",
+		flags: func(flags *flag.FlagSet) {
+			flags.String("pool", "", "Only list subnets in this pool")
+		},
+		run: func(ctl *ctl, flags *flag.FlagSet, args []string) error {
+			subnets, err := ctl.client.Subnets(ctl.ctx, value(flags, "pool"))
+			if err != nil {
+				return err
+			}
+			return ctl.print(subnets, func() table { return subnetTable(subnets...) })
+		},
+	},
+
+	"subnets usage": {
+		args: "ID",
+		help: "Count the allocated, reserved and free addresses of a subnet",
+		run: func(ctl *ctl, flags *flag.FlagSet, args []string) error {
+			if len(args) != 1 {
+				return errUsage
+			}
+			usage, err := ctl.client.SubnetUsage(ctl.ctx, args[0])
+			if err != nil {
+				return err
+			}
+			return ctl.print(usage, func() table { return usageTable(usage) })
+		},
+	},
+
+	"subnets get": {
+		args: "ID",
+		help: "Show a subnet",
+		run: func(ctl *ctl, flags *flag.FlagSet, args []string) error {
+			if len(args) != 1 {
+				return errUsage
+			}
+			subnet, err := ctl.client.Subnet(ctl.ctx, args[0])
+			if err != nil {
+				return err
+			}
+			return ctl.print(subnet, func() table { return subnetTable(subnet) })
+		},
+	},
+
+	"subnets create": {
+		args: "-name NAME -pool POOL -prefix CIDR|-start ADDRESS -end ADDRESS",
+		help: "Create an IPv4 or IPv6 subnet in a pool",
+		flags: func(flags *flag.FlagSet) {
+			flags.String("name", "", "Subnet name")
+			flags.String("pool", "", "ID of the pool the subnet belongs to")
+			flags.String("prefix", "", "Subnet prefix, e.g. 10.1.0.0/24 or 2001:db8:0:1::/64")
+			flags.String("start", "", "First address of the range, by default the first usable address of the prefix")
+			flags.String("end", "", "Last address of the range, by default the last usable address of the prefix")
+		},
+		run: func(ctl *ctl, flags *flag.FlagSet, args []string) error {
+			request := model.CreateSubnetRequest{
+				Name:   value(flags, "name"),
+				Pool:   value(flags, "pool"),
+				Prefix: value(flags, "prefix"),
+				Start:  value(flags, "start"),
+				End:    value(flags, "end"),
+			}
+			hasRange := request.Prefix != "" || (request.Start != "" && request.End != "")
+			if request.Name == "" || request.Pool == "" || !hasRange || len(args) != 0 {
+				return errUsage
+			}
+			id, err := ctl.client.CreateSubnet(ctl.ctx, request)
+			if err != nil {
+				return err
+			}
+			return ctl.printID(id)
+		},
+	},
+
+	"subnets delete": {
+		args: "ID",
+		help: "Delete a subnet that has no leased or reserved addresses",
+		run: func(ctl *ctl, flags *flag.FlagSet, args []string) error {
+			if len(args) != 1 {
+				return errUsage
+			}
+			if err := ctl.client.DeleteSubnet(ctl.ctx, args[0]); err != nil {
+				return err
+			}
+			return ctl.printID(args[0])
+		},
+	},
+
+	"subnets reservations": {
+		args: "SUBNET",
+		help: "List the reserved addresses of a subnet",
+		run: func(ctl *ctl, flags *flag.FlagSet, args []string) error {
+			if len(args) != 1 {
+				return errUsage
+			}
+			reservations, err := ctl.client.Reservations(ctl.ctx, args[0])
+			if err != nil {
+				return err
+			}
+			return ctl.print(reservations, func() table { return reservationTable(reservations...) })
+		},
+	},
+
+	"subnets reserve": {
+		args: "-address ADDRESS -mac MAC|-node NODE SUBNET",
+		help: "Reserve an address of a subnet for the node with a MAC address, or for a node",
+		flags: func(flags *flag.FlagSet) {
+			flags.String("address", "", "Address to reserve")
+			flags.String("mac", "", "MAC address of the node, which need not be discovered yet")
+			flags.String("node", "", "Node to reserve the address for, instead of a MAC address")
+		},
+		run: func(ctl *ctl, flags *flag.FlagSet, args []string) error {
+			request := model.ReservationRequest{
+				Address: value(flags, "address"),
+				MAC:     value(flags, "mac"),
+				Node:    value(flags, "node"),
+			}
+			if request.Address == "" || (request.MAC == "") == (request.Node == "") || len(args) != 1 {
+				return errUsage
+			}
+			reservation, err := ctl.client.Reserve(ctl.ctx, args[0], request)
+			if err != nil {
+				return err
+			}
+			return ctl.print(reservation, func() table { return reservationTable(reservation) })
+		},
+	},
+
+	"subnets unreserve": {
+		args: "SUBNET ADDRESS",
+		help: "Release a reserved address, and its node's lease of it",
+		run: func(ctl *ctl, flags *flag.FlagSet, args []string) error {
+			if len(args) != 2 {
+				return errUsage
+			}
+			if err := ctl.client.Unreserve(ctl.ctx, args[0], args[1]); err != nil {
+				return err
+			}
+			return ctl.printID(args[1])
+		},
+	},
+
+	"subnets dhcp": {
+		args: "SUBNET",
+		help: "Show the DHCP options of a subnet",
+		run: func(ctl *ctl, flags *flag.FlagSet, args []string) error {
+			if len(args) != 1 {
+				return errUsage
+			}
+			options, err := ctl.client.DHCPOptions(ctl.ctx, args[0])
+			if err != nil {
+				return err
+			}
+			return ctl.print(options, func() table { return dhcpOptionsTable(options) })
+		},
+	},
+
+	"subnets set-dhcp": {
+		args: "[-gateway ADDRESS] [-dns ADDRESS,...] [-domain DOMAIN] [-next-server ADDRESS] [-boot-file FILE] SUBNET",
+		help: "Replace the DHCP options of a subnet. Options left out are cleared.",
+		flags: func(flags *flag.FlagSet) {
+			flags.String("gateway", "", "Default gateway, IPv4 subnets only")
+			flags.String("dns", "", "Comma-separated DNS servers")
+			flags.String("domain", "", "Domain name")
+			flags.String("next-server", "", "TFTP server to boot from, IPv4 subnets only")
+			flags.String("boot-file", "", "Boot file name, or URL on IPv6 subnets")
+		},
+		run: func(ctl *ctl, flags *flag.FlagSet, args []string) error {
+			if len(args) != 1 {
+				return errUsage
+			}
+			options, err := ctl.client.SetDHCPOptions(ctl.ctx, args[0], model.DHCPOptions{
+				Gateway:    value(flags, "gateway"),
+				DNS:        list(value(flags, "dns")),
+				Domain:     value(flags, "domain"),
+				NextServer: value(flags, "next-server"),
+				BootFile:   value(flags, "boot-file"),
+			})
+			if err != nil {
+				return err
+			}
+			return ctl.print(options, func() table { return dhcpOptionsTable(options) })
+		},
+	},
+
+	"addresses list": {
+		args: "[-subnet SUBNET] [-node NODE]",
+		help: "List leased addresses",
+		flags: func(flags *flag.FlagSet) {
+			flags.String("subnet", "", "Only list leases in this subnet")
+			flags.String("node", "", "Only list leases held by this node")
+		},
+		run: func(ctl *ctl, flags *flag.FlagSet, args []string) error {
+			leases, err := ctl.client.Addresses(ctl.ctx, value(flags, "subnet"), value(flags, "node"))
+			if err != nil {
+				return err
+			}
+			return ctl.print(leases, func() table { return leaseTable(leases...) })
+		},
+	},
+
+	"addresses allocate": {
+		args: "-subnet SUBNET[,SUBNET] -node NODE [-ttl DURATION]",
+		help: "Lease an address in a subnet to a node, or one in each of an IPv4 and an IPv6 subnet",
+		flags: func(flags *flag.FlagSet) {
+			flags.String("subnet", "", "Subnet to allocate from, or comma-separated subnets of different families")
+			flags.String("node", "", "Node to lease the address to")
+			flags.String("ttl", "", "How long the lease lasts, e.g. 72h; it does not expire when empty")
+		},
+		run: func(ctl *ctl, flags *flag.FlagSet, args []string) error {
+			subnets, node, ttl := list(value(flags, "subnet")), value(flags, "node"), value(flags, "ttl")
+			if len(subnets) == 0 || node == "" || len(args) != 0 {
+				return errUsage
+			}
+			if len(subnets) > 1 {
+				leases, err := ctl.client.AllocateNodeAddresses(ctl.ctx, node, model.AllocateAddressesRequest{Subnets: subnets, TTL: ttl})
+				if err != nil {
+					return err
+				}
+				return ctl.print(leases, func() table { return leaseTable(leases...) })
+			}
+
+			lease, err := ctl.client.LeaseAddress(ctl.ctx, model.AllocateAddressRequest{Subnet: subnets[0], Node: node, TTL: ttl})
+			if err != nil {
+				return err
+			}
+			return ctl.print(lease, func() table { return leaseTable(lease) })
+		},
+	},
+
+	"addresses release": {
+		args: "ID",
+		help: "Release a leased address",
+		run: func(ctl *ctl, flags *flag.FlagSet, args []string) error {
+			if len(args) != 1 {
+				return errUsage
+			}
+			if err := ctl.client.ReleaseAddress(ctl.ctx, args[0]); err != nil {
+				return err
+			}
+			return ctl.printID(args[0])
+		},
+	},
+
+	"addresses sweep": {
+		args: "[-dry-run]",
+		help: "Release expired leases and leases of nodes gone from inventory",
+		flags: func(flags *flag.FlagSet) {
+			flags.Bool("dry-run", false, "Only list the leases that would be released")
+		},
+		run: func(ctl *ctl, flags *flag.FlagSet, args []string) error {
+			if len(args) != 0 {
+				return errUsage
+			}
+			report, err := ctl.client.SweepLeases(ctl.ctx, value(flags, "dry-run") == "true")
+			if err != nil {
+				return err
+			}
+			return ctl.print(report, func() table {
+				t := table{header: []string{"ID", "NODE", "ADDRESS", "REASON", "SINCE", "ERROR"}}
+				for _, r := range report.Leases {
+					t.add(r.Lease.ID, r.Lease.NodeID, r.Lease.Address, r.Reason, r.Since.Format(time.RFC3339), r.Error)
+				}
+				return t
+			})
+		},
+	},
+
+	"ipam export": {
+		args: "[-file FILE]",
+		help: "Write every pool, subnet and lease as JSON, for ipam import",
+		flags: func(flags *flag.FlagSet) {
+			flags.String("file", "", "Write the export to FILE instead of standard output")
+		},
+		run: func(ctl *ctl, flags *flag.FlagSet, args []string) error {
+			if len(args) != 0 {
+				return errUsage
+			}
+			export, err := ctl.client.ExportIPAM(ctl.ctx)
+			if err != nil {
+				return err
+			}
+			data, err := json.MarshalIndent(export, "", "  ")
+			if err != nil {
+				return err
+			}
+			if file := value(flags, "file"); file != "" {
+				return ioutil.WriteFile(file, append(data, '\n'), 0644)
+			}
+			_, err = fmt.Fprintln(ctl.out, string(data))
+			return err
+		},
+	},
+
+	"ipam import": {
+		args: "[-dry-run] FILE",
+		help: "Create the pools, subnets, reservations and leases of an export that do not exist yet. FILE - reads standard input.",
+		flags: func(flags *flag.FlagSet) {
+			flags.Bool("dry-run", false, "Only report what the import would do")
+		},
+		run: func(ctl *ctl, flags *flag.FlagSet, args []string) error {
+			if len(args) != 1 {
+				return errUsage
+			}
+			var data []byte
+			var err error
+			if args[0] == "-" {
+				data, err = ioutil.ReadAll(os.Stdin)
+			} else {
+				data, err = ioutil.ReadFile(args[0])
+			}
+			if err != nil {
+				return err
+			}
+			export := model.IPAMExport{}
+			if err = json.Unmarshal(data, &export); err != nil {
+				return fmt.Errorf("invalid export %s: %s", args[0], err)
+			}
+
+			report, err := ctl.client.ImportIPAM(ctl.ctx, export, value(flags, "dry-run") == "true")
+			if err != nil {
+				return err
+			}
+			err = ctl.print(report, func() table {
+				t := table{header: []string{"KIND", "ID", "OUTCOME", "NEW ID", "MESSAGE"}}
+				for _, item := range report.Items {
+					t.add(item.Kind, item.ID, item.Outcome, item.NewID, item.Message)
+				}
+				return t
+			})
+			if err == nil && report.Counts[model.ImportConflict]+report.Counts[model.ImportFailed] > 0 {
+				err = fmt.Errorf("%d conflicts and %d failures", report.Counts[model.ImportConflict], report.Counts[model.ImportFailed])
+			}
+			return err
+		},
+	},
+
+	"dhcp config": {
+		args: "[-format dnsmasq|isc] [-pool POOL] [-family ipv4|ipv6] [-file FILE]",
+		help: "Render a DHCP server config from the subnets, reservations and leases of Houston",
+		flags: func(flags *flag.FlagSet) {
+			flags.String("format", model.DHCPFormatDnsmasq, "Config format (dnsmasq|isc)")
+			flags.String("pool", "", "Only render the subnets of this pool")
+			flags.String("family", "", "Only render subnets of this address family (ipv4|ipv6)")
+			flags.String("file", "", "Write the config to FILE, leaving it untouched if it has not changed")
+		},
+		run: func(ctl *ctl, flags *flag.FlagSet, args []string) error {
+			if len(args) != 0 {
+				return errUsage
+			}
+			config, err := ctl.client.DHCPConfig(ctl.ctx, client.DHCPConfigFilter{
+				Format: value(flags, "format"),
+				Pool:   value(flags, "pool"),
+				Family: value(flags, "family"),
+			})
+			if err != nil {
+				return err
+			}
+			file := value(flags, "file")
+			if file == "" {
+				_, err = ctl.out.Write(config)
+				return err
+			}
+			if current, err := ioutil.ReadFile(file); err == nil && bytes.Equal(current, config) {
+				return nil
+			}
+			return ioutil.WriteFile(file, config, 0644)
+		},
+	},
+
+	"jobs list": {
+		args: "[-kind KIND] [-status STATUS] [-actor ACTOR]",
+		help: "List jobs, newest first",
+		flags: func(flags *flag.FlagSet) {
+			flags.String("kind", "", "Only list jobs of this kind, e.g. create_pool")
+			flags.String("status", "", "Only list jobs with this status (queued|running|succeeded|failed|canceled)")
+			flags.String("actor", "", "Only list jobs started by this subject")
+		},
+		run: func(ctl *ctl, flags *flag.FlagSet, args []string) error {
+			jobs, err := ctl.client.Jobs(ctl.ctx, client.JobFilter{
+				Kind:   value(flags, "kind"),
+				Status: value(flags, "status"),
+				Actor:  value(flags, "actor"),
+			})
+			if err != nil {
+				return err
+			}
+			return ctl.print(jobs, func() table { return jobTable(jobs...) })
+		},
+	},
+
+	"jobs get": {
+		args: "ID",
+		help: "Show a job",
+		run: func(ctl *ctl, flags *flag.FlagSet, args []string) error {
+			if len(args) != 1 {
+				return errUsage
+			}
+			job, err := ctl.client.Job(ctl.ctx, args[0])
+			if err != nil {
+				return err
+			}
+			return ctl.print(job, func() table { return jobTable(job) })
+		},
+	},
+
+	"jobs cancel": {
+		args: "ID",
+		help: "Cancel a queued or running job",
+		run: func(ctl *ctl, flags *flag.FlagSet, args []string) error {
+			if len(args) != 1 {
+				return errUsage
+			}
+			job, err := ctl.client.CancelJob(ctl.ctx, args[0])
+			if err != nil {
+				return err
+			}
+			return ctl.print(job, func() table { return jobTable(job) })
+		},
+	},
+}
+
+func (ctl *ctl) print(value interface{}, rows func() table) error {
+	return printResult(ctl.out, ctl.format, value, rows)
+}
+
+// printID prints the ID of the object a command created or deleted
+func (ctl *ctl) printID(id string) error {
+	return ctl.print(model.Created{ID: id}, func() table {
+		return table{header: []string{"ID"}, rows: [][]string{{id}}}
+	})
+}
+
+// value returns the value of a flag declared by a command
+func value(flags *flag.FlagSet, name string) string {
+	return flags.Lookup(name).Value.String()
+}
+
+// list splits a comma-separated flag value, ignoring empty items
+func list(text string) []string {
+	items := []string{}
+	for _, item := range strings.Split(text, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	if len(items) == 0 {
+		return nil
+	}
+	return items
+}
+
+// field returns a top-level field of a node as text
+func field(node model.Node, key string) string {
+	switch v := node[key].(type) {
+	case nil:
+		return ""
+	case string:
+		return v
+	default:
+		data, _ := json.Marshal(v)
+		return string(data)
+	}
+}
+
+func workflowTable(workflows ...model.WorkflowEntity) table {
+	t := table{header: []string{"ID", "NODE", "GRAPH", "STATUS", "CREATED"}}
+	for _, workflow := range workflows {
+		t.add(workflow.ID, workflow.NodeID, workflow.Graph, workflow.Status, workflow.CreatedAt.Format(time.RFC3339))
+	}
+	return t
+}
+
+func poolTable(pools ...model.Pool) table {
+	t := table{header: []string{"ID", "NAME", "PREFIXES"}}
+	for _, pool := range pools {
+		t.add(pool.ID, pool.Name, strings.Join(pool.Prefixes, ","))
+	}
+	return t
+}
+
+func subnetTable(subnets ...model.Subnet) table {
+	t := table{header: []string{"ID", "NAME", "POOL", "FAMILY", "PREFIX", "RANGE"}}
+	for _, subnet := range subnets {
+		family, prefix, addresses := "", "", ""
+		if r := subnet.SubnetRangeEntity; r != nil {
+			family, prefix, addresses = r.Family, r.Prefix, r.Start+"-"+r.End
+		}
+		t.add(subnet.ID, subnet.Name, subnet.PoolID, family, prefix, addresses)
+	}
+	return t
+}
+
+func usageTable(subnets ...model.SubnetUsage) table {
+	t := table{header: []string{"SUBNET", "POOL", "TOTAL", "ALLOCATED", "RESERVED", "FREE", "LARGEST FREE BLOCK"}}
+	for _, subnet := range subnets {
+		t.add(subnet.Subnet, subnet.Pool, fmt.Sprint(subnet.Total), fmt.Sprint(subnet.Allocated), fmt.Sprint(subnet.Reserved), fmt.Sprint(subnet.Free), freeBlock(subnet.Usage))
+	}
+	return t
+}
+
+// freeBlock renders the largest free block of usage as its size and first address
+func freeBlock(usage model.Usage) string {
+	if usage.LargestFreeBlock == 0 {
+		return "0"
+	}
+	return fmt.Sprintf("%d from %s", usage.LargestFreeBlock, usage.LargestFreeStart)
+}
+
+func reservationTable(reservations ...model.ReservationEntity) table {
+	t := table{header: []string{"SUBNET", "ADDRESS", "MAC", "NODE", "LEASED TO"}}
+	for _, r := range reservations {
+		t.add(r.SubnetID, r.Address, r.MAC, r.NodeID, r.AssignedTo)
+	}
+	return t
+}
+
+func dhcpOptionsTable(options model.DHCPOptions) table {
+	t := table{header: []string{"GATEWAY", "DNS", "DOMAIN", "NEXT SERVER", "BOOT FILE"}}
+	t.add(options.Gateway, strings.Join(options.DNS, ","), options.Domain, options.NextServer, options.BootFile)
+	return t
+}
+
+func leaseTable(leases ...model.LeaseEntity) table {
+	t := table{header: []string{"ID", "SUBNET", "NODE", "ADDRESS", "CREATED", "EXPIRES"}}
+	for _, lease := range leases {
+		expires := ""
+		if lease.ExpiresAt != nil {
+			expires = lease.ExpiresAt.Format(time.RFC3339)
+		}
+		t.add(lease.ID, lease.SubnetID, lease.NodeID, lease.Address, lease.CreatedAt.Format(time.RFC3339), expires)
+	}
+	return t
+}
+
+func placementTable(placements ...model.PlacementEntity) table {
+	t := table{header: []string{"NODE", "RACK", "UNITS"}}
+	for _, p := range placements {
+		t.add(p.NodeID, p.RackID, fmt.Sprintf("%d-%d", p.Position, p.Top()))
+	}
+	return t
+}
+
+func connectionTable(connections ...model.ConnectionEntity) table {
+	t := table{header: []string{"NODE", "NIC", "SWITCH", "PORT"}}
+	for _, c := range connections {
+		t.add(c.NodeID, c.NIC, c.SwitchID, c.Port)
+	}
+	return t
+}
+
+func labelTable(labels model.Labels) table {
+	keys := []string{}
+	for key := range labels {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	t := table{header: []string{"KEY", "VALUE"}}
+	for _, key := range keys {
+		t.add(key, labels[key])
+	}
+	return t
+}
+
+func jobTable(jobs ...model.JobEntity) table {
+	t := table{header: []string{"ID", "KIND", "STATUS", "PROGRESS", "ACTOR", "CREATED", "ERROR"}}
+	for _, job := range jobs {
+		t.add(job.ID, job.Kind, job.Status, fmt.Sprintf("%d%%", job.Progress), job.Actor, job.CreatedAt.Format(time.RFC3339), job.Error)
+	}
+	return t
+}
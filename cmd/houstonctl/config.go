@@ -0,0 +1,90 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/RackHD/voyager-houston/client"
+	"gopkg.in/yaml.v2"
+)
+
+// Config is read from the config file and overridden by flags
+type Config struct {
+	// Endpoint is Houston's address, e.g. https://houston:8080
+	Endpoint string `yaml:"endpoint"`
+	APIKey   string `yaml:"api_key"`
+	Token    string `yaml:"token"`
+
+	// CAFile verifies Houston's certificate instead of the system roots
+	CAFile string `yaml:"ca_file"`
+	// CertFile and KeyFile are presented when Houston requires client certificates
+	CertFile string `yaml:"cert_file"`
+	KeyFile  string `yaml:"key_file"`
+}
+
+// defaultConfigFile is $HOUSTONCTL_CONFIG, or ~/.houstonctl.yaml
+func defaultConfigFile() string {
+	if file := os.Getenv("HOUSTONCTL_CONFIG"); file != "" {
+		return file
+	}
+	return filepath.Join(os.Getenv("HOME"), ".houstonctl.yaml")
+}
+
+// loadConfig reads file. A missing file is only an error if required is set.
+func loadConfig(file string, required bool) (Config, error) {
+	config := Config{}
+
+	data, err := ioutil.ReadFile(file)
+	if os.IsNotExist(err) && !required {
+		return config, nil
+	}
+	if err != nil {
+		return config, err
+	}
+
+	if err = yaml.Unmarshal(data, &config); err != nil {
+		return config, fmt.Errorf("%s: %s", file, err)
+	}
+	return config, nil
+}
+
+// newClient returns a Houston client for config
+func newClient(config Config, timeout time.Duration) (*client.Client, error) {
+	if config.Endpoint == "" {
+		return nil, fmt.Errorf("no endpoint configured, set endpoint in %s or pass -endpoint", defaultConfigFile())
+	}
+
+	tlsConfig := &tls.Config{}
+	if config.CAFile != "" {
+		pem, err := ioutil.ReadFile(config.CAFile)
+		if err != nil {
+			return nil, err
+		}
+		tlsConfig.RootCAs = x509.NewCertPool()
+		if !tlsConfig.RootCAs.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in %s", config.CAFile)
+		}
+	}
+	if config.CertFile != "" {
+		cert, err := tls.LoadX509KeyPair(config.CertFile, config.KeyFile)
+		if err != nil {
+			return nil, err
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+
+	c := client.New(config.Endpoint)
+	c.APIKey = config.APIKey
+	c.Token = config.Token
+	c.HTTPClient = &http.Client{
+		Timeout:   timeout,
+		Transport: &http.Transport{Proxy: http.ProxyFromEnvironment, TLSClientConfig: tlsConfig},
+	}
+	return c, nil
+}
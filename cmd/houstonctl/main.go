@@ -0,0 +1,142 @@
+// houstonctl is a command-line client for the Houston API
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/RackHD/voyager-houston/client"
+)
+
+// ctl is what a command runs with
+type ctl struct {
+	client *client.Client
+	format string
+	out    io.Writer
+	ctx    context.Context
+}
+
+// command is a subcommand such as "pools create"
+type command struct {
+	args string
+	help string
+	run  func(ctl *ctl, flags *flag.FlagSet, args []string) error
+	// flags declares the command's flags before they are parsed
+	flags func(flags *flag.FlagSet)
+}
+
+// errUsage is returned by commands called with the wrong arguments
+var errUsage = fmt.Errorf("invalid arguments")
+
+func main() {
+	os.Exit(run(os.Args[1:], os.Stdout, os.Stderr))
+}
+
+// run runs houstonctl with args and returns the exit code
+func run(args []string, stdout, stderr io.Writer) int {
+	global := flag.NewFlagSet("houstonctl", flag.ContinueOnError)
+	global.SetOutput(stderr)
+	configFile := global.String("config", "", "Config file with the endpoint and credentials (default $HOUSTONCTL_CONFIG or ~/.houstonctl.yaml)")
+	endpoint := global.String("endpoint", "", "Houston address, e.g. https://houston:8080")
+	apiKey := global.String("api-key", "", "API key to authenticate with")
+	token := global.String("token", "", "JWT bearer token to authenticate with")
+	format := global.String("o", formatTable, "Output format: table, json or yaml")
+	timeout := global.Duration("timeout", 30*time.Second, "Timeout for each request")
+	global.Usage = func() { usage(stderr, global) }
+
+	if err := global.Parse(args); err != nil {
+		return 2
+	}
+
+	name, cmd, rest := lookup(global.Args())
+	if cmd == nil {
+		usage(stderr, global)
+		return 2
+	}
+
+	config, err := loadConfig(defaultConfigFile(), false)
+	if *configFile != "" {
+		config, err = loadConfig(*configFile, true)
+	}
+	if err != nil {
+		fmt.Fprintf(stderr, "houstonctl: %s\n", err)
+		return 1
+	}
+	if *endpoint != "" {
+		config.Endpoint = *endpoint
+	}
+	if *apiKey != "" {
+		config.APIKey = *apiKey
+	}
+	if *token != "" {
+		config.Token = *token
+	}
+
+	flags := flag.NewFlagSet("houstonctl "+name, flag.ContinueOnError)
+	flags.SetOutput(stderr)
+	if cmd.flags != nil {
+		cmd.flags(flags)
+	}
+	flags.Usage = func() {
+		fmt.Fprintf(stderr, "Usage: houstonctl %s %s\n\n%s\n", name, cmd.args, cmd.help)
+		flags.PrintDefaults()
+	}
+	if err = flags.Parse(rest); err != nil {
+		return 2
+	}
+
+	c, err := newClient(config, *timeout)
+	if err != nil {
+		fmt.Fprintf(stderr, "houstonctl: %s\n", err)
+		return 1
+	}
+
+	err = cmd.run(&ctl{client: c, format: *format, out: stdout, ctx: context.Background()}, flags, flags.Args())
+	if err == errUsage {
+		flags.Usage()
+		return 2
+	}
+	if err != nil {
+		fmt.Fprintf(stderr, "houstonctl: %s\n", err)
+		return 1
+	}
+	return 0
+}
+
+// lookup finds the command named by the first one or two arguments
+func lookup(args []string) (string, *command, []string) {
+	if len(args) >= 2 {
+		name := args[0] + " " + args[1]
+		if cmd, ok := commands[name]; ok {
+			return name, &cmd, args[2:]
+		}
+	}
+	if len(args) >= 1 {
+		if cmd, ok := commands[args[0]]; ok {
+			return args[0], &cmd, args[1:]
+		}
+	}
+	return "", nil, nil
+}
+
+func usage(w io.Writer, global *flag.FlagSet) {
+	fmt.Fprintf(w, "Usage: houstonctl [flags] COMMAND [args]\n\nCommands:\n")
+
+	names := []string{}
+	for name := range commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		fmt.Fprintf(w, "  %-40s %s\n", strings.TrimSpace(name+" "+commands[name].args), commands[name].help)
+	}
+
+	fmt.Fprintf(w, "\nFlags:\n")
+	global.PrintDefaults()
+}
@@ -0,0 +1,385 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/RackHD/voyager-houston/server"
+	"github.com/gin-gonic/gin"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("houstonctl", func() {
+	var houston *httptest.Server
+	var stdout, stderr *bytes.Buffer
+
+	houstonctl := func(args ...string) int {
+		return run(append([]string{"-endpoint", houston.URL}, args...), stdout, stderr)
+	}
+
+	BeforeEach(func() {
+		gin.SetMode(gin.ReleaseMode)
+		gin.DefaultWriter = ioutil.Discard
+		houston = httptest.NewServer((&server.Server{}).Router())
+
+		stdout, stderr = &bytes.Buffer{}, &bytes.Buffer{}
+		os.Setenv("HOUSTONCTL_CONFIG", filepath.Join(os.TempDir(), "houstonctl-missing.yaml"))
+	})
+
+	AfterEach(func() {
+		houston.Close()
+	})
+
+	Context("output formats", func() {
+		It("UNIT should print a table by default", func() {
+			Expect(houstonctl("info")).To(Equal(0))
+			Expect(stdout.String()).To(Equal("NAME\nvoyager\n"))
+		})
+
+		It("UNIT should print JSON", func() {
+			Expect(houstonctl("-o", "json", "whoami")).To(Equal(0))
+			Expect(stdout.String()).To(ContainSubstring(`"subject": "anonymous"`))
+		})
+
+		It("UNIT should print YAML", func() {
+			Expect(houstonctl("-o", "yaml", "info")).To(Equal(0))
+			Expect(stdout.String()).To(Equal("name: voyager\n"))
+		})
+
+		It("UNIT should reject unknown formats", func() {
+			Expect(houstonctl("-o", "xml", "info")).To(Equal(1))
+			Expect(stderr.String()).To(ContainSubstring("unknown output format"))
+		})
+	})
+
+	It("UNIT should print usage for unknown commands and missing arguments", func() {
+		Expect(houstonctl("pools", "explode")).To(Equal(2))
+		Expect(stderr.String()).To(ContainSubstring("pools create"))
+
+		Expect(houstonctl("pools", "create")).To(Equal(2))
+		Expect(stderr.String()).To(ContainSubstring("-name NAME"))
+
+		Expect(houstonctl("addresses", "allocate", "-subnet", "s", "-node", "n", "extra")).To(Equal(2))
+	})
+
+	It("UNIT should report API errors", func() {
+		houston.Close()
+		houston = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			defer GinkgoRecover()
+			w.WriteHeader(http.StatusConflict)
+			w.Write([]byte(`{"error":{"code":"CONFLICT","message":"Pool p1 still has 2 subnets","request_id":"req-1"}}`))
+		}))
+
+		Expect(houstonctl("pools", "delete", "p1")).To(Equal(1))
+		Expect(stderr.String()).To(ContainSubstring("CONFLICT: Pool p1 still has 2 subnets"))
+		Expect(stderr.String()).To(ContainSubstring("req-1"))
+	})
+
+	It("UNIT should find a node by ID", func() {
+		houston.Close()
+		houston = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			defer GinkgoRecover()
+			Expect(r.URL.Path).To(Equal("/api/v1/nodes"))
+			w.Write([]byte(`[{"id":"n1","name":"compute-1","type":"compute"},{"id":"n2","name":"switch-1","type":"switch"}]`))
+		}))
+
+		Expect(houstonctl("nodes", "get", "n2")).To(Equal(0))
+		Expect(stdout.String()).To(ContainSubstring("switch-1"))
+
+		Expect(houstonctl("nodes", "get", "n3")).To(Equal(1))
+		Expect(stderr.String()).To(ContainSubstring("node n3 not found"))
+	})
+
+	It("UNIT should list jobs filtered by status", func() {
+		houston.Close()
+		houston = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			defer GinkgoRecover()
+			Expect(r.URL.Path).To(Equal("/api/v1/jobs"))
+			Expect(r.URL.Query().Get("status")).To(Equal("failed"))
+			w.Write([]byte(`[{"id":"j1","kind":"create_pool","status":"failed","progress":0,"request":{"name":"p"},"error":"Request timed out after 5s"}]`))
+		}))
+
+		Expect(houstonctl("jobs", "list", "-status", "failed")).To(Equal(0))
+		Expect(stdout.String()).To(ContainSubstring("create_pool"))
+		Expect(stdout.String()).To(ContainSubstring("Request timed out after 5s"))
+	})
+
+	It("UNIT should run a workflow with options", func() {
+		houston.Close()
+		houston = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			defer GinkgoRecover()
+			Expect(r.Method).To(Equal("POST"))
+			Expect(r.URL.Path).To(Equal("/api/v1/nodes/n1/workflows"))
+			body, err := ioutil.ReadAll(r.Body)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(body).To(MatchJSON(`{"graph":"Graph.Discovery","options":{"defaults":{"x":1}}}`))
+			w.WriteHeader(http.StatusCreated)
+			w.Write([]byte(`{"id":"wf-1","node":"n1","graph":"Graph.Discovery","status":"running"}`))
+		}))
+
+		Expect(houstonctl("workflows", "run", "-graph", "Graph.Discovery", "-options", `{"defaults":{"x":1}}`, "n1")).To(Equal(0))
+		Expect(stdout.String()).To(ContainSubstring("wf-1"))
+
+		Expect(houstonctl("workflows", "run", "-graph", "Graph.Discovery", "-options", "{", "n1")).To(Equal(1))
+		Expect(stderr.String()).To(ContainSubstring("invalid -options"))
+	})
+
+	It("UNIT should act on nodes selected by label", func() {
+		houston.Close()
+		houston = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			defer GinkgoRecover()
+			Expect(r.Method).To(Equal("POST"))
+			Expect(r.URL.Path).To(Equal("/api/v1/node-actions"))
+			body, err := ioutil.ReadAll(r.Body)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(body).To(MatchJSON(`{"selector":{"labels":{"rack":"r1"}},"action":{"type":"power","power":"cycle"},"parallelism":2}`))
+			w.WriteHeader(http.StatusAccepted)
+			w.Write([]byte(`{"id":"j1","kind":"node_actions","status":"queued","progress":0,"request":{}}`))
+		}))
+
+		Expect(houstonctl("nodes", "act", "-labels", "rack=r1", "-parallelism", "2", "power", "cycle")).To(Equal(0))
+		Expect(stdout.String()).To(ContainSubstring("node_actions"))
+
+		Expect(houstonctl("nodes", "act", "-labels", "rack", "power", "cycle")).To(Equal(1))
+		Expect(stderr.String()).To(ContainSubstring("invalid label"))
+	})
+
+	It("UNIT should set and remove labels", func() {
+		houston.Close()
+		houston = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			defer GinkgoRecover()
+			Expect(r.Method).To(Equal("PATCH"))
+			Expect(r.URL.Path).To(Equal("/api/v1/nodes/n1/labels"))
+			body, err := ioutil.ReadAll(r.Body)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(body).To(MatchJSON(`{"role":"storage","owner":null}`))
+			w.Write([]byte(`{"role":"storage","rack":"r1"}`))
+		}))
+
+		Expect(houstonctl("nodes", "label", "n1", "role=storage", "owner-")).To(Equal(0))
+		Expect(stdout.String()).To(ContainSubstring("storage"))
+
+		Expect(houstonctl("nodes", "label", "n1", "role")).To(Equal(1))
+		Expect(stderr.String()).To(ContainSubstring("invalid label"))
+	})
+
+	It("UNIT should place a node and refuse taken units", func() {
+		houston.Close()
+		houston = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			defer GinkgoRecover()
+			Expect(r.Method).To(Equal("PUT"))
+			Expect(r.URL.Path).To(Equal("/api/v1/nodes/n1/placement"))
+			body, err := ioutil.ReadAll(r.Body)
+			Expect(err).ToNot(HaveOccurred())
+			if string(body) == `{"rack":"r1","position":3,"height":2}` {
+				w.Write([]byte(`{"node":"n1","rack":"r1","position":3,"height":2}`))
+				return
+			}
+			w.WriteHeader(http.StatusConflict)
+			w.Write([]byte(`{"error":{"code":"CONFLICT","message":"Node n2 takes up units 1-1 of rack r1"}}`))
+		}))
+
+		Expect(houstonctl("nodes", "place", "-height", "2", "n1", "r1", "3")).To(Equal(0))
+		Expect(stdout.String()).To(ContainSubstring("3-4"))
+
+		Expect(houstonctl("nodes", "place", "n1", "r1", "1")).To(Equal(1))
+		Expect(stderr.String()).To(ContainSubstring("takes up units"))
+	})
+
+	It("UNIT should connect NICs and look up switch ports with slashes", func() {
+		houston.Close()
+		houston = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			defer GinkgoRecover()
+			switch r.Method + " " + r.URL.EscapedPath() {
+			case "PUT /api/v1/nodes/n1/connections/eth0":
+				body, err := ioutil.ReadAll(r.Body)
+				Expect(err).ToNot(HaveOccurred())
+				Expect(body).To(MatchJSON(`{"switch":"tor1","port":"Ethernet1/1"}`))
+				fallthrough
+			case "GET /api/v1/switches/tor1/ports/Ethernet1%2F1/node":
+				w.Write([]byte(`{"node":"n1","nic":"eth0","switch":"tor1","port":"Ethernet1/1"}`))
+			default:
+				w.WriteHeader(http.StatusNotFound)
+				w.Write([]byte(`{"error":{"code":"NOT_FOUND","message":"Nothing is connected"}}`))
+			}
+		}))
+
+		Expect(houstonctl("nodes", "connect", "n1", "eth0", "tor1", "Ethernet1/1")).To(Equal(0))
+		Expect(stdout.String()).To(ContainSubstring("Ethernet1/1"))
+
+		stdout.Reset()
+		Expect(houstonctl("switches", "port-node", "tor1", "Ethernet1/1")).To(Equal(0))
+		Expect(stdout.String()).To(ContainSubstring("eth0"))
+
+		Expect(houstonctl("switches", "port-node", "tor1", "Ethernet1/2")).To(Equal(1))
+		Expect(stderr.String()).To(ContainSubstring("Nothing is connected"))
+	})
+
+	It("UNIT should run a lease sweep only as a dry run when asked", func() {
+		houston.Close()
+		houston = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			defer GinkgoRecover()
+			Expect(r.URL.Path).To(Equal("/api/v1/addresses/sweep"))
+			w.Write([]byte(`{"dry_run":` + fmt.Sprint(r.Method == "GET") + `,"leases":[{"lease":{"id":"l1","node":"n2","address":"10.0.0.7"},"reason":"node_gone"}]}`))
+		}))
+
+		Expect(houstonctl("-o", "json", "addresses", "sweep", "-dry-run")).To(Equal(0))
+		Expect(stdout.String()).To(ContainSubstring(`"dry_run": true`))
+
+		stdout.Reset()
+		Expect(houstonctl("addresses", "sweep")).To(Equal(0))
+		Expect(stdout.String()).To(ContainSubstring("node_gone"))
+	})
+
+	It("UNIT should create a pool with prefixes and report overlapping subnets", func() {
+		houston.Close()
+		houston = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			defer GinkgoRecover()
+			body, err := ioutil.ReadAll(r.Body)
+			Expect(err).ToNot(HaveOccurred())
+			switch r.URL.Path {
+			case "/api/v1/pools":
+				Expect(body).To(MatchJSON(`{"name":"dc","metadata":"","prefixes":["10.8.0.0/16","2001:db8::/48"]}`))
+				w.WriteHeader(http.StatusCreated)
+				w.Write([]byte(`{"id":"dc"}`))
+			default:
+				w.WriteHeader(http.StatusConflict)
+				w.Write([]byte(`{"error":{"code":"CONFLICT","message":"Range 10.8.0.1-10.8.0.254 overlaps subnet s1 (10.8.0.10-10.8.0.20)","details":{"subnet":"s1"}}}`))
+			}
+		}))
+
+		Expect(houstonctl("pools", "create", "-name", "dc", "-prefixes", "10.8.0.0/16, 2001:db8::/48")).To(Equal(0))
+		Expect(stdout.String()).To(ContainSubstring("dc"))
+
+		Expect(houstonctl("subnets", "create", "-name", "a", "-pool", "dc", "-prefix", "10.8.0.0/24")).To(Equal(1))
+		Expect(stderr.String()).To(ContainSubstring("overlaps subnet s1"))
+	})
+
+	It("UNIT should show the usage of a pool with a total row", func() {
+		houston.Close()
+		houston = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			defer GinkgoRecover()
+			Expect(r.URL.Path).To(Equal("/api/v1/pools/p1/usage"))
+			w.Write([]byte(`{"pool":"p1","total":256,"allocated":3,"reserved":2,"free":251,"largest_free_block":154,"largest_free_start":"10.0.0.101",` +
+				`"subnets":[{"subnet":"s4","pool":"p1","total":256,"allocated":3,"reserved":2,"free":251,"largest_free_block":154,"largest_free_start":"10.0.0.101"}]}`))
+		}))
+
+		Expect(houstonctl("pools", "usage", "p1")).To(Equal(0))
+		Expect(stdout.String()).To(ContainSubstring("LARGEST FREE BLOCK"))
+		Expect(stdout.String()).To(ContainSubstring("154 from 10.0.0.101"))
+		Expect(strings.Count(stdout.String(), "251")).To(Equal(2))
+	})
+
+	It("UNIT should export to a file and fail an import with conflicts", func() {
+		houston.Close()
+		houston = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			defer GinkgoRecover()
+			switch r.Method + " " + r.URL.Path {
+			case "GET /api/v1/ipam/export":
+				w.Write([]byte(`{"version":1,"pools":[{"id":"p1","name":"lab"}],"subnets":[],"leases":[]}`))
+			case "POST /api/v1/ipam/import":
+				Expect(r.URL.Query().Get("dry_run")).To(Equal("true"))
+				body, err := ioutil.ReadAll(r.Body)
+				Expect(err).ToNot(HaveOccurred())
+				Expect(string(body)).To(ContainSubstring(`"name":"lab"`))
+				w.Write([]byte(`{"dry_run":true,"items":[{"kind":"pool","id":"p1","outcome":"conflict","message":"Pool lab already exists"}],"counts":{"conflict":1}}`))
+			}
+		}))
+
+		file := filepath.Join(os.TempDir(), "houstonctl-export.json")
+		defer os.Remove(file)
+		Expect(houstonctl("ipam", "export", "-file", file)).To(Equal(0))
+
+		Expect(houstonctl("ipam", "import", "-dry-run", file)).To(Equal(1))
+		Expect(stdout.String()).To(ContainSubstring("Pool lab already exists"))
+		Expect(stderr.String()).To(ContainSubstring("1 conflicts and 0 failures"))
+	})
+
+	It("UNIT should reserve an address by MAC and refuse a MAC and a node together", func() {
+		houston.Close()
+		houston = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			defer GinkgoRecover()
+			Expect(r.Method + " " + r.URL.Path).To(Equal("POST /api/v1/subnets/s4/reservations"))
+			body, err := ioutil.ReadAll(r.Body)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(string(body)).To(MatchJSON(`{"address":"10.0.0.20","mac":"52:54:00:aa:bb:cc"}`))
+			w.WriteHeader(http.StatusCreated)
+			w.Write([]byte(`{"subnet":"s4","address":"10.0.0.20","mac":"52:54:00:aa:bb:cc","lease":"l9"}`))
+		}))
+
+		Expect(houstonctl("subnets", "reserve", "-address", "10.0.0.20", "-mac", "52:54:00:aa:bb:cc", "s4")).To(Equal(0))
+		Expect(stdout.String()).To(ContainSubstring("LEASED TO"))
+		Expect(stdout.String()).To(ContainSubstring("52:54:00:aa:bb:cc"))
+
+		Expect(houstonctl("subnets", "reserve", "-address", "10.0.0.20", "-mac", "52:54:00:aa:bb:cc", "-node", "n1", "s4")).To(Equal(2))
+	})
+
+	It("UNIT should set DHCP options and only rewrite a config file when it changed", func() {
+		config := "dhcp-range=set:s4,10.0.0.10,10.0.0.254,255.255.255.0\n"
+		houston.Close()
+		houston = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			defer GinkgoRecover()
+			switch r.Method + " " + r.URL.Path {
+			case "PUT /api/v1/subnets/s4/dhcp":
+				body, err := ioutil.ReadAll(r.Body)
+				Expect(err).ToNot(HaveOccurred())
+				Expect(string(body)).To(MatchJSON(`{"gateway":"10.0.0.1","dns":["10.0.0.2","10.0.0.3"]}`))
+				w.Write(body)
+			case "GET /api/v1/dhcp/config":
+				Expect(r.URL.Query().Get("format")).To(Equal("dnsmasq"))
+				w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+				w.Write([]byte(config))
+			}
+		}))
+
+		Expect(houstonctl("subnets", "set-dhcp", "-gateway", "10.0.0.1", "-dns", "10.0.0.2,10.0.0.3", "s4")).To(Equal(0))
+		Expect(stdout.String()).To(ContainSubstring("10.0.0.2,10.0.0.3"))
+
+		stdout.Reset()
+		Expect(houstonctl("dhcp", "config")).To(Equal(0))
+		Expect(stdout.String()).To(Equal(config))
+
+		file := filepath.Join(os.TempDir(), "houstonctl-dnsmasq.conf")
+		defer os.Remove(file)
+		Expect(houstonctl("dhcp", "config", "-file", file)).To(Equal(0))
+		Expect(ioutil.ReadFile(file)).To(Equal([]byte(config)))
+
+		past := time.Now().Add(-time.Hour).Truncate(time.Second)
+		Expect(os.Chtimes(file, past, past)).To(Succeed())
+		Expect(houstonctl("dhcp", "config", "-file", file)).To(Equal(0))
+		info, err := os.Stat(file)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(info.ModTime()).To(Equal(past))
+	})
+
+	It("UNIT should read the endpoint and credentials from the config file", func() {
+		var apiKey string
+		houston.Close()
+		houston = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			defer GinkgoRecover()
+			apiKey = r.Header.Get("X-API-Key")
+			w.Write([]byte(`{"name":"voyager"}`))
+		}))
+
+		dir, err := ioutil.TempDir("", "houstonctl")
+		Expect(err).ToNot(HaveOccurred())
+		defer os.RemoveAll(dir)
+
+		config := filepath.Join(dir, "config.yaml")
+		Expect(ioutil.WriteFile(config, []byte("endpoint: "+houston.URL+"\napi_key: secret\n"), 0600)).To(Succeed())
+
+		Expect(run([]string{"-config", config, "info"}, stdout, stderr)).To(Equal(0))
+		Expect(apiKey).To(Equal("secret"))
+
+		Expect(run([]string{"-config", filepath.Join(dir, "missing.yaml"), "info"}, stdout, stderr)).To(Equal(1))
+	})
+})
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"text/tabwriter"
+
+	"gopkg.in/yaml.v2"
+)
+
+// Output formats
+const (
+	formatTable = "table"
+	formatJSON  = "json"
+	formatYAML  = "yaml"
+)
+
+// table is what a command prints in table format
+type table struct {
+	header []string
+	rows   [][]string
+}
+
+func (t *table) add(row ...string) {
+	t.rows = append(t.rows, row)
+}
+
+// printResult writes value as JSON or YAML, or as the table built by rows
+func printResult(w io.Writer, format string, value interface{}, rows func() table) error {
+	switch format {
+	case formatJSON:
+		data, err := json.MarshalIndent(value, "", "  ")
+		if err != nil {
+			return err
+		}
+		_, err = fmt.Fprintln(w, string(data))
+		return err
+
+	case formatYAML:
+		// Round trip through JSON so YAML uses the same field names as the API
+		data, err := json.Marshal(value)
+		if err != nil {
+			return err
+		}
+		var generic interface{}
+		if err = yaml.Unmarshal(data, &generic); err != nil {
+			return err
+		}
+		if data, err = yaml.Marshal(generic); err != nil {
+			return err
+		}
+		_, err = w.Write(data)
+		return err
+
+	case formatTable:
+		t := rows()
+		tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+		fmt.Fprintln(tw, strings.Join(t.header, "\t"))
+		for _, row := range t.rows {
+			fmt.Fprintln(tw, strings.Join(row, "\t"))
+		}
+		return tw.Flush()
+	}
+	return fmt.Errorf("unknown output format %q, use table, json or yaml", format)
+}
+
+// fieldTable lists the fields of an object as key/value rows
+func fieldTable(fields map[string]interface{}) table {
+	keys := []string{}
+	for key := range fields {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	t := table{header: []string{"FIELD", "VALUE"}}
+	for _, key := range keys {
+		value := fields[key]
+		if _, scalar := value.(string); !scalar {
+			data, _ := json.Marshal(value)
+			value = string(data)
+		}
+		t.add(key, fmt.Sprint(value))
+	}
+	return t
+}
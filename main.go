@@ -2,6 +2,8 @@ package main
 
 import (
 	"flag"
+	"fmt"
+	"time"
 
 	"github.com/RackHD/voyager-houston/server"
 	log "github.com/sirupsen/logrus"
@@ -15,6 +17,27 @@ var (
 	bindingKey   = flag.String("key", "test-key", "AMQP binding key")
 	consumerTag  = flag.String("consumer-tag", "simple-consumer", "AMQP consumer tag (should not be blank)")
 	dbAddress    = flag.String("db-address", "root@(mysql:3306)/mysql", "Address of the MySQL database")
+	tlsCert      = flag.String("tls-cert", "", "Certificate file for serving the API over HTTPS")
+	tlsKey       = flag.String("tls-key", "", "Key file for serving the API over HTTPS")
+	tlsClientCA  = flag.String("tls-client-ca", "", "CA bundle to require and verify API client certificates against")
+	amqpCA       = flag.String("amqp-ca", "", "CA bundle to verify RabbitMQ against when using amqps://")
+	amqpCert     = flag.String("amqp-cert", "", "Client certificate file for connecting to RabbitMQ over amqps://")
+	amqpKey      = flag.String("amqp-key", "", "Client key file for connecting to RabbitMQ over amqps://")
+	authAPIKeys  = flag.Bool("auth-api-keys", false, "Accept API keys in the X-API-Key header")
+	jwtSecret    = flag.String("jwt-secret-file", "", "File holding the HMAC secret for JWT bearer tokens")
+	jwksFile     = flag.String("jwks-file", "", "JWKS file holding the public keys for JWT bearer tokens")
+	jwtIssuer    = flag.String("jwt-issuer", "", "Required issuer of JWT bearer tokens")
+	jwtAudience  = flag.String("jwt-audience", "", "Required audience of JWT bearer tokens")
+	createAPIKey = flag.String("create-api-key", "", "Create an API key with this name, print it and exit")
+	role         = flag.String("role", "", "Role to bind to the API key created with -create-api-key (viewer|operator|admin)")
+	rateLimits   = flag.String("rate-limits", "", "Per-client rate limits as comma-separated ROUTE=RATE:BURST, e.g. 'GET /nodes=2:10,*=20:40'")
+	maxInFlight  = flag.String("max-inflight", "*=50", "Maximum concurrent requests to each Voyager service as comma-separated SERVICE=N")
+	cacheTTL     = flag.Duration("inventory-cache-ttl", 30*time.Second, "How long GET /nodes is answered from the cache, 0 to disable it")
+	cacheStale   = flag.Duration("inventory-cache-max-stale", 10*time.Minute, "Oldest cached nodes served while the inventory service is slow or down, 0 for no limit")
+	leaseSweep   = flag.Duration("lease-sweep-interval", 5*time.Minute, "How often expired leases and leases of nodes gone from inventory are released, 0 to disable")
+	leaseGrace   = flag.Duration("lease-grace-period", time.Hour, "How long a node may be missing from inventory before its leases are released")
+	leaseMaxGone = flag.Float64("lease-sweep-max-gone", 0.5, "Largest fraction of leases a sweep releases because their nodes are gone, 0 for no limit")
+	usageMaxAge  = flag.Duration("usage-gauge-max-age", time.Minute, "How long /metrics serves the address usage gauges before computing them again from MySQL")
 )
 
 func init() {
@@ -22,12 +45,63 @@ func init() {
 }
 
 func main() {
-	s := server.NewServer(*uri, *dbAddress)
+	limits, err := server.ParseRateLimits(*rateLimits)
+	if err != nil {
+		log.Fatalf("Invalid -rate-limits: %s", err)
+	}
+	inFlight, err := server.ParseMaxInFlight(*maxInFlight)
+	if err != nil {
+		log.Fatalf("Invalid -max-inflight: %s", err)
+	}
+
+	s := server.NewServerWithConfig(*uri, *dbAddress, server.Config{
+		TLSCertFile:     *tlsCert,
+		TLSKeyFile:      *tlsKey,
+		TLSClientCAFile: *tlsClientCA,
+		AMQPCAFile:      *amqpCA,
+		AMQPCertFile:    *amqpCert,
+		AMQPKeyFile:     *amqpKey,
+		AuthAPIKeys:     *authAPIKeys,
+		JWTSecretFile:   *jwtSecret,
+		JWKSFile:        *jwksFile,
+		JWTIssuer:       *jwtIssuer,
+		JWTAudience:     *jwtAudience,
+		RateLimits:      limits,
+		MaxInFlight:     inFlight,
+
+		InventoryCacheTTL:      *cacheTTL,
+		InventoryCacheMaxStale: *cacheStale,
+		LeaseGracePeriod:       *leaseGrace,
+		LeaseSweepMaxGone:      *leaseMaxGone,
+		UsageGaugeMaxAge:       *usageMaxAge,
+	})
 	defer s.MQ.Close()
 
+	if *createAPIKey != "" {
+		key, err := s.CreateAPIKey(*createAPIKey)
+		if err != nil {
+			log.Fatalf("Could not create API key: %s", err)
+		}
+		if *role != "" {
+			if _, err = s.BindRole("api-key:"+*createAPIKey, *role); err != nil {
+				log.Fatalf("Could not bind role: %s", err)
+			}
+		}
+		fmt.Println(key)
+		return
+	}
+
 	log.Info("Trying to init IPAM now")
 	s.InitIPAM()
 
+	if err = s.ResumeJobs(); err != nil {
+		log.Warnf("Could not resume jobs: %s", err)
+	}
+
+	if *leaseSweep > 0 {
+		go s.SweepLeasesEvery(*leaseSweep)
+	}
+
 	// Listen for messages in the background in infinite loop
 	s.ListenOnHoustonReceiveQueue()
 	s.Run()
@@ -0,0 +1,75 @@
+package model
+
+// Types of action run by POST /node-actions
+const (
+	PowerAction    = "power"
+	WorkflowAction = "workflow"
+	AllocateAction = "allocate_address"
+	TagAction      = "tag"
+)
+
+// Outcomes of a node action
+const (
+	OutcomeSucceeded = "succeeded"
+	OutcomeFailed    = "failed"
+	OutcomeSkipped   = "skipped"
+)
+
+// NodeSelector picks nodes by ID, by the labels and top-level fields the
+// inventory reports for them, or both. A node must match every criterion given.
+type NodeSelector struct {
+	IDs    []string          `json:"ids,omitempty"`
+	Labels map[string]string `json:"labels,omitempty"`
+	Filter map[string]string `json:"filter,omitempty"`
+}
+
+// Empty reports whether the selector has no criteria
+func (s NodeSelector) Empty() bool {
+	return len(s.IDs) == 0 && len(s.Labels) == 0 && len(s.Filter) == 0
+}
+
+// NodeAction is an action to run on each selected node. Type picks which of
+// the other fields apply.
+type NodeAction struct {
+	Type     string              `json:"type" binding:"required"`
+	Power    string              `json:"power,omitempty"`
+	Workflow *RunWorkflowRequest `json:"workflow,omitempty"`
+	Subnet   string              `json:"subnet,omitempty"`
+	Tags     []string            `json:"tags,omitempty"`
+}
+
+// NodeActionsRequest is the body of POST /node-actions
+type NodeActionsRequest struct {
+	Selector NodeSelector `json:"selector"`
+	Action   NodeAction   `json:"action"`
+	// Parallelism caps the nodes acted on at once
+	Parallelism int `json:"parallelism,omitempty"`
+	// StopOnFirstError and MaxFailures stop starting new nodes after the first
+	// failure, or after MaxFailures failures if it is positive
+	StopOnFirstError bool `json:"stop_on_first_error,omitempty"`
+	MaxFailures      int  `json:"max_failures,omitempty"`
+}
+
+// TagCommand is the message sent to voyager-rackhd-service to tag a node
+type TagCommand struct {
+	Command string   `json:"command"`
+	NodeID  string   `json:"nodeId"`
+	Tags    []string `json:"tags"`
+}
+
+// NodeOutcome is what an action did on one node
+type NodeOutcome struct {
+	Node   string      `json:"node"`
+	Status string      `json:"status"`
+	Result interface{} `json:"result,omitempty"`
+	Error  string      `json:"error,omitempty"`
+}
+
+// NodeActionsResult is the result of a POST /node-actions job
+type NodeActionsResult struct {
+	Total     int           `json:"total"`
+	Succeeded int           `json:"succeeded"`
+	Failed    int           `json:"failed"`
+	Skipped   int           `json:"skipped"`
+	Nodes     []NodeOutcome `json:"nodes"`
+}
@@ -0,0 +1,59 @@
+package model
+
+import (
+	"strings"
+	"time"
+)
+
+// Formats GET /dhcp/config renders
+const (
+	DHCPFormatDnsmasq = "dnsmasq"
+	DHCPFormatISC     = "isc"
+)
+
+// DHCPOptions are the options a DHCP server hands out on a subnet, as set by
+// PUT /subnets/:id/dhcp. Gateway and NextServer only apply to IPv4 subnets.
+type DHCPOptions struct {
+	Gateway    string   `json:"gateway,omitempty"`
+	DNS        []string `json:"dns,omitempty"`
+	Domain     string   `json:"domain,omitempty"`
+	NextServer string   `json:"next_server,omitempty"`
+	BootFile   string   `json:"boot_file,omitempty"`
+}
+
+// DHCPOptionsEntity stores the DHCP options of a subnet
+type DHCPOptionsEntity struct {
+	SubnetID   string `gorm:"primary_key;size:64"`
+	Gateway    string
+	DNS        string `gorm:"column:dns"`
+	Domain     string
+	NextServer string
+	BootFile   string
+	UpdatedAt  time.Time
+}
+
+// NewDHCPOptionsEntity returns the entity storing options for a subnet
+func NewDHCPOptionsEntity(subnetID string, options DHCPOptions) DHCPOptionsEntity {
+	return DHCPOptionsEntity{
+		SubnetID:   subnetID,
+		Gateway:    options.Gateway,
+		DNS:        strings.Join(options.DNS, ","),
+		Domain:     options.Domain,
+		NextServer: options.NextServer,
+		BootFile:   options.BootFile,
+	}
+}
+
+// Options returns the options stored in e
+func (e DHCPOptionsEntity) Options() DHCPOptions {
+	options := DHCPOptions{
+		Gateway:    e.Gateway,
+		Domain:     e.Domain,
+		NextServer: e.NextServer,
+		BootFile:   e.BootFile,
+	}
+	if e.DNS != "" {
+		options.DNS = strings.Split(e.DNS, ",")
+	}
+	return options
+}
@@ -1,8 +1,144 @@
 package model
 
+import "time"
+
 type Houston struct {
 	Name string `json:"name"`
 }
 
 type Nodes struct {
 }
+
+// Node is a node as reported by the inventory service, which defines its fields
+type Node map[string]interface{}
+
+// NodeEvent is published to the Houston exchange when a node is created, updated or deleted
+type NodeEvent struct {
+	ID         string `json:"id"`
+	Action     string `json:"action"`
+	ObjectType string `json:"objectType"`
+}
+
+// ID returns the node's identifier, which inventory reports as "id" or "ID"
+func (n Node) ID() string {
+	for _, key := range []string{"id", "ID"} {
+		if id, ok := n[key].(string); ok {
+			return id
+		}
+	}
+	return ""
+}
+
+// MACs returns the MAC addresses of the node's NICs, which inventory lists
+// in "nics" with a "mac" each
+func (n Node) MACs() []string {
+	nics, _ := n["nics"].([]interface{})
+	macs := []string{}
+	for _, nic := range nics {
+		fields, _ := nic.(map[string]interface{})
+		if mac, ok := fields["mac"].(string); ok {
+			macs = append(macs, mac)
+		}
+	}
+	return macs
+}
+
+// Identity is the authenticated caller of an API request
+type Identity struct {
+	Subject string `json:"subject"`
+	Method  string `json:"method"`
+	Role    string `json:"role,omitempty"`
+}
+
+// APIKeyEntity is a static API key. Only the SHA-256 hash of the key is stored.
+type APIKeyEntity struct {
+	ID        uint      `gorm:"primary_key" json:"id"`
+	Name      string    `json:"name"`
+	KeyHash   string    `gorm:"unique_index" json:"-"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// RoleBindingEntity grants a role to an authenticated subject
+type RoleBindingEntity struct {
+	ID        uint      `gorm:"primary_key" json:"-"`
+	Subject   string    `gorm:"unique_index" json:"subject"`
+	Role      string    `json:"role"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// RoleBindingRequest is the body of PUT /rolebindings/:subject
+type RoleBindingRequest struct {
+	Role string `json:"role" binding:"required"`
+}
+
+// AuditEntity records a mutating API call, or a command Houston sent to another Voyager service
+type AuditEntity struct {
+	ID   uint      `gorm:"primary_key" json:"id"`
+	Time time.Time `gorm:"index" json:"time"`
+	// Kind is api or command
+	Kind   string `json:"kind"`
+	Actor  string `gorm:"index" json:"actor"`
+	Action string `json:"action"`
+	Target string `json:"target"`
+	// PayloadHash is the SHA-256 of the request body or command message
+	PayloadHash string `json:"payload_hash,omitempty"`
+	// Result is the HTTP status of an API call, or ok or the error of a command
+	Result string `json:"result"`
+	// CorrelationID is the request ID of an API call, or the AMQP correlation ID of a command
+	CorrelationID string `gorm:"index" json:"correlation_id"`
+	// RequestID is the API request a command was sent for
+	RequestID string `gorm:"index" json:"request_id,omitempty"`
+}
+
+// Job statuses
+const (
+	JobQueued    = "queued"
+	JobRunning   = "running"
+	JobSucceeded = "succeeded"
+	JobFailed    = "failed"
+	JobCanceled  = "canceled"
+)
+
+// JSONText is a JSON document stored as text and returned as is
+type JSONText string
+
+// MarshalJSON returns the document, or null if it is empty
+func (t JSONText) MarshalJSON() ([]byte, error) {
+	if t == "" {
+		return []byte("null"), nil
+	}
+	return []byte(t), nil
+}
+
+// UnmarshalJSON stores the document as is
+func (t *JSONText) UnmarshalJSON(data []byte) error {
+	*t = JSONText(data)
+	return nil
+}
+
+// JobEntity is a long-running operation started by an API request
+type JobEntity struct {
+	ID     string `gorm:"primary_key" json:"id"`
+	Kind   string `gorm:"index" json:"kind"`
+	Status string `gorm:"index" json:"status"`
+	// Progress is the percentage of the job done
+	Progress int    `json:"progress"`
+	Message  string `json:"message,omitempty"`
+	// Request is what the job was asked to do, Result what it did
+	Request   JSONText   `gorm:"type:text" json:"request"`
+	Result    JSONText   `gorm:"type:text" json:"result,omitempty"`
+	Error     string     `gorm:"type:text" json:"error,omitempty"`
+	Actor     string     `gorm:"index" json:"actor,omitempty"`
+	RequestID string     `gorm:"index" json:"request_id,omitempty"`
+	CreatedAt time.Time  `gorm:"index" json:"created_at"`
+	UpdatedAt time.Time  `json:"updated_at"`
+	StartedAt *time.Time `json:"started_at,omitempty"`
+	// FinishedAt is set once the job has succeeded, failed or been canceled
+	FinishedAt *time.Time `json:"finished_at,omitempty"`
+}
+
+// Finished reports whether the job has stopped for good
+func (j JobEntity) Finished() bool {
+	return j.Status != JobQueued && j.Status != JobRunning
+}
@@ -0,0 +1,288 @@
+package model
+
+import (
+	"time"
+
+	"github.com/RackHD/voyager-utilities/models"
+)
+
+// Actions and object types Houston uses in addition to the ones in voyager-utilities
+const (
+	UpdateAction = "update"
+	DeleteAction = "delete"
+	LeaseType    = "lease"
+	NodeType     = "node"
+)
+
+// Address families of subnets and leases
+const (
+	FamilyIPv4 = "ipv4"
+	FamilyIPv6 = "ipv6"
+)
+
+// PoolRangeEntity is a prefix of a pool. The subnets of a pool with prefixes
+// must lie within one of them.
+type PoolRangeEntity struct {
+	PoolID string `gorm:"primary_key;size:64" json:"-"`
+	Prefix string `gorm:"primary_key;size:64" json:"prefix"`
+}
+
+// Pool is a pool with the prefixes Houston records for it
+type Pool struct {
+	models.PoolEntity
+	Prefixes []string `json:"prefixes,omitempty"`
+}
+
+// SubnetRangeEntity is the address family and range Houston records for a
+// subnet, which voyager-utilities' SubnetEntity has no fields for
+type SubnetRangeEntity struct {
+	SubnetID string `gorm:"primary_key;size:64" json:"-"`
+	Family   string `gorm:"size:4" json:"family"`
+	Prefix   string `json:"prefix,omitempty"`
+	Start    string `json:"start"`
+	End      string `json:"end"`
+}
+
+// Subnet is a subnet with its address family and range. Subnets created
+// before Houston recorded ranges have none.
+type Subnet struct {
+	models.SubnetEntity
+	*SubnetRangeEntity
+}
+
+// IPAMLeaseMsg is the message exchanged with voyager-ipam-service to allocate and release addresses
+type IPAMLeaseMsg struct {
+	ID         string `json:"id"`
+	Action     string `json:"action"`
+	ObjectType string `json:"objectType"`
+	Subnet     string `json:"subnet"`
+	Node       string `json:"node"`
+	Address    string `json:"address"`
+}
+
+// LeaseEntity is an address allocated to a node from a subnet. The lease
+// sweeper releases it once ExpiresAt has passed, or once its node has been
+// missing from inventory since MissingSince for longer than the grace period.
+type LeaseEntity struct {
+	ID           string     `gorm:"primary_key" json:"id"`
+	SubnetID     string     `json:"subnet"`
+	NodeID       string     `json:"node"`
+	Address      string     `json:"address"`
+	Family       string     `gorm:"size:4" json:"family,omitempty"`
+	CreatedAt    time.Time  `json:"created_at"`
+	ExpiresAt    *time.Time `json:"expires_at,omitempty"`
+	MissingSince *time.Time `json:"missing_since,omitempty"`
+}
+
+// Reasons the lease sweeper reclaims a lease
+const (
+	ReclaimExpired  = "expired"
+	ReclaimNodeGone = "node_gone"
+)
+
+// ReclaimedLease is a lease the sweeper released, or would release in a dry run.
+// Since is when the lease expired or its node went missing.
+type ReclaimedLease struct {
+	Lease  LeaseEntity `json:"lease"`
+	Reason string      `json:"reason"`
+	Since  time.Time   `json:"since"`
+	Error  string      `json:"error,omitempty"`
+}
+
+// SweepReport is the outcome of a lease sweep. InventoryError is set when
+// the nodes could not be listed, in which case only expired leases are
+// reclaimed.
+type SweepReport struct {
+	DryRun         bool             `json:"dry_run"`
+	Time           time.Time        `json:"time"`
+	Leases         []ReclaimedLease `json:"leases"`
+	InventoryError string           `json:"inventory_error,omitempty"`
+	// NodeGoneSkipped says why leases of nodes gone from inventory were kept
+	NodeGoneSkipped string `json:"node_gone_skipped,omitempty"`
+}
+
+// ReservationEntity is an address of a subnet set aside for the node with a
+// MAC address, or for a node by ID. Houston holds the address in IPAM as
+// LeaseID from the moment it is reserved, and records that lease as the
+// node's once the node is known.
+type ReservationEntity struct {
+	SubnetID  string    `gorm:"primary_key;size:64" json:"subnet"`
+	Address   string    `gorm:"primary_key;size:64" json:"address"`
+	MAC       string    `gorm:"column:mac;size:17;index" json:"mac,omitempty"`
+	NodeID    string    `gorm:"index" json:"node,omitempty"`
+	LeaseID   string    `json:"lease"`
+	CreatedAt time.Time `json:"created_at"`
+	// AssignedTo is the node the address is leased to, if any
+	AssignedTo string `gorm:"-" json:"assigned_to,omitempty"`
+}
+
+// ReservationRequest is the body of POST /subnets/:id/reservations. It
+// reserves Address for the node with MAC, or for Node, but not both.
+type ReservationRequest struct {
+	Address string `json:"address" binding:"required"`
+	MAC     string `json:"mac,omitempty"`
+	Node    string `json:"node,omitempty"`
+}
+
+// Usage counts the addresses of a subnet, or of the subnets of a pool.
+// Total is the size of the subnet's prefix, or of its range if it has none.
+// Reserved addresses are those of the prefix outside the range, such as the
+// network and broadcast addresses, and those held by reservations that are
+// not leased to their node yet. Counts too large for a uint64 are given
+// as the largest uint64.
+type Usage struct {
+	Total     uint64 `json:"total"`
+	Allocated uint64 `json:"allocated"`
+	Reserved  uint64 `json:"reserved"`
+	Free      uint64 `json:"free"`
+	// LargestFreeBlock is the longest run of free addresses, which begins at
+	// LargestFreeStart. Runs do not continue from one subnet into the next.
+	LargestFreeBlock uint64 `json:"largest_free_block"`
+	LargestFreeStart string `json:"largest_free_start,omitempty"`
+}
+
+// SubnetUsage is the usage of a subnet, served at GET /subnets/:id/usage
+type SubnetUsage struct {
+	Subnet string `json:"subnet"`
+	Pool   string `json:"pool"`
+	Usage
+}
+
+// PoolUsage is the usage of the subnets of a pool, served at
+// GET /pools/:id/usage. Subnets Houston recorded no range for are listed in
+// Unranged and not counted.
+type PoolUsage struct {
+	Pool string `json:"pool"`
+	Usage
+	Subnets  []SubnetUsage `json:"subnets"`
+	Unranged []string      `json:"unranged,omitempty"`
+}
+
+// CreatePoolRequest is the body of POST /pools
+type CreatePoolRequest struct {
+	Name     string `json:"name" binding:"required"`
+	Metadata string `json:"metadata"`
+	// Prefixes the pool's subnets must lie within, e.g. 10.0.0.0/16 and 2001:db8::/48
+	Prefixes []string `json:"prefixes,omitempty"`
+}
+
+// CreateSubnetRequest is the body of POST /subnets. The subnet is given by
+// Prefix in CIDR notation, by Start and End, or by both to use part of the
+// prefix. Start and End default to the first and last usable addresses of Prefix.
+type CreateSubnetRequest struct {
+	Name   string `json:"name" binding:"required"`
+	Pool   string `json:"pool" binding:"required"`
+	Prefix string `json:"prefix,omitempty"`
+	Start  string `json:"start,omitempty"`
+	End    string `json:"end,omitempty"`
+}
+
+// AllocateAddressRequest is the body of POST /addresses
+type AllocateAddressRequest struct {
+	Subnet string `json:"subnet" binding:"required"`
+	Node   string `json:"node" binding:"required"`
+	// TTL is how long the lease lasts, e.g. "72h". Leases without one do not expire.
+	TTL string `json:"ttl,omitempty"`
+}
+
+// AllocateAddressesRequest is the body of POST /nodes/:id/addresses, which
+// leases an address in each subnet to the node, e.g. one IPv4 and one IPv6
+type AllocateAddressesRequest struct {
+	Subnets []string `json:"subnets" binding:"required"`
+	TTL     string   `json:"ttl,omitempty"`
+}
+
+// Created is the response to a request that created an object
+type Created struct {
+	ID string `json:"id"`
+}
+
+// IPAMExportVersion is the version of the documents GET /ipam/export
+// produces. POST /ipam/import refuses documents of other versions.
+const IPAMExportVersion = 1
+
+// IPAMExport is every pool, subnet, reservation and lease Houston has, as
+// served by GET /ipam/export and replayed by POST /ipam/import. IDs are those
+// of the exporting Houston; the IPAM service gives imported objects new ones.
+type IPAMExport struct {
+	Version      int                   `json:"version" binding:"required"`
+	Exported     time.Time             `json:"exported"`
+	Pools        []ExportedPool        `json:"pools"`
+	Subnets      []ExportedSubnet      `json:"subnets"`
+	Reservations []ExportedReservation `json:"reservations"`
+	Leases       []ExportedLease       `json:"leases"`
+}
+
+// ExportedPool is a pool in an IPAMExport
+type ExportedPool struct {
+	ID       string   `json:"id"`
+	Name     string   `json:"name"`
+	Prefixes []string `json:"prefixes,omitempty"`
+}
+
+// ExportedSubnet is a subnet in an IPAMExport. Subnets Houston recorded no
+// range for have no Family, Start or End, and cannot be imported.
+type ExportedSubnet struct {
+	ID     string       `json:"id"`
+	Name   string       `json:"name"`
+	Pool   string       `json:"pool"`
+	Family string       `json:"family,omitempty"`
+	Prefix string       `json:"prefix,omitempty"`
+	Start  string       `json:"start,omitempty"`
+	End    string       `json:"end,omitempty"`
+	DHCP   *DHCPOptions `json:"dhcp,omitempty"`
+}
+
+// ExportedReservation is a reservation in an IPAMExport
+type ExportedReservation struct {
+	Subnet  string `json:"subnet"`
+	Address string `json:"address"`
+	MAC     string `json:"mac,omitempty"`
+	Node    string `json:"node,omitempty"`
+}
+
+// ExportedLease is a lease in an IPAMExport
+type ExportedLease struct {
+	ID        string     `json:"id"`
+	Subnet    string     `json:"subnet"`
+	Node      string     `json:"node"`
+	Address   string     `json:"address"`
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+}
+
+// Kinds of objects in an import report
+const (
+	ImportPool        = "pool"
+	ImportSubnet      = "subnet"
+	ImportReservation = "reservation"
+	ImportLease       = "lease"
+)
+
+// Outcomes of importing an object. A dry run reports the outcome an import
+// would have.
+const (
+	ImportCreated  = "created"
+	ImportExists   = "exists"
+	ImportUpdated  = "updated"
+	ImportConflict = "conflict"
+	ImportSkipped  = "skipped"
+	ImportFailed   = "failed"
+)
+
+// ImportItem is the outcome of importing one object of an IPAMExport. ID is
+// its ID in the document, NewID its ID here.
+type ImportItem struct {
+	Kind    string `json:"kind"`
+	ID      string `json:"id"`
+	Outcome string `json:"outcome"`
+	NewID   string `json:"new_id,omitempty"`
+	Message string `json:"message,omitempty"`
+}
+
+// ImportReport is the response to POST /ipam/import, with Counts of the
+// items by outcome
+type ImportReport struct {
+	DryRun bool           `json:"dry_run"`
+	Items  []ImportItem   `json:"items"`
+	Counts map[string]int `json:"counts"`
+}
@@ -0,0 +1,18 @@
+package model
+
+import "time"
+
+// LabelEntity is a key/value label Houston stores on a node. Houston's labels
+// are merged over the labels the inventory service reports.
+type LabelEntity struct {
+	NodeID    string    `gorm:"primary_key;size:64" json:"node"`
+	Key       string    `gorm:"primary_key;size:63" json:"key"`
+	Value     string    `gorm:"size:63" json:"value"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// Labels are the labels of a node, by key
+type Labels map[string]string
+
+// LabelsPatch is the body of PATCH /nodes/:id/labels. Keys set to null are removed.
+type LabelsPatch map[string]*string
@@ -0,0 +1,61 @@
+package model
+
+// Power actions of POST /nodes/:id/power. Status only reads the power state,
+// as GET /nodes/:id/power does.
+const (
+	PowerOn     = "on"
+	PowerOff    = "off"
+	PowerReset  = "reset"
+	PowerCycle  = "cycle"
+	PowerStatus = "status"
+)
+
+// Boot devices of POST /nodes/:id/boot-device
+const (
+	BootPXE  = "pxe"
+	BootDisk = "disk"
+	BootBIOS = "bios"
+)
+
+// PowerRequest is the body of POST /nodes/:id/power
+type PowerRequest struct {
+	Action string `json:"action" binding:"required"`
+}
+
+// BootDeviceRequest is the body of POST /nodes/:id/boot-device. The device is
+// used for the next boot only unless Persistent is set.
+type BootDeviceRequest struct {
+	Device     string `json:"device" binding:"required"`
+	Persistent bool   `json:"persistent"`
+}
+
+// OOBCommand is the message sent to voyager-oob-service to control a node out of band
+type OOBCommand struct {
+	Command    string `json:"command"`
+	NodeID     string `json:"nodeId"`
+	Action     string `json:"action,omitempty"`
+	Device     string `json:"device,omitempty"`
+	Persistent bool   `json:"persistent,omitempty"`
+}
+
+// OOBReply is the reply of voyager-oob-service to an OOBCommand
+type OOBReply struct {
+	PowerState string `json:"powerState"`
+	// Error is set when the BMC refused or failed the command
+	Error string `json:"error"`
+}
+
+// PowerResult is the response to GET and POST /nodes/:id/power
+type PowerResult struct {
+	Node   string `json:"node"`
+	Action string `json:"action"`
+	// PowerState is the power state the BMC reports after the action, on or off
+	PowerState string `json:"power_state,omitempty"`
+}
+
+// BootDeviceResult is the response to POST /nodes/:id/boot-device
+type BootDeviceResult struct {
+	Node       string `json:"node"`
+	Device     string `json:"device"`
+	Persistent bool   `json:"persistent"`
+}
@@ -0,0 +1,43 @@
+package model
+
+import "time"
+
+// Switch is a switch as reported by the inventory service, which defines its fields
+type Switch map[string]interface{}
+
+// ID returns the switch's identifier, which inventory reports as "id" or "ID"
+func (s Switch) ID() string {
+	return Node(s).ID()
+}
+
+// SwitchPort is a port of a switch as reported by the inventory service
+type SwitchPort map[string]interface{}
+
+// Name returns the port's name, as connections refer to it
+func (p SwitchPort) Name() string {
+	name, _ := p["name"].(string)
+	return name
+}
+
+// InventoryCommand is the message sent to voyager-inventory-service
+type InventoryCommand struct {
+	Command string `json:"command"`
+	Options string `json:"options"`
+}
+
+// ConnectionEntity records that a NIC of a node is cabled to a switch port.
+// A port connects to one NIC at most.
+type ConnectionEntity struct {
+	NodeID    string    `gorm:"primary_key;size:64" json:"node"`
+	NIC       string    `gorm:"column:nic;primary_key;size:64" json:"nic"`
+	SwitchID  string    `gorm:"size:64;unique_index:idx_switch_port" json:"switch"`
+	Port      string    `gorm:"size:64;unique_index:idx_switch_port" json:"port"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// ConnectionRequest is the body of PUT /nodes/:id/connections/:nic
+type ConnectionRequest struct {
+	Switch string `json:"switch" binding:"required"`
+	Port   string `json:"port" binding:"required"`
+}
@@ -0,0 +1,84 @@
+package model
+
+import "time"
+
+// DefaultRackHeight is the height in rack units of racks created without one
+const DefaultRackHeight = 42
+
+// SiteEntity is a data center
+type SiteEntity struct {
+	ID          string    `gorm:"primary_key" json:"id"`
+	Name        string    `gorm:"unique_index" json:"name"`
+	Description string    `json:"description,omitempty"`
+	CreatedAt   time.Time `json:"created_at"`
+	UpdatedAt   time.Time `json:"updated_at"`
+}
+
+// RoomEntity is a room of a site
+type RoomEntity struct {
+	ID        string    `gorm:"primary_key" json:"id"`
+	SiteID    string    `gorm:"index" json:"site"`
+	Name      string    `json:"name"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// RackEntity is a rack in a room. Its units are numbered from 1 at the bottom.
+type RackEntity struct {
+	ID        string    `gorm:"primary_key" json:"id"`
+	RoomID    string    `gorm:"index" json:"room"`
+	Name      string    `json:"name"`
+	Height    int       `json:"height"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// PlacementEntity puts a node in a rack, in Height units from Position up
+type PlacementEntity struct {
+	NodeID    string    `gorm:"primary_key;size:64" json:"node"`
+	RackID    string    `gorm:"index" json:"rack"`
+	Position  int       `json:"position"`
+	Height    int       `json:"height"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// Top returns the highest unit the node takes up
+func (p PlacementEntity) Top() int {
+	return p.Position + p.Height - 1
+}
+
+// SiteRequest is the body of POST /sites and PUT /sites/:id
+type SiteRequest struct {
+	Name        string `json:"name" binding:"required"`
+	Description string `json:"description"`
+}
+
+// RoomRequest is the body of POST /rooms and PUT /rooms/:id
+type RoomRequest struct {
+	Site string `json:"site" binding:"required"`
+	Name string `json:"name" binding:"required"`
+}
+
+// RackRequest is the body of POST /racks and PUT /racks/:id
+type RackRequest struct {
+	Room   string `json:"room" binding:"required"`
+	Name   string `json:"name" binding:"required"`
+	Height int    `json:"height"`
+}
+
+// PlacementRequest is the body of PUT /nodes/:id/placement
+type PlacementRequest struct {
+	Rack     string `json:"rack" binding:"required"`
+	Position int    `json:"position" binding:"required"`
+	Height   int    `json:"height"`
+}
+
+// Location is where a node lives, as added to the nodes listed by GET /nodes
+type Location struct {
+	Site     string `json:"site"`
+	Room     string `json:"room"`
+	Rack     string `json:"rack"`
+	Position int    `json:"position"`
+	Height   int    `json:"height"`
+}
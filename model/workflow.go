@@ -0,0 +1,67 @@
+package model
+
+import "time"
+
+// Workflow statuses, as RackHD reports them
+const (
+	WorkflowPending   = "pending"
+	WorkflowRunning   = "running"
+	WorkflowSucceeded = "succeeded"
+	WorkflowFailed    = "failed"
+	WorkflowCancelled = "cancelled"
+)
+
+// WorkflowType is the object type of WorkflowEvents
+const WorkflowType = "workflow"
+
+// WorkflowCommand is the message sent to voyager-rackhd-service to run or
+// cancel a workflow on a node
+type WorkflowCommand struct {
+	Command string                 `json:"command"`
+	NodeID  string                 `json:"nodeId"`
+	Graph   string                 `json:"graph,omitempty"`
+	Options map[string]interface{} `json:"options,omitempty"`
+}
+
+// WorkflowReply is the reply of voyager-rackhd-service to a WorkflowCommand
+type WorkflowReply struct {
+	ID     string `json:"instanceId"`
+	Status string `json:"status"`
+	// Error is set when RackHD refused the command
+	Error string `json:"error"`
+}
+
+// WorkflowEvent is published to the Houston exchange when a workflow changes status
+type WorkflowEvent struct {
+	ID         string `json:"instanceId"`
+	NodeID     string `json:"nodeId"`
+	Graph      string `json:"graph"`
+	Status     string `json:"status"`
+	ObjectType string `json:"objectType"`
+}
+
+// WorkflowEntity is a RackHD workflow run on a node, with its last known status
+type WorkflowEntity struct {
+	ID        string    `gorm:"primary_key" json:"id"`
+	NodeID    string    `gorm:"index" json:"node"`
+	Graph     string    `json:"graph"`
+	Options   JSONText  `gorm:"type:text" json:"options,omitempty"`
+	Status    string    `gorm:"index" json:"status"`
+	Actor     string    `json:"actor,omitempty"`
+	RequestID string    `json:"request_id,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+	// FinishedAt is set once the workflow has succeeded, failed or been cancelled
+	FinishedAt *time.Time `json:"finished_at,omitempty"`
+}
+
+// Active reports whether the workflow is still pending or running
+func (w WorkflowEntity) Active() bool {
+	return w.Status == WorkflowPending || w.Status == WorkflowRunning
+}
+
+// RunWorkflowRequest is the body of POST /nodes/:id/workflows
+type RunWorkflowRequest struct {
+	Graph   string                 `json:"graph" binding:"required"`
+	Options map[string]interface{} `json:"options"`
+}
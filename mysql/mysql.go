@@ -7,9 +7,37 @@ import (
 
 	_ "github.com/go-sql-driver/mysql" // Blank import because the library says to.
 	"github.com/jinzhu/gorm"
+	"github.com/RackHD/voyager-houston/model"
 	"github.com/RackHD/voyager-utilities/models"
 )
 
+// sharedTables hold the voyager-utilities entities Houston stores
+var sharedTables = []interface{}{
+	&models.NodeEntity{},
+	&models.PoolEntity{},
+	&models.SubnetEntity{},
+}
+
+// houstonTables hold the entities defined by Houston
+var houstonTables = []interface{}{
+	&model.APIKeyEntity{},
+	&model.RoleBindingEntity{},
+	&model.PoolRangeEntity{},
+	&model.SubnetRangeEntity{},
+	&model.LeaseEntity{},
+	&model.ReservationEntity{},
+	&model.DHCPOptionsEntity{},
+	&model.AuditEntity{},
+	&model.JobEntity{},
+	&model.WorkflowEntity{},
+	&model.LabelEntity{},
+	&model.SiteEntity{},
+	&model.RoomEntity{},
+	&model.RackEntity{},
+	&model.PlacementEntity{},
+	&model.ConnectionEntity{},
+}
+
 // DBconn is a struct for maintaining a connection to the MySQL Database
 type DBconn struct {
 	DB *gorm.DB
@@ -36,21 +64,19 @@ func (d *DBconn) Initialize(address string) error {
 			continue
 		}
 
-		if hasNodes := d.DB.HasTable(&models.NodeEntity{}); !hasNodes {
-			if err = d.DB.CreateTable(&models.NodeEntity{}).Error; err != nil {
-				log.Printf("Error creating Node table: %s\n", err)
-			}
-		}
-
-		if hasPools := d.DB.HasTable(&models.PoolEntity{}); !hasPools {
-			if err = d.DB.CreateTable(&models.PoolEntity{}).Error; err != nil {
-				log.Printf("Error creating Pool table: %s\n", err)
+		for _, table := range sharedTables {
+			if hasTable := d.DB.HasTable(table); !hasTable {
+				if err = d.DB.CreateTable(table).Error; err != nil {
+					log.Printf("Error creating %T table: %s\n", table, err)
+				}
 			}
 		}
 
-		if hasSubnets := d.DB.HasTable(&models.SubnetEntity{}); !hasSubnets {
-			if err = d.DB.CreateTable(&models.SubnetEntity{}).Error; err != nil {
-				log.Printf("Error creating Pool table: %s\n", err)
+		// Houston's own tables are migrated so new columns are added to existing databases
+		for _, table := range houstonTables {
+			if err = d.DB.AutoMigrate(table).Error; err != nil {
+				log.Printf("Error migrating %T table: %s\n", table, err)
+				break
 			}
 		}
 
@@ -0,0 +1,224 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sync"
+
+	"github.com/RackHD/voyager-houston/model"
+	"github.com/RackHD/voyager-utilities/models"
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	// jobNodeActions is the kind of the jobs started by POST /node-actions
+	jobNodeActions = "node_actions"
+	// defaultParallelism is how many nodes POST /node-actions acts on at once unless told otherwise
+	defaultParallelism = 8
+	// maxParallelism is the most nodes POST /node-actions acts on at once
+	maxParallelism = 64
+)
+
+// nodeActionPermissions is the permission each type of node action needs
+var nodeActionPermissions = map[string]Permission{
+	model.PowerAction:    PermNodesPower,
+	model.WorkflowAction: PermWorkflowsRun,
+	model.AllocateAction: PermAddressAllocate,
+	model.TagAction:      PermNodesTag,
+}
+
+// TagNode adds tags to a node in RackHD
+func (s *Server) TagNode(ctx context.Context, nodeID string, tags []string) error {
+	_, err := s.rackhdRequest(ctx, "add_tags", model.TagCommand{Command: "add_tags", NodeID: nodeID, Tags: tags})
+	return err
+}
+
+// NodeActionsHandler Serves POST /node-actions
+func (s *Server) NodeActionsHandler(c *gin.Context) {
+	request := model.NodeActionsRequest{}
+	if !bindJSON(c, &request) {
+		return
+	}
+	if request.Selector.Empty() {
+		abortWithError(c, http.StatusBadRequest, fmt.Errorf("The selector must name ids, labels or a filter"))
+		return
+	}
+	permission, ok := nodeActionPermissions[request.Action.Type]
+	if !ok {
+		abortWithError(c, http.StatusBadRequest, fmt.Errorf("Unknown action type %q", request.Action.Type))
+		return
+	}
+	if !s.authorize(c, permission) {
+		return
+	}
+	if err := checkNodeAction(request.Action); err != nil {
+		abortWithError(c, http.StatusBadRequest, err)
+		return
+	}
+	if request.Action.Type == model.AllocateAction {
+		subnet := models.SubnetEntity{}
+		if !s.find(c, &subnet, request.Action.Subnet) {
+			return
+		}
+	}
+
+	request.Parallelism = clampParallelism(request.Parallelism)
+	s.startJob(c, jobNodeActions, request)
+}
+
+// clampParallelism returns parallelism within 1 and maxParallelism, or
+// defaultParallelism if it is not positive
+func clampParallelism(parallelism int) int {
+	if parallelism <= 0 {
+		return defaultParallelism
+	}
+	if parallelism > maxParallelism {
+		return maxParallelism
+	}
+	return parallelism
+}
+
+// checkNodeAction returns an error if action lacks the fields its type needs
+func checkNodeAction(action model.NodeAction) error {
+	switch action.Type {
+	case model.PowerAction:
+		if action.Power == "" {
+			return fmt.Errorf("A power action needs action.power")
+		}
+		if !powerActions[action.Power] || action.Power == model.PowerStatus {
+			return fmt.Errorf("Unknown power action %q", action.Power)
+		}
+	case model.WorkflowAction:
+		if action.Workflow == nil || action.Workflow.Graph == "" {
+			return fmt.Errorf("A workflow action needs action.workflow.graph")
+		}
+	case model.AllocateAction:
+		if action.Subnet == "" {
+			return fmt.Errorf("An allocate_address action needs action.subnet")
+		}
+	case model.TagAction:
+		if len(action.Tags) == 0 {
+			return fmt.Errorf("A tag action needs action.tags")
+		}
+	}
+	return nil
+}
+
+// nodeActionsJob runs the action of a POST /node-actions request on the
+// selected nodes. The job fails if the action failed on any node, and its
+// result lists the outcome on each.
+func nodeActionsJob(s *Server, ctx context.Context, job *jobRun) (interface{}, error) {
+	request := model.NodeActionsRequest{}
+	if err := job.decode(&request); err != nil {
+		return nil, err
+	}
+
+	ids, missing, err := s.selectNodes(ctx, request.Selector)
+	if err != nil {
+		return nil, err
+	}
+
+	result := s.runNodeActions(ctx, job, request, ids, missing)
+	if result.Failed > 0 {
+		return result, fmt.Errorf("Action failed on %d of %d nodes", result.Failed, result.Total)
+	}
+	return result, nil
+}
+
+// runNodeActions runs the action on the nodes, no more than
+// request.Parallelism at once. Nodes the inventory does not know fail. Once
+// the failures reach the limit the request sets, or ctx is done, the nodes
+// not yet started are skipped.
+func (s *Server) runNodeActions(ctx context.Context, job *jobRun, request model.NodeActionsRequest, ids, missing []string) model.NodeActionsResult {
+	result := model.NodeActionsResult{
+		Total: len(missing) + len(ids),
+		Nodes: make([]model.NodeOutcome, len(missing)+len(ids)),
+	}
+
+	mu := sync.Mutex{}
+	stopped := false
+	done := 0
+	// record stores an outcome and reports progress. It must be called with mu held.
+	record := func(i int, outcome model.NodeOutcome) {
+		result.Nodes[i] = outcome
+		switch outcome.Status {
+		case model.OutcomeSucceeded:
+			result.Succeeded++
+		case model.OutcomeFailed:
+			result.Failed++
+			if request.StopOnFirstError || (request.MaxFailures > 0 && result.Failed >= request.MaxFailures) {
+				stopped = true
+			}
+		case model.OutcomeSkipped:
+			result.Skipped++
+		}
+		done++
+		job.progress(done * 100 / result.Total)
+	}
+
+	for i, id := range missing {
+		record(i, model.NodeOutcome{Node: id, Status: model.OutcomeFailed, Error: fmt.Sprintf("Node %s not found in the inventory", id)})
+	}
+
+	slots := make(chan struct{}, clampParallelism(request.Parallelism))
+	wg := sync.WaitGroup{}
+	for n, id := range ids {
+		i := len(missing) + n
+
+		acquired := false
+		select {
+		case slots <- struct{}{}:
+			acquired = true
+		case <-ctx.Done():
+		}
+		mu.Lock()
+		skip := stopped || ctx.Err() != nil
+		if skip {
+			record(i, model.NodeOutcome{Node: id, Status: model.OutcomeSkipped})
+		}
+		mu.Unlock()
+		if skip {
+			if acquired {
+				<-slots
+			}
+			continue
+		}
+
+		wg.Add(1)
+		go func(i int, id string) {
+			defer wg.Done()
+			defer func() { <-slots }()
+
+			outcome := model.NodeOutcome{Node: id, Status: model.OutcomeSucceeded}
+			value, err := s.nodeAction(ctx, id, request.Action)
+			if err != nil {
+				outcome.Status = model.OutcomeFailed
+				outcome.Error = err.Error()
+			} else {
+				outcome.Result = value
+			}
+
+			mu.Lock()
+			record(i, outcome)
+			mu.Unlock()
+		}(i, id)
+	}
+	wg.Wait()
+	return result
+}
+
+// nodeAction runs action on one node
+func (s *Server) nodeAction(ctx context.Context, nodeID string, action model.NodeAction) (interface{}, error) {
+	switch action.Type {
+	case model.PowerAction:
+		return s.Power(ctx, nodeID, action.Power)
+	case model.WorkflowAction:
+		return s.RunWorkflow(ctx, nodeID, *action.Workflow)
+	case model.AllocateAction:
+		return s.AllocateAddress(ctx, action.Subnet, nodeID, 0)
+	case model.TagAction:
+		return nil, s.TagNode(ctx, nodeID, action.Tags)
+	}
+	return nil, fmt.Errorf("Unknown action type %q", action.Type)
+}
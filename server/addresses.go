@@ -0,0 +1,262 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/netip"
+	"sort"
+	"strings"
+
+	"github.com/RackHD/voyager-houston/model"
+)
+
+// parseAddr parses an IPv4 or IPv6 address, treating IPv4-mapped IPv6
+// addresses as IPv4
+func parseAddr(name, text string) (netip.Addr, error) {
+	addr, err := netip.ParseAddr(text)
+	if err != nil || addr.Zone() != "" {
+		return netip.Addr{}, fmt.Errorf("Invalid %s address %q", name, text)
+	}
+	return addr.Unmap(), nil
+}
+
+// family returns the address family of addr
+func family(addr netip.Addr) string {
+	if addr.Is4() {
+		return model.FamilyIPv4
+	}
+	return model.FamilyIPv6
+}
+
+// addressFamily returns the address family of an address, or "" if it is not one
+func addressFamily(text string) string {
+	addr, err := netip.ParseAddr(text)
+	if err != nil {
+		return ""
+	}
+	return family(addr.Unmap())
+}
+
+// lastAddr returns the last address of a prefix
+func lastAddr(p netip.Prefix) netip.Addr {
+	b := p.Addr().AsSlice()
+	for i := p.Bits(); i < len(b)*8; i++ {
+		b[i/8] |= 0x80 >> uint(i%8)
+	}
+	addr, _ := netip.AddrFromSlice(b)
+	return addr
+}
+
+// usableRange returns the first and last addresses of a prefix that can be
+// leased. The network and broadcast addresses of IPv4 prefixes and the
+// subnet-router anycast address of IPv6 prefixes are left out, except in
+// point-to-point and host prefixes.
+func usableRange(p netip.Prefix) (first, last netip.Addr) {
+	first, last = p.Addr(), lastAddr(p)
+	if p.Bits() < first.BitLen()-1 {
+		first = first.Next()
+		if first.Is4() {
+			last = last.Prev()
+		}
+	}
+	return first, last
+}
+
+// enclosingPrefix returns the smallest prefix that holds first and last
+func enclosingPrefix(first, last netip.Addr) netip.Prefix {
+	for bits := first.BitLen(); bits > 0; bits-- {
+		if p := netip.PrefixFrom(first, bits).Masked(); p.Contains(last) {
+			return p
+		}
+	}
+	return netip.PrefixFrom(first, 0).Masked()
+}
+
+// invalidRangeError is returned for a well-formed range that cannot be used
+func invalidRangeError(format string, args ...interface{}) *APIError {
+	return &APIError{
+		Status:  http.StatusUnprocessableEntity,
+		Code:    CodeInvalidRange,
+		Message: fmt.Sprintf(format, args...),
+	}
+}
+
+// parseSubnetRange checks the prefix or range of a new subnet and returns
+// its family and range in canonical form
+func parseSubnetRange(prefix, start, end string) (model.SubnetRangeEntity, error) {
+	r := model.SubnetRangeEntity{}
+	var p netip.Prefix
+	var first, last netip.Addr
+	var err error
+
+	if prefix != "" {
+		if p, err = netip.ParsePrefix(prefix); err != nil {
+			return r, fmt.Errorf("Invalid prefix %q", prefix)
+		}
+		if p != p.Masked() {
+			return r, fmt.Errorf("Prefix %s has host bits set, did you mean %s?", prefix, p.Masked())
+		}
+		first, last = usableRange(p)
+		r.Prefix = p.String()
+	} else if start == "" || end == "" {
+		return r, errors.New("A subnet needs a prefix, or a start and an end address")
+	}
+
+	if start != "" {
+		if first, err = parseAddr("start", start); err != nil {
+			return r, err
+		}
+	}
+	if end != "" {
+		if last, err = parseAddr("end", end); err != nil {
+			return r, err
+		}
+	}
+	if first.Is4() != last.Is4() {
+		return r, invalidRangeError("Start %s and end %s are not in the same address family", first, last)
+	}
+	if p.IsValid() && (!p.Contains(first) || !p.Contains(last)) {
+		return r, invalidRangeError("Range %s-%s is not in prefix %s", first, last, p)
+	}
+	if last.Less(first) {
+		return r, invalidRangeError("Start %s is after end %s", first, last)
+	}
+	if p.IsValid() {
+		if err = checkUsable(p, first, last); err != nil {
+			return r, err
+		}
+	}
+
+	r.Family, r.Start, r.End = family(first), first.String(), last.String()
+	return r, nil
+}
+
+// checkUsable refuses a range of p that includes an address usableRange
+// leaves out
+func checkUsable(p netip.Prefix, first, last netip.Addr) error {
+	usableFirst, usableLast := usableRange(p)
+	if first.Less(usableFirst) {
+		name := "network"
+		if !first.Is4() {
+			name = "subnet-router anycast"
+		}
+		return invalidRangeError("Range %s-%s includes the %s address %s of %s", first, last, name, p.Addr(), p)
+	}
+	if usableLast.Less(last) {
+		return invalidRangeError("Range %s-%s includes the broadcast address %s of %s", first, last, lastAddr(p), p)
+	}
+	return nil
+}
+
+// parsePoolPrefixes checks the prefixes of a new pool and returns them in
+// canonical form
+func parsePoolPrefixes(prefixes []string) ([]string, error) {
+	parsed := []netip.Prefix{}
+	for _, prefix := range prefixes {
+		p, err := netip.ParsePrefix(prefix)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid prefix %q", prefix)
+		}
+		if p != p.Masked() {
+			return nil, fmt.Errorf("Prefix %s has host bits set, did you mean %s?", prefix, p.Masked())
+		}
+		for _, other := range parsed {
+			if p.Overlaps(other) {
+				return nil, invalidRangeError("Prefixes %s and %s overlap", other, p)
+			}
+		}
+		parsed = append(parsed, p)
+	}
+
+	canonical := []string{}
+	for _, p := range parsed {
+		canonical = append(canonical, p.String())
+	}
+	return canonical, nil
+}
+
+// checkSubnet refuses a subnet range that is not in a prefix of its pool, or
+// that overlaps a subnet Houston already has. It only reads MySQL, so it can
+// run before anything is sent to voyager-ipam-service.
+func (s *Server) checkSubnet(poolID string, r model.SubnetRangeEntity) error {
+	first, last := netip.MustParseAddr(r.Start), netip.MustParseAddr(r.End)
+
+	poolRanges := []model.PoolRangeEntity{}
+	if err := s.MySQL.DB.Where("pool_id = ?", poolID).Find(&poolRanges).Error; err != nil {
+		return err
+	}
+	if len(poolRanges) > 0 {
+		var network netip.Prefix
+		prefixes := []string{}
+		for _, poolRange := range poolRanges {
+			p, err := netip.ParsePrefix(poolRange.Prefix)
+			if err != nil {
+				continue
+			}
+			prefixes = append(prefixes, p.String())
+			if p.Contains(first) && p.Contains(last) {
+				network = p
+			}
+		}
+		if !network.IsValid() {
+			return invalidRangeError("Range %s-%s is not in a prefix of pool %s (%s)", first, last, poolID, strings.Join(prefixes, ", "))
+		}
+		// Subnets given by a prefix were checked against it already
+		if r.Prefix == "" {
+			if err := checkUsable(network, first, last); err != nil {
+				return err
+			}
+		}
+	}
+
+	others := []model.SubnetRangeEntity{}
+	if err := s.MySQL.DB.Where("family = ?", r.Family).Find(&others).Error; err != nil {
+		return err
+	}
+	for _, other := range others {
+		otherFirst, err := netip.ParseAddr(other.Start)
+		if err != nil {
+			continue
+		}
+		otherLast, err := netip.ParseAddr(other.End)
+		if err != nil {
+			continue
+		}
+
+		message := ""
+		if !last.Less(otherFirst) && !otherLast.Less(first) {
+			message = fmt.Sprintf("Range %s-%s overlaps subnet %s (%s-%s)", first, last, other.SubnetID, otherFirst, otherLast)
+		} else if r.Prefix != "" && other.Prefix != "" {
+			p, err1 := netip.ParsePrefix(r.Prefix)
+			otherPrefix, err2 := netip.ParsePrefix(other.Prefix)
+			if err1 == nil && err2 == nil && p.Overlaps(otherPrefix) {
+				message = fmt.Sprintf("Prefix %s overlaps subnet %s (%s)", p, other.SubnetID, otherPrefix)
+			}
+		}
+		if message != "" {
+			return &APIError{
+				Status:  http.StatusConflict,
+				Code:    CodeConflict,
+				Message: message,
+				Details: map[string]string{"subnet": other.SubnetID},
+			}
+		}
+	}
+	return nil
+}
+
+// sortLeases orders leases by subnet, then numerically by address, IPv4 first
+func sortLeases(leases []model.LeaseEntity) {
+	sort.SliceStable(leases, func(i, j int) bool {
+		if leases[i].SubnetID != leases[j].SubnetID {
+			return leases[i].SubnetID < leases[j].SubnetID
+		}
+		a, errA := netip.ParseAddr(leases[i].Address)
+		b, errB := netip.ParseAddr(leases[j].Address)
+		if errA != nil || errB != nil {
+			return leases[i].Address < leases[j].Address
+		}
+		return a.Unmap().Less(b.Unmap())
+	})
+}
@@ -0,0 +1,230 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/RackHD/voyager-houston/model"
+	"github.com/RackHD/voyager-utilities/random"
+	"github.com/gin-gonic/gin"
+	"github.com/jinzhu/gorm"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	// requestIDHeader carries the ID correlating an API request with its audit log entries
+	requestIDHeader = "X-Request-ID"
+	// requestIDKey and routeKey are the gin context keys for the request ID and the matched route
+	requestIDKey = "request_id"
+	routeKey     = "route"
+
+	// maxRequestIDLength caps request IDs supplied by clients
+	maxRequestIDLength = 128
+	// maxAuditEntries caps the number of entries returned by GET /audit
+	maxAuditEntries = 1000
+	// maxRequestBodySize caps the body of a mutating call, which Audit reads
+	// into memory to hash it
+	maxRequestBodySize = 8 << 20
+)
+
+// Kinds of audit log entries
+const (
+	AuditKindAPI     = "api"
+	AuditKindCommand = "command"
+)
+
+type requestInfoKey struct{}
+
+// requestInfo identifies the API request a backend command is sent for
+type requestInfo struct {
+	RequestID string
+	Actor     string
+}
+
+// RequestID is middleware that assigns each request an ID, or keeps the one in
+// the X-Request-ID header, and returns it in the response
+func (s *Server) RequestID(c *gin.Context) {
+	id := c.Request.Header.Get(requestIDHeader)
+	if id == "" || len(id) > maxRequestIDLength {
+		id = random.RandQueue()
+	}
+	c.Set(requestIDKey, id)
+	c.Header(requestIDHeader, id)
+}
+
+// requestID returns the ID set by RequestID
+func requestID(c *gin.Context) string {
+	id, _ := c.Get(requestIDKey)
+	requestID, _ := id.(string)
+	return requestID
+}
+
+// route returns the route of the request as registered with handle, e.g. "DELETE /pools/:id"
+func route(c *gin.Context) string {
+	if r, ok := c.Get(routeKey); ok {
+		return r.(string)
+	}
+	return c.Request.Method + " " + c.Request.URL.Path
+}
+
+// requestContext returns a context for backend commands sent on behalf of the request
+func requestContext(c *gin.Context) context.Context {
+	info := requestInfo{RequestID: requestID(c)}
+	if id := identity(c); id != nil {
+		info.Actor = id.Subject
+	}
+	return context.WithValue(c.Request.Context(), requestInfoKey{}, info)
+}
+
+// Audit is middleware that records every mutating API call in the audit log
+func (s *Server) Audit(c *gin.Context) {
+	switch c.Request.Method {
+	case "GET", "HEAD", "OPTIONS":
+		return
+	}
+
+	start := time.Now()
+	payloadHash := ""
+	if c.Request.Body != nil {
+		body, err := ioutil.ReadAll(io.LimitReader(c.Request.Body, maxRequestBodySize+1))
+		if err != nil {
+			log.Warnf("Could not read request body for the audit log: %s", err)
+		}
+		if len(body) > maxRequestBodySize {
+			// The call is recorded as refused, without reading the rest of the body
+			abortWithError(c, http.StatusRequestEntityTooLarge, fmt.Errorf("Request body is larger than %d bytes", maxRequestBodySize))
+			body = nil
+		}
+		if len(body) > 0 {
+			payloadHash = hashPayload(body)
+		}
+		c.Request.Body = ioutil.NopCloser(bytes.NewReader(body))
+	}
+
+	c.Next()
+
+	entry := model.AuditEntity{
+		Time:          start,
+		Kind:          AuditKindAPI,
+		Action:        route(c),
+		Target:        c.Request.URL.Path,
+		PayloadHash:   payloadHash,
+		Result:        strconv.Itoa(c.Writer.Status()),
+		CorrelationID: requestID(c),
+		RequestID:     requestID(c),
+	}
+	if id := identity(c); id != nil {
+		entry.Actor = id.Subject
+	}
+	s.record(entry)
+}
+
+// auditCommand records a command sent to another Voyager service
+func (s *Server) auditCommand(ctx context.Context, req rpcRequest, correlationID string, err error) {
+	info, _ := ctx.Value(requestInfoKey{}).(requestInfo)
+	if info.Actor == "" {
+		info.Actor = "houston"
+	}
+
+	entry := model.AuditEntity{
+		Time:          time.Now(),
+		Kind:          AuditKindCommand,
+		Actor:         info.Actor,
+		Action:        req.Command,
+		Target:        req.Exchange,
+		PayloadHash:   hashPayload(req.Message),
+		Result:        "ok",
+		CorrelationID: correlationID,
+		RequestID:     info.RequestID,
+	}
+	if err != nil {
+		entry.Result = err.Error()
+	}
+	s.record(entry)
+}
+
+// record writes entry to the audit table
+func (s *Server) record(entry model.AuditEntity) {
+	if s.MySQL == nil {
+		return
+	}
+	if err := s.MySQL.DB.Create(&entry).Error; err != nil {
+		log.Warnf("Could not write audit log entry %+v: %s", entry, err)
+	}
+}
+
+func hashPayload(payload []byte) string {
+	sum := sha256.Sum256(payload)
+	return hex.EncodeToString(sum[:])
+}
+
+// AuditHandler Serves GET /audit. Entries can be filtered by kind, actor,
+// action, target, result, correlation_id and request_id, and by time with
+// since and until (RFC 3339). They are returned newest first, paged with
+// limit and offset.
+func (s *Server) AuditHandler(c *gin.Context) {
+	query, ok := filter(c, s.MySQL.DB, "time", "kind", "actor", "action", "target", "result", "correlation_id", "request_id")
+	if !ok {
+		return
+	}
+	limit, offset, ok := page(c, maxAuditEntries)
+	if !ok {
+		return
+	}
+
+	entries := []model.AuditEntity{}
+	if err := query.Order("time desc").Limit(limit).Offset(offset).Find(&entries).Error; err != nil {
+		abortWithError(c, http.StatusInternalServerError, err)
+		return
+	}
+	c.JSON(http.StatusOK, entries)
+}
+
+// filter narrows query to the rows whose columns equal the query parameters
+// of the same name, and whose timeColumn is within the since and until
+// parameters (RFC 3339). It responds with 400 and returns false if a time is invalid.
+func filter(c *gin.Context, query *gorm.DB, timeColumn string, columns ...string) (*gorm.DB, bool) {
+	for _, column := range columns {
+		if value := c.Query(column); value != "" {
+			query = query.Where(column+" = ?", value)
+		}
+	}
+
+	for param, condition := range map[string]string{"since": " >= ?", "until": " < ?"} {
+		value := c.Query(param)
+		if value == "" {
+			continue
+		}
+		t, err := time.Parse(time.RFC3339, value)
+		if err != nil {
+			abortWithError(c, http.StatusBadRequest, fmt.Errorf("Invalid %s: %s", param, err))
+			return nil, false
+		}
+		query = query.Where(timeColumn+condition, t)
+	}
+	return query, true
+}
+
+// page returns the limit and offset query parameters, by default 100 and 0.
+// It responds with 400 and returns false if they are out of range.
+func page(c *gin.Context, max int) (limit, offset int, ok bool) {
+	limit, err := strconv.Atoi(c.DefaultQuery("limit", "100"))
+	if err != nil || limit < 1 || limit > max {
+		abortWithError(c, http.StatusBadRequest, fmt.Errorf("limit must be between 1 and %d", max))
+		return 0, 0, false
+	}
+	offset, err = strconv.Atoi(c.DefaultQuery("offset", "0"))
+	if err != nil || offset < 0 {
+		abortWithError(c, http.StatusBadRequest, fmt.Errorf("offset must not be negative"))
+		return 0, 0, false
+	}
+	return limit, offset, true
+}
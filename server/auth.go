@@ -0,0 +1,162 @@
+package server
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jinzhu/gorm"
+	"github.com/RackHD/voyager-houston/model"
+	log "github.com/sirupsen/logrus"
+)
+
+// identityKey is the gin context key the authenticated caller is stored under
+const identityKey = "identity"
+
+// errNoCredentials is returned by an Authenticator when the request carries no credentials for it
+var errNoCredentials = errors.New("no credentials")
+
+// Authenticator identifies the caller of an API request
+type Authenticator interface {
+	// Authenticate returns the caller's identity, errNoCredentials when the
+	// request has no credentials for this scheme, or an error when they are invalid.
+	Authenticate(r *http.Request) (*model.Identity, error)
+}
+
+// APIKeyAuthenticator accepts static API keys in the X-API-Key header
+type APIKeyAuthenticator struct {
+	DB *gorm.DB
+}
+
+// Authenticate looks up the hash of the API key in MySQL
+func (a *APIKeyAuthenticator) Authenticate(r *http.Request) (*model.Identity, error) {
+	key := r.Header.Get("X-API-Key")
+	if key == "" {
+		return nil, errNoCredentials
+	}
+
+	apiKey := model.APIKeyEntity{}
+	if err := a.DB.Where("key_hash = ?", hashAPIKey(key)).First(&apiKey).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return nil, fmt.Errorf("invalid API key")
+		}
+		return nil, err
+	}
+
+	return &model.Identity{
+		Subject: apiKey.Name,
+		Method:  "api-key",
+	}, nil
+}
+
+// CreateAPIKey generates a new API key for name and stores its hash. The key itself is not stored.
+func (s *Server) CreateAPIKey(name string) (string, error) {
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		return "", err
+	}
+	key := hex.EncodeToString(secret)
+
+	apiKey := model.APIKeyEntity{
+		Name:    name,
+		KeyHash: hashAPIKey(key),
+	}
+	if err := s.MySQL.DB.Create(&apiKey).Error; err != nil {
+		return "", err
+	}
+	return key, nil
+}
+
+func hashAPIKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+// newAuthenticators builds the authenticators enabled in config
+func (s *Server) newAuthenticators(config Config) ([]Authenticator, error) {
+	authenticators := []Authenticator{}
+
+	if config.AuthAPIKeys {
+		authenticators = append(authenticators, &APIKeyAuthenticator{DB: s.MySQL.DB})
+	}
+
+	if config.JWTSecretFile != "" || config.JWKSFile != "" {
+		jwt := &JWTAuthenticator{
+			Issuer:   config.JWTIssuer,
+			Audience: config.JWTAudience,
+		}
+
+		if config.JWTSecretFile != "" {
+			secret, err := ioutil.ReadFile(config.JWTSecretFile)
+			if err != nil {
+				return nil, err
+			}
+			jwt.Secret = bytes.TrimSpace(secret)
+		}
+
+		if config.JWKSFile != "" {
+			keys, err := LoadJWKS(config.JWKSFile)
+			if err != nil {
+				return nil, err
+			}
+			jwt.Keys = keys
+		}
+
+		authenticators = append(authenticators, jwt)
+	}
+
+	return authenticators, nil
+}
+
+// Authenticate is middleware that identifies the caller with the first
+// Authenticator the request has credentials for. All requests are let through
+// as anonymous when no authenticators are configured.
+func (s *Server) Authenticate(c *gin.Context) {
+	if len(s.Authenticators) == 0 {
+		c.Set(identityKey, &model.Identity{Subject: "anonymous", Method: "none"})
+		return
+	}
+
+	for _, authenticator := range s.Authenticators {
+		identity, err := authenticator.Authenticate(c.Request)
+		if err == errNoCredentials {
+			continue
+		}
+		if err != nil {
+			log.Infof("Authentication failed: %s", err)
+			c.Header("WWW-Authenticate", "Bearer")
+			abortWithError(c, http.StatusUnauthorized, err)
+			return
+		}
+
+		if identity.Role, err = s.roleOf(identity); err != nil {
+			abortWithError(c, http.StatusInternalServerError, err)
+			return
+		}
+
+		c.Set(identityKey, identity)
+		return
+	}
+
+	c.Header("WWW-Authenticate", "Bearer")
+	abortWithError(c, http.StatusUnauthorized, errors.New("Authentication required"))
+}
+
+// identity returns the caller set by Authenticate
+func identity(c *gin.Context) *model.Identity {
+	if id, ok := c.Get(identityKey); ok {
+		return id.(*model.Identity)
+	}
+	return nil
+}
+
+// WhoAmIHandler Serves /whoami
+func (s *Server) WhoAmIHandler(c *gin.Context) {
+	c.JSON(http.StatusOK, identity(c))
+}
@@ -0,0 +1,185 @@
+package server_test
+
+import (
+	"crypto"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/RackHD/voyager-houston/model"
+	. "github.com/RackHD/voyager-houston/server"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+// signHS256 returns a JWT with the given claims signed with secret
+func signHS256(secret []byte, claims map[string]interface{}) string {
+	header, _ := json.Marshal(map[string]string{"alg": "HS256", "typ": "JWT"})
+	payload, _ := json.Marshal(claims)
+	signed := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(signed))
+	return signed + "." + base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// signRS256 returns a JWT with the given claims signed with key
+func signRS256(key *rsa.PrivateKey, kid string, claims map[string]interface{}) string {
+	header, _ := json.Marshal(map[string]string{"alg": "RS256", "typ": "JWT", "kid": kid})
+	payload, _ := json.Marshal(claims)
+	signed := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+
+	digest := sha256.Sum256([]byte(signed))
+	signature, err := rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, digest[:])
+	Expect(err).ToNot(HaveOccurred())
+	return signed + "." + base64.RawURLEncoding.EncodeToString(signature)
+}
+
+// get performs a GET request against handler with the given headers
+func get(handler http.Handler, path string, headers map[string]string) *httptest.ResponseRecorder {
+	req, err := http.NewRequest("GET", path, nil)
+	Expect(err).ToNot(HaveOccurred())
+	for name, value := range headers {
+		req.Header.Set(name, value)
+	}
+
+	resp := httptest.NewRecorder()
+	handler.ServeHTTP(resp, req)
+	return resp
+}
+
+var _ = Describe("Authentication", func() {
+	var secret []byte
+	var s *Server
+
+	BeforeEach(func() {
+		gin.SetMode(gin.ReleaseMode)
+		gin.DefaultWriter = ioutil.Discard
+
+		secret = []byte("correct horse battery staple")
+		s = &Server{
+			Authenticators: []Authenticator{
+				&JWTAuthenticator{Secret: secret, Audience: "houston"},
+			},
+		}
+	})
+
+	Context("API endpoints", func() {
+		It("UNIT should let anyone reach /healthz and /info", func() {
+			router := s.Router()
+			Expect(get(router, "/api/v1/healthz", nil).Code).To(Equal(http.StatusOK))
+			Expect(get(router, "/api/v1/info", nil).Code).To(Equal(http.StatusOK))
+		})
+
+		It("UNIT should reject requests without credentials", func() {
+			resp := get(s.Router(), "/api/v1/whoami", nil)
+			Expect(resp.Code).To(Equal(http.StatusUnauthorized))
+			Expect(resp.Header().Get("WWW-Authenticate")).To(Equal("Bearer"))
+			Expect(get(s.Router(), "/api/v1/metrics", nil).Code).To(Equal(http.StatusUnauthorized))
+		})
+
+		It("UNIT should identify the caller of /whoami", func() {
+			token := signHS256(secret, map[string]interface{}{
+				"sub": "alice",
+				"aud": []string{"houston"},
+				"exp": time.Now().Add(time.Minute).Unix(),
+			})
+
+			resp := get(s.Router(), "/api/v1/whoami", map[string]string{"Authorization": "Bearer " + token})
+			Expect(resp.Code).To(Equal(http.StatusOK))
+
+			identity := model.Identity{}
+			Expect(json.Unmarshal(resp.Body.Bytes(), &identity)).To(Succeed())
+			Expect(identity.Subject).To(Equal("alice"))
+			Expect(identity.Method).To(Equal("jwt"))
+		})
+
+		It("UNIT should let everyone in as anonymous when no authenticators are configured", func() {
+			s.Authenticators = nil
+
+			resp := get(s.Router(), "/api/v1/whoami", nil)
+			Expect(resp.Code).To(Equal(http.StatusOK))
+
+			identity := model.Identity{}
+			Expect(json.Unmarshal(resp.Body.Bytes(), &identity)).To(Succeed())
+			Expect(identity.Subject).To(Equal("anonymous"))
+		})
+	})
+
+	Context("JWT bearer tokens", func() {
+		It("UNIT should reject a token with a bad signature", func() {
+			token := signHS256([]byte("wrong"), map[string]interface{}{"sub": "alice", "aud": "houston"})
+
+			resp := get(s.Router(), "/api/v1/whoami", map[string]string{"Authorization": "Bearer " + token})
+			Expect(resp.Code).To(Equal(http.StatusUnauthorized))
+		})
+
+		It("UNIT should reject an expired token", func() {
+			token := signHS256(secret, map[string]interface{}{
+				"sub": "alice",
+				"aud": "houston",
+				"exp": time.Now().Add(-time.Minute).Unix(),
+			})
+
+			_, err := s.Authenticators[0].(*JWTAuthenticator).Verify(token)
+			Expect(err).To(MatchError("token has expired"))
+		})
+
+		It("UNIT should reject a token for another audience", func() {
+			token := signHS256(secret, map[string]interface{}{"sub": "alice", "aud": "someone-else"})
+
+			_, err := s.Authenticators[0].(*JWTAuthenticator).Verify(token)
+			Expect(err).To(HaveOccurred())
+		})
+
+		It("UNIT should reject unsigned tokens", func() {
+			header := base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"none"}`))
+			payload := base64.RawURLEncoding.EncodeToString([]byte(`{"sub":"alice","aud":"houston"}`))
+
+			_, err := s.Authenticators[0].(*JWTAuthenticator).Verify(header + "." + payload + ".")
+			Expect(err).To(HaveOccurred())
+		})
+
+		It("UNIT should verify RS256 tokens against a JWKS file", func() {
+			key, err := rsa.GenerateKey(rand.Reader, 2048)
+			Expect(err).ToNot(HaveOccurred())
+
+			dir, err := ioutil.TempDir("", "houston-jwks")
+			Expect(err).ToNot(HaveOccurred())
+			defer os.RemoveAll(dir)
+
+			jwksFile := filepath.Join(dir, "jwks.json")
+			jwks := fmt.Sprintf(`{"keys":[{"kty":"RSA","kid":"k1","n":"%s","e":"%s"}]}`,
+				base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+				base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()))
+			Expect(ioutil.WriteFile(jwksFile, []byte(jwks), 0600)).To(Succeed())
+
+			keys, err := LoadJWKS(jwksFile)
+			Expect(err).ToNot(HaveOccurred())
+			authenticator := &JWTAuthenticator{Keys: keys}
+
+			claims, err := authenticator.Verify(signRS256(key, "k1", map[string]interface{}{"sub": "bob"}))
+			Expect(err).ToNot(HaveOccurred())
+			Expect(claims["sub"]).To(Equal("bob"))
+
+			_, err = authenticator.Verify(signRS256(key, "k2", map[string]interface{}{"sub": "bob"}))
+			Expect(err).To(HaveOccurred())
+
+			_, err = authenticator.Verify(signHS256(secret, map[string]interface{}{"sub": "bob"}))
+			Expect(err).To(HaveOccurred())
+		})
+	})
+})
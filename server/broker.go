@@ -0,0 +1,150 @@
+package server
+
+import (
+	"sync"
+	"time"
+
+	"github.com/RackHD/voyager-utilities/amqp"
+	log "github.com/sirupsen/logrus"
+	samqp "github.com/streadway/amqp"
+)
+
+// connectionGrace is how long a replaced AMQP connection is kept open so
+// in-flight requests on it can finish
+const connectionGrace = 10 * time.Second
+
+// Broker is the message bus Houston talks to the other Voyager services over
+type Broker interface {
+	Listen(exchange, exchangeType, queueName, bindingKey, consumerTag string) (*samqp.Channel, <-chan samqp.Delivery, error)
+	Send(exchange, exchangeType, routingKey, message, correlationID, replyTo string) error
+	Close()
+}
+
+// plainBroker adapts the voyager-utilities AMQP client to the Broker interface
+type plainBroker struct {
+	*amqp.Client
+}
+
+// Close closes the underlying client
+func (b plainBroker) Close() {
+	b.Client.Close()
+}
+
+// tlsBroker is a Broker connected over amqps:// with a CA bundle and client
+// certificate. It redials whenever its certificates are reloaded.
+type tlsBroker struct {
+	uri   string
+	certs *CertReloader
+
+	mu   sync.RWMutex
+	conn *samqp.Connection
+}
+
+// newTLSBroker dials the broker at uri using the certificates in certs
+func newTLSBroker(uri string, certs *CertReloader) (*tlsBroker, error) {
+	b := &tlsBroker{
+		uri:   uri,
+		certs: certs,
+	}
+	if err := b.dial(); err != nil {
+		return nil, err
+	}
+
+	certs.OnReload(func() {
+		if err := b.dial(); err != nil {
+			log.Warnf("Could not reconnect to RabbitMQ with reloaded certificates: %s", err)
+		}
+	})
+
+	return b, nil
+}
+
+// dial opens a new connection and swaps it in. The previous connection, if
+// any, is closed once in-flight requests have had time to complete.
+func (b *tlsBroker) dial() error {
+	conn, err := samqp.DialTLS(b.uri, b.certs.ClientConfig())
+	if err != nil {
+		return err
+	}
+
+	b.mu.Lock()
+	old := b.conn
+	b.conn = conn
+	b.mu.Unlock()
+
+	if old != nil {
+		time.AfterFunc(connectionGrace, func() {
+			if err := old.Close(); err != nil {
+				log.Info("Closing replaced connection failed: ", err)
+			}
+		})
+	}
+	return nil
+}
+
+func (b *tlsBroker) channel() (*samqp.Channel, error) {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	return b.conn.Channel()
+}
+
+// Listen declares the exchange and a queue bound to it, and consumes from that queue
+func (b *tlsBroker) Listen(exchange, exchangeType, queueName, bindingKey, consumerTag string) (*samqp.Channel, <-chan samqp.Delivery, error) {
+	ch, err := b.channel()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if err = ch.ExchangeDeclare(exchange, exchangeType, true, false, false, false, nil); err != nil {
+		ch.Close()
+		return nil, nil, err
+	}
+
+	queue, err := ch.QueueDeclare(queueName, true, false, false, false, nil)
+	if err != nil {
+		ch.Close()
+		return nil, nil, err
+	}
+
+	if err = ch.QueueBind(queue.Name, bindingKey, exchange, false, nil); err != nil {
+		ch.Close()
+		return nil, nil, err
+	}
+
+	deliveries, err := ch.Consume(queue.Name, consumerTag, false, false, false, false, nil)
+	if err != nil {
+		ch.Close()
+		return nil, nil, err
+	}
+
+	return ch, deliveries, nil
+}
+
+// Send publishes message to the exchange with the given routing key
+func (b *tlsBroker) Send(exchange, exchangeType, routingKey, message, correlationID, replyTo string) error {
+	ch, err := b.channel()
+	if err != nil {
+		return err
+	}
+	defer ch.Close()
+
+	if err = ch.ExchangeDeclare(exchange, exchangeType, true, false, false, false, nil); err != nil {
+		return err
+	}
+
+	return ch.Publish(exchange, routingKey, false, false, samqp.Publishing{
+		ContentType:   "text/plain",
+		Body:          []byte(message),
+		CorrelationId: correlationID,
+		ReplyTo:       replyTo,
+	})
+}
+
+// Close closes the current connection
+func (b *tlsBroker) Close() {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	if err := b.conn.Close(); err != nil {
+		log.Info("Closing connection failed: ", err)
+	}
+}
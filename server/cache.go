@@ -0,0 +1,265 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/RackHD/voyager-houston/model"
+	"github.com/RackHD/voyager-utilities/models"
+	"github.com/gin-gonic/gin"
+	log "github.com/sirupsen/logrus"
+)
+
+// Cache states reported in the X-Cache header of GET /nodes
+const (
+	cacheHit   = "HIT"
+	cacheMiss  = "MISS"
+	cacheStale = "STALE"
+)
+
+// staleWait is how long a request with a stale cache entry waits for the
+// inventory service before it is answered from the cache
+const staleWait = 500 * time.Millisecond
+
+// inventoryCache holds the last get_nodes reply from the inventory service
+type inventoryCache struct {
+	mu      sync.Mutex
+	nodes   []byte
+	fetched time.Time
+	// invalidated is set when a node-change event arrives
+	invalidated bool
+	// generation counts the node-change events, so that a refresh started
+	// before one is neither cached nor joined
+	generation int
+	// refreshing is the refresh in progress, if any
+	refreshing *refresh
+}
+
+// nodeDataCache holds the nodes of the last get_nodes reply decoded, with the
+// labels and locations Houston stores added, so that they are not read from
+// MySQL for every request
+type nodeDataCache struct {
+	mu     sync.Mutex
+	reply  []byte
+	nodes  []model.Node
+	merged time.Time
+	// version counts the changes to labels and the topology. Nodes merged at
+	// an older version are not used.
+	version       int
+	mergedVersion int
+}
+
+// refresh is a get_nodes request made on behalf of the cache
+type refresh struct {
+	done       chan struct{}
+	generation int
+	nodes      []byte
+	err        error
+}
+
+// cachedNodes returns the get_nodes reply, from the cache when it is fresh.
+// A stale entry is revalidated, and returned if the inventory service does
+// not reply within staleWait, fails, or ctx is done. No more than maxStale old
+// entries are returned.
+func (s *Server) cachedNodes(ctx context.Context) (nodes []byte, state string, age time.Duration, err error) {
+	cache := &s.inventory
+
+	cache.mu.Lock()
+	now := time.Now()
+	age = now.Sub(cache.fetched)
+	if cache.nodes != nil && !cache.invalidated && age < s.Config.InventoryCacheTTL {
+		nodes = cache.nodes
+		cache.mu.Unlock()
+		return nodes, cacheHit, age, nil
+	}
+
+	r := cache.refreshing
+	if r == nil || r.generation != cache.generation {
+		r = &refresh{done: make(chan struct{}), generation: cache.generation}
+		cache.refreshing = r
+		// After a node-change event, a get_nodes request already in flight
+		// may have been answered before it
+		go s.refreshNodes(r, !cache.invalidated)
+	}
+	stale := cache.nodes
+	if s.Config.InventoryCacheMaxStale > 0 && age >= s.Config.InventoryCacheMaxStale {
+		stale = nil
+	}
+	cache.mu.Unlock()
+
+	if stale == nil {
+		select {
+		case <-r.done:
+			return r.nodes, cacheMiss, 0, r.err
+		case <-ctx.Done():
+			return nil, "", 0, ctx.Err()
+		}
+	}
+
+	select {
+	case <-r.done:
+		if r.err == nil {
+			return r.nodes, cacheMiss, 0, nil
+		}
+		log.Warnf("Serving cached nodes after inventory refresh failed: %s", r.err)
+	case <-time.After(staleWait):
+	case <-ctx.Done():
+	}
+	return stale, cacheStale, time.Since(cache.fetchedAt()), nil
+}
+
+// refreshNodes fetches the nodes for the cache. It is not tied to any API
+// request, so that a refresh started by a request that gives up still
+// completes. A reply that is not a list of nodes is not cached, nor is one
+// to a refresh started before the last node-change event.
+func (s *Server) refreshNodes(r *refresh, coalesce bool) {
+	r.nodes, r.err = s.fetchNodes(context.Background(), coalesce)
+	if r.err == nil {
+		if err := json.Unmarshal(r.nodes, &[]model.Node{}); err != nil {
+			r.nodes, r.err = nil, newAPIError(http.StatusBadGateway, fmt.Errorf("Invalid reply from voyager-inventory-service: %s", err))
+		}
+	}
+
+	cache := &s.inventory
+	cache.mu.Lock()
+	if r.err == nil && r.generation == cache.generation {
+		cache.nodes = r.nodes
+		cache.fetched = time.Now()
+		cache.invalidated = false
+	}
+	if cache.refreshing == r {
+		cache.refreshing = nil
+	}
+	cache.mu.Unlock()
+
+	close(r.done)
+}
+
+func (c *inventoryCache) fetchedAt() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.fetched
+}
+
+// invalidate marks the cached nodes stale, so the next request revalidates them
+func (c *inventoryCache) invalidate() {
+	c.mu.Lock()
+	c.invalidated = true
+	c.generation++
+	c.mu.Unlock()
+}
+
+// inventoryNodes returns the nodes, from the cache when it is enabled
+func (s *Server) inventoryNodes(ctx context.Context) ([]model.Node, error) {
+	var body []byte
+	var err error
+	if s.Config.InventoryCacheTTL > 0 {
+		body, _, _, err = s.cachedNodes(ctx)
+	} else {
+		body, err = s.fetchNodes(ctx, true)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return s.decodeNodes(body)
+}
+
+// decodeNodes unmarshals a get_nodes reply and adds the labels and locations
+// Houston stores to the nodes. The result is reused for the same reply for up
+// to InventoryCacheTTL, until labels or the topology change, so the nodes must
+// not be modified. If MySQL fails, the nodes are returned as the inventory
+// reports them.
+func (s *Server) decodeNodes(body []byte) ([]model.Node, error) {
+	cache := &s.nodeData
+	cache.mu.Lock()
+	if cache.nodes != nil && cache.mergedVersion == cache.version && bytes.Equal(cache.reply, body) &&
+		time.Since(cache.merged) < s.Config.InventoryCacheTTL {
+		nodes := cache.nodes
+		cache.mu.Unlock()
+		return nodes, nil
+	}
+	version := cache.version
+	cache.mu.Unlock()
+
+	nodes := []model.Node{}
+	if err := json.Unmarshal(body, &nodes); err != nil {
+		return nil, fmt.Errorf("Error unmarshaling voyager-inventory-service response: %s", err)
+	}
+	if err := s.mergeNodeData(nodes); err != nil {
+		log.Warnf("Serving nodes without the labels and locations Houston stores: %s", err)
+		return nodes, nil
+	}
+
+	cache.mu.Lock()
+	cache.reply, cache.nodes, cache.merged, cache.mergedVersion = body, nodes, time.Now(), version
+	cache.mu.Unlock()
+	return nodes, nil
+}
+
+// mergeNodeData adds the labels and locations Houston stores to nodes
+func (s *Server) mergeNodeData(nodes []model.Node) error {
+	if err := s.labelNodes(nodes); err != nil {
+		return err
+	}
+	return s.locateNodes(nodes)
+}
+
+// invalidate discards the merged nodes after labels or the topology changed
+func (c *nodeDataCache) invalidate() {
+	c.mu.Lock()
+	c.version++
+	c.mu.Unlock()
+}
+
+// fetchNodes asks the inventory service for the nodes. With coalesce, the
+// reply may be to an identical request sent a little earlier.
+func (s *Server) fetchNodes(ctx context.Context, coalesce bool) ([]byte, error) {
+	return s.call(ctx, rpcRequest{
+		Exchange:     inventoryExchange,
+		ExchangeType: inventoryExchangeType,
+		RoutingKey:   inventoryRequestKey,
+		ReplyKey:     inventoryReplyKey,
+		Command:      "get_nodes",
+		Message:      []byte(`{"command": "get_nodes", "options":""}`),
+		Coalesce:     coalesce,
+	})
+}
+
+// setCacheHeaders tells the client where a cached response came from. A
+// stale response also gets a Warning, as RFC 7234 requires.
+func setCacheHeaders(c *gin.Context, state string, age time.Duration) {
+	c.Header("X-Cache", state)
+	if state == cacheMiss {
+		return
+	}
+	c.Header("Age", strconv.Itoa(int(age.Seconds())))
+	if state == cacheStale {
+		c.Header("Warning", `110 - "Response is Stale"`)
+	}
+}
+
+// processNodeEvent invalidates the inventory cache when a node changes, and
+// leases a created or updated node the addresses reserved for it
+func (s *Server) processNodeEvent(body []byte) error {
+	event := model.NodeEvent{}
+	if err := json.Unmarshal(body, &event); err != nil {
+		return fmt.Errorf("invalid node event: %s", err)
+	}
+	if event.ObjectType != model.NodeType {
+		return fmt.Errorf("unknown object type %q", event.ObjectType)
+	}
+
+	log.Infof("Node %s: %s, invalidating inventory cache", event.ID, event.Action)
+	s.inventory.invalidate()
+	if s.MySQL != nil && s.MySQL.DB != nil && (event.Action == models.CreateAction || event.Action == model.UpdateAction) {
+		s.assignDiscoveredNode(event.ID)
+	}
+	return nil
+}
@@ -0,0 +1,215 @@
+package server_test
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	. "github.com/RackHD/voyager-houston/server"
+	"github.com/gin-gonic/gin"
+	samqp "github.com/streadway/amqp"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+// fakeBroker answers requests sent to an exchange with reply, on the routing
+// key the request asks for
+type fakeBroker struct {
+	// reply returns the reply to a message, or "" for none
+	reply func(exchange, routingKey, message string) string
+	sent  int32
+
+	mu        sync.Mutex
+	listeners map[string][]chan samqp.Delivery
+}
+
+func (b *fakeBroker) Listen(exchange, exchangeType, queueName, bindingKey, consumerTag string) (*samqp.Channel, <-chan samqp.Delivery, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	deliveries := make(chan samqp.Delivery, 10)
+	if b.listeners == nil {
+		b.listeners = map[string][]chan samqp.Delivery{}
+	}
+	b.listeners[exchange+"/"+bindingKey] = append(b.listeners[exchange+"/"+bindingKey], deliveries)
+	return nil, deliveries, nil
+}
+
+func (b *fakeBroker) Send(exchange, exchangeType, routingKey, message, correlationID, replyTo string) error {
+	atomic.AddInt32(&b.sent, 1)
+	if b.reply == nil {
+		return nil
+	}
+
+	go func() {
+		body := b.reply(exchange, routingKey, message)
+		if body == "" {
+			return
+		}
+
+		b.mu.Lock()
+		defer b.mu.Unlock()
+		for _, deliveries := range b.listeners[exchange+"/"+replyTo] {
+			select {
+			case deliveries <- samqp.Delivery{Exchange: exchange, CorrelationId: correlationID, Body: []byte(body)}:
+			default:
+			}
+		}
+	}()
+	return nil
+}
+
+func (b *fakeBroker) Close() {}
+
+func (b *fakeBroker) requests() int {
+	return int(atomic.LoadInt32(&b.sent))
+}
+
+var _ = Describe("Inventory cache", func() {
+	var broker *fakeBroker
+	var inventoryUp atomic.Value
+	var s *Server
+
+	BeforeEach(func() {
+		gin.SetMode(gin.ReleaseMode)
+		gin.DefaultWriter = ioutil.Discard
+
+		inventoryUp.Store(true)
+		broker = &fakeBroker{reply: func(exchange, routingKey, message string) string {
+			if !inventoryUp.Load().(bool) {
+				return ""
+			}
+			return `[{"id":"n1"}]`
+		}}
+		s = &Server{MQ: broker, Config: Config{InventoryCacheTTL: time.Hour}}
+	})
+
+	It("UNIT should answer from the cache until the TTL expires", func() {
+		router := s.Router()
+
+		resp := get(router, "/api/v1/nodes", nil)
+		Expect(resp.Code).To(Equal(http.StatusOK))
+		Expect(resp.Header().Get("X-Cache")).To(Equal("MISS"))
+
+		resp = get(router, "/api/v1/nodes", nil)
+		Expect(resp.Code).To(Equal(http.StatusOK))
+		Expect(resp.Header().Get("X-Cache")).To(Equal("HIT"))
+		Expect(resp.Body.String()).To(Equal(`[{"id":"n1"}]`))
+		Expect(broker.requests()).To(Equal(1))
+	})
+
+	It("UNIT should not cache a reply that is not a list of nodes", func() {
+		broker.reply = func(exchange, routingKey, message string) string {
+			if broker.requests() == 1 {
+				return `{"error": "database is starting"}`
+			}
+			return `[{"id":"n1"}]`
+		}
+		router := s.Router()
+
+		resp := get(router, "/api/v1/nodes", nil)
+		Expect(resp.Code).To(Equal(http.StatusBadGateway))
+
+		resp = get(router, "/api/v1/nodes", nil)
+		Expect(resp.Code).To(Equal(http.StatusOK))
+		Expect(resp.Header().Get("X-Cache")).To(Equal("MISS"))
+		Expect(broker.requests()).To(Equal(2))
+	})
+
+	It("UNIT should not cache when the TTL is zero", func() {
+		s.Config.InventoryCacheTTL = 0
+		router := s.Router()
+
+		get(router, "/api/v1/nodes", nil)
+		resp := get(router, "/api/v1/nodes", nil)
+		Expect(resp.Header().Get("X-Cache")).To(BeEmpty())
+		Expect(broker.requests()).To(Equal(2))
+	})
+
+	It("UNIT should serve stale nodes flagged as such while the inventory service is down", func() {
+		s.Config.InventoryCacheTTL = time.Millisecond
+		router := s.Router()
+		Expect(get(router, "/api/v1/nodes", nil).Code).To(Equal(http.StatusOK))
+
+		inventoryUp.Store(false)
+		time.Sleep(2 * time.Millisecond)
+
+		resp := get(router, "/api/v1/nodes", nil)
+		Expect(resp.Code).To(Equal(http.StatusOK))
+		Expect(resp.Header().Get("X-Cache")).To(Equal("STALE"))
+		Expect(resp.Header().Get("Warning")).To(ContainSubstring("Response is Stale"))
+		Expect(resp.Body.String()).To(Equal(`[{"id":"n1"}]`))
+	})
+
+	It("UNIT should revalidate after a node-change event", func() {
+		router := s.Router()
+		get(router, "/api/v1/nodes", nil)
+
+		err := s.ProcessAMQPMessage(&samqp.Delivery{
+			Exchange: "Houston",
+			Body:     []byte(`{"id":"n2","action":"create","objectType":"node"}`),
+		})
+		Expect(err).ToNot(HaveOccurred())
+
+		resp := get(router, "/api/v1/nodes", nil)
+		Expect(resp.Header().Get("X-Cache")).To(Equal("MISS"))
+		Expect(broker.requests()).To(Equal(2))
+	})
+
+	It("UNIT should not cache a reply to a refresh started before a node-change event", func() {
+		hold := make(chan struct{})
+		broker.reply = func(exchange, routingKey, message string) string {
+			if broker.requests() == 1 {
+				<-hold
+				return `[{"id":"n1"}]`
+			}
+			return `[{"id":"n1"},{"id":"n2"}]`
+		}
+		router := s.Router()
+
+		done := make(chan struct{})
+		go func() {
+			defer GinkgoRecover()
+			defer close(done)
+			Expect(get(router, "/api/v1/nodes", nil).Body.String()).To(Equal(`[{"id":"n1"}]`))
+		}()
+		Eventually(broker.requests).Should(Equal(1))
+
+		err := s.ProcessAMQPMessage(&samqp.Delivery{
+			Exchange: "Houston",
+			Body:     []byte(`{"id":"n2","action":"create","objectType":"node"}`),
+		})
+		Expect(err).ToNot(HaveOccurred())
+		close(hold)
+		Eventually(done).Should(BeClosed())
+
+		resp := get(router, "/api/v1/nodes", nil)
+		Expect(resp.Header().Get("X-Cache")).To(Equal("MISS"))
+		Expect(resp.Body.String()).To(Equal(`[{"id":"n1"},{"id":"n2"}]`))
+		Expect(broker.requests()).To(Equal(2))
+	})
+
+	It("UNIT should reject malformed events", func() {
+		err := s.ProcessAMQPMessage(&samqp.Delivery{Exchange: "Houston", Body: []byte(`{"objectType":"pool"}`)})
+		Expect(err).To(HaveOccurred())
+	})
+
+	It("UNIT should fail when there is nothing cached and the inventory service is down", func() {
+		inventoryUp.Store(false)
+
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+		defer cancel()
+		req, err := http.NewRequest("GET", "/api/v1/nodes", nil)
+		Expect(err).ToNot(HaveOccurred())
+
+		resp := httptest.NewRecorder()
+		s.Router().ServeHTTP(resp, req.WithContext(ctx))
+		Expect(resp.Code).To(Equal(http.StatusServiceUnavailable))
+		Expect(errorBody(resp.Body.Bytes()).Code).To(Equal(CodeCanceled))
+	})
+})
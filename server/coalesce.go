@@ -0,0 +1,74 @@
+package server
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// flight is a coalesced request in progress
+type flight struct {
+	done  chan struct{}
+	reply []byte
+	err   error
+}
+
+// flights tracks the coalesced requests in progress by exchange, command and message
+type flights struct {
+	mu       sync.Mutex
+	inFlight map[string]*flight
+}
+
+// coalesce sends req unless an identical request is already in flight, in
+// which case it waits for that request's reply instead
+func (s *Server) coalesce(ctx context.Context, req rpcRequest) ([]byte, error) {
+	key := req.Exchange + "\x00" + req.Command + "\x00" + string(req.Message)
+	series := labels{"exchange": req.Exchange, "command": req.Command}
+
+	s.flights.mu.Lock()
+	f, ok := s.flights.inFlight[key]
+	if ok {
+		s.flights.mu.Unlock()
+		s.metrics.add("houston_rpc_coalesced_total", "Requests to Voyager services answered by an identical request already in flight", series, 1)
+	} else {
+		f = &flight{done: make(chan struct{})}
+		if s.flights.inFlight == nil {
+			s.flights.inFlight = map[string]*flight{}
+		}
+		s.flights.inFlight[key] = f
+		s.flights.mu.Unlock()
+
+		// The request is shared, so it must not be canceled when the handler
+		// that happened to send it gives up
+		go func() {
+			f.reply, f.err = s.roundTrip(detach(ctx), req)
+
+			s.flights.mu.Lock()
+			delete(s.flights.inFlight, key)
+			s.flights.mu.Unlock()
+			close(f.done)
+		}()
+	}
+
+	select {
+	case <-f.done:
+		return f.reply, f.err
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// detached is a context with the values of another but never done
+type detached struct {
+	context.Context
+}
+
+func (detached) Deadline() (time.Time, bool) { return time.Time{}, false }
+func (detached) Done() <-chan struct{}       { return nil }
+func (detached) Err() error                  { return nil }
+
+// detach returns a context with the values of ctx, such as the request ID for
+// the audit log, that is not canceled with it
+func detach(ctx context.Context) context.Context {
+	return detached{ctx}
+}
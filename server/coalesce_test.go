@@ -0,0 +1,71 @@
+package server_test
+
+import (
+	"io/ioutil"
+	"net/http"
+	"sync"
+	"time"
+
+	. "github.com/RackHD/voyager-houston/server"
+	"github.com/gin-gonic/gin"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Coalescing", func() {
+	var broker *fakeBroker
+
+	BeforeEach(func() {
+		gin.SetMode(gin.ReleaseMode)
+		gin.DefaultWriter = ioutil.Discard
+
+		broker = &fakeBroker{reply: func(exchange, routingKey, message string) string {
+			time.Sleep(100 * time.Millisecond)
+			return `[{"id":"n1"}]`
+		}}
+	})
+
+	It("UNIT should send one request for identical requests in flight", func() {
+		router := (&Server{MQ: broker}).Router()
+
+		wg := sync.WaitGroup{}
+		codes := make(chan int, 10)
+		for i := 0; i < 10; i++ {
+			wg.Add(1)
+			go func() {
+				defer GinkgoRecover()
+				defer wg.Done()
+
+				resp := get(router, "/api/v1/nodes", nil)
+				Expect(resp.Body.String()).To(Equal(`[{"id":"n1"}]`))
+				codes <- resp.Code
+			}()
+		}
+		wg.Wait()
+		close(codes)
+
+		for code := range codes {
+			Expect(code).To(Equal(http.StatusOK))
+		}
+		Expect(broker.requests()).To(Equal(1))
+
+		metrics := get(router, "/api/v1/metrics", nil).Body.String()
+		Expect(metrics).To(ContainSubstring(`houston_rpc_requests_total{command="get_nodes",exchange="voyager-inventory-service"} 1`))
+		Expect(metrics).To(ContainSubstring(`houston_rpc_coalesced_total{command="get_nodes",exchange="voyager-inventory-service"} 9`))
+	})
+
+	It("UNIT should send a new request once the previous one has finished", func() {
+		router := (&Server{MQ: broker}).Router()
+
+		Expect(get(router, "/api/v1/nodes", nil).Code).To(Equal(http.StatusOK))
+		Expect(get(router, "/api/v1/nodes", nil).Code).To(Equal(http.StatusOK))
+		Expect(broker.requests()).To(Equal(2))
+	})
+
+	It("UNIT should serve metrics in the Prometheus text format", func() {
+		resp := get((&Server{}).Router(), "/api/v1/metrics", nil)
+		Expect(resp.Code).To(Equal(http.StatusOK))
+		Expect(resp.Header().Get("Content-Type")).To(HavePrefix("text/plain; version=0.0.4"))
+	})
+})
@@ -0,0 +1,512 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/RackHD/voyager-houston/model"
+	"github.com/RackHD/voyager-utilities/models"
+	"github.com/gin-gonic/gin"
+	"github.com/jinzhu/gorm"
+)
+
+// dhcpConfigHeader starts every rendered DHCP server config
+const dhcpConfigHeader = "# Generated by voyager-houston from its IPAM data. Changes made here are overwritten.\n"
+
+// configValue matches the option values that can be written into a DHCP
+// server config as they are
+var configValue = regexp.MustCompile(`^[A-Za-z0-9._/:+-]*$`)
+
+// configName turns an ID into a name dnsmasq tags and dhcpd host
+// declarations accept
+func configName(id string) string {
+	return strings.Map(func(r rune) rune {
+		if r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '_' || r == '-' {
+			return r
+		}
+		return '-'
+	}, id)
+}
+
+// parseDHCPOptions checks the DHCP options of a subnet and returns them with
+// their addresses in canonical form
+func parseDHCPOptions(r model.SubnetRangeEntity, options model.DHCPOptions) (model.DHCPOptions, error) {
+	parsed := model.DHCPOptions{Domain: options.Domain, BootFile: options.BootFile}
+	is4 := r.Family == model.FamilyIPv4
+	p, _ := netip.ParsePrefix(r.Prefix)
+
+	if options.Gateway != "" {
+		gateway, err := parseAddr("gateway", options.Gateway)
+		if err != nil {
+			return parsed, err
+		}
+		if !is4 || !gateway.Is4() {
+			return parsed, invalidRangeError("Gateway %s is not an IPv4 address of an IPv4 subnet; IPv6 routers announce themselves", gateway)
+		}
+		if p.IsValid() && !p.Contains(gateway) {
+			return parsed, invalidRangeError("Gateway %s is not in prefix %s", gateway, p)
+		}
+		parsed.Gateway = gateway.String()
+	}
+	for _, text := range options.DNS {
+		server, err := parseAddr("DNS server", text)
+		if err != nil {
+			return parsed, err
+		}
+		if server.Is4() != is4 {
+			return parsed, invalidRangeError("DNS server %s is not in the address family of the subnet (%s)", server, r.Family)
+		}
+		parsed.DNS = append(parsed.DNS, server.String())
+	}
+	if options.NextServer != "" {
+		next, err := parseAddr("next server", options.NextServer)
+		if err != nil {
+			return parsed, err
+		}
+		if !is4 || !next.Is4() {
+			return parsed, invalidRangeError("Next server %s is not an IPv4 address of an IPv4 subnet", next)
+		}
+		parsed.NextServer = next.String()
+	}
+	if !configValue.MatchString(options.Domain) {
+		return parsed, fmt.Errorf("Invalid domain %q", options.Domain)
+	}
+	if !configValue.MatchString(options.BootFile) {
+		return parsed, fmt.Errorf("Invalid boot file %q", options.BootFile)
+	}
+	return parsed, nil
+}
+
+// SetDHCPOptions replaces the DHCP options of a subnet
+func (s *Server) SetDHCPOptions(subnetID string, options model.DHCPOptions) (model.DHCPOptions, error) {
+	subnets, err := s.subnetRanges(models.SubnetEntity{ID: subnetID})
+	if err != nil {
+		return options, err
+	}
+	if subnets[0].SubnetRangeEntity == nil {
+		return options, newAPIError(http.StatusConflict, fmt.Errorf("Houston has no range recorded for subnet %s", subnetID))
+	}
+	if options, err = parseDHCPOptions(*subnets[0].SubnetRangeEntity, options); err != nil {
+		return options, newAPIError(http.StatusBadRequest, err)
+	}
+
+	entity := model.NewDHCPOptionsEntity(subnetID, options)
+	tx := s.MySQL.DB.Begin()
+	if err = tx.Where("subnet_id = ?", subnetID).Delete(&model.DHCPOptionsEntity{}).Error; err != nil {
+		tx.Rollback()
+		return options, err
+	}
+	if err = tx.Create(&entity).Error; err != nil {
+		tx.Rollback()
+		return options, err
+	}
+	return options, tx.Commit().Error
+}
+
+// dhcpHost is a static host entry: an address handed to a node's NICs
+type dhcpHost struct {
+	name    string
+	macs    []string
+	address netip.Addr
+}
+
+// dhcpSubnet is a subnet as a DHCP server config renders it
+type dhcpSubnet struct {
+	model.Subnet
+	options model.DHCPOptions
+	// ranges are the parts of the subnet's range no lease, reservation or
+	// option address holds, which the DHCP server may hand out
+	ranges [][2]netip.Addr
+	hosts  []dhcpHost
+	// unknown lists the leased addresses of nodes with no known MAC address
+	unknown []string
+}
+
+// freeRanges returns the runs of addresses from first to last not in held
+func freeRanges(first, last netip.Addr, held []netip.Addr) [][2]netip.Addr {
+	sort.Slice(held, func(i, j int) bool { return held[i].Less(held[j]) })
+	ranges := [][2]netip.Addr{}
+	next := first
+	for _, addr := range held {
+		if addr.Less(next) || last.Less(addr) {
+			continue
+		}
+		if next.Less(addr) {
+			ranges = append(ranges, [2]netip.Addr{next, addr.Prev()})
+		}
+		if next = addr.Next(); !next.IsValid() || last.Less(next) {
+			return ranges
+		}
+	}
+	return append(ranges, [2]netip.Addr{next, last})
+}
+
+// dhcpSubnets gathers the subnets of a pool, or of every pool if it is
+// empty, with their options and host entries. Subnets Houston recorded no
+// range for are left out, as are those of other families if family is set.
+func (s *Server) dhcpSubnets(ctx context.Context, pool, family string) ([]dhcpSubnet, error) {
+	query := s.MySQL.DB.Order("id")
+	if pool != "" {
+		query = query.Where("pool_id = ?", pool)
+	}
+	entities := []models.SubnetEntity{}
+	if err := query.Find(&entities).Error; err != nil {
+		return nil, err
+	}
+	ranged, err := s.subnetRanges(entities...)
+	if err != nil {
+		return nil, err
+	}
+
+	ids := []string{}
+	for _, subnet := range ranged {
+		ids = append(ids, subnet.ID)
+	}
+	optionEntities := []model.DHCPOptionsEntity{}
+	reservations := []model.ReservationEntity{}
+	leases := []model.LeaseEntity{}
+	if len(ids) > 0 {
+		if err = s.MySQL.DB.Where("subnet_id IN (?)", ids).Find(&optionEntities).Error; err != nil {
+			return nil, err
+		}
+		if err = s.MySQL.DB.Where("subnet_id IN (?)", ids).Find(&reservations).Error; err != nil {
+			return nil, err
+		}
+		if err = s.MySQL.DB.Where("subnet_id IN (?)", ids).Find(&leases).Error; err != nil {
+			return nil, err
+		}
+	}
+	options := map[string]model.DHCPOptions{}
+	for _, entity := range optionEntities {
+		options[entity.SubnetID] = entity.Options()
+	}
+
+	// The MAC addresses of nodes, fetched from inventory the first time a
+	// node's are needed
+	var nodeMACList map[string][]string
+	macsOf := func(node string) ([]string, error) {
+		if nodeMACList == nil {
+			nodes, err := s.inventoryNodes(ctx)
+			if err != nil {
+				return nil, err
+			}
+			nodeMACList = map[string][]string{}
+			for _, n := range nodes {
+				macs := map[string]bool{}
+				nodeMACs(n, macs)
+				for mac := range macs {
+					nodeMACList[n.ID()] = append(nodeMACList[n.ID()], mac)
+				}
+				sort.Strings(nodeMACList[n.ID()])
+			}
+		}
+		return nodeMACList[node], nil
+	}
+
+	subnets := []dhcpSubnet{}
+	for _, subnet := range ranged {
+		r := subnet.SubnetRangeEntity
+		if r == nil || (family != "" && r.Family != family) {
+			continue
+		}
+		first, err := netip.ParseAddr(r.Start)
+		if err != nil {
+			return nil, fmt.Errorf("Subnet %s: %s", subnet.ID, err)
+		}
+		last, err := netip.ParseAddr(r.End)
+		if err != nil {
+			return nil, fmt.Errorf("Subnet %s: %s", subnet.ID, err)
+		}
+		d := dhcpSubnet{Subnet: subnet, options: options[subnet.ID]}
+
+		held := []netip.Addr{}
+		seen := map[netip.Addr]bool{}
+		addHost := func(owner, node, address string, macs []string) error {
+			addr, err := netip.ParseAddr(address)
+			if err != nil || seen[addr] {
+				return nil
+			}
+			seen[addr] = true
+			held = append(held, addr)
+			if macs == nil && node != "" {
+				if macs, err = macsOf(node); err != nil {
+					return err
+				}
+			}
+			if len(macs) == 0 {
+				d.unknown = append(d.unknown, fmt.Sprintf("%s (node %s)", addr, configName(node)))
+				return nil
+			}
+			d.hosts = append(d.hosts, dhcpHost{name: configName(owner), macs: macs, address: addr})
+			return nil
+		}
+		for _, reservation := range reservations {
+			if reservation.SubnetID != subnet.ID {
+				continue
+			}
+			if reservation.MAC != "" {
+				err = addHost(reservation.MAC, "", reservation.Address, []string{reservation.MAC})
+			} else {
+				err = addHost(reservation.NodeID, reservation.NodeID, reservation.Address, nil)
+			}
+			if err != nil {
+				return nil, err
+			}
+		}
+		for _, lease := range leases {
+			if lease.SubnetID != subnet.ID {
+				continue
+			}
+			if err = addHost(lease.NodeID, lease.NodeID, lease.Address, nil); err != nil {
+				return nil, err
+			}
+		}
+		sort.Slice(d.hosts, func(i, j int) bool { return d.hosts[i].address.Less(d.hosts[j].address) })
+
+		for _, address := range append([]string{d.options.Gateway, d.options.NextServer}, d.options.DNS...) {
+			if addr, err := netip.ParseAddr(address); err == nil {
+				held = append(held, addr)
+			}
+		}
+		d.ranges = freeRanges(first, last, held)
+		subnets = append(subnets, d)
+	}
+	return subnets, nil
+}
+
+// netmask returns the IPv4 netmask of a prefix
+func netmask(p netip.Prefix) string {
+	return net.IP(net.CIDRMask(p.Bits(), 32)).String()
+}
+
+// writeSubnetComment introduces a subnet in a rendered config
+func writeSubnetComment(b *bytes.Buffer, subnet dhcpSubnet) {
+	fmt.Fprintf(b, "\n# Subnet %s %q in pool %s\n", configName(subnet.ID), subnet.Name, configName(subnet.PoolID))
+	for _, unknown := range subnet.unknown {
+		fmt.Fprintf(b, "# %s is left out, its node has no known MAC address\n", unknown)
+	}
+}
+
+// renderDnsmasq renders subnets as dnsmasq configuration, tagging each
+// subnet's ranges and options with its ID
+func renderDnsmasq(subnets []dhcpSubnet) []byte {
+	b := bytes.NewBufferString(dhcpConfigHeader)
+	for _, subnet := range subnets {
+		writeSubnetComment(b, subnet)
+		tag := configName(subnet.ID)
+		is4 := subnet.Family == model.FamilyIPv4
+		p, _ := netip.ParsePrefix(subnet.Prefix)
+
+		// Range lines end with the netmask or prefix length, if there is one
+		suffix := ""
+		if p.IsValid() && is4 {
+			suffix = "," + netmask(p)
+		} else if p.IsValid() {
+			suffix = fmt.Sprintf(",%d", p.Bits())
+		}
+		for _, r := range subnet.ranges {
+			fmt.Fprintf(b, "dhcp-range=set:%s,%s,%s%s\n", tag, r[0], r[1], suffix)
+		}
+		if len(subnet.ranges) == 0 {
+			// Only the host entries are served
+			fmt.Fprintf(b, "dhcp-range=set:%s,%s,static%s\n", tag, subnet.Start, suffix)
+		}
+
+		options := subnet.options
+		if is4 {
+			if options.Gateway != "" {
+				fmt.Fprintf(b, "dhcp-option=tag:%s,option:router,%s\n", tag, options.Gateway)
+			}
+			if len(options.DNS) > 0 {
+				fmt.Fprintf(b, "dhcp-option=tag:%s,option:dns-server,%s\n", tag, strings.Join(options.DNS, ","))
+			}
+			if options.Domain != "" {
+				fmt.Fprintf(b, "dhcp-option=tag:%s,option:domain-name,%s\n", tag, options.Domain)
+			}
+			switch {
+			case options.BootFile != "":
+				fmt.Fprintf(b, "dhcp-boot=tag:%s,%s,,%s\n", tag, options.BootFile, options.NextServer)
+			case options.NextServer != "":
+				fmt.Fprintf(b, "dhcp-option=tag:%s,option:tftp-server,%s\n", tag, options.NextServer)
+			}
+		} else {
+			if len(options.DNS) > 0 {
+				fmt.Fprintf(b, "dhcp-option=tag:%s,option6:dns-server,[%s]\n", tag, strings.Join(options.DNS, "],["))
+			}
+			if options.Domain != "" {
+				fmt.Fprintf(b, "dhcp-option=tag:%s,option6:domain-search,%s\n", tag, options.Domain)
+			}
+			if options.BootFile != "" {
+				fmt.Fprintf(b, "dhcp-option=tag:%s,option6:bootfile-url,%s\n", tag, options.BootFile)
+			}
+		}
+
+		for _, host := range subnet.hosts {
+			address := host.address.String()
+			if !is4 {
+				address = "[" + address + "]"
+			}
+			fmt.Fprintf(b, "dhcp-host=%s,%s\n", strings.Join(host.macs, ","), address)
+		}
+	}
+	return b.Bytes()
+}
+
+// renderISC renders subnets of one family as ISC dhcpd configuration:
+// dhcpd.conf for IPv4, or the dhcpd -6 configuration for IPv6. dhcpd needs
+// the prefix of a subnet, so subnets without one are left out.
+func renderISC(subnets []dhcpSubnet) []byte {
+	b := bytes.NewBufferString(dhcpConfigHeader)
+	for _, subnet := range subnets {
+		writeSubnetComment(b, subnet)
+		p, err := netip.ParsePrefix(subnet.Prefix)
+		if err != nil {
+			fmt.Fprintf(b, "# It has no prefix, which dhcpd needs, so it is left out\n")
+			continue
+		}
+		options := subnet.options
+		if subnet.Family == model.FamilyIPv4 {
+			fmt.Fprintf(b, "subnet %s netmask %s {\n", p.Addr(), netmask(p))
+			for _, r := range subnet.ranges {
+				fmt.Fprintf(b, "  range %s %s;\n", r[0], r[1])
+			}
+			if options.Gateway != "" {
+				fmt.Fprintf(b, "  option routers %s;\n", options.Gateway)
+			}
+			if len(options.DNS) > 0 {
+				fmt.Fprintf(b, "  option domain-name-servers %s;\n", strings.Join(options.DNS, ", "))
+			}
+			if options.Domain != "" {
+				fmt.Fprintf(b, "  option domain-name %q;\n", options.Domain)
+			}
+			if options.NextServer != "" {
+				fmt.Fprintf(b, "  next-server %s;\n", options.NextServer)
+			}
+			if options.BootFile != "" {
+				fmt.Fprintf(b, "  filename %q;\n", options.BootFile)
+			}
+		} else {
+			fmt.Fprintf(b, "subnet6 %s {\n", p)
+			for _, r := range subnet.ranges {
+				fmt.Fprintf(b, "  range6 %s %s;\n", r[0], r[1])
+			}
+			if len(options.DNS) > 0 {
+				fmt.Fprintf(b, "  option dhcp6.name-servers %s;\n", strings.Join(options.DNS, ", "))
+			}
+			if options.Domain != "" {
+				fmt.Fprintf(b, "  option dhcp6.domain-search %q;\n", options.Domain)
+			}
+			if options.BootFile != "" {
+				fmt.Fprintf(b, "  option dhcp6.bootfile-url %q;\n", options.BootFile)
+			}
+		}
+		b.WriteString("}\n")
+
+		fixedAddress := "fixed-address"
+		if subnet.Family == model.FamilyIPv6 {
+			fixedAddress = "fixed-address6"
+		}
+		for _, host := range subnet.hosts {
+			for i, mac := range host.macs {
+				name := host.name + "-" + configName(host.address.String())
+				if len(host.macs) > 1 {
+					name += fmt.Sprintf("-%d", i)
+				}
+				fmt.Fprintf(b, "host %s {\n  hardware ethernet %s;\n  %s %s;\n}\n", name, mac, fixedAddress, host.address)
+			}
+		}
+	}
+	return b.Bytes()
+}
+
+// etagMatches reports whether an If-None-Match header matches etag
+func etagMatches(header, etag string) bool {
+	for _, tag := range strings.Split(header, ",") {
+		tag = strings.TrimPrefix(strings.TrimSpace(tag), "W/")
+		if tag == etag || tag == "*" {
+			return true
+		}
+	}
+	return false
+}
+
+// DHCPOptionsHandler Serves GET /subnets/:id/dhcp
+func (s *Server) DHCPOptionsHandler(c *gin.Context) {
+	subnet := models.SubnetEntity{}
+	if !s.find(c, &subnet, c.Param("id")) {
+		return
+	}
+
+	entity := model.DHCPOptionsEntity{}
+	err := s.MySQL.DB.Where("subnet_id = ?", subnet.ID).First(&entity).Error
+	if err != nil && err != gorm.ErrRecordNotFound {
+		abortWithError(c, http.StatusInternalServerError, err)
+		return
+	}
+	c.JSON(http.StatusOK, entity.Options())
+}
+
+// PutDHCPOptionsHandler Serves PUT /subnets/:id/dhcp
+func (s *Server) PutDHCPOptionsHandler(c *gin.Context) {
+	request := model.DHCPOptions{}
+	if !bindJSON(c, &request) {
+		return
+	}
+	subnet := models.SubnetEntity{}
+	if !s.find(c, &subnet, c.Param("id")) {
+		return
+	}
+
+	options, err := s.SetDHCPOptions(subnet.ID, request)
+	if err != nil {
+		abortWithRPCError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, options)
+}
+
+// DHCPConfigHandler Serves GET /dhcp/config?format=dnsmasq|isc, optionally
+// for one ?pool= and ?family=. ISC dhcpd serves one family per config, so
+// format=isc renders IPv4 unless family=ipv6. The ETag is a hash of the
+// config, so If-None-Match is answered with 304 until the config changes.
+func (s *Server) DHCPConfigHandler(c *gin.Context) {
+	format, family := c.DefaultQuery("format", model.DHCPFormatDnsmasq), c.Query("family")
+	if format != model.DHCPFormatDnsmasq && format != model.DHCPFormatISC {
+		abortWithError(c, http.StatusBadRequest, fmt.Errorf("Unknown format %q, expected dnsmasq or isc", format))
+		return
+	}
+	if family != "" && family != model.FamilyIPv4 && family != model.FamilyIPv6 {
+		abortWithError(c, http.StatusBadRequest, fmt.Errorf("Unknown family %q, expected ipv4 or ipv6", family))
+		return
+	}
+	if format == model.DHCPFormatISC && family == "" {
+		family = model.FamilyIPv4
+	}
+
+	subnets, err := s.dhcpSubnets(requestContext(c), c.Query("pool"), family)
+	if err != nil {
+		abortWithRPCError(c, err)
+		return
+	}
+	config := renderDnsmasq(subnets)
+	if format == model.DHCPFormatISC {
+		config = renderISC(subnets)
+	}
+
+	etag := fmt.Sprintf(`"%x"`, sha256.Sum256(config))
+	c.Header("ETag", etag)
+	c.Header("Cache-Control", "no-cache")
+	if etagMatches(c.Request.Header.Get("If-None-Match"), etag) {
+		c.Status(http.StatusNotModified)
+		return
+	}
+	c.Data(http.StatusOK, "text/plain; charset=utf-8", config)
+}
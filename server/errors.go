@@ -0,0 +1,199 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"runtime/debug"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/binding"
+	"github.com/go-sql-driver/mysql"
+	log "github.com/sirupsen/logrus"
+)
+
+// errDuplicateKey is the number of the MySQL error for a duplicate entry in a unique index
+const errDuplicateKey = 1062
+
+// Error codes returned in the error envelope. Clients may rely on these not changing.
+const (
+	CodeBadRequest          = "BAD_REQUEST"
+	CodeValidationFailed    = "VALIDATION_FAILED"
+	CodeUnauthenticated     = "UNAUTHENTICATED"
+	CodeForbidden           = "FORBIDDEN"
+	CodeNotFound            = "NOT_FOUND"
+	CodeMethodNotAllowed    = "METHOD_NOT_ALLOWED"
+	CodeRequestTooLarge     = "REQUEST_TOO_LARGE"
+	CodeConflict            = "CONFLICT"
+	CodeInvalidRange        = "INVALID_RANGE"
+	CodeRateLimited         = "RATE_LIMITED"
+	CodeUpstreamBusy        = "UPSTREAM_BUSY"
+	CodeUpstreamUnavailable = "UPSTREAM_UNAVAILABLE"
+	CodeUpstreamTimeout     = "UPSTREAM_TIMEOUT"
+	CodeUpstreamRejected    = "UPSTREAM_REJECTED"
+	CodeCanceled            = "CANCELED"
+	CodeInternal            = "INTERNAL"
+)
+
+// statusCodes is the code used for an HTTP status when a handler does not pick one
+var statusCodes = map[int]string{
+	http.StatusBadRequest:            CodeBadRequest,
+	http.StatusUnauthorized:          CodeUnauthenticated,
+	http.StatusForbidden:             CodeForbidden,
+	http.StatusNotFound:              CodeNotFound,
+	http.StatusMethodNotAllowed:      CodeMethodNotAllowed,
+	http.StatusConflict:              CodeConflict,
+	http.StatusRequestEntityTooLarge: CodeRequestTooLarge,
+	http.StatusTooManyRequests:       CodeRateLimited,
+	http.StatusBadGateway:            CodeUpstreamUnavailable,
+	http.StatusServiceUnavailable:    CodeUpstreamUnavailable,
+	http.StatusGatewayTimeout:        CodeUpstreamTimeout,
+	http.StatusInternalServerError:   CodeInternal,
+}
+
+// APIError is the error every route responds with, as
+// {"error": {"code", "message", "details", "request_id"}}
+type APIError struct {
+	Status    int         `json:"-"`
+	Code      string      `json:"code"`
+	Message   string      `json:"message"`
+	Details   interface{} `json:"details,omitempty"`
+	RequestID string      `json:"request_id,omitempty"`
+}
+
+func (e *APIError) Error() string {
+	return e.Message
+}
+
+// newAPIError returns an APIError for status with the default code for it
+func newAPIError(status int, err error) *APIError {
+	if apiErr, ok := err.(*APIError); ok {
+		return apiErr
+	}
+
+	code, ok := statusCodes[status]
+	if !ok {
+		code = CodeInternal
+	}
+	return &APIError{Status: status, Code: code, Message: err.Error()}
+}
+
+// upstreamError is returned when another Voyager service cannot be reached
+type upstreamError struct {
+	err error
+}
+
+func (e *upstreamError) Error() string {
+	return e.err.Error()
+}
+
+// replyLostError is returned when a command was sent to another Voyager
+// service but its reply was lost. The service may have acted on it, so it is
+// reported like a timeout rather than as a service that cannot be reached.
+type replyLostError struct {
+	err error
+}
+
+func (e *replyLostError) Error() string {
+	return e.err.Error()
+}
+
+// rejectedError is returned when another Voyager service refuses a command
+func rejectedError(by, command, reason string) *APIError {
+	return &APIError{
+		Status:  http.StatusUnprocessableEntity,
+		Code:    CodeUpstreamRejected,
+		Message: fmt.Sprintf("%s refused to %s: %s", by, command, reason),
+	}
+}
+
+// Errors renders the error recorded by abortWithError, or a panic in a later
+// handler, as the error envelope
+func (s *Server) Errors(c *gin.Context) {
+	defer func() {
+		if r := recover(); r != nil {
+			log.Errorf("Panic serving %s %s: %v\n%s", c.Request.Method, c.Request.URL.Path, r, debug.Stack())
+			c.Abort()
+			abortWithAPIError(c, &APIError{Status: http.StatusInternalServerError, Code: CodeInternal, Message: "Internal server error"})
+			renderError(c)
+		}
+	}()
+
+	c.Next()
+	renderError(c)
+}
+
+// renderError writes the last error recorded for the request unless a response has already been written
+func renderError(c *gin.Context) {
+	last := c.Errors.Last()
+	if last == nil || c.Writer.Written() {
+		return
+	}
+
+	apiErr, ok := last.Err.(*APIError)
+	if !ok {
+		apiErr = newAPIError(http.StatusInternalServerError, last.Err)
+	}
+	apiErr.RequestID = requestID(c)
+	c.JSON(apiErr.Status, gin.H{"error": apiErr})
+}
+
+// NotFoundHandler responds to requests for routes that do not exist
+func (s *Server) NotFoundHandler(c *gin.Context) {
+	abortWithError(c, http.StatusNotFound, fmt.Errorf("No route for %s %s", c.Request.Method, c.Request.URL.Path))
+}
+
+// abortWithAPIError records err to be rendered by Errors and stops the handler chain
+func abortWithAPIError(c *gin.Context, err *APIError) {
+	c.Status(err.Status)
+	c.Error(err)
+	c.Abort()
+}
+
+// abortWithError responds with status and err in the error envelope and stops the handler chain
+func abortWithError(c *gin.Context, status int, err error) {
+	abortWithAPIError(c, newAPIError(status, err))
+}
+
+// abortWithRPCError responds with the error envelope for an error calling another Voyager service
+func abortWithRPCError(c *gin.Context, err error) {
+	switch err.(type) {
+	case *upstreamError:
+		abortWithAPIError(c, &APIError{Status: http.StatusBadGateway, Code: CodeUpstreamUnavailable, Message: err.Error()})
+		return
+	case *replyLostError:
+		abortWithAPIError(c, &APIError{Status: http.StatusGatewayTimeout, Code: CodeUpstreamTimeout, Message: err.Error()})
+		return
+	case *APIError:
+		abortWithAPIError(c, err.(*APIError))
+		return
+	}
+
+	switch err {
+	case errRPCTimeout:
+		abortWithAPIError(c, &APIError{Status: http.StatusGatewayTimeout, Code: CodeUpstreamTimeout, Message: err.Error()})
+	case errTooManyInFlight:
+		c.Header("Retry-After", "1")
+		abortWithAPIError(c, &APIError{Status: http.StatusTooManyRequests, Code: CodeUpstreamBusy, Message: err.Error()})
+	case context.Canceled, context.DeadlineExceeded:
+		abortWithAPIError(c, &APIError{Status: http.StatusServiceUnavailable, Code: CodeCanceled, Message: "Request was canceled"})
+	default:
+		abortWithError(c, http.StatusInternalServerError, err)
+	}
+}
+
+// bindJSON decodes the request body into obj, responding with a 400 if it cannot
+func bindJSON(c *gin.Context, obj interface{}) bool {
+	if err := binding.JSON.Bind(c.Request, obj); err != nil {
+		abortWithError(c, http.StatusBadRequest, err)
+		return false
+	}
+	return true
+}
+
+// isDuplicateKey reports whether err is MySQL refusing a duplicate entry in the unique index named index
+func isDuplicateKey(err error, index string) bool {
+	mysqlErr, ok := err.(*mysql.MySQLError)
+	return ok && mysqlErr.Number == errDuplicateKey && strings.Contains(mysqlErr.Message, "'"+index+"'")
+}
@@ -0,0 +1,112 @@
+package server_test
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+
+	. "github.com/RackHD/voyager-houston/server"
+	"github.com/gin-gonic/gin"
+	samqp "github.com/streadway/amqp"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+// downBroker fails as if RabbitMQ could not be reached
+type downBroker struct{}
+
+func (downBroker) Listen(exchange, exchangeType, queueName, bindingKey, consumerTag string) (*samqp.Channel, <-chan samqp.Delivery, error) {
+	return nil, nil, errors.New("connection refused")
+}
+
+func (downBroker) Send(exchange, exchangeType, routingKey, message, correlationID, replyTo string) error {
+	return errors.New("connection refused")
+}
+
+func (downBroker) Close() {}
+
+// lostBroker sends commands, then closes the reply queue before a reply arrives
+type lostBroker struct {
+	deliveries chan samqp.Delivery
+}
+
+func (b *lostBroker) Listen(exchange, exchangeType, queueName, bindingKey, consumerTag string) (*samqp.Channel, <-chan samqp.Delivery, error) {
+	b.deliveries = make(chan samqp.Delivery)
+	return nil, b.deliveries, nil
+}
+
+func (b *lostBroker) Send(exchange, exchangeType, routingKey, message, correlationID, replyTo string) error {
+	close(b.deliveries)
+	return nil
+}
+
+func (b *lostBroker) Close() {}
+
+// errorBody decodes the error envelope of a response
+func errorBody(body []byte) APIError {
+	envelope := map[string]APIError{}
+	Expect(json.Unmarshal(body, &envelope)).To(Succeed())
+	Expect(envelope).To(HaveKey("error"))
+	return envelope["error"]
+}
+
+var _ = Describe("Errors", func() {
+	BeforeEach(func() {
+		gin.SetMode(gin.ReleaseMode)
+		gin.DefaultWriter = ioutil.Discard
+	})
+
+	It("UNIT should answer unknown routes with NOT_FOUND", func() {
+		resp := get((&Server{}).Router(), "/api/v1/nowhere", map[string]string{"X-Request-ID": "req-404"})
+		Expect(resp.Code).To(Equal(http.StatusNotFound))
+
+		apiErr := errorBody(resp.Body.Bytes())
+		Expect(apiErr.Code).To(Equal(CodeNotFound))
+		Expect(apiErr.RequestID).To(Equal("req-404"))
+	})
+
+	It("UNIT should list validation problems in the details", func() {
+		resp := do((&Server{}).Router(), "POST", "/api/v1/pools", map[string]interface{}{}, nil)
+		Expect(resp.Code).To(Equal(http.StatusBadRequest))
+
+		apiErr := errorBody(resp.Body.Bytes())
+		Expect(apiErr.Code).To(Equal(CodeValidationFailed))
+		Expect(apiErr.Details).To(ConsistOf("body.name is required"))
+	})
+
+	It("UNIT should answer with UPSTREAM_UNAVAILABLE when RabbitMQ cannot be reached", func() {
+		resp := get((&Server{MQ: downBroker{}}).Router(), "/api/v1/nodes", nil)
+		Expect(resp.Code).To(Equal(http.StatusBadGateway))
+		Expect(errorBody(resp.Body.Bytes()).Code).To(Equal(CodeUpstreamUnavailable))
+	})
+
+	It("UNIT should answer with UPSTREAM_TIMEOUT when the reply to a sent command is lost", func() {
+		resp := do((&Server{MQ: &lostBroker{}}).Router(), "POST", "/api/v1/nodes/n1/power", map[string]string{"action": "cycle"}, nil)
+		Expect(resp.Code).To(Equal(http.StatusGatewayTimeout), resp.Body.String())
+		Expect(errorBody(resp.Body.Bytes()).Code).To(Equal(CodeUpstreamTimeout))
+	})
+
+	It("UNIT should recover from panics with INTERNAL", func() {
+		router := (&Server{}).Router()
+		router.GET("/api/v1/panic", func(c *gin.Context) {
+			panic("something broke")
+		})
+
+		resp := get(router, "/api/v1/panic", map[string]string{"X-Request-ID": "req-500"})
+		Expect(resp.Code).To(Equal(http.StatusInternalServerError))
+
+		apiErr := errorBody(resp.Body.Bytes())
+		Expect(apiErr.Code).To(Equal(CodeInternal))
+		Expect(apiErr.Message).ToNot(ContainSubstring("something broke"))
+		Expect(apiErr.RequestID).To(Equal("req-500"))
+	})
+
+	It("UNIT should answer authentication failures with UNAUTHENTICATED", func() {
+		s := &Server{Authenticators: []Authenticator{&JWTAuthenticator{Secret: []byte("secret")}}}
+		resp := get(s.Router(), "/api/v1/whoami", nil)
+		Expect(resp.Code).To(Equal(http.StatusUnauthorized))
+		Expect(errorBody(resp.Body.Bytes()).Code).To(Equal(CodeUnauthenticated))
+	})
+})
@@ -0,0 +1,473 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/RackHD/voyager-houston/model"
+	"github.com/RackHD/voyager-utilities/models"
+	"github.com/gin-gonic/gin"
+	log "github.com/sirupsen/logrus"
+)
+
+// jobImportIPAM is the kind of job POST /ipam/import starts with "Prefer: respond-async"
+const jobImportIPAM = "import_ipam"
+
+// ipamImportRequest is the request of an import_ipam job
+type ipamImportRequest struct {
+	DryRun bool             `json:"dry_run"`
+	Export model.IPAMExport `json:"export"`
+}
+
+// ExportIPAM returns every pool, subnet, reservation and lease in Houston's tables
+func (s *Server) ExportIPAM() (model.IPAMExport, error) {
+	export := model.IPAMExport{
+		Version:      model.IPAMExportVersion,
+		Exported:     time.Now().UTC(),
+		Pools:        []model.ExportedPool{},
+		Subnets:      []model.ExportedSubnet{},
+		Reservations: []model.ExportedReservation{},
+		Leases:       []model.ExportedLease{},
+	}
+
+	poolEntities := []models.PoolEntity{}
+	if err := s.MySQL.DB.Order("id").Find(&poolEntities).Error; err != nil {
+		return export, err
+	}
+	pools, err := s.poolPrefixes(poolEntities...)
+	if err != nil {
+		return export, err
+	}
+	for _, pool := range pools {
+		export.Pools = append(export.Pools, model.ExportedPool{ID: pool.ID, Name: pool.Name, Prefixes: pool.Prefixes})
+	}
+
+	subnetEntities := []models.SubnetEntity{}
+	if err = s.MySQL.DB.Order("id").Find(&subnetEntities).Error; err != nil {
+		return export, err
+	}
+	subnets, err := s.subnetRanges(subnetEntities...)
+	if err != nil {
+		return export, err
+	}
+	optionEntities := []model.DHCPOptionsEntity{}
+	if err = s.MySQL.DB.Find(&optionEntities).Error; err != nil {
+		return export, err
+	}
+	options := map[string]model.DHCPOptions{}
+	for _, entity := range optionEntities {
+		options[entity.SubnetID] = entity.Options()
+	}
+	for _, subnet := range subnets {
+		exported := model.ExportedSubnet{ID: subnet.ID, Name: subnet.Name, Pool: subnet.PoolID}
+		if r := subnet.SubnetRangeEntity; r != nil {
+			exported.Family, exported.Prefix, exported.Start, exported.End = r.Family, r.Prefix, r.Start, r.End
+		}
+		if subnetOptions, ok := options[subnet.ID]; ok {
+			exported.DHCP = &subnetOptions
+		}
+		export.Subnets = append(export.Subnets, exported)
+	}
+
+	reservations := []model.ReservationEntity{}
+	if err = s.MySQL.DB.Order("subnet_id, address").Find(&reservations).Error; err != nil {
+		return export, err
+	}
+	for _, reservation := range reservations {
+		export.Reservations = append(export.Reservations, model.ExportedReservation{
+			Subnet:  reservation.SubnetID,
+			Address: reservation.Address,
+			MAC:     reservation.MAC,
+			Node:    reservation.NodeID,
+		})
+	}
+
+	leases := []model.LeaseEntity{}
+	if err = s.MySQL.DB.Order("id").Find(&leases).Error; err != nil {
+		return export, err
+	}
+	for _, lease := range leases {
+		export.Leases = append(export.Leases, model.ExportedLease{
+			ID:        lease.ID,
+			Subnet:    lease.SubnetID,
+			Node:      lease.NodeID,
+			Address:   lease.Address,
+			ExpiresAt: lease.ExpiresAt,
+		})
+	}
+	return export, nil
+}
+
+// checkExportVersion refuses documents ImportIPAM cannot read
+func checkExportVersion(export model.IPAMExport) error {
+	if export.Version != model.IPAMExportVersion {
+		return fmt.Errorf("Unsupported export version %d, expected %d", export.Version, model.IPAMExportVersion)
+	}
+	return nil
+}
+
+// importOutcome is the outcome of importing an object that failed with err
+func importOutcome(err error) string {
+	if apiErr, ok := err.(*APIError); ok && (apiErr.Code == CodeConflict || apiErr.Code == CodeInvalidRange) {
+		return model.ImportConflict
+	}
+	return model.ImportFailed
+}
+
+// importDHCPOptions gives an existing subnet the DHCP options of an export,
+// and reports whether they differed from its own. A dry run only compares them.
+func (s *Server) importDHCPOptions(subnetID string, r model.SubnetRangeEntity, options model.DHCPOptions, dryRun bool) (bool, error) {
+	wanted, err := parseDHCPOptions(r, options)
+	if err != nil {
+		return false, err
+	}
+	stored := []model.DHCPOptionsEntity{}
+	if err = s.MySQL.DB.Where("subnet_id = ?", subnetID).Find(&stored).Error; err != nil {
+		return false, err
+	}
+	if len(stored) > 0 && reflect.DeepEqual(stored[0].Options(), wanted) {
+		return false, nil
+	}
+	if !dryRun {
+		if _, err = s.SetDHCPOptions(subnetID, wanted); err != nil {
+			return false, err
+		}
+	}
+	return true, nil
+}
+
+// sameSet reports whether a and b hold the same strings
+func sameSet(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	a, b = append([]string{}, a...), append([]string{}, b...)
+	sort.Strings(a)
+	sort.Strings(b)
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// ImportIPAM replays an export through the IPAM service: pools, then
+// subnets, then reservations, then leases. Pools are matched by name and
+// subnets by name within their pool, so importing a document twice creates
+// nothing the second time. Existing subnets are given the DHCP options of
+// the document. Leases of reserved addresses are left to the reservations,
+// which lease them once their node is known.
+// Objects that differ from the ones here are reported as conflicts and left
+// alone, as are the subnets and leases that depend on them. A dry run only
+// reports what an import would do, checking subnets against the ones that
+// exist now. progress, if not nil, is given the percentage of objects done.
+func (s *Server) ImportIPAM(ctx context.Context, export model.IPAMExport, dryRun bool, progress func(percent int)) (model.ImportReport, error) {
+	report := model.ImportReport{DryRun: dryRun, Items: []model.ImportItem{}, Counts: map[string]int{}}
+	if err := checkExportVersion(export); err != nil {
+		return report, err
+	}
+
+	if !dryRun {
+		s.importMu.Lock()
+		defer s.importMu.Unlock()
+	}
+
+	total := len(export.Pools) + len(export.Subnets) + len(export.Reservations) + len(export.Leases)
+	record := func(item model.ImportItem) {
+		report.Items = append(report.Items, item)
+		report.Counts[item.Outcome]++
+		if progress != nil {
+			progress(len(report.Items) * 100 / total)
+		}
+	}
+
+	// IDs here of the pools and subnets of the document, or "" for those a
+	// dry run would create
+	pools, subnets := map[string]string{}, map[string]string{}
+
+	for _, pool := range export.Pools {
+		if err := ctx.Err(); err != nil {
+			return report, err
+		}
+		item := model.ImportItem{Kind: model.ImportPool, ID: pool.ID}
+		prefixes, err := parsePoolPrefixes(pool.Prefixes)
+		if err != nil {
+			item.Outcome, item.Message = model.ImportFailed, err.Error()
+			record(item)
+			continue
+		}
+
+		existing := []models.PoolEntity{}
+		if err = s.MySQL.DB.Where("name = ?", pool.Name).Find(&existing).Error; err != nil {
+			return report, err
+		}
+		switch {
+		case len(existing) > 0:
+			found, err := s.poolPrefixes(existing[0])
+			if err != nil {
+				return report, err
+			}
+			if sameSet(found[0].Prefixes, prefixes) {
+				item.Outcome, item.NewID = model.ImportExists, existing[0].ID
+				pools[pool.ID] = existing[0].ID
+			} else {
+				item.Outcome = model.ImportConflict
+				item.Message = fmt.Sprintf("Pool %s already exists with prefixes [%s], not [%s]", pool.Name, strings.Join(found[0].Prefixes, ", "), strings.Join(prefixes, ", "))
+			}
+		case dryRun:
+			item.Outcome = model.ImportCreated
+			pools[pool.ID] = ""
+		default:
+			if item.NewID, err = s.CreatePool(ctx, pool.Name, "", prefixes); err != nil {
+				item.Outcome, item.Message = importOutcome(err), err.Error()
+			} else {
+				item.Outcome = model.ImportCreated
+				pools[pool.ID] = item.NewID
+			}
+		}
+		record(item)
+	}
+
+	for _, subnet := range export.Subnets {
+		if err := ctx.Err(); err != nil {
+			return report, err
+		}
+		item := model.ImportItem{Kind: model.ImportSubnet, ID: subnet.ID}
+		poolID, ok := pools[subnet.Pool]
+		if !ok {
+			item.Outcome, item.Message = model.ImportSkipped, fmt.Sprintf("Pool %s was not imported", subnet.Pool)
+			record(item)
+			continue
+		}
+		if subnet.Prefix == "" && subnet.Start == "" {
+			item.Outcome, item.Message = model.ImportSkipped, "The subnet has no recorded range"
+			record(item)
+			continue
+		}
+		subnetRange, err := parseSubnetRange(subnet.Prefix, subnet.Start, subnet.End)
+		if err == nil && subnet.DHCP != nil {
+			_, err = parseDHCPOptions(subnetRange, *subnet.DHCP)
+		}
+		if err != nil {
+			item.Outcome, item.Message = model.ImportFailed, err.Error()
+			record(item)
+			continue
+		}
+
+		existing := []models.SubnetEntity{}
+		if poolID != "" {
+			if err = s.MySQL.DB.Where("name = ? AND pool_id = ?", subnet.Name, poolID).Find(&existing).Error; err != nil {
+				return report, err
+			}
+		}
+		switch {
+		case len(existing) > 0:
+			found, err := s.subnetRanges(existing[0])
+			if err != nil {
+				return report, err
+			}
+			r := found[0].SubnetRangeEntity
+			if r != nil && r.Prefix == subnetRange.Prefix && r.Start == subnetRange.Start && r.End == subnetRange.End {
+				item.Outcome, item.NewID = model.ImportExists, existing[0].ID
+				subnets[subnet.ID] = existing[0].ID
+				if subnet.DHCP == nil {
+					break
+				}
+				updated, err := s.importDHCPOptions(existing[0].ID, *r, *subnet.DHCP, dryRun)
+				if err != nil {
+					item.Message = fmt.Sprintf("DHCP options not set: %s", err)
+				} else if updated {
+					item.Outcome = model.ImportUpdated
+				}
+			} else {
+				item.Outcome, item.Message = model.ImportConflict, fmt.Sprintf("Subnet %s already exists in pool %s with another range", subnet.Name, poolID)
+			}
+		case dryRun:
+			if err = s.checkSubnet(poolID, subnetRange); err != nil {
+				if _, ok := err.(*APIError); !ok {
+					return report, err
+				}
+				item.Outcome, item.Message = model.ImportConflict, err.Error()
+			} else {
+				item.Outcome = model.ImportCreated
+				subnets[subnet.ID] = ""
+			}
+		default:
+			if item.NewID, err = s.CreateSubnet(ctx, subnet.Name, poolID, subnetRange); err != nil {
+				item.Outcome, item.Message = importOutcome(err), err.Error()
+				break
+			}
+			item.Outcome = model.ImportCreated
+			subnets[subnet.ID] = item.NewID
+			if subnet.DHCP != nil {
+				if _, err = s.SetDHCPOptions(item.NewID, *subnet.DHCP); err != nil {
+					item.Message = fmt.Sprintf("DHCP options not set: %s", err)
+				}
+			}
+		}
+		record(item)
+	}
+
+	// Addresses of the document reserved in subnets it imports
+	reserved := map[string]bool{}
+	for _, reservation := range export.Reservations {
+		if err := ctx.Err(); err != nil {
+			return report, err
+		}
+		item := model.ImportItem{Kind: model.ImportReservation, ID: reservation.Subnet + "/" + reservation.Address}
+		subnetID, ok := subnets[reservation.Subnet]
+		if !ok {
+			item.Outcome, item.Message = model.ImportSkipped, fmt.Sprintf("Subnet %s was not imported", reservation.Subnet)
+			record(item)
+			continue
+		}
+		request := model.ReservationRequest{Address: reservation.Address, MAC: reservation.MAC, Node: reservation.Node}
+		wanted, err := parseReservation(subnetID, request)
+		if err != nil {
+			item.Outcome, item.Message = model.ImportFailed, err.Error()
+			record(item)
+			continue
+		}
+		reserved[reservation.Subnet+"/"+wanted.Address] = true
+
+		existing := []model.ReservationEntity{}
+		if subnetID != "" {
+			if err = s.MySQL.DB.Where("subnet_id = ? AND address = ?", subnetID, wanted.Address).Find(&existing).Error; err != nil {
+				return report, err
+			}
+		}
+		switch {
+		case len(existing) > 0 && existing[0].MAC == wanted.MAC && existing[0].NodeID == wanted.NodeID:
+			item.Outcome, item.NewID = model.ImportExists, existing[0].LeaseID
+		case len(existing) > 0:
+			item.Outcome, item.Message = model.ImportConflict, fmt.Sprintf("Address %s is already reserved for %s", wanted.Address, reservationOwner(existing[0]))
+		case dryRun:
+			item.Outcome = model.ImportCreated
+		default:
+			created, err := s.Reserve(ctx, subnetID, request)
+			if err != nil {
+				item.Outcome, item.Message = importOutcome(err), err.Error()
+			} else {
+				item.Outcome, item.NewID = model.ImportCreated, created.LeaseID
+			}
+		}
+		record(item)
+	}
+
+	now := time.Now()
+	for _, lease := range export.Leases {
+		if err := ctx.Err(); err != nil {
+			return report, err
+		}
+		item := model.ImportItem{Kind: model.ImportLease, ID: lease.ID}
+		subnetID, ok := subnets[lease.Subnet]
+		if !ok {
+			item.Outcome, item.Message = model.ImportSkipped, fmt.Sprintf("Subnet %s was not imported", lease.Subnet)
+			record(item)
+			continue
+		}
+		if lease.ExpiresAt != nil && lease.ExpiresAt.Before(now) {
+			item.Outcome, item.Message = model.ImportSkipped, fmt.Sprintf("The lease expired at %s", lease.ExpiresAt.Format(time.RFC3339))
+			record(item)
+			continue
+		}
+		address, err := parseAddr("leased", lease.Address)
+		if err != nil {
+			item.Outcome, item.Message = model.ImportFailed, err.Error()
+			record(item)
+			continue
+		}
+
+		held := []model.LeaseEntity{}
+		if subnetID != "" {
+			if err = s.MySQL.DB.Where("subnet_id = ? AND address = ?", subnetID, address.String()).Find(&held).Error; err != nil {
+				return report, err
+			}
+		}
+		switch {
+		case len(held) > 0 && held[0].NodeID == lease.Node:
+			item.Outcome, item.NewID = model.ImportExists, held[0].ID
+		case reserved[lease.Subnet+"/"+address.String()]:
+			item.Outcome, item.Message = model.ImportSkipped, "The address is reserved, and is leased once its node is known"
+		case len(held) > 0:
+			item.Outcome, item.Message = model.ImportConflict, fmt.Sprintf("Address %s is already leased to node %s", address, held[0].NodeID)
+		case dryRun:
+			item.Outcome = model.ImportCreated
+		default:
+			leased, err := s.leaseAddress(ctx, subnetID, lease.Node, address.String(), lease.ExpiresAt)
+			switch {
+			case err != nil:
+				item.Outcome, item.Message = importOutcome(err), err.Error()
+			case leased.Address != address.String():
+				item.Outcome, item.Message = model.ImportConflict, fmt.Sprintf("voyager-ipam-service leased %s instead of %s", leased.Address, address)
+				if err = s.ReleaseAddress(ctx, leased.ID); err != nil {
+					log.Warnf("Could not release lease %s of %s: %s", leased.ID, leased.Address, err)
+				}
+			default:
+				item.Outcome, item.NewID = model.ImportCreated, leased.ID
+			}
+		}
+		record(item)
+	}
+
+	log.Infof("Imported IPAM export (dry run %t): %v", dryRun, report.Counts)
+	return report, nil
+}
+
+// ExportIPAMHandler Serves GET /ipam/export
+func (s *Server) ExportIPAMHandler(c *gin.Context) {
+	export, err := s.ExportIPAM()
+	if err != nil {
+		abortWithError(c, http.StatusInternalServerError, err)
+		return
+	}
+	c.JSON(http.StatusOK, export)
+}
+
+// ImportIPAMHandler Serves POST /ipam/import, which only reports what it
+// would do with ?dry_run=true
+func (s *Server) ImportIPAMHandler(c *gin.Context) {
+	export := model.IPAMExport{}
+	if !bindJSON(c, &export) {
+		return
+	}
+	if err := checkExportVersion(export); err != nil {
+		abortWithError(c, http.StatusBadRequest, err)
+		return
+	}
+	dryRun := false
+	if value, ok := c.GetQuery("dry_run"); ok {
+		var err error
+		if dryRun, err = strconv.ParseBool(value); err != nil {
+			abortWithError(c, http.StatusBadRequest, fmt.Errorf("Invalid dry_run %q, expected true or false", value))
+			return
+		}
+	}
+
+	if preferAsync(c) {
+		s.startJob(c, jobImportIPAM, ipamImportRequest{DryRun: dryRun, Export: export})
+		return
+	}
+
+	report, err := s.ImportIPAM(requestContext(c), export, dryRun, nil)
+	if err != nil {
+		abortWithRPCError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, report)
+}
+
+func importIPAMJob(s *Server, ctx context.Context, job *jobRun) (interface{}, error) {
+	request := ipamImportRequest{}
+	if err := job.decode(&request); err != nil {
+		return nil, err
+	}
+	return s.ImportIPAM(ctx, request.Export, request.DryRun, job.progress)
+}
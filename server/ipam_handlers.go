@@ -0,0 +1,402 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/RackHD/voyager-houston/model"
+	"github.com/RackHD/voyager-utilities/models"
+	"github.com/gin-gonic/gin"
+	"github.com/jinzhu/gorm"
+)
+
+// ListPoolsHandler Serves GET /pools
+func (s *Server) ListPoolsHandler(c *gin.Context) {
+	entities := []models.PoolEntity{}
+	if err := s.MySQL.DB.Find(&entities).Error; err != nil {
+		abortWithError(c, http.StatusInternalServerError, err)
+		return
+	}
+	pools, err := s.poolPrefixes(entities...)
+	if err != nil {
+		abortWithError(c, http.StatusInternalServerError, err)
+		return
+	}
+	c.JSON(http.StatusOK, pools)
+}
+
+// GetPoolHandler Serves GET /pools/:id
+func (s *Server) GetPoolHandler(c *gin.Context) {
+	entity := models.PoolEntity{}
+	if !s.find(c, &entity, c.Param("id")) {
+		return
+	}
+	pools, err := s.poolPrefixes(entity)
+	if err != nil {
+		abortWithError(c, http.StatusInternalServerError, err)
+		return
+	}
+	c.JSON(http.StatusOK, pools[0])
+}
+
+// poolPrefixes adds the prefixes Houston recorded to pools
+func (s *Server) poolPrefixes(entities ...models.PoolEntity) ([]model.Pool, error) {
+	ids := []string{}
+	for _, entity := range entities {
+		ids = append(ids, entity.ID)
+	}
+	ranges := []model.PoolRangeEntity{}
+	if len(ids) > 0 {
+		if err := s.MySQL.DB.Where("pool_id IN (?)", ids).Order("prefix").Find(&ranges).Error; err != nil {
+			return nil, err
+		}
+	}
+
+	byID := map[string][]string{}
+	for _, r := range ranges {
+		byID[r.PoolID] = append(byID[r.PoolID], r.Prefix)
+	}
+	pools := []model.Pool{}
+	for _, entity := range entities {
+		pools = append(pools, model.Pool{PoolEntity: entity, Prefixes: byID[entity.ID]})
+	}
+	return pools, nil
+}
+
+// CreatePoolHandler Serves POST /pools
+func (s *Server) CreatePoolHandler(c *gin.Context) {
+	request := model.CreatePoolRequest{}
+	if !bindJSON(c, &request) {
+		return
+	}
+	if _, err := parsePoolPrefixes(request.Prefixes); err != nil {
+		abortWithError(c, http.StatusBadRequest, err)
+		return
+	}
+
+	if preferAsync(c) {
+		s.startJob(c, jobCreatePool, request)
+		return
+	}
+
+	poolID, err := s.CreatePool(requestContext(c), request.Name, request.Metadata, request.Prefixes)
+	if err != nil {
+		abortWithRPCError(c, err)
+		return
+	}
+	c.JSON(http.StatusCreated, model.Created{ID: poolID})
+}
+
+// DeletePoolHandler Serves DELETE /pools/:id
+func (s *Server) DeletePoolHandler(c *gin.Context) {
+	pool := models.PoolEntity{}
+	if !s.find(c, &pool, c.Param("id")) {
+		return
+	}
+
+	subnets := 0
+	if err := s.MySQL.DB.Model(&models.SubnetEntity{}).Where("pool_id = ?", pool.ID).Count(&subnets).Error; err != nil {
+		abortWithError(c, http.StatusInternalServerError, err)
+		return
+	}
+	if subnets > 0 {
+		abortWithError(c, http.StatusConflict, fmt.Errorf("Pool %s still has %d subnets", pool.ID, subnets))
+		return
+	}
+
+	if preferAsync(c) {
+		s.startJob(c, jobDeletePool, targetRequest{ID: pool.ID})
+		return
+	}
+
+	if err := s.DeletePool(requestContext(c), pool.ID); err != nil {
+		abortWithRPCError(c, err)
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
+
+// ListSubnetsHandler Serves GET /subnets, optionally filtered by ?pool= and ?family=
+func (s *Server) ListSubnetsHandler(c *gin.Context) {
+	query := s.MySQL.DB
+	if pool := c.Query("pool"); pool != "" {
+		query = query.Where("pool_id = ?", pool)
+	}
+
+	entities := []models.SubnetEntity{}
+	if err := query.Find(&entities).Error; err != nil {
+		abortWithError(c, http.StatusInternalServerError, err)
+		return
+	}
+	subnets, err := s.subnetRanges(entities...)
+	if err != nil {
+		abortWithError(c, http.StatusInternalServerError, err)
+		return
+	}
+
+	if family := c.Query("family"); family != "" {
+		matching := []model.Subnet{}
+		for _, subnet := range subnets {
+			if subnet.SubnetRangeEntity != nil && subnet.Family == family {
+				matching = append(matching, subnet)
+			}
+		}
+		subnets = matching
+	}
+	c.JSON(http.StatusOK, subnets)
+}
+
+// GetSubnetHandler Serves GET /subnets/:id
+func (s *Server) GetSubnetHandler(c *gin.Context) {
+	entity := models.SubnetEntity{}
+	if !s.find(c, &entity, c.Param("id")) {
+		return
+	}
+	subnets, err := s.subnetRanges(entity)
+	if err != nil {
+		abortWithError(c, http.StatusInternalServerError, err)
+		return
+	}
+	c.JSON(http.StatusOK, subnets[0])
+}
+
+// subnetRanges adds the family and range Houston recorded to subnets
+func (s *Server) subnetRanges(entities ...models.SubnetEntity) ([]model.Subnet, error) {
+	ids := []string{}
+	for _, entity := range entities {
+		ids = append(ids, entity.ID)
+	}
+	ranges := []model.SubnetRangeEntity{}
+	if len(ids) > 0 {
+		if err := s.MySQL.DB.Where("subnet_id IN (?)", ids).Find(&ranges).Error; err != nil {
+			return nil, err
+		}
+	}
+
+	byID := map[string]*model.SubnetRangeEntity{}
+	for i := range ranges {
+		byID[ranges[i].SubnetID] = &ranges[i]
+	}
+	subnets := []model.Subnet{}
+	for _, entity := range entities {
+		subnets = append(subnets, model.Subnet{SubnetEntity: entity, SubnetRangeEntity: byID[entity.ID]})
+	}
+	return subnets, nil
+}
+
+// CreateSubnetHandler Serves POST /subnets
+func (s *Server) CreateSubnetHandler(c *gin.Context) {
+	request := model.CreateSubnetRequest{}
+	if !bindJSON(c, &request) {
+		return
+	}
+	subnetRange, err := parseSubnetRange(request.Prefix, request.Start, request.End)
+	if err != nil {
+		abortWithError(c, http.StatusBadRequest, err)
+		return
+	}
+
+	pool := models.PoolEntity{}
+	if !s.find(c, &pool, request.Pool) {
+		return
+	}
+	// CreateSubnet checks again, but a job should not be started for a
+	// range that is refused
+	if err := s.checkSubnet(pool.ID, subnetRange); err != nil {
+		abortWithError(c, http.StatusInternalServerError, err)
+		return
+	}
+
+	if preferAsync(c) {
+		s.startJob(c, jobCreateSubnet, request)
+		return
+	}
+
+	subnetID, err := s.CreateSubnet(requestContext(c), request.Name, pool.ID, subnetRange)
+	if err != nil {
+		abortWithRPCError(c, err)
+		return
+	}
+	c.JSON(http.StatusCreated, model.Created{ID: subnetID})
+}
+
+// DeleteSubnetHandler Serves DELETE /subnets/:id
+func (s *Server) DeleteSubnetHandler(c *gin.Context) {
+	subnet := models.SubnetEntity{}
+	if !s.find(c, &subnet, c.Param("id")) {
+		return
+	}
+
+	leases := 0
+	if err := s.MySQL.DB.Model(&model.LeaseEntity{}).Where("subnet_id = ?", subnet.ID).Count(&leases).Error; err != nil {
+		abortWithError(c, http.StatusInternalServerError, err)
+		return
+	}
+	if leases > 0 {
+		abortWithError(c, http.StatusConflict, fmt.Errorf("Subnet %s still has %d leased addresses", subnet.ID, leases))
+		return
+	}
+	reservations := 0
+	if err := s.MySQL.DB.Model(&model.ReservationEntity{}).Where("subnet_id = ?", subnet.ID).Count(&reservations).Error; err != nil {
+		abortWithError(c, http.StatusInternalServerError, err)
+		return
+	}
+	if reservations > 0 {
+		abortWithError(c, http.StatusConflict, fmt.Errorf("Subnet %s still has %d reserved addresses", subnet.ID, reservations))
+		return
+	}
+
+	if preferAsync(c) {
+		s.startJob(c, jobDeleteSubnet, targetRequest{ID: subnet.ID})
+		return
+	}
+
+	if err := s.DeleteSubnet(requestContext(c), subnet.ID); err != nil {
+		abortWithRPCError(c, err)
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
+
+// ListAddressesHandler Serves GET /addresses, optionally filtered by
+// ?subnet=, ?node= and ?family=, ordered by subnet and address
+func (s *Server) ListAddressesHandler(c *gin.Context) {
+	query := s.MySQL.DB
+	if subnet := c.Query("subnet"); subnet != "" {
+		query = query.Where("subnet_id = ?", subnet)
+	}
+	if node := c.Query("node"); node != "" {
+		query = query.Where("node_id = ?", node)
+	}
+
+	leases := []model.LeaseEntity{}
+	if err := query.Find(&leases).Error; err != nil {
+		abortWithError(c, http.StatusInternalServerError, err)
+		return
+	}
+
+	// Leases made before Houston recorded families get theirs from the address
+	family := c.Query("family")
+	matching := []model.LeaseEntity{}
+	for _, lease := range leases {
+		if lease.Family == "" {
+			lease.Family = addressFamily(lease.Address)
+		}
+		if family == "" || lease.Family == family {
+			matching = append(matching, lease)
+		}
+	}
+	sortLeases(matching)
+	c.JSON(http.StatusOK, matching)
+}
+
+// AllocateAddressHandler Serves POST /addresses
+func (s *Server) AllocateAddressHandler(c *gin.Context) {
+	request := model.AllocateAddressRequest{}
+	if !bindJSON(c, &request) {
+		return
+	}
+	ttl, err := leaseTTL(request.TTL)
+	if err != nil {
+		abortWithError(c, http.StatusBadRequest, err)
+		return
+	}
+
+	subnet := models.SubnetEntity{}
+	if !s.find(c, &subnet, request.Subnet) {
+		return
+	}
+
+	if preferAsync(c) {
+		s.startJob(c, jobAllocateAddress, request)
+		return
+	}
+
+	lease, err := s.AllocateAddress(requestContext(c), subnet.ID, request.Node, ttl)
+	if err != nil {
+		abortWithRPCError(c, err)
+		return
+	}
+	c.JSON(http.StatusCreated, lease)
+}
+
+// AllocateAddressesHandler Serves POST /nodes/:id/addresses, which leases an
+// address in each of up to one subnet per family to the node
+func (s *Server) AllocateAddressesHandler(c *gin.Context) {
+	request := model.AllocateAddressesRequest{}
+	if !bindJSON(c, &request) {
+		return
+	}
+	ttl, err := leaseTTL(request.TTL)
+	if err != nil {
+		abortWithError(c, http.StatusBadRequest, err)
+		return
+	}
+
+	families := map[string]string{}
+	for _, id := range request.Subnets {
+		subnet := models.SubnetEntity{}
+		if !s.find(c, &subnet, id) {
+			return
+		}
+		subnets, err := s.subnetRanges(subnet)
+		if err != nil {
+			abortWithError(c, http.StatusInternalServerError, err)
+			return
+		}
+		if subnets[0].SubnetRangeEntity == nil {
+			continue
+		}
+		if other, ok := families[subnets[0].Family]; ok {
+			abortWithError(c, http.StatusBadRequest, fmt.Errorf("Subnets %s and %s are both %s", other, id, subnets[0].Family))
+			return
+		}
+		families[subnets[0].Family] = id
+	}
+
+	if preferAsync(c) {
+		s.startJob(c, jobAllocateAddresses, nodeAddressesRequest{Node: c.Param("id"), AllocateAddressesRequest: request})
+		return
+	}
+
+	leases, err := s.AllocateAddresses(requestContext(c), c.Param("id"), request.Subnets, ttl)
+	if err != nil {
+		abortWithRPCError(c, err)
+		return
+	}
+	c.JSON(http.StatusCreated, leases)
+}
+
+// ReleaseAddressHandler Serves DELETE /addresses/:id
+func (s *Server) ReleaseAddressHandler(c *gin.Context) {
+	lease := model.LeaseEntity{}
+	if !s.find(c, &lease, c.Param("id")) {
+		return
+	}
+
+	if preferAsync(c) {
+		s.startJob(c, jobReleaseAddress, targetRequest{ID: lease.ID})
+		return
+	}
+
+	if err := s.ReleaseAddress(requestContext(c), lease.ID); err != nil {
+		abortWithRPCError(c, err)
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
+
+// find loads the entity with the given ID into out. It responds with 404 or
+// 500 and returns false if that fails.
+func (s *Server) find(c *gin.Context, out interface{}, id string) bool {
+	err := s.MySQL.DB.Where("id = ?", id).First(out).Error
+	if err == gorm.ErrRecordNotFound {
+		abortWithError(c, http.StatusNotFound, fmt.Errorf("%s not found", id))
+		return false
+	}
+	if err != nil {
+		abortWithError(c, http.StatusInternalServerError, err)
+		return false
+	}
+	return true
+}
@@ -0,0 +1,134 @@
+package server
+
+import (
+	"context"
+
+	"github.com/RackHD/voyager-houston/model"
+)
+
+// Kinds of IPAM jobs, started by the IPAM handlers for requests with "Prefer: respond-async"
+const (
+	jobCreatePool        = "create_pool"
+	jobDeletePool        = "delete_pool"
+	jobCreateSubnet      = "create_subnet"
+	jobDeleteSubnet      = "delete_subnet"
+	jobAllocateAddress   = "allocate_address"
+	jobAllocateAddresses = "allocate_addresses"
+	jobReleaseAddress    = "release_address"
+	jobReserveAddress    = "reserve_address"
+	jobUnreserveAddress  = "unreserve_address"
+)
+
+// targetRequest is the request of a job acting on an existing entity
+type targetRequest struct {
+	ID string `json:"id"`
+}
+
+func createPoolJob(s *Server, ctx context.Context, job *jobRun) (interface{}, error) {
+	request := model.CreatePoolRequest{}
+	if err := job.decode(&request); err != nil {
+		return nil, err
+	}
+	poolID, err := s.CreatePool(ctx, request.Name, request.Metadata, request.Prefixes)
+	if err != nil {
+		return nil, err
+	}
+	return model.Created{ID: poolID}, nil
+}
+
+func deletePoolJob(s *Server, ctx context.Context, job *jobRun) (interface{}, error) {
+	request := targetRequest{}
+	if err := job.decode(&request); err != nil {
+		return nil, err
+	}
+	return nil, s.DeletePool(ctx, request.ID)
+}
+
+func createSubnetJob(s *Server, ctx context.Context, job *jobRun) (interface{}, error) {
+	request := model.CreateSubnetRequest{}
+	if err := job.decode(&request); err != nil {
+		return nil, err
+	}
+	subnetRange, err := parseSubnetRange(request.Prefix, request.Start, request.End)
+	if err != nil {
+		return nil, err
+	}
+	subnetID, err := s.CreateSubnet(ctx, request.Name, request.Pool, subnetRange)
+	if err != nil {
+		return nil, err
+	}
+	return model.Created{ID: subnetID}, nil
+}
+
+func deleteSubnetJob(s *Server, ctx context.Context, job *jobRun) (interface{}, error) {
+	request := targetRequest{}
+	if err := job.decode(&request); err != nil {
+		return nil, err
+	}
+	return nil, s.DeleteSubnet(ctx, request.ID)
+}
+
+func allocateAddressJob(s *Server, ctx context.Context, job *jobRun) (interface{}, error) {
+	request := model.AllocateAddressRequest{}
+	if err := job.decode(&request); err != nil {
+		return nil, err
+	}
+	ttl, err := leaseTTL(request.TTL)
+	if err != nil {
+		return nil, err
+	}
+	lease, err := s.AllocateAddress(ctx, request.Subnet, request.Node, ttl)
+	if err != nil {
+		return nil, err
+	}
+	return lease, nil
+}
+
+// nodeAddressesRequest is the request of an allocate_addresses job
+type nodeAddressesRequest struct {
+	Node string `json:"node"`
+	model.AllocateAddressesRequest
+}
+
+func allocateAddressesJob(s *Server, ctx context.Context, job *jobRun) (interface{}, error) {
+	request := nodeAddressesRequest{}
+	if err := job.decode(&request); err != nil {
+		return nil, err
+	}
+	ttl, err := leaseTTL(request.TTL)
+	if err != nil {
+		return nil, err
+	}
+	return s.AllocateAddresses(ctx, request.Node, request.Subnets, ttl)
+}
+
+func releaseAddressJob(s *Server, ctx context.Context, job *jobRun) (interface{}, error) {
+	request := targetRequest{}
+	if err := job.decode(&request); err != nil {
+		return nil, err
+	}
+	return nil, s.ReleaseAddress(ctx, request.ID)
+}
+
+// subnetReservationRequest is the request of a reserve_address or
+// unreserve_address job
+type subnetReservationRequest struct {
+	Subnet string `json:"subnet"`
+	model.ReservationRequest
+}
+
+func reserveAddressJob(s *Server, ctx context.Context, job *jobRun) (interface{}, error) {
+	request := subnetReservationRequest{}
+	if err := job.decode(&request); err != nil {
+		return nil, err
+	}
+	return s.Reserve(ctx, request.Subnet, request.ReservationRequest)
+}
+
+func unreserveAddressJob(s *Server, ctx context.Context, job *jobRun) (interface{}, error) {
+	request := subnetReservationRequest{}
+	if err := job.decode(&request); err != nil {
+		return nil, err
+	}
+	return nil, s.Unreserve(ctx, request.Subnet, request.Address)
+}
@@ -1,12 +1,16 @@
 package server
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
+	"net/http"
+	"net/netip"
 	"time"
 
+	"github.com/RackHD/voyager-houston/model"
 	"github.com/RackHD/voyager-utilities/models"
-	"github.com/RackHD/voyager-utilities/random"
+	"github.com/jinzhu/gorm"
 	log "github.com/sirupsen/logrus"
 )
 
@@ -23,14 +27,18 @@ func (s *Server) InitIPAM() {
 
 	if !ipamIsInit {
 		// Create default pools. Configurable in the future
-		poolID, err := s.CreatePool(models.DefaultKey, models.DefaultRange)
+		poolID, err := s.CreatePool(context.Background(), models.DefaultKey, models.DefaultRange, nil)
 		if err != nil {
 			log.Fatalf("Could not initialize Houston's connection to IPAM: %s", err)
 		}
 		log.Info("Initialized IPAM with pool: ", poolID)
 
 		// Create default subnets. Configurable in the future
-		subnetID, err := s.CreateSubnet(models.DefaultKey, poolID, models.DefaultStart, models.DefaultEnd)
+		subnetRange, err := defaultSubnetRange()
+		if err != nil {
+			log.Fatalf("Invalid default subnet: %s", err)
+		}
+		subnetID, err := s.CreateSubnet(context.Background(), models.DefaultKey, poolID, subnetRange)
 		if err != nil {
 			log.Fatalf("Could not initialize Houston's connection to IPAM: %s", err)
 		}
@@ -38,237 +46,390 @@ func (s *Server) InitIPAM() {
 
 	} else {
 		log.Info("IPAM is already initialized")
+		if err := s.recordDefaultRange(); err != nil {
+			log.Fatalf("Could not record the range of the default subnet: %s", err)
+		}
 	}
 
 }
 
-// CreatePool creates a pool in IPAM
-func (s *Server) CreatePool(name, metadata string) (string, error) {
-	queueName := random.RandQueue()
-	correlationID := random.RandQueue()
-	consumerTag := random.RandQueue()
-	finish := make(chan struct{})
-	poolID := ""
+// defaultSubnetRange returns the range of the default subnet, in the smallest
+// prefix that holds it, as DHCP servers need one. The range is left without a
+// prefix if it includes that prefix's network or broadcast address.
+func defaultSubnetRange() (model.SubnetRangeEntity, error) {
+	r, err := parseSubnetRange("", models.DefaultStart, models.DefaultEnd)
+	if err != nil {
+		return r, err
+	}
+	prefix := enclosingPrefix(netip.MustParseAddr(r.Start), netip.MustParseAddr(r.End))
+	if withPrefix, err := parseSubnetRange(prefix.String(), r.Start, r.End); err == nil {
+		return withPrefix, nil
+	}
+	return r, nil
+}
 
-	//Create pool
-	message, err := json.Marshal(models.IPAMPoolMsg{
-		Name:       name,
-		Action:     models.CreateAction,
-		ObjectType: models.PoolType,
-		Metadata:   metadata,
-	})
+// recordDefaultRange records the range of the default subnet when Houston
+// created it before it recorded ranges, or its prefix when Houston recorded
+// its range without one, so that it is checked for overlaps, exported,
+// reported on and served by DHCP like the others
+func (s *Server) recordDefaultRange() error {
+	pools := []models.PoolEntity{}
+	if err := s.MySQL.DB.Where("name = ?", models.DefaultKey).Find(&pools).Error; err != nil {
+		return err
+	}
+	poolIDs := []string{}
+	for _, pool := range pools {
+		poolIDs = append(poolIDs, pool.ID)
+	}
 
+	subnets := []models.SubnetEntity{}
+	if err := s.MySQL.DB.Where("name = ? AND pool_id IN (?)", models.DefaultKey, poolIDs).Find(&subnets).Error; err != nil {
+		return err
+	}
+	for _, subnet := range subnets {
+		subnetRange, err := defaultSubnetRange()
+		if err != nil {
+			return err
+		}
+		subnetRange.SubnetID = subnet.ID
+
+		recorded := model.SubnetRangeEntity{}
+		err = s.MySQL.DB.Where("subnet_id = ?", subnet.ID).First(&recorded).Error
+		switch {
+		case err == gorm.ErrRecordNotFound:
+			if err = s.MySQL.DB.Create(&subnetRange).Error; err != nil {
+				return err
+			}
+			log.Infof("Recorded range %s-%s of subnet %s", subnetRange.Start, subnetRange.End, subnet.ID)
+		case err != nil:
+			return err
+		case recorded.Prefix == "" && subnetRange.Prefix != "" && recorded.Start == subnetRange.Start && recorded.End == subnetRange.End:
+			if err = s.MySQL.DB.Model(&recorded).Update("prefix", subnetRange.Prefix).Error; err != nil {
+				return err
+			}
+			log.Infof("Recorded prefix %s of subnet %s", subnetRange.Prefix, subnet.ID)
+		}
+	}
+	return nil
+}
+
+// CreatePool creates a pool in IPAM and records the prefixes its subnets
+// must lie within
+func (s *Server) CreatePool(ctx context.Context, name, metadata string, prefixes []string) (string, error) {
+	prefixes, err := parsePoolPrefixes(prefixes)
 	if err != nil {
-		log.Info("Error: ", err, "")
 		return "", err
 	}
 
-	// Listen
-	mqChannel, deliveries, err := s.MQ.Listen(models.IpamExchange, models.IpamExchangeType, queueName, models.HoustonRepliesQueue, consumerTag)
+	reply := models.IPAMPoolMsg{}
+	err = s.ipamRequest(ctx, "create pool", models.IPAMPoolMsg{
+		Name:       name,
+		Action:     models.CreateAction,
+		ObjectType: models.PoolType,
+		Metadata:   metadata,
+	}, &reply)
 	if err != nil {
-		log.Info("Error listening: %s", err)
 		return "", err
 	}
+	if reply.ID == "" {
+		return "", fmt.Errorf("voyager-ipam-service did not return a pool ID")
+	}
 
-	defer func() {
-		log.Info("Trying to close the channel")
-		if err = mqChannel.Cancel(consumerTag, false); err != nil {
-			log.Info("Consumer cancel failed: %s", err)
-		}
+	newPool := models.PoolEntity{
+		ID:   reply.ID,
+		Name: reply.Name,
+	}
 
-		log.Info("Trying to delete the queue: ", queueName)
-		if _, err = mqChannel.QueueDelete(queueName, false, false, true); err != nil {
-			log.Info("Queue Delete failed: %s", err)
+	log.Info("Adding new pool to DB: ", newPool)
+	tx := s.MySQL.DB.Begin()
+	err = tx.Create(&newPool).Error
+	for _, prefix := range prefixes {
+		if err == nil {
+			err = tx.Create(&model.PoolRangeEntity{PoolID: newPool.ID, Prefix: prefix}).Error
 		}
-
-		if err = mqChannel.Close(); err != nil {
-			log.Info("Closing channel failed: ", err)
+	}
+	if err != nil {
+		tx.Rollback()
+	} else {
+		err = tx.Commit().Error
+	}
+	if err != nil {
+		log.Info("Error: ", err)
+		if deleteErr := s.deleteIPAMPool(detach(ctx), newPool.ID); deleteErr != nil {
+			log.Warnf("Could not delete pool %s from voyager-ipam-service after failing to record it: %s", newPool.ID, deleteErr)
 		}
-		close(finish)
-	}()
+		return "", err
+	}
 
-	err = s.MQ.Send(models.IpamExchange, models.IpamExchangeType, models.IpamReceiveQueue, string(message), correlationID, models.HoustonRepliesQueue)
+	log.Info("Created Pool: ", newPool.ID)
+	return newPool.ID, nil
+}
 
+// DeletePool deletes a pool from IPAM
+func (s *Server) DeletePool(ctx context.Context, poolID string) error {
+	err := s.deleteIPAMPool(ctx, poolID)
 	if err != nil {
-		log.Info("Error: ", err, "")
-		return "", err
+		return err
 	}
 
-	// poll the listener for response with matching correlationID
-	log.Info("Listening to deliveries (pool)")
-	cancel := make(chan struct{})
-	defer close(cancel)
-	go func() {
-		// Write to finish channel before exiting Gofunc
-		defer func() {
-			finish <- struct{}{}
-		}()
-
-		for {
-			select {
-			case d := <-deliveries:
-				log.Info("Got a message")
-				if d.CorrelationId == correlationID {
-
-					log.Info("Pool message: ", string(d.Body))
-
-					// Extract PoolID from d.Body
-					reply := models.IPAMPoolMsg{}
-					err = json.Unmarshal(d.Body, &reply)
-					if err != nil {
-						log.Warnf("Error unmarshaling voyager-ipam-service response %s", err)
-					}
-
-					newPool := models.PoolEntity{
-						ID:   reply.ID,
-						Name: reply.Name,
-					}
-
-					log.Info("Adding new pool to DB: ", newPool)
-					err = s.MySQL.DB.Create(&newPool).Error
-					log.Info("I tried to add it")
-					if err != nil {
-						log.Info("Error: ", err)
-						poolID = ""
-						return
-					}
-
-					log.Info("Created Pool: ", newPool.ID)
-					d.Ack(true)
-					poolID = newPool.ID
-					return
-				}
-			case <-cancel:
-				poolID = ""
-				return
-			}
-		}
-		poolID = ""
-		return
-	}()
-
-	// Time out after 5 seconds
-	select {
-	case <-time.After(5 * time.Second):
-		cancel <- struct{}{} // send signal to cancel the goroutine!
-		return "", fmt.Errorf("Error: Request to Create pool timed out after 5s")
-	case <-finish:
-		return poolID, nil
+	if err = s.MySQL.DB.Where("pool_id = ?", poolID).Delete(&model.PoolRangeEntity{}).Error; err != nil {
+		return err
 	}
+	return s.MySQL.DB.Where("id = ?", poolID).Delete(&models.PoolEntity{}).Error
 }
 
-// CreateSubnet creates a pool in IPAM
-func (s *Server) CreateSubnet(name, poolID, start, end string) (string, error) {
-	queueName := random.RandQueue()
-	correlationID := random.RandQueue()
-	consumerTag := random.RandQueue()
-	finish := make(chan struct{})
-	subnetID := ""
+// CreateSubnet creates a subnet in IPAM and records its family and range,
+// unless checkSubnet refuses the range
+func (s *Server) CreateSubnet(ctx context.Context, name, poolID string, subnetRange model.SubnetRangeEntity) (string, error) {
+	s.subnetsMu.Lock()
+	defer s.subnetsMu.Unlock()
+	if err := s.checkSubnet(poolID, subnetRange); err != nil {
+		return "", err
+	}
 
-	//Create pool
-	message, err := json.Marshal(models.IPAMSubnetMsg{
+	reply := models.IPAMSubnetMsg{}
+	err := s.ipamRequest(ctx, "create subnet", models.IPAMSubnetMsg{
 		Name:       name,
 		Action:     models.CreateAction,
 		ObjectType: models.SubnetType,
 		Pool:       poolID,
-		Start:      start,
-		End:        end,
-	})
-
+		Start:      subnetRange.Start,
+		End:        subnetRange.End,
+	}, &reply)
 	if err != nil {
-		log.Info("Error: ", err)
 		return "", err
 	}
+	if reply.ID == "" {
+		return "", fmt.Errorf("voyager-ipam-service did not return a subnet ID")
+	}
+
+	newSubnet := models.SubnetEntity{
+		ID:     reply.ID,
+		Name:   reply.Name,
+		PoolID: reply.Pool,
+	}
 
-	// Listen
-	mqChannel, deliveries, err := s.MQ.Listen(models.IpamExchange, models.IpamExchangeType, queueName, models.HoustonRepliesQueue, consumerTag)
+	log.Info("Adding new subnet to DB: ", newSubnet)
+	subnetRange.SubnetID = reply.ID
+	tx := s.MySQL.DB.Begin()
+	if err = tx.Create(&newSubnet).Error; err == nil {
+		err = tx.Create(&subnetRange).Error
+	}
 	if err != nil {
-		log.Info("Error listening: %s", err)
-		return "", err
+		tx.Rollback()
+	} else {
+		err = tx.Commit().Error
 	}
-	defer func() {
-		log.Info("Trying to close the channel")
-		if err = mqChannel.Cancel(consumerTag, false); err != nil {
-			log.Info("Consumer cancel failed: %s", err)
+	if err != nil {
+		log.Infof("Error: %s", err)
+		if deleteErr := s.deleteIPAMSubnet(detach(ctx), newSubnet.ID); deleteErr != nil {
+			log.Warnf("Could not delete subnet %s from voyager-ipam-service after failing to record it: %s", newSubnet.ID, deleteErr)
 		}
+		return "", err
+	}
 
-		log.Info("Trying to delete the queue: ", queueName)
-		if _, err = mqChannel.QueueDelete(queueName, false, false, true); err != nil {
-			log.Info("Queue Delete failed: %s", err)
-		}
+	log.Infof("Created Subnet: %s", newSubnet.ID)
+	return newSubnet.ID, nil
+}
 
-		if err = mqChannel.Close(); err != nil {
-			log.Info("Closing channel failed: ", err)
-		}
-		close(finish)
-	}()
+// DeleteSubnet deletes a subnet from IPAM
+func (s *Server) DeleteSubnet(ctx context.Context, subnetID string) error {
+	err := s.deleteIPAMSubnet(ctx, subnetID)
+	if err != nil {
+		return err
+	}
 
-	err = s.MQ.Send(models.IpamExchange, models.IpamExchangeType, models.IpamReceiveQueue, string(message), correlationID, models.HoustonRepliesQueue)
+	if err = s.MySQL.DB.Where("subnet_id = ?", subnetID).Delete(&model.DHCPOptionsEntity{}).Error; err != nil {
+		return err
+	}
+	if err = s.MySQL.DB.Where("subnet_id = ?", subnetID).Delete(&model.SubnetRangeEntity{}).Error; err != nil {
+		return err
+	}
+	return s.MySQL.DB.Where("id = ?", subnetID).Delete(&models.SubnetEntity{}).Error
+}
 
+// AllocateAddress leases an address in a subnet to a node, for ttl if it is not zero
+func (s *Server) AllocateAddress(ctx context.Context, subnetID, nodeID string, ttl time.Duration) (model.LeaseEntity, error) {
+	var expiresAt *time.Time
+	if ttl > 0 {
+		t := time.Now().Add(ttl)
+		expiresAt = &t
+	}
+	return s.leaseAddress(ctx, subnetID, nodeID, "", expiresAt)
+}
+
+// leaseAddress leases an address in a subnet to a node until expiresAt, or
+// for good if it is nil. A non-empty address asks IPAM for that address,
+// which it may not grant.
+func (s *Server) leaseAddress(ctx context.Context, subnetID, nodeID, address string, expiresAt *time.Time) (model.LeaseEntity, error) {
+	reply := model.IPAMLeaseMsg{}
+	err := s.ipamRequest(ctx, "allocate address", model.IPAMLeaseMsg{
+		Action:     models.CreateAction,
+		ObjectType: model.LeaseType,
+		Subnet:     subnetID,
+		Node:       nodeID,
+		Address:    address,
+	}, &reply)
 	if err != nil {
-		log.Info("Error: ", err, "")
-		return "", err
+		return model.LeaseEntity{}, err
+	}
+	if reply.ID == "" || reply.Address == "" {
+		return model.LeaseEntity{}, fmt.Errorf("voyager-ipam-service did not return a lease")
+	}
+
+	leased, err := parseAddr("leased", reply.Address)
+	if err != nil {
+		if releaseErr := s.releaseLease(detach(ctx), reply.ID); releaseErr != nil {
+			log.Warnf("Could not release invalid lease %s of %q: %s", reply.ID, reply.Address, releaseErr)
+		}
+		return model.LeaseEntity{}, fmt.Errorf("voyager-ipam-service returned an invalid lease: %s", err)
+	}
+	if err = s.checkUnreserved(ctx, subnetID, reply.ID, leased.String()); err != nil {
+		return model.LeaseEntity{}, err
+	}
+
+	lease := model.LeaseEntity{
+		ID:        reply.ID,
+		SubnetID:  subnetID,
+		NodeID:    nodeID,
+		Address:   leased.String(),
+		Family:    family(leased),
+		ExpiresAt: expiresAt,
+	}
+	if err = s.MySQL.DB.Create(&lease).Error; err != nil {
+		if releaseErr := s.releaseLease(detach(ctx), lease.ID); releaseErr != nil {
+			log.Warnf("Could not release lease %s of %s after failing to record it: %s", lease.ID, lease.Address, releaseErr)
+		}
+		return model.LeaseEntity{}, err
 	}
 
-	// poll the listener for response with matching correlationID
-	log.Info("Listening to deliveries")
-	cancel := make(chan struct{})
-	defer close(cancel)
-	go func() {
-		// Write to finish channel before exiting Gofunc
-		defer func() {
-			finish <- struct{}{}
-		}()
-
-		for {
-			select {
-			case d := <-deliveries:
-				log.Info("Got a message")
-				if d.CorrelationId == correlationID {
-					log.Info("Subnet message: ", string(d.Body))
-
-					// Extract PoolID from d.Body
-					reply := models.IPAMSubnetMsg{}
-					err = json.Unmarshal(d.Body, &reply)
-					if err != nil {
-						log.Warnf("Error unmarshaling voyager-ipam-service response %s", err)
-					}
-
-					newSubnet := models.SubnetEntity{
-						ID:     reply.ID,
-						Name:   reply.Name,
-						PoolID: reply.Pool,
-					}
-
-					log.Info("Adding new subnet to DB: ", newSubnet)
-					if err = s.MySQL.DB.Create(&newSubnet).Error; err != nil {
-						log.Infof("Error: %s", err)
-						subnetID = ""
-					}
-
-					d.Ack(true)
-					log.Infof("Created Subnet: %s", newSubnet.ID)
-					subnetID = newSubnet.ID
-					return
+	log.Infof("Leased %s to node %s", lease.Address, nodeID)
+	return lease, nil
+}
+
+// AllocateAddresses leases an address in each subnet to a node, e.g. for
+// dual-stack. If one cannot be allocated, the ones already leased are
+// released, even if ctx is done.
+func (s *Server) AllocateAddresses(ctx context.Context, nodeID string, subnetIDs []string, ttl time.Duration) ([]model.LeaseEntity, error) {
+	leases := []model.LeaseEntity{}
+	for _, subnetID := range subnetIDs {
+		lease, err := s.AllocateAddress(ctx, subnetID, nodeID, ttl)
+		if err != nil {
+			for _, leased := range leases {
+				if releaseErr := s.ReleaseAddress(detach(ctx), leased.ID); releaseErr != nil {
+					log.Warnf("Could not release %s after failing to allocate in subnet %s: %s", leased.Address, subnetID, releaseErr)
 				}
-			case <-cancel:
-				subnetID = ""
-				return
 			}
+			return nil, err
 		}
-		subnetID = ""
-		return
-	}()
+		leases = append(leases, lease)
+	}
+	return leases, nil
+}
+
+// checkUnreserved releases a lease voyager-ipam-service made of an address
+// it already holds for a reservation, and refuses it
+func (s *Server) checkUnreserved(ctx context.Context, subnetID, leaseID, address string) error {
+	reservations := []model.ReservationEntity{}
+	if err := s.MySQL.DB.Where("subnet_id = ? AND address = ? AND lease_id <> ?", subnetID, address, leaseID).Find(&reservations).Error; err != nil {
+		return err
+	}
+	if len(reservations) == 0 {
+		return nil
+	}
+	if err := s.releaseLease(ctx, leaseID); err != nil {
+		log.Warnf("Could not release reserved address %s leased by mistake: %s", address, err)
+	}
+	return newAPIError(http.StatusConflict, fmt.Errorf("voyager-ipam-service leased %s, which is reserved for %s", address, reservationOwner(reservations[0])))
+}
+
+// ReleaseAddress returns a leased address to its subnet. A reserved address
+// is only taken from its node, and stays held for the reservation.
+func (s *Server) ReleaseAddress(ctx context.Context, leaseID string) error {
+	reserved := 0
+	if err := s.MySQL.DB.Model(&model.ReservationEntity{}).Where("lease_id = ?", leaseID).Count(&reserved).Error; err != nil {
+		return err
+	}
+	if reserved > 0 {
+		log.Info("Unassigned reserved lease: ", leaseID)
+	} else if err := s.releaseLease(ctx, leaseID); err != nil {
+		return err
+	}
+	return s.MySQL.DB.Where("id = ?", leaseID).Delete(&model.LeaseEntity{}).Error
+}
+
+// releaseLease returns the address of a lease to voyager-ipam-service
+func (s *Server) releaseLease(ctx context.Context, leaseID string) error {
+	err := s.ipamRequest(ctx, "release address", model.IPAMLeaseMsg{
+		ID:         leaseID,
+		Action:     model.DeleteAction,
+		ObjectType: model.LeaseType,
+	}, nil)
+	if err != nil {
+		return err
+	}
+
+	log.Info("Released lease: ", leaseID)
+	return nil
+}
+
+// deleteIPAMPool deletes a pool from voyager-ipam-service only
+func (s *Server) deleteIPAMPool(ctx context.Context, poolID string) error {
+	err := s.ipamRequest(ctx, "delete pool", models.IPAMPoolMsg{
+		ID:         poolID,
+		Action:     model.DeleteAction,
+		ObjectType: models.PoolType,
+	}, nil)
+	if err != nil {
+		return err
+	}
 
-	// Time out after 5 seconds
-	select {
-	case <-time.After(5 * time.Second):
-		cancel <- struct{}{} // send signal to cancel the goroutine!
-		return "", fmt.Errorf("Error: Request to Create subnet timed out after 5s")
-	case <-finish:
-		return subnetID, nil
+	log.Info("Deleted Pool: ", poolID)
+	return nil
+}
+
+// deleteIPAMSubnet deletes a subnet from voyager-ipam-service only
+func (s *Server) deleteIPAMSubnet(ctx context.Context, subnetID string) error {
+	err := s.ipamRequest(ctx, "delete subnet", models.IPAMSubnetMsg{
+		ID:         subnetID,
+		Action:     model.DeleteAction,
+		ObjectType: models.SubnetType,
+	}, nil)
+	if err != nil {
+		return err
 	}
 
+	log.Info("Deleted Subnet: ", subnetID)
+	return nil
+}
+
+// ipamRequest sends message to voyager-ipam-service and unmarshals its reply into reply, if not nil
+func (s *Server) ipamRequest(ctx context.Context, command string, message, reply interface{}) error {
+	body, err := json.Marshal(message)
+	if err != nil {
+		return err
+	}
+
+	replyBody, err := s.call(ctx, rpcRequest{
+		Exchange:     models.IpamExchange,
+		ExchangeType: models.IpamExchangeType,
+		RoutingKey:   models.IpamReceiveQueue,
+		ReplyKey:     models.HoustonRepliesQueue,
+		Command:      command,
+		Message:      body,
+	})
+	if err != nil {
+		return err
+	}
+	log.Info("IPAM message: ", string(replyBody))
+
+	if reply == nil {
+		return nil
+	}
+	if err = json.Unmarshal(replyBody, reply); err != nil {
+		return fmt.Errorf("Error unmarshaling voyager-ipam-service response: %s", err)
+	}
+	return nil
 }
 
 // returns whether IPAM has been initialized, or false with an error.
@@ -10,6 +10,7 @@ import (
 	log "github.com/sirupsen/logrus"
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/gomega"
+	"github.com/RackHD/voyager-houston/model"
 	"github.com/RackHD/voyager-houston/server"
 	"github.com/RackHD/voyager-utilities/models"
 )
@@ -29,6 +30,7 @@ var _ = Describe("Houston interface to IPAM", func() {
 		AfterEach(func() {
 			s.MySQL.DB.DropTableIfExists("pool_entities")
 			s.MySQL.DB.DropTableIfExists("subnet_entities")
+			s.MySQL.DB.DropTableIfExists("subnet_range_entities")
 		})
 
 		It("INTEGRATION should initialize IPAM with one pool and one subnet", func() {
@@ -107,6 +109,45 @@ var _ = Describe("Houston interface to IPAM", func() {
 			Expect(len(testSubnets)).To(Equal(1))
 		})
 
+		It("INTEGRATION should record the range of a default subnet created without one", func() {
+			s = server.NewServer(uri, dbAddress)
+			defer s.MQ.Close()
+			Expect(s.MySQL.DB.Create(&models.PoolEntity{ID: "old-pool", Name: models.DefaultKey}).Error).To(Succeed())
+			Expect(s.MySQL.DB.Create(&models.SubnetEntity{ID: "old-subnet", Name: models.DefaultKey, PoolID: "old-pool"}).Error).To(Succeed())
+			s.InitIPAM()
+
+			subnetRange := model.SubnetRangeEntity{}
+			Expect(s.MySQL.DB.Where("subnet_id = ?", "old-subnet").First(&subnetRange).Error).To(Succeed())
+			Expect(subnetRange.Start).To(Equal(models.DefaultStart))
+			Expect(subnetRange.End).To(Equal(models.DefaultEnd))
+
+			s.InitIPAM()
+			count := 0
+			Expect(s.MySQL.DB.Model(&model.SubnetRangeEntity{}).Count(&count).Error).To(Succeed())
+			Expect(count).To(Equal(1))
+		})
+
+		It("INTEGRATION should create and delete pools through the API", func() {
+			s = server.NewServer(uri, dbAddress)
+			defer s.MQ.Close()
+			router := s.Router()
+
+			resp := do(router, "POST", "/api/v1/pools", model.CreatePoolRequest{Name: "lab"}, nil)
+			Expect(resp.Code).To(Equal(http.StatusCreated))
+			created := model.Created{}
+			Expect(json.Unmarshal(resp.Body.Bytes(), &created)).To(Succeed())
+			Expect(created.ID).ToNot(Equal(""))
+
+			resp = do(router, "GET", "/api/v1/pools/"+created.ID, nil, nil)
+			Expect(resp.Code).To(Equal(http.StatusOK))
+
+			resp = do(router, "DELETE", "/api/v1/pools/"+created.ID, nil, nil)
+			Expect(resp.Code).To(Equal(http.StatusNoContent))
+
+			resp = do(router, "GET", "/api/v1/pools/"+created.ID, nil, nil)
+			Expect(resp.Code).To(Equal(http.StatusNotFound))
+		})
+
 	})
 
 })
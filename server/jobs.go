@@ -0,0 +1,316 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/RackHD/voyager-houston/model"
+	"github.com/RackHD/voyager-utilities/random"
+	"github.com/gin-gonic/gin"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	// maxJobs caps the number of jobs returned by GET /jobs
+	maxJobs = 1000
+
+	// jobInterrupted is the error of jobs that were running when Houston
+	// stopped and cannot safely be run again
+	jobInterrupted = "Houston restarted while the job was running"
+)
+
+// jobKind runs the jobs of one kind
+type jobKind struct {
+	run func(s *Server, ctx context.Context, job *jobRun) (interface{}, error)
+	// resumable jobs are run again when Houston restarts while they run, as
+	// imports skip what already exists. Other jobs may have been partly done,
+	// so they are marked failed.
+	resumable bool
+	// permission is needed to start jobs of the kind, and so to cancel them
+	permission Permission
+}
+
+// jobKinds are the jobs Houston can run, by kind
+var jobKinds = map[string]jobKind{
+	jobCreatePool:        {run: createPoolJob, permission: PermIPAMWrite},
+	jobDeletePool:        {run: deletePoolJob, permission: PermIPAMWrite},
+	jobCreateSubnet:      {run: createSubnetJob, permission: PermIPAMWrite},
+	jobDeleteSubnet:      {run: deleteSubnetJob, permission: PermIPAMWrite},
+	jobAllocateAddress:   {run: allocateAddressJob, permission: PermAddressAllocate},
+	jobAllocateAddresses: {run: allocateAddressesJob, permission: PermAddressAllocate},
+	jobReleaseAddress:    {run: releaseAddressJob, permission: PermAddressRelease},
+	jobReserveAddress:    {run: reserveAddressJob, permission: PermIPAMWrite},
+	jobUnreserveAddress:  {run: unreserveAddressJob, permission: PermIPAMWrite},
+	jobNodeActions:       {run: nodeActionsJob},
+	jobImportIPAM:        {run: importIPAMJob, resumable: true, permission: PermIPAMWrite},
+}
+
+// jobPermission returns the permission needed to cancel job: the one needed
+// to start it. Node actions need the permission of their action type, and
+// jobs of unknown kinds need an admin.
+func jobPermission(job model.JobEntity) Permission {
+	if job.Kind == jobNodeActions {
+		request := model.NodeActionsRequest{}
+		if err := json.Unmarshal([]byte(job.Request), &request); err == nil {
+			if permission, ok := nodeActionPermissions[request.Action.Type]; ok {
+				return permission
+			}
+		}
+	}
+	if kind, ok := jobKinds[job.Kind]; ok && kind.permission != "" {
+		return kind.permission
+	}
+	return PermRoleBindingAdmin
+}
+
+// jobRun is a job being run
+type jobRun struct {
+	s       *Server
+	ID      string
+	request []byte
+}
+
+// decode unmarshals the request of the job into v
+func (j *jobRun) decode(v interface{}) error {
+	if err := json.Unmarshal(j.request, v); err != nil {
+		return fmt.Errorf("invalid job request: %s", err)
+	}
+	return nil
+}
+
+// progress records how much of the job is done, in percent
+func (j *jobRun) progress(percent int) {
+	j.s.updateJob(j.ID, map[string]interface{}{"progress": percent}, model.JobRunning)
+}
+
+// jobs holds the cancel functions of the jobs running in this process
+type jobs struct {
+	mu      sync.Mutex
+	cancels map[string]context.CancelFunc
+}
+
+// preferAsync reports whether the client asked for the request to be run as
+// a job, with the header "Prefer: respond-async"
+func preferAsync(c *gin.Context) bool {
+	for _, header := range c.Request.Header["Prefer"] {
+		for _, preference := range strings.Split(header, ",") {
+			if strings.EqualFold(strings.TrimSpace(preference), "respond-async") {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// startJob stores a job of kind for request, starts it and responds 202
+// Accepted with the job and its URL
+func (s *Server) startJob(c *gin.Context, kind string, request interface{}) {
+	body, err := json.Marshal(request)
+	if err != nil {
+		abortWithError(c, http.StatusInternalServerError, err)
+		return
+	}
+
+	job := model.JobEntity{
+		ID:        random.RandQueue(),
+		Kind:      kind,
+		Status:    model.JobQueued,
+		Request:   model.JSONText(body),
+		RequestID: requestID(c),
+	}
+	if id := identity(c); id != nil {
+		job.Actor = id.Subject
+	}
+	if err = s.MySQL.DB.Create(&job).Error; err != nil {
+		abortWithError(c, http.StatusInternalServerError, err)
+		return
+	}
+
+	// The job outlives the request, but its commands are audited under it
+	s.runJob(detach(requestContext(c)), job)
+
+	if preferAsync(c) {
+		c.Header("Preference-Applied", "respond-async")
+	}
+	c.Header("Location", apiPrefix+"/jobs/"+job.ID)
+	c.JSON(http.StatusAccepted, job)
+}
+
+// runJob runs a queued job in the background until it finishes or is canceled
+func (s *Server) runJob(ctx context.Context, job model.JobEntity) {
+	ctx, cancel := context.WithCancel(ctx)
+	s.jobs.mu.Lock()
+	if s.jobs.cancels == nil {
+		s.jobs.cancels = map[string]context.CancelFunc{}
+	}
+	s.jobs.cancels[job.ID] = cancel
+	s.jobs.mu.Unlock()
+
+	go func() {
+		defer func() {
+			s.jobs.mu.Lock()
+			delete(s.jobs.cancels, job.ID)
+			s.jobs.mu.Unlock()
+			cancel()
+		}()
+
+		started := s.updateJob(job.ID, map[string]interface{}{
+			"status":     model.JobRunning,
+			"started_at": time.Now(),
+		}, model.JobQueued)
+		if !started {
+			// Canceled before it started
+			return
+		}
+
+		kind, ok := jobKinds[job.Kind]
+		if !ok {
+			s.finishJob(job, nil, fmt.Errorf("unknown job kind %q", job.Kind))
+			return
+		}
+		result, err := kind.run(s, ctx, &jobRun{s: s, ID: job.ID, request: []byte(job.Request)})
+		s.finishJob(job, result, err)
+	}()
+}
+
+// finishJob records the outcome of a running job, including the result of a
+// failed job if it has one. Canceled jobs are left as they are.
+func (s *Server) finishJob(job model.JobEntity, result interface{}, err error) {
+	fields := map[string]interface{}{
+		"status":      model.JobSucceeded,
+		"progress":    100,
+		"finished_at": time.Now(),
+	}
+	if err != nil {
+		fields["status"] = model.JobFailed
+		fields["error"] = err.Error()
+		delete(fields, "progress")
+	}
+	if result != nil {
+		body, err := json.Marshal(result)
+		if err != nil {
+			log.Warnf("Could not store the result of job %s: %s", job.ID, err)
+		}
+		fields["result"] = string(body)
+	}
+
+	if s.updateJob(job.ID, fields, model.JobRunning) {
+		s.metrics.add("houston_jobs_total", "Jobs finished, by kind and status", labels{"kind": job.Kind, "status": fields["status"].(string)}, 1)
+	}
+}
+
+// updateJob sets fields of a job whose status is one of from, and reports
+// whether it did
+func (s *Server) updateJob(id string, fields map[string]interface{}, from ...string) bool {
+	query := s.MySQL.DB.Model(&model.JobEntity{}).Where("id = ? AND status IN (?)", id, from).Updates(fields)
+	if query.Error != nil {
+		log.Warnf("Could not update job %s: %s", id, query.Error)
+		return false
+	}
+	return query.RowsAffected > 0
+}
+
+// ResumeJobs restarts the jobs left queued when Houston stopped, and the
+// running ones that can safely run again. Other running jobs are marked failed.
+// It assumes no other Houston shares the database.
+func (s *Server) ResumeJobs() error {
+	pending := []model.JobEntity{}
+	err := s.MySQL.DB.Where("status IN (?)", []string{model.JobQueued, model.JobRunning}).Order("created_at").Find(&pending).Error
+	if err != nil {
+		return err
+	}
+
+	for _, job := range pending {
+		if job.Status == model.JobRunning {
+			if !jobKinds[job.Kind].resumable {
+				log.Warnf("Job %s (%s) was interrupted, marking it failed", job.ID, job.Kind)
+				s.updateJob(job.ID, map[string]interface{}{
+					"status":      model.JobFailed,
+					"error":       jobInterrupted,
+					"finished_at": time.Now(),
+				}, model.JobRunning)
+				continue
+			}
+			s.updateJob(job.ID, map[string]interface{}{"status": model.JobQueued}, model.JobRunning)
+		}
+
+		log.Infof("Resuming job %s (%s)", job.ID, job.Kind)
+		ctx := context.WithValue(context.Background(), requestInfoKey{}, requestInfo{RequestID: job.RequestID, Actor: job.Actor})
+		s.runJob(ctx, job)
+	}
+	return nil
+}
+
+// ListJobsHandler Serves GET /jobs. Jobs can be filtered by kind, status,
+// actor and request_id, and by creation time with since and until (RFC 3339).
+// They are returned newest first, paged with limit and offset.
+func (s *Server) ListJobsHandler(c *gin.Context) {
+	query, ok := filter(c, s.MySQL.DB, "created_at", "kind", "status", "actor", "request_id")
+	if !ok {
+		return
+	}
+	limit, offset, ok := page(c, maxJobs)
+	if !ok {
+		return
+	}
+
+	found := []model.JobEntity{}
+	if err := query.Order("created_at desc").Limit(limit).Offset(offset).Find(&found).Error; err != nil {
+		abortWithError(c, http.StatusInternalServerError, err)
+		return
+	}
+	c.JSON(http.StatusOK, found)
+}
+
+// GetJobHandler Serves GET /jobs/:id
+func (s *Server) GetJobHandler(c *gin.Context) {
+	job := model.JobEntity{}
+	if !s.find(c, &job, c.Param("id")) {
+		return
+	}
+	c.JSON(http.StatusOK, job)
+}
+
+// CancelJobHandler Serves DELETE /jobs/:id. Callers also need the permission
+// to start the job. Commands the job has already sent to other Voyager
+// services are not undone.
+func (s *Server) CancelJobHandler(c *gin.Context) {
+	job := model.JobEntity{}
+	if !s.find(c, &job, c.Param("id")) {
+		return
+	}
+	if !s.authorize(c, jobPermission(job)) {
+		return
+	}
+
+	reason := "Canceled"
+	if id := identity(c); id != nil {
+		reason = "Canceled by " + id.Subject
+	}
+	canceled := s.updateJob(job.ID, map[string]interface{}{
+		"status":      model.JobCanceled,
+		"error":       reason,
+		"finished_at": time.Now(),
+	}, model.JobQueued, model.JobRunning)
+	if !canceled {
+		abortWithError(c, http.StatusConflict, fmt.Errorf("Job %s has already finished", job.ID))
+		return
+	}
+
+	s.jobs.mu.Lock()
+	if cancel, ok := s.jobs.cancels[job.ID]; ok {
+		cancel()
+	}
+	s.jobs.mu.Unlock()
+	s.metrics.add("houston_jobs_total", "Jobs finished, by kind and status", labels{"kind": job.Kind, "status": model.JobCanceled}, 1)
+
+	if !s.find(c, &job, job.ID) {
+		return
+	}
+	c.JSON(http.StatusOK, job)
+}
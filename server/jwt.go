@@ -0,0 +1,263 @@
+package server
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/RackHD/voyager-houston/model"
+)
+
+// JWTAuthenticator accepts bearer tokens signed with an HMAC secret or with a key from a JWKS file
+type JWTAuthenticator struct {
+	// Secret verifies HS256/HS384/HS512 tokens
+	Secret []byte
+	// Keys verify RS* and ES* tokens, by key ID
+	Keys map[string]crypto.PublicKey
+	// Issuer and Audience, when set, must match the iss and aud claims
+	Issuer   string
+	Audience string
+}
+
+type jwtHeader struct {
+	Alg string `json:"alg"`
+	Kid string `json:"kid"`
+}
+
+// Authenticate validates the bearer token in the Authorization header
+func (a *JWTAuthenticator) Authenticate(r *http.Request) (*model.Identity, error) {
+	auth := r.Header.Get("Authorization")
+	if !strings.HasPrefix(auth, "Bearer ") {
+		return nil, errNoCredentials
+	}
+
+	claims, err := a.Verify(strings.TrimPrefix(auth, "Bearer "))
+	if err != nil {
+		return nil, err
+	}
+
+	subject, _ := claims["sub"].(string)
+	if subject == "" {
+		return nil, fmt.Errorf("token has no subject")
+	}
+
+	return &model.Identity{
+		Subject: subject,
+		Method:  "jwt",
+	}, nil
+}
+
+// Verify checks the signature and time, issuer and audience claims of token and returns its claims
+func (a *JWTAuthenticator) Verify(token string) (map[string]interface{}, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, fmt.Errorf("malformed token")
+	}
+
+	header := jwtHeader{}
+	if err := decodeSegment(parts[0], &header); err != nil {
+		return nil, fmt.Errorf("malformed token header: %s", err)
+	}
+
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, fmt.Errorf("malformed token signature: %s", err)
+	}
+
+	if err = a.verifySignature(header, []byte(parts[0]+"."+parts[1]), signature); err != nil {
+		return nil, err
+	}
+
+	claims := map[string]interface{}{}
+	if err = decodeSegment(parts[1], &claims); err != nil {
+		return nil, fmt.Errorf("malformed token claims: %s", err)
+	}
+
+	now := float64(time.Now().Unix())
+	if exp, ok := claims["exp"].(float64); ok && now >= exp {
+		return nil, fmt.Errorf("token has expired")
+	}
+	if nbf, ok := claims["nbf"].(float64); ok && now < nbf {
+		return nil, fmt.Errorf("token is not valid yet")
+	}
+	if a.Issuer != "" && claims["iss"] != a.Issuer {
+		return nil, fmt.Errorf("token issuer is not %s", a.Issuer)
+	}
+	if a.Audience != "" && !hasAudience(claims["aud"], a.Audience) {
+		return nil, fmt.Errorf("token audience does not include %s", a.Audience)
+	}
+
+	return claims, nil
+}
+
+func (a *JWTAuthenticator) verifySignature(header jwtHeader, signed, signature []byte) error {
+	hashes := map[string]crypto.Hash{"256": crypto.SHA256, "384": crypto.SHA384, "512": crypto.SHA512}
+	if len(header.Alg) != 5 || hashes[header.Alg[2:]] == 0 {
+		return fmt.Errorf("unsupported token algorithm %q", header.Alg)
+	}
+	hash := hashes[header.Alg[2:]]
+
+	if header.Alg[:2] == "HS" {
+		if len(a.Secret) == 0 {
+			return fmt.Errorf("HMAC tokens are not accepted")
+		}
+		mac := hmac.New(hash.New, a.Secret)
+		mac.Write(signed)
+		if !hmac.Equal(mac.Sum(nil), signature) {
+			return fmt.Errorf("invalid token signature")
+		}
+		return nil
+	}
+
+	key, err := a.key(header.Kid)
+	if err != nil {
+		return err
+	}
+	digest := hash.New()
+	digest.Write(signed)
+
+	switch header.Alg[:2] {
+	case "RS":
+		rsaKey, ok := key.(*rsa.PublicKey)
+		if !ok {
+			return fmt.Errorf("key %q is not an RSA key", header.Kid)
+		}
+		if err = rsa.VerifyPKCS1v15(rsaKey, hash, digest.Sum(nil), signature); err != nil {
+			return fmt.Errorf("invalid token signature")
+		}
+		return nil
+
+	case "ES":
+		ecKey, ok := key.(*ecdsa.PublicKey)
+		if !ok {
+			return fmt.Errorf("key %q is not an EC key", header.Kid)
+		}
+		size := (ecKey.Curve.Params().BitSize + 7) / 8
+		if len(signature) != 2*size {
+			return fmt.Errorf("invalid token signature")
+		}
+		r := new(big.Int).SetBytes(signature[:size])
+		s := new(big.Int).SetBytes(signature[size:])
+		if !ecdsa.Verify(ecKey, digest.Sum(nil), r, s) {
+			return fmt.Errorf("invalid token signature")
+		}
+		return nil
+	}
+
+	return fmt.Errorf("unsupported token algorithm %q", header.Alg)
+}
+
+// key looks up a key by ID. A token without a key ID may use the only key there is.
+func (a *JWTAuthenticator) key(kid string) (crypto.PublicKey, error) {
+	if key, ok := a.Keys[kid]; ok {
+		return key, nil
+	}
+	if kid == "" && len(a.Keys) == 1 {
+		for _, key := range a.Keys {
+			return key, nil
+		}
+	}
+	return nil, fmt.Errorf("unknown token key %q", kid)
+}
+
+func decodeSegment(segment string, v interface{}) error {
+	data, err := base64.RawURLEncoding.DecodeString(segment)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, v)
+}
+
+// hasAudience reports whether the aud claim, a string or a list of strings, contains audience
+func hasAudience(aud interface{}, audience string) bool {
+	switch aud := aud.(type) {
+	case string:
+		return aud == audience
+	case []interface{}:
+		for _, a := range aud {
+			if a == audience {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+type jsonWebKey struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Crv string `json:"crv"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+// LoadJWKS reads the RSA and EC public keys from a JSON Web Key Set file
+func LoadJWKS(file string) (map[string]crypto.PublicKey, error) {
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+
+	set := struct {
+		Keys []jsonWebKey `json:"keys"`
+	}{}
+	if err = json.Unmarshal(data, &set); err != nil {
+		return nil, err
+	}
+
+	keys := map[string]crypto.PublicKey{}
+	for _, k := range set.Keys {
+		switch k.Kty {
+		case "RSA":
+			n, err := base64.RawURLEncoding.DecodeString(k.N)
+			if err != nil {
+				return nil, fmt.Errorf("key %q: %s", k.Kid, err)
+			}
+			e, err := base64.RawURLEncoding.DecodeString(k.E)
+			if err != nil {
+				return nil, fmt.Errorf("key %q: %s", k.Kid, err)
+			}
+			keys[k.Kid] = &rsa.PublicKey{
+				N: new(big.Int).SetBytes(n),
+				E: int(new(big.Int).SetBytes(e).Int64()),
+			}
+
+		case "EC":
+			curves := map[string]elliptic.Curve{"P-256": elliptic.P256(), "P-384": elliptic.P384(), "P-521": elliptic.P521()}
+			curve, ok := curves[k.Crv]
+			if !ok {
+				return nil, fmt.Errorf("key %q: unsupported curve %q", k.Kid, k.Crv)
+			}
+			x, err := base64.RawURLEncoding.DecodeString(k.X)
+			if err != nil {
+				return nil, fmt.Errorf("key %q: %s", k.Kid, err)
+			}
+			y, err := base64.RawURLEncoding.DecodeString(k.Y)
+			if err != nil {
+				return nil, fmt.Errorf("key %q: %s", k.Kid, err)
+			}
+			keys[k.Kid] = &ecdsa.PublicKey{
+				Curve: curve,
+				X:     new(big.Int).SetBytes(x),
+				Y:     new(big.Int).SetBytes(y),
+			}
+		}
+	}
+
+	if len(keys) == 0 {
+		return nil, fmt.Errorf("no RSA or EC keys found in %s", file)
+	}
+	return keys, nil
+}
@@ -0,0 +1,183 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+	"regexp"
+
+	"github.com/RackHD/voyager-houston/model"
+	"github.com/gin-gonic/gin"
+)
+
+var (
+	// labelKeyPattern matches label keys: letters and digits, with '-', '_',
+	// '.' and '/' inside, up to 63 characters
+	labelKeyPattern = regexp.MustCompile(`^[A-Za-z0-9]([-A-Za-z0-9_./]{0,61}[A-Za-z0-9])?$`)
+	// labelValuePattern matches label values, which are like keys without '/' or empty
+	labelValuePattern = regexp.MustCompile(`^([A-Za-z0-9]([-A-Za-z0-9_.]{0,61}[A-Za-z0-9])?)?$`)
+)
+
+// checkLabel returns an error if key or value is not a valid label
+func checkLabel(key, value string) error {
+	if !labelKeyPattern.MatchString(key) {
+		return fmt.Errorf("Invalid label key %q", key)
+	}
+	if !labelValuePattern.MatchString(value) {
+		return fmt.Errorf("Invalid value %q for label %s", value, key)
+	}
+	return nil
+}
+
+// Labels returns the labels Houston stores on a node
+func (s *Server) Labels(nodeID string) (model.Labels, error) {
+	entities := []model.LabelEntity{}
+	if err := s.MySQL.DB.Where("node_id = ?", nodeID).Find(&entities).Error; err != nil {
+		return nil, err
+	}
+
+	labels := model.Labels{}
+	for _, label := range entities {
+		labels[label.Key] = label.Value
+	}
+	return labels, nil
+}
+
+// SetLabels replaces the labels Houston stores on a node
+func (s *Server) SetLabels(nodeID string, labels model.Labels) error {
+	defer s.nodeData.invalidate()
+	tx := s.MySQL.DB.Begin()
+	if err := tx.Where("node_id = ?", nodeID).Delete(&model.LabelEntity{}).Error; err != nil {
+		tx.Rollback()
+		return err
+	}
+	for key, value := range labels {
+		if err := tx.Create(&model.LabelEntity{NodeID: nodeID, Key: key, Value: value}).Error; err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+	return tx.Commit().Error
+}
+
+// PatchLabels sets the labels of a node that patch gives a value, and removes
+// those it sets to nil
+func (s *Server) PatchLabels(nodeID string, patch model.LabelsPatch) error {
+	defer s.nodeData.invalidate()
+	tx := s.MySQL.DB.Begin()
+	for key, value := range patch {
+		var err error
+		if value == nil {
+			err = tx.Where("node_id = ? AND `key` = ?", nodeID, key).Delete(&model.LabelEntity{}).Error
+		} else {
+			err = tx.Where(model.LabelEntity{NodeID: nodeID, Key: key}).
+				Assign(model.LabelEntity{Value: *value}).
+				FirstOrCreate(&model.LabelEntity{}).Error
+		}
+		if err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+	return tx.Commit().Error
+}
+
+// labelNodes merges the labels Houston stores over the labels the inventory
+// reports on each node
+func (s *Server) labelNodes(nodes []model.Node) error {
+	if s.MySQL == nil {
+		return nil
+	}
+
+	entities := []model.LabelEntity{}
+	if err := s.MySQL.DB.Find(&entities).Error; err != nil {
+		return err
+	}
+	stored := map[string]model.Labels{}
+	for _, label := range entities {
+		if stored[label.NodeID] == nil {
+			stored[label.NodeID] = model.Labels{}
+		}
+		stored[label.NodeID][label.Key] = label.Value
+	}
+
+	for _, node := range nodes {
+		if extra, ok := stored[node.ID()]; ok {
+			labels := nodeLabels(node)
+			for key, value := range extra {
+				labels[key] = value
+			}
+			node["labels"] = labels
+		}
+	}
+	return nil
+}
+
+// GetLabelsHandler Serves GET /nodes/:id/labels. Only the labels stored by
+// Houston are returned, not those the inventory reports.
+func (s *Server) GetLabelsHandler(c *gin.Context) {
+	labels, err := s.Labels(c.Param("id"))
+	if err != nil {
+		abortWithError(c, http.StatusInternalServerError, err)
+		return
+	}
+	c.JSON(http.StatusOK, labels)
+}
+
+// PutLabelsHandler Serves PUT /nodes/:id/labels, replacing the node's labels
+func (s *Server) PutLabelsHandler(c *gin.Context) {
+	labels := model.Labels{}
+	if !bindJSON(c, &labels) {
+		return
+	}
+	for key, value := range labels {
+		if err := checkLabel(key, value); err != nil {
+			abortWithError(c, http.StatusBadRequest, err)
+			return
+		}
+	}
+
+	if err := s.SetLabels(c.Param("id"), labels); err != nil {
+		abortWithError(c, http.StatusInternalServerError, err)
+		return
+	}
+	s.GetLabelsHandler(c)
+}
+
+// PatchLabelsHandler Serves PATCH /nodes/:id/labels. Labels set to null are
+// removed, the others are added or changed.
+func (s *Server) PatchLabelsHandler(c *gin.Context) {
+	patch := model.LabelsPatch{}
+	if !bindJSON(c, &patch) {
+		return
+	}
+	for key, value := range patch {
+		if value == nil {
+			continue
+		}
+		if err := checkLabel(key, *value); err != nil {
+			abortWithError(c, http.StatusBadRequest, err)
+			return
+		}
+	}
+
+	if err := s.PatchLabels(c.Param("id"), patch); err != nil {
+		abortWithError(c, http.StatusInternalServerError, err)
+		return
+	}
+	s.GetLabelsHandler(c)
+}
+
+// DeleteLabelsHandler Serves DELETE /nodes/:id/labels. It removes the labels
+// named by the key query parameters, or all of the node's labels if there are none.
+func (s *Server) DeleteLabelsHandler(c *gin.Context) {
+	query := s.MySQL.DB.Where("node_id = ?", c.Param("id"))
+	if keys := c.Request.URL.Query()["key"]; len(keys) > 0 {
+		query = query.Where("`key` IN (?)", keys)
+	}
+	if err := query.Delete(&model.LabelEntity{}).Error; err != nil {
+		abortWithError(c, http.StatusInternalServerError, err)
+		return
+	}
+	s.nodeData.invalidate()
+	c.Status(http.StatusNoContent)
+}
@@ -0,0 +1,172 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/RackHD/voyager-houston/model"
+	"github.com/gin-gonic/gin"
+	log "github.com/sirupsen/logrus"
+)
+
+// leaseSweeper is the actor the background lease sweeper is audited as
+const leaseSweeper = "lease-sweeper"
+
+// leaseTTL parses the ttl of an address allocation. Empty means no expiry.
+func leaseTTL(ttl string) (time.Duration, error) {
+	if ttl == "" {
+		return 0, nil
+	}
+	d, err := time.ParseDuration(ttl)
+	if err != nil || d <= 0 {
+		return 0, fmt.Errorf("Invalid ttl %q, expected a positive duration such as 72h", ttl)
+	}
+	return d, nil
+}
+
+// presentNodes returns the IDs of the nodes the inventory service reports now
+func (s *Server) presentNodes(ctx context.Context) (map[string]bool, error) {
+	body, err := s.fetchNodes(ctx, true)
+	if err != nil {
+		return nil, err
+	}
+	nodes := []model.Node{}
+	if err = json.Unmarshal(body, &nodes); err != nil {
+		return nil, fmt.Errorf("Error unmarshaling voyager-inventory-service response: %s", err)
+	}
+
+	present := map[string]bool{}
+	for _, node := range nodes {
+		present[node.ID()] = true
+	}
+	return present, nil
+}
+
+// SweepLeases releases expired leases and leases of nodes that have been
+// missing from inventory for longer than Config.LeaseGracePeriod, unless more
+// than Config.LeaseSweepMaxGone of the leases would go that way. A dry run
+// only reports what would be released and changes nothing.
+func (s *Server) SweepLeases(ctx context.Context, dryRun bool) (model.SweepReport, error) {
+	if !dryRun {
+		s.sweepMu.Lock()
+		defer s.sweepMu.Unlock()
+	}
+
+	now := time.Now()
+	report := model.SweepReport{DryRun: dryRun, Time: now, Leases: []model.ReclaimedLease{}}
+
+	leases := []model.LeaseEntity{}
+	if err := s.MySQL.DB.Order("created_at").Find(&leases).Error; err != nil {
+		return report, err
+	}
+
+	// Without inventory every node looks missing, so only expiry is checked
+	present, err := s.presentNodes(ctx)
+	if err == nil && len(present) == 0 {
+		present, err = nil, fmt.Errorf("voyager-inventory-service reported no nodes")
+	}
+	if err != nil {
+		log.Warnf("Lease sweep could not list nodes: %s", err)
+		report.InventoryError = err.Error()
+	}
+
+	candidates := []model.ReclaimedLease{}
+	gone := 0
+	for _, lease := range leases {
+		reclaimed := model.ReclaimedLease{Lease: lease}
+		switch {
+		case lease.ExpiresAt != nil && !lease.ExpiresAt.After(now):
+			reclaimed.Reason, reclaimed.Since = model.ReclaimExpired, *lease.ExpiresAt
+		case present == nil:
+			continue
+		case present[lease.NodeID]:
+			if lease.MissingSince != nil && !dryRun {
+				s.markMissing(lease, nil)
+			}
+			continue
+		default:
+			missing := now
+			if lease.MissingSince != nil {
+				missing = *lease.MissingSince
+			} else if !dryRun {
+				s.markMissing(lease, &now)
+			}
+			if now.Sub(missing) < s.Config.LeaseGracePeriod {
+				continue
+			}
+			reclaimed.Reason, reclaimed.Since = model.ReclaimNodeGone, missing
+			gone++
+		}
+		candidates = append(candidates, reclaimed)
+	}
+
+	keepGone := false
+	if max := s.Config.LeaseSweepMaxGone; max > 0 && float64(gone) > max*float64(len(leases)) {
+		keepGone = true
+		report.NodeGoneSkipped = fmt.Sprintf("The nodes of %d of %d leases are gone from inventory, over the limit of %g", gone, len(leases), max)
+		log.Warnf("Lease sweep kept leases of nodes gone from inventory: %s", report.NodeGoneSkipped)
+	}
+
+	for _, reclaimed := range candidates {
+		if keepGone && reclaimed.Reason == model.ReclaimNodeGone {
+			continue
+		}
+		lease := reclaimed.Lease
+		if !dryRun {
+			if err := s.ReleaseAddress(ctx, lease.ID); err != nil {
+				log.Warnf("Lease sweep could not release %s of node %s: %s", lease.Address, lease.NodeID, err)
+				reclaimed.Error = err.Error()
+			} else {
+				s.metrics.add("houston_leases_reclaimed_total", "Leases released by the lease sweeper, by reason", labels{"reason": reclaimed.Reason}, 1)
+			}
+		}
+		report.Leases = append(report.Leases, reclaimed)
+	}
+	return report, nil
+}
+
+// markMissing records when the node of a lease went missing from inventory,
+// or clears it when since is nil
+func (s *Server) markMissing(lease model.LeaseEntity, since *time.Time) {
+	err := s.MySQL.DB.Model(&model.LeaseEntity{}).Where("id = ?", lease.ID).Update("missing_since", since).Error
+	if err != nil {
+		log.Warnf("Could not update lease %s: %s", lease.ID, err)
+	}
+}
+
+// SweepLeasesEvery sweeps leases every interval. It never returns.
+func (s *Server) SweepLeasesEvery(interval time.Duration) {
+	ctx := context.WithValue(context.Background(), requestInfoKey{}, requestInfo{Actor: leaseSweeper})
+	for range time.Tick(interval) {
+		report, err := s.SweepLeases(ctx, false)
+		if err != nil {
+			log.Warnf("Lease sweep failed: %s", err)
+			continue
+		}
+		if len(report.Leases) > 0 {
+			log.Infof("Lease sweep reclaimed %d leases", len(report.Leases))
+		}
+	}
+}
+
+// SweepReportHandler Serves GET /addresses/sweep, a dry run of the lease sweeper
+func (s *Server) SweepReportHandler(c *gin.Context) {
+	s.sweepHandler(c, true)
+}
+
+// SweepHandler Serves POST /addresses/sweep, which runs the lease sweeper now
+func (s *Server) SweepHandler(c *gin.Context) {
+	s.sweepHandler(c, false)
+}
+
+func (s *Server) sweepHandler(c *gin.Context, dryRun bool) {
+	report, err := s.SweepLeases(requestContext(c), dryRun)
+	if err != nil {
+		abortWithError(c, http.StatusInternalServerError, err)
+		return
+	}
+	c.JSON(http.StatusOK, report)
+}
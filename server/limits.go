@@ -0,0 +1,211 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// defaultLimitKey configures the limit for routes or services not listed explicitly
+const defaultLimitKey = "*"
+
+// maxBuckets is the number of clients tracked per route before idle ones are dropped
+const maxBuckets = 10000
+
+// errTooManyInFlight is returned when a Voyager service already has the maximum number of requests in flight
+var errTooManyInFlight = errors.New("Too many requests in flight to this service, try again later")
+
+// RateLimit allows Burst requests at once, refilled at Rate requests per second
+type RateLimit struct {
+	Rate  float64
+	Burst int
+}
+
+// ParseRateLimits parses comma-separated ROUTE=RATE:BURST entries, e.g.
+// "GET /nodes=2:10,*=20:40". ROUTE is "*" or a method and path as registered.
+func ParseRateLimits(s string) (map[string]RateLimit, error) {
+	limits := map[string]RateLimit{}
+	if strings.TrimSpace(s) == "" {
+		return limits, nil
+	}
+
+	for _, entry := range strings.Split(s, ",") {
+		parts := strings.SplitN(entry, "=", 2)
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("rate limit %q is not ROUTE=RATE:BURST", entry)
+		}
+		values := strings.SplitN(parts[1], ":", 2)
+		if len(values) != 2 {
+			return nil, fmt.Errorf("rate limit %q is not ROUTE=RATE:BURST", entry)
+		}
+
+		rate, err := strconv.ParseFloat(values[0], 64)
+		if err != nil || rate <= 0 {
+			return nil, fmt.Errorf("rate limit %q has an invalid rate", entry)
+		}
+		burst, err := strconv.Atoi(values[1])
+		if err != nil || burst < 1 {
+			return nil, fmt.Errorf("rate limit %q has an invalid burst", entry)
+		}
+
+		limits[strings.TrimSpace(parts[0])] = RateLimit{Rate: rate, Burst: burst}
+	}
+	return limits, nil
+}
+
+// ParseMaxInFlight parses comma-separated SERVICE=N entries, e.g.
+// "voyager-inventory-service=10,*=50". SERVICE is "*" or an exchange name.
+func ParseMaxInFlight(s string) (map[string]int, error) {
+	limits := map[string]int{}
+	if strings.TrimSpace(s) == "" {
+		return limits, nil
+	}
+
+	for _, entry := range strings.Split(s, ",") {
+		parts := strings.SplitN(entry, "=", 2)
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("limit %q is not SERVICE=N", entry)
+		}
+		n, err := strconv.Atoi(parts[1])
+		if err != nil || n < 1 {
+			return nil, fmt.Errorf("limit %q is not SERVICE=N", entry)
+		}
+		limits[strings.TrimSpace(parts[0])] = n
+	}
+	return limits, nil
+}
+
+type tokenBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// rateLimiter keeps a token bucket per client
+type rateLimiter struct {
+	limit RateLimit
+
+	mu      sync.Mutex
+	buckets map[string]*tokenBucket
+}
+
+func newRateLimiter(limit RateLimit) *rateLimiter {
+	return &rateLimiter{
+		limit:   limit,
+		buckets: map[string]*tokenBucket{},
+	}
+}
+
+// allow takes a token from client's bucket. If there is none, it returns how
+// long until there will be.
+func (l *rateLimiter) allow(client string, now time.Time) (bool, time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	bucket, ok := l.buckets[client]
+	if !ok {
+		if len(l.buckets) >= maxBuckets {
+			l.dropIdle(now)
+		}
+		bucket = &tokenBucket{tokens: float64(l.limit.Burst), last: now}
+		l.buckets[client] = bucket
+	}
+
+	bucket.tokens = math.Min(float64(l.limit.Burst), bucket.tokens+now.Sub(bucket.last).Seconds()*l.limit.Rate)
+	bucket.last = now
+	if bucket.tokens >= 1 {
+		bucket.tokens--
+		return true, 0
+	}
+
+	wait := (1 - bucket.tokens) / l.limit.Rate
+	return false, time.Duration(wait * float64(time.Second))
+}
+
+// dropIdle forgets the clients whose buckets have refilled
+func (l *rateLimiter) dropIdle(now time.Time) {
+	for client, bucket := range l.buckets {
+		if bucket.tokens+now.Sub(bucket.last).Seconds()*l.limit.Rate >= float64(l.limit.Burst) {
+			delete(l.buckets, client)
+		}
+	}
+}
+
+// limitRate returns middleware enforcing the rate limit configured for route,
+// or nil if there is none
+func (s *Server) limitRate(route string) gin.HandlerFunc {
+	limit, ok := s.Config.RateLimits[route]
+	if !ok {
+		limit, ok = s.Config.RateLimits[defaultLimitKey]
+	}
+	if !ok {
+		return nil
+	}
+
+	limiter := newRateLimiter(limit)
+	return func(c *gin.Context) {
+		client := c.ClientIP()
+		if id := identity(c); id != nil && id.Method != "none" {
+			client = id.Method + ":" + id.Subject
+		}
+
+		if ok, wait := limiter.allow(client, time.Now()); !ok {
+			c.Header("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+			abortWithError(c, http.StatusTooManyRequests, fmt.Errorf("Rate limit of %g requests per second exceeded", limit.Rate))
+		}
+	}
+}
+
+// acquire takes one of the in-flight slots of a Voyager service. It returns
+// false if they are all taken.
+func (s *Server) acquire(exchange string) bool {
+	slots := s.inFlightSlots(exchange)
+	if slots == nil {
+		return true
+	}
+
+	select {
+	case slots <- struct{}{}:
+		return true
+	default:
+		return false
+	}
+}
+
+// release returns a slot taken with acquire
+func (s *Server) release(exchange string) {
+	if slots := s.inFlightSlots(exchange); slots != nil {
+		<-slots
+	}
+}
+
+// inFlightSlots returns the semaphore limiting requests to exchange, or nil if they are not limited
+func (s *Server) inFlightSlots(exchange string) chan struct{} {
+	s.inFlightMu.Lock()
+	defer s.inFlightMu.Unlock()
+
+	if slots, ok := s.inFlight[exchange]; ok {
+		return slots
+	}
+
+	max, ok := s.Config.MaxInFlight[exchange]
+	if !ok {
+		max = s.Config.MaxInFlight[defaultLimitKey]
+	}
+
+	var slots chan struct{}
+	if max > 0 {
+		slots = make(chan struct{}, max)
+	}
+	if s.inFlight == nil {
+		s.inFlight = map[string]chan struct{}{}
+	}
+	s.inFlight[exchange] = slots
+	return slots
+}
@@ -0,0 +1,78 @@
+package server_test
+
+import (
+	"io/ioutil"
+	"net/http"
+
+	. "github.com/RackHD/voyager-houston/server"
+	"github.com/gin-gonic/gin"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Limits", func() {
+	BeforeEach(func() {
+		gin.SetMode(gin.ReleaseMode)
+		gin.DefaultWriter = ioutil.Discard
+	})
+
+	Context("Parsing", func() {
+		It("UNIT should parse rate limits per route", func() {
+			limits, err := ParseRateLimits("GET /nodes=2:10, *=0.5:1")
+			Expect(err).ToNot(HaveOccurred())
+			Expect(limits).To(Equal(map[string]RateLimit{
+				"GET /nodes": {Rate: 2, Burst: 10},
+				"*":          {Rate: 0.5, Burst: 1},
+			}))
+
+			limits, err = ParseRateLimits("")
+			Expect(err).ToNot(HaveOccurred())
+			Expect(limits).To(BeEmpty())
+
+			for _, invalid := range []string{"GET /nodes", "GET /nodes=2", "GET /nodes=0:1", "GET /nodes=1:0", "*=a:b"} {
+				_, err = ParseRateLimits(invalid)
+				Expect(err).To(HaveOccurred(), invalid)
+			}
+		})
+
+		It("UNIT should parse in-flight limits per service", func() {
+			limits, err := ParseMaxInFlight("voyager-inventory-service=10,*=50")
+			Expect(err).ToNot(HaveOccurred())
+			Expect(limits).To(Equal(map[string]int{"voyager-inventory-service": 10, "*": 50}))
+
+			_, err = ParseMaxInFlight("voyager-inventory-service=none")
+			Expect(err).To(HaveOccurred())
+		})
+	})
+
+	Context("API endpoints", func() {
+		It("UNIT should answer 429 with Retry-After once a client's burst is used up", func() {
+			s := &Server{Config: Config{
+				RateLimits: map[string]RateLimit{"GET /roles": {Rate: 0.1, Burst: 2}},
+			}}
+			router := s.Router()
+
+			Expect(get(router, "/api/v1/roles", nil).Code).To(Equal(http.StatusOK))
+			Expect(get(router, "/api/v1/roles", nil).Code).To(Equal(http.StatusOK))
+
+			resp := get(router, "/api/v1/roles", nil)
+			Expect(resp.Code).To(Equal(http.StatusTooManyRequests))
+			Expect(resp.Header().Get("Retry-After")).To(Equal("10"))
+
+			// Other routes are not limited
+			Expect(get(router, "/api/v1/whoami", nil).Code).To(Equal(http.StatusOK))
+		})
+
+		It("UNIT should keep a bucket per client", func() {
+			s := &Server{Config: Config{
+				RateLimits: map[string]RateLimit{"*": {Rate: 0.1, Burst: 1}},
+			}}
+			router := s.Router()
+
+			Expect(get(router, "/api/v1/roles", map[string]string{"X-Forwarded-For": "10.0.0.1"}).Code).To(Equal(http.StatusOK))
+			Expect(get(router, "/api/v1/roles", map[string]string{"X-Forwarded-For": "10.0.0.1"}).Code).To(Equal(http.StatusTooManyRequests))
+			Expect(get(router, "/api/v1/roles", map[string]string{"X-Forwarded-For": "10.0.0.2"}).Code).To(Equal(http.StatusOK))
+		})
+	})
+})
@@ -0,0 +1,122 @@
+package server
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Metric types in the Prometheus text format
+const (
+	counterType = "counter"
+	gaugeType   = "gauge"
+)
+
+// labels are the names and values that identify one series of a metric
+type labels map[string]string
+
+// key renders labels as they appear in the text format, sorted by name
+func (l labels) key() string {
+	names := []string{}
+	for name := range l {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	pairs := []string{}
+	for _, name := range names {
+		value := strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(l[name])
+		pairs = append(pairs, fmt.Sprintf(`%s="%s"`, name, value))
+	}
+	return strings.Join(pairs, ",")
+}
+
+type metric struct {
+	help   string
+	kind   string
+	series map[string]float64
+}
+
+// registry holds the metrics served at /metrics. The zero value is ready to use.
+type registry struct {
+	mu      sync.Mutex
+	metrics map[string]*metric
+}
+
+// add adds delta to a counter
+func (r *registry) add(name, help string, l labels, delta float64) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.get(name, help, counterType).series[l.key()] += delta
+}
+
+// set sets a gauge
+func (r *registry) set(name, help string, l labels, value float64) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.get(name, help, gaugeType).series[l.key()] = value
+}
+
+// reset removes every series of a gauge, e.g. before setting the current ones
+func (r *registry) reset(name string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if m, ok := r.metrics[name]; ok {
+		m.series = map[string]float64{}
+	}
+}
+
+func (r *registry) get(name, help, kind string) *metric {
+	if r.metrics == nil {
+		r.metrics = map[string]*metric{}
+	}
+	m, ok := r.metrics[name]
+	if !ok {
+		m = &metric{help: help, kind: kind, series: map[string]float64{}}
+		r.metrics[name] = m
+	}
+	return m
+}
+
+// text renders the metrics in the Prometheus text exposition format
+func (r *registry) text() []byte {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	names := []string{}
+	for name := range r.metrics {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	out := &bytes.Buffer{}
+	for _, name := range names {
+		m := r.metrics[name]
+		fmt.Fprintf(out, "# HELP %s %s\n# TYPE %s %s\n", name, m.help, name, m.kind)
+
+		keys := []string{}
+		for key := range m.series {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			if key == "" {
+				fmt.Fprintf(out, "%s %g\n", name, m.series[key])
+			} else {
+				fmt.Fprintf(out, "%s{%s} %g\n", name, key, m.series[key])
+			}
+		}
+	}
+	return out.Bytes()
+}
+
+// MetricsHandler Serves GET /metrics in the Prometheus text format
+func (s *Server) MetricsHandler(c *gin.Context) {
+	s.refreshUsageGauges()
+	c.Data(http.StatusOK, "text/plain; version=0.0.4; charset=utf-8", s.metrics.text())
+}
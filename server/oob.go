@@ -0,0 +1,133 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/RackHD/voyager-houston/model"
+	"github.com/gin-gonic/gin"
+)
+
+// Exchange and routing keys of voyager-oob-service, which manages nodes out of band through their BMCs
+const (
+	oobExchange     = "voyager-oob-service"
+	oobExchangeType = "topic"
+	oobRequestKey   = "requests"
+	oobReplyKey     = "replies"
+)
+
+// powerActions are the power actions voyager-oob-service runs
+var powerActions = map[string]bool{
+	model.PowerOn:     true,
+	model.PowerOff:    true,
+	model.PowerReset:  true,
+	model.PowerCycle:  true,
+	model.PowerStatus: true,
+}
+
+// Power runs a power action on a node and returns the power state reported after it
+func (s *Server) Power(ctx context.Context, nodeID, action string) (model.PowerResult, error) {
+	if !powerActions[action] {
+		return model.PowerResult{}, newAPIError(http.StatusBadRequest, fmt.Errorf("Unknown power action %q", action))
+	}
+	reply, err := s.oobRequest(ctx, model.OOBCommand{Command: "power", NodeID: nodeID, Action: action})
+	if err != nil {
+		return model.PowerResult{}, err
+	}
+	return model.PowerResult{Node: nodeID, Action: action, PowerState: reply.PowerState}, nil
+}
+
+// bootDevices are the devices voyager-oob-service can make a node boot from
+var bootDevices = map[string]bool{
+	model.BootPXE:  true,
+	model.BootDisk: true,
+	model.BootBIOS: true,
+}
+
+// SetBootDevice sets the device a node boots from, next time only unless persistent
+func (s *Server) SetBootDevice(ctx context.Context, nodeID, device string, persistent bool) (model.BootDeviceResult, error) {
+	if !bootDevices[device] {
+		return model.BootDeviceResult{}, newAPIError(http.StatusBadRequest, fmt.Errorf("Unknown boot device %q", device))
+	}
+	_, err := s.oobRequest(ctx, model.OOBCommand{Command: "set_boot_device", NodeID: nodeID, Device: device, Persistent: persistent})
+	if err != nil {
+		return model.BootDeviceResult{}, err
+	}
+	return model.BootDeviceResult{Node: nodeID, Device: device, Persistent: persistent}, nil
+}
+
+// oobRequest sends command to voyager-oob-service. A command the BMC refuses
+// fails with a 422 UPSTREAM_REJECTED.
+func (s *Server) oobRequest(ctx context.Context, command model.OOBCommand) (model.OOBReply, error) {
+	reply := model.OOBReply{}
+	body, err := json.Marshal(command)
+	if err != nil {
+		return reply, err
+	}
+
+	replyBody, err := s.call(ctx, rpcRequest{
+		Exchange:     oobExchange,
+		ExchangeType: oobExchangeType,
+		RoutingKey:   oobRequestKey,
+		ReplyKey:     oobReplyKey,
+		Command:      command.Command,
+		Message:      body,
+	})
+	if err != nil {
+		return reply, err
+	}
+	if err = json.Unmarshal(replyBody, &reply); err != nil {
+		return reply, fmt.Errorf("Error unmarshaling voyager-oob-service response: %s", err)
+	}
+	if reply.Error != "" {
+		return reply, rejectedError("The BMC of node "+command.NodeID, command.Command, reply.Error)
+	}
+	return reply, nil
+}
+
+// PowerStateHandler Serves GET /nodes/:id/power, which reads the power state
+// as POST with the status action does
+func (s *Server) PowerStateHandler(c *gin.Context) {
+	result, err := s.Power(requestContext(c), c.Param("id"), model.PowerStatus)
+	if err != nil {
+		abortWithRPCError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, result)
+}
+
+// PowerHandler Serves POST /nodes/:id/power. The status action only reads the
+// power state, so it only needs nodes:read; the others need nodes:power.
+func (s *Server) PowerHandler(c *gin.Context) {
+	request := model.PowerRequest{}
+	if !bindJSON(c, &request) {
+		return
+	}
+	if request.Action != model.PowerStatus && !s.authorize(c, PermNodesPower) {
+		return
+	}
+
+	result, err := s.Power(requestContext(c), c.Param("id"), request.Action)
+	if err != nil {
+		abortWithRPCError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, result)
+}
+
+// BootDeviceHandler Serves POST /nodes/:id/boot-device
+func (s *Server) BootDeviceHandler(c *gin.Context) {
+	request := model.BootDeviceRequest{}
+	if !bindJSON(c, &request) {
+		return
+	}
+
+	result, err := s.SetBootDevice(requestContext(c), c.Param("id"), request.Device, request.Persistent)
+	if err != nil {
+		abortWithRPCError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, result)
+}
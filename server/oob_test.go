@@ -0,0 +1,106 @@
+package server_test
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"sync"
+
+	"github.com/RackHD/voyager-houston/model"
+	. "github.com/RackHD/voyager-houston/server"
+	"github.com/gin-gonic/gin"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Out-of-band management", func() {
+	var router *gin.Engine
+	var mu sync.Mutex
+	var sent string
+	var bmcError string
+
+	BeforeEach(func() {
+		gin.SetMode(gin.ReleaseMode)
+		gin.DefaultWriter = ioutil.Discard
+
+		bmcError = ""
+		broker := &fakeBroker{reply: func(exchange, routingKey, message string) string {
+			mu.Lock()
+			defer mu.Unlock()
+			sent = message
+			if bmcError != "" {
+				return `{"error":"` + bmcError + `"}`
+			}
+			return `{"powerState":"on"}`
+		}}
+		router = (&Server{MQ: broker}).Router()
+	})
+
+	It("UNIT should run power actions and return the power state", func() {
+		resp := do(router, "POST", "/api/v1/nodes/n1/power", model.PowerRequest{Action: model.PowerCycle}, nil)
+		Expect(resp.Code).To(Equal(http.StatusOK))
+
+		result := model.PowerResult{}
+		Expect(json.Unmarshal(resp.Body.Bytes(), &result)).To(Succeed())
+		Expect(result).To(Equal(model.PowerResult{Node: "n1", Action: "cycle", PowerState: "on"}))
+
+		mu.Lock()
+		defer mu.Unlock()
+		Expect(sent).To(MatchJSON(`{"command":"power","nodeId":"n1","action":"cycle"}`))
+	})
+
+	It("UNIT should read the power state", func() {
+		resp := get(router, "/api/v1/nodes/n1/power", nil)
+		Expect(resp.Code).To(Equal(http.StatusOK))
+		Expect(resp.Body.String()).To(MatchJSON(`{"node":"n1","action":"status","power_state":"on"}`))
+
+		resp = do(router, "POST", "/api/v1/nodes/n1/power", model.PowerRequest{Action: model.PowerStatus}, nil)
+		Expect(resp.Code).To(Equal(http.StatusOK))
+		Expect(resp.Body.String()).To(MatchJSON(`{"node":"n1","action":"status","power_state":"on"}`))
+
+		mu.Lock()
+		defer mu.Unlock()
+		Expect(sent).To(MatchJSON(`{"command":"power","nodeId":"n1","action":"status"}`))
+	})
+
+	It("UNIT should reject unknown actions and devices", func() {
+		resp := do(router, "POST", "/api/v1/nodes/n1/power", model.PowerRequest{Action: "explode"}, nil)
+		Expect(resp.Code).To(Equal(http.StatusBadRequest))
+		Expect(errorBody(resp.Body.Bytes()).Code).To(Equal(CodeValidationFailed))
+
+		_, err := (&Server{}).Power(context.Background(), "n1", "explode")
+		Expect(err).To(HaveOccurred())
+		Expect(err.(*APIError).Status).To(Equal(http.StatusBadRequest))
+
+		resp = do(router, "POST", "/api/v1/nodes/n1/boot-device", model.BootDeviceRequest{Device: "floppy"}, nil)
+		Expect(resp.Code).To(Equal(http.StatusBadRequest))
+
+		_, err = (&Server{}).SetBootDevice(context.Background(), "n1", "floppy", false)
+		Expect(err).To(HaveOccurred())
+		Expect(err.(*APIError).Status).To(Equal(http.StatusBadRequest))
+	})
+
+	It("UNIT should set the boot device", func() {
+		resp := do(router, "POST", "/api/v1/nodes/n1/boot-device", model.BootDeviceRequest{Device: model.BootPXE, Persistent: true}, nil)
+		Expect(resp.Code).To(Equal(http.StatusOK))
+		Expect(resp.Body.String()).To(MatchJSON(`{"node":"n1","device":"pxe","persistent":true}`))
+
+		mu.Lock()
+		defer mu.Unlock()
+		Expect(sent).To(MatchJSON(`{"command":"set_boot_device","nodeId":"n1","device":"pxe","persistent":true}`))
+	})
+
+	It("UNIT should report commands the BMC refuses", func() {
+		mu.Lock()
+		bmcError = "IPMI session failed"
+		mu.Unlock()
+
+		resp := do(router, "POST", "/api/v1/nodes/n1/power", model.PowerRequest{Action: model.PowerOff}, nil)
+		Expect(resp.Code).To(Equal(http.StatusUnprocessableEntity))
+		apiErr := errorBody(resp.Body.Bytes())
+		Expect(apiErr.Code).To(Equal(CodeUpstreamRejected))
+		Expect(apiErr.Message).To(ContainSubstring("IPMI session failed"))
+	})
+})
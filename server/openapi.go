@@ -0,0 +1,324 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// apiPrefix is where the current version of the API is mounted
+const apiPrefix = "/api/v1"
+
+// openAPI is the parsed OpenAPI document
+var openAPI map[string]interface{}
+
+// patterns holds the compiled pattern of every schema in the OpenAPI document
+var patterns = map[string]*regexp.Regexp{}
+
+func init() {
+	if err := json.Unmarshal([]byte(openAPISpec), &openAPI); err != nil {
+		panic(fmt.Sprintf("invalid OpenAPI document: %s", err))
+	}
+	if err := compilePatterns(openAPI); err != nil {
+		panic(fmt.Sprintf("invalid OpenAPI document: %s", err))
+	}
+}
+
+// compilePatterns compiles the patterns of the schemas in v into patterns
+func compilePatterns(v interface{}) error {
+	switch v := v.(type) {
+	case map[string]interface{}:
+		for key, item := range v {
+			if pattern, ok := item.(string); ok && key == "pattern" {
+				re, err := regexp.Compile(pattern)
+				if err != nil {
+					return fmt.Errorf("pattern %s: %s", pattern, err)
+				}
+				patterns[pattern] = re
+			} else if err := compilePatterns(item); err != nil {
+				return err
+			}
+		}
+	case []interface{}:
+		for _, item := range v {
+			if err := compilePatterns(item); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// OpenAPIHandler Serves GET /api/v1/openapi.json
+func (s *Server) OpenAPIHandler(c *gin.Context) {
+	c.Data(http.StatusOK, "application/json; charset=utf-8", []byte(openAPISpec))
+}
+
+// operation returns the OpenAPI operation for a route as registered with gin,
+// e.g. "GET" and "/pools/:id", or nil if the document does not describe it
+func operation(method, path string) map[string]interface{} {
+	paths, _ := openAPI["paths"].(map[string]interface{})
+	item, _ := paths[openAPIPath(path)].(map[string]interface{})
+	op, _ := item[strings.ToLower(method)].(map[string]interface{})
+	return op
+}
+
+// openAPIPath converts a gin path to an OpenAPI path, e.g. /pools/:id to /pools/{id}
+func openAPIPath(path string) string {
+	segments := strings.Split(path, "/")
+	for i, segment := range segments {
+		if strings.HasPrefix(segment, ":") || strings.HasPrefix(segment, "*") {
+			segments[i] = "{" + segment[1:] + "}"
+		}
+	}
+	return strings.Join(segments, "/")
+}
+
+// validateRequest returns middleware that checks the query parameters and JSON
+// body of requests against the OpenAPI operation for a route, or nil if there is nothing to check
+func validateRequest(method, path string) gin.HandlerFunc {
+	op := operation(method, path)
+	if op == nil {
+		panic(fmt.Sprintf("%s %s is missing from the OpenAPI document", method, path))
+	}
+
+	params := []map[string]interface{}{}
+	list, _ := op["parameters"].([]interface{})
+	for _, p := range list {
+		param := resolve(p)
+		if param["in"] == "query" {
+			params = append(params, param)
+		}
+	}
+
+	var bodySchema map[string]interface{}
+	if body := resolve(op["requestBody"]); body != nil {
+		content, _ := body["content"].(map[string]interface{})
+		media := resolve(content["application/json"])
+		bodySchema = resolve(media["schema"])
+	}
+
+	if len(params) == 0 && bodySchema == nil {
+		return nil
+	}
+
+	return func(c *gin.Context) {
+		problems := []string{}
+
+		for _, param := range params {
+			name, _ := param["name"].(string)
+			value, present := c.GetQuery(name)
+			if !present {
+				if required, _ := param["required"].(bool); required {
+					problems = append(problems, fmt.Sprintf("query parameter %s is required", name))
+				}
+				continue
+			}
+			problems = append(problems, validateQuery(resolve(param["schema"]), value, name)...)
+		}
+
+		if bodySchema != nil {
+			body, err := ioutil.ReadAll(c.Request.Body)
+			if err != nil {
+				abortWithError(c, http.StatusBadRequest, err)
+				return
+			}
+			c.Request.Body = ioutil.NopCloser(bytes.NewReader(body))
+
+			var document interface{}
+			decoder := json.NewDecoder(bytes.NewReader(body))
+			decoder.UseNumber()
+			if err = decoder.Decode(&document); err != nil {
+				problems = append(problems, fmt.Sprintf("body is not valid JSON: %s", err))
+			} else {
+				problems = append(problems, validate(bodySchema, document, "body")...)
+			}
+		}
+
+		if len(problems) > 0 {
+			abortWithAPIError(c, &APIError{
+				Status:  http.StatusBadRequest,
+				Code:    CodeValidationFailed,
+				Message: fmt.Sprintf("Invalid request: %s", strings.Join(problems, "; ")),
+				Details: problems,
+			})
+		}
+	}
+}
+
+// resolve follows a local $ref, returning the object it points to
+func resolve(v interface{}) map[string]interface{} {
+	object, _ := v.(map[string]interface{})
+	ref, ok := object["$ref"].(string)
+	if !ok {
+		return object
+	}
+
+	var target interface{} = openAPI
+	for _, key := range strings.Split(strings.TrimPrefix(ref, "#/"), "/") {
+		parent, _ := target.(map[string]interface{})
+		target = parent[key]
+	}
+	return resolve(target)
+}
+
+// validateQuery checks a query parameter against its schema
+func validateQuery(schema map[string]interface{}, value, name string) []string {
+	switch schema["type"] {
+	case "integer":
+		n, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			return []string{fmt.Sprintf("%s must be an integer", name)}
+		}
+		return validate(schema, json.Number(strconv.FormatInt(n, 10)), name)
+	case "number":
+		if _, err := strconv.ParseFloat(value, 64); err != nil {
+			return []string{fmt.Sprintf("%s must be a number", name)}
+		}
+		return validate(schema, json.Number(value), name)
+	case "boolean":
+		if _, err := strconv.ParseBool(value); err != nil {
+			return []string{fmt.Sprintf("%s must be true or false", name)}
+		}
+		return nil
+	}
+	return validate(schema, value, name)
+}
+
+// validate checks value against the subset of JSON Schema used in the OpenAPI
+// document: type, enum, required, properties, additionalProperties, items,
+// minLength, maxLength, pattern, minimum, maximum, minItems and maxItems.
+func validate(schema map[string]interface{}, value interface{}, path string) []string {
+	if schema == nil {
+		return nil
+	}
+	if value == nil {
+		if nullable, _ := schema["nullable"].(bool); nullable {
+			return nil
+		}
+	}
+
+	if enum, ok := schema["enum"].([]interface{}); ok {
+		found := false
+		for _, allowed := range enum {
+			if fmt.Sprint(allowed) == fmt.Sprint(value) {
+				found = true
+			}
+		}
+		if !found {
+			return []string{fmt.Sprintf("%s must be one of %v", path, enum)}
+		}
+	}
+
+	switch schema["type"] {
+	case "object":
+		object, ok := value.(map[string]interface{})
+		if !ok {
+			return []string{fmt.Sprintf("%s must be an object", path)}
+		}
+		return validateObject(schema, object, path)
+
+	case "array":
+		array, ok := value.([]interface{})
+		if !ok {
+			return []string{fmt.Sprintf("%s must be an array", path)}
+		}
+		problems := []string{}
+		if min, ok := schema["minItems"].(float64); ok && float64(len(array)) < min {
+			problems = append(problems, fmt.Sprintf("%s must have at least %g items", path, min))
+		}
+		if max, ok := schema["maxItems"].(float64); ok && float64(len(array)) > max {
+			problems = append(problems, fmt.Sprintf("%s must have at most %g items", path, max))
+		}
+		for i, item := range array {
+			problems = append(problems, validate(resolve(schema["items"]), item, fmt.Sprintf("%s[%d]", path, i))...)
+		}
+		return problems
+
+	case "string":
+		str, ok := value.(string)
+		if !ok {
+			return []string{fmt.Sprintf("%s must be a string", path)}
+		}
+		if min, ok := schema["minLength"].(float64); ok && float64(len(str)) < min {
+			return []string{fmt.Sprintf("%s must be at least %g characters", path, min)}
+		}
+		if max, ok := schema["maxLength"].(float64); ok && float64(len(str)) > max {
+			return []string{fmt.Sprintf("%s must be at most %g characters", path, max)}
+		}
+		if pattern, ok := schema["pattern"].(string); ok {
+			if !patterns[pattern].MatchString(str) {
+				return []string{fmt.Sprintf("%s must match %s", path, pattern)}
+			}
+		}
+		return nil
+
+	case "integer", "number":
+		number, ok := value.(json.Number)
+		if !ok {
+			return []string{fmt.Sprintf("%s must be a %s", path, schema["type"])}
+		}
+		if schema["type"] == "integer" {
+			if _, err := number.Int64(); err != nil {
+				return []string{fmt.Sprintf("%s must be an integer", path)}
+			}
+		}
+		n, _ := number.Float64()
+		if min, ok := schema["minimum"].(float64); ok && n < min {
+			return []string{fmt.Sprintf("%s must be at least %g", path, min)}
+		}
+		if max, ok := schema["maximum"].(float64); ok && n > max {
+			return []string{fmt.Sprintf("%s must be at most %g", path, max)}
+		}
+		return nil
+
+	case "boolean":
+		if _, ok := value.(bool); !ok {
+			return []string{fmt.Sprintf("%s must be true or false", path)}
+		}
+	}
+	return nil
+}
+
+func validateObject(schema, object map[string]interface{}, path string) []string {
+	problems := []string{}
+
+	required, _ := schema["required"].([]interface{})
+	for _, name := range required {
+		if _, ok := object[name.(string)]; !ok {
+			problems = append(problems, fmt.Sprintf("%s.%s is required", path, name))
+		}
+	}
+
+	properties, _ := schema["properties"].(map[string]interface{})
+	names := []string{}
+	for name := range object {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		property, ok := properties[name]
+		if !ok {
+			switch additional := schema["additionalProperties"].(type) {
+			case bool:
+				if !additional {
+					problems = append(problems, fmt.Sprintf("%s.%s is not allowed", path, name))
+				}
+			case map[string]interface{}:
+				problems = append(problems, validate(resolve(additional), object[name], path+"."+name)...)
+			}
+			continue
+		}
+		problems = append(problems, validate(resolve(property), object[name], path+"."+name)...)
+	}
+	return problems
+}
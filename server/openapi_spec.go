@@ -0,0 +1,1432 @@
+package server
+
+// openAPISpec describes the API mounted at apiPrefix. Every route registered
+// with the router must appear here; requests are validated against it.
+const openAPISpec = `{
+  "openapi": "3.0.0",
+  "info": {
+    "title": "Voyager Houston",
+    "description": "Orchestration API for the Voyager inventory and IPAM services",
+    "version": "1.0.0"
+  },
+  "servers": [{"url": "/api/v1"}],
+  "security": [{"apiKey": []}, {"bearer": []}],
+  "paths": {
+    "/healthz": {
+      "get": {
+        "summary": "Report that Houston is up",
+        "security": [],
+        "responses": {
+          "200": {"description": "Houston is up", "content": {"application/json": {"schema": {"$ref": "#/components/schemas/Health"}}}}
+        }
+      }
+    },
+    "/info": {
+      "get": {
+        "summary": "Describe this service",
+        "security": [],
+        "responses": {
+          "200": {"description": "Service information", "content": {"application/json": {"schema": {"$ref": "#/components/schemas/Houston"}}}}
+        }
+      }
+    },
+    "/openapi.json": {
+      "get": {
+        "summary": "This document",
+        "security": [],
+        "responses": {
+          "200": {"description": "The OpenAPI document", "content": {"application/json": {"schema": {"type": "object"}}}}
+        }
+      }
+    },
+    "/metrics": {
+      "get": {
+        "summary": "Metrics in the Prometheus text format",
+        "responses": {
+          "200": {"description": "Metrics", "content": {"text/plain": {"schema": {"type": "string"}}}}
+        }
+      }
+    },
+    "/whoami": {
+      "get": {
+        "summary": "Identify the caller",
+        "responses": {
+          "200": {"description": "The caller's identity", "content": {"application/json": {"schema": {"$ref": "#/components/schemas/Identity"}}}},
+          "401": {"$ref": "#/components/responses/Error"}
+        }
+      }
+    },
+    "/nodes": {
+      "get": {
+        "summary": "List nodes known to the inventory service, with the labels and locations stored by Houston added",
+        "parameters": [
+          {"name": "labelSelector", "in": "query", "description": "Kubernetes-style label selector, e.g. env=prod,role!=gpu,tier in (a,b)", "schema": {"type": "string"}},
+          {"name": "site", "in": "query", "description": "Only nodes placed in this site", "schema": {"type": "string"}},
+          {"name": "room", "in": "query", "description": "Only nodes placed in this room", "schema": {"type": "string"}},
+          {"name": "rack", "in": "query", "description": "Only nodes placed in this rack", "schema": {"type": "string"}}
+        ],
+        "responses": {
+          "200": {
+            "description": "Nodes",
+            "headers": {
+              "X-Cache": {"description": "Where the nodes came from when the inventory cache is enabled: HIT, MISS, or STALE when the inventory service was slow or down", "schema": {"type": "string", "enum": ["HIT", "MISS", "STALE"]}},
+              "Age": {"description": "Seconds since the cached nodes were fetched", "schema": {"type": "integer"}},
+              "Warning": {"description": "Set to 110 for stale responses", "schema": {"type": "string"}}
+            },
+            "content": {"application/json": {"schema": {"type": "array", "items": {"$ref": "#/components/schemas/Node"}}}}
+          },
+          "400": {"$ref": "#/components/responses/Error"},
+          "429": {"$ref": "#/components/responses/Error"},
+          "502": {"$ref": "#/components/responses/Error"},
+          "504": {"$ref": "#/components/responses/Error"}
+        }
+      }
+    },
+    "/nodes/{id}/labels": {
+      "parameters": [{"name": "id", "in": "path", "required": true, "schema": {"type": "string"}}],
+      "get": {
+        "summary": "Get the labels Houston stores on a node",
+        "responses": {
+          "200": {"description": "Labels", "content": {"application/json": {"schema": {"$ref": "#/components/schemas/Labels"}}}}
+        }
+      },
+      "put": {
+        "summary": "Replace the labels Houston stores on a node",
+        "requestBody": {"required": true, "content": {"application/json": {"schema": {"$ref": "#/components/schemas/Labels"}}}},
+        "responses": {
+          "200": {"description": "Labels", "content": {"application/json": {"schema": {"$ref": "#/components/schemas/Labels"}}}},
+          "400": {"$ref": "#/components/responses/Error"}
+        }
+      },
+      "patch": {
+        "summary": "Add, change or remove (with null) labels of a node",
+        "requestBody": {"required": true, "content": {"application/json": {"schema": {"$ref": "#/components/schemas/LabelsPatch"}}}},
+        "responses": {
+          "200": {"description": "Labels", "content": {"application/json": {"schema": {"$ref": "#/components/schemas/Labels"}}}},
+          "400": {"$ref": "#/components/responses/Error"}
+        }
+      },
+      "delete": {
+        "summary": "Remove the labels named by key, or all labels of a node",
+        "parameters": [{"name": "key", "in": "query", "description": "Label to remove; may be repeated", "schema": {"type": "string"}}],
+        "responses": {
+          "204": {"description": "Labels removed"}
+        }
+      }
+    },
+    "/nodes/{id}/placement": {
+      "parameters": [{"name": "id", "in": "path", "required": true, "schema": {"type": "string"}}],
+      "get": {
+        "summary": "Get where a node is placed in a rack",
+        "responses": {
+          "200": {"description": "Placement", "content": {"application/json": {"schema": {"$ref": "#/components/schemas/Placement"}}}},
+          "404": {"$ref": "#/components/responses/Error"}
+        }
+      },
+      "put": {
+        "summary": "Place a node in a rack, or move it",
+        "requestBody": {"required": true, "content": {"application/json": {"schema": {"$ref": "#/components/schemas/PlacementRequest"}}}},
+        "responses": {
+          "200": {"description": "Placement", "content": {"application/json": {"schema": {"$ref": "#/components/schemas/Placement"}}}},
+          "400": {"$ref": "#/components/responses/Error"},
+          "404": {"$ref": "#/components/responses/Error"},
+          "409": {"$ref": "#/components/responses/Error"}
+        }
+      },
+      "delete": {
+        "summary": "Take a node out of its rack",
+        "responses": {
+          "204": {"description": "Node taken out of its rack"},
+          "404": {"$ref": "#/components/responses/Error"}
+        }
+      }
+    },
+    "/nodes/{id}/addresses": {
+      "parameters": [{"name": "id", "in": "path", "required": true, "schema": {"type": "string"}}],
+      "post": {
+        "summary": "Lease an address in each subnet to a node, e.g. one IPv4 and one IPv6 for dual-stack",
+        "parameters": [{"$ref": "#/components/parameters/prefer"}],
+        "requestBody": {"required": true, "content": {"application/json": {"schema": {"$ref": "#/components/schemas/AllocateAddressesRequest"}}}},
+        "responses": {
+          "201": {"description": "Addresses allocated", "content": {"application/json": {"schema": {"type": "array", "items": {"$ref": "#/components/schemas/Lease"}}}}},
+          "202": {"$ref": "#/components/responses/Accepted"},
+          "400": {"$ref": "#/components/responses/Error"},
+          "404": {"$ref": "#/components/responses/Error"},
+          "504": {"$ref": "#/components/responses/Error"}
+        }
+      }
+    },
+    "/nodes/{id}/connections": {
+      "parameters": [{"name": "id", "in": "path", "required": true, "schema": {"type": "string"}}],
+      "get": {
+        "summary": "List the switch ports a node's NICs are connected to",
+        "responses": {
+          "200": {"description": "Connections", "content": {"application/json": {"schema": {"type": "array", "items": {"$ref": "#/components/schemas/Connection"}}}}}
+        }
+      }
+    },
+    "/nodes/{id}/connections/{nic}": {
+      "parameters": [
+        {"name": "id", "in": "path", "required": true, "schema": {"type": "string"}},
+        {"name": "nic", "in": "path", "required": true, "description": "Name or MAC address of the NIC", "schema": {"type": "string"}}
+      ],
+      "put": {
+        "summary": "Record the switch port a NIC is connected to",
+        "requestBody": {"required": true, "content": {"application/json": {"schema": {"$ref": "#/components/schemas/ConnectionRequest"}}}},
+        "responses": {
+          "200": {"description": "Connection", "content": {"application/json": {"schema": {"$ref": "#/components/schemas/Connection"}}}},
+          "400": {"$ref": "#/components/responses/Error"},
+          "404": {"$ref": "#/components/responses/Error"},
+          "409": {"$ref": "#/components/responses/Error"},
+          "502": {"$ref": "#/components/responses/Error"},
+          "504": {"$ref": "#/components/responses/Error"}
+        }
+      },
+      "delete": {
+        "summary": "Forget the switch port a NIC is connected to",
+        "responses": {
+          "204": {"description": "Connection removed"},
+          "404": {"$ref": "#/components/responses/Error"}
+        }
+      }
+    },
+    "/node-actions": {
+      "post": {
+        "summary": "Run an action on the selected nodes as a job, whose result lists the outcome on each node",
+        "requestBody": {"required": true, "content": {"application/json": {"schema": {"$ref": "#/components/schemas/NodeActionsRequest"}}}},
+        "responses": {
+          "202": {"$ref": "#/components/responses/Accepted"},
+          "400": {"$ref": "#/components/responses/Error"},
+          "403": {"$ref": "#/components/responses/Error"},
+          "404": {"$ref": "#/components/responses/Error"}
+        }
+      }
+    },
+    "/nodes/{id}/workflows": {
+      "parameters": [{"$ref": "#/components/parameters/id"}],
+      "get": {
+        "summary": "List the workflows run on a node, newest first, as last reported by RackHD",
+        "parameters": [{"name": "status", "in": "query", "schema": {"$ref": "#/components/schemas/WorkflowStatus"}}],
+        "responses": {
+          "200": {"description": "Workflows", "content": {"application/json": {"schema": {"type": "array", "items": {"$ref": "#/components/schemas/Workflow"}}}}}
+        }
+      },
+      "post": {
+        "summary": "Run a RackHD workflow on a node",
+        "requestBody": {"required": true, "content": {"application/json": {"schema": {"$ref": "#/components/schemas/RunWorkflowRequest"}}}},
+        "responses": {
+          "201": {"description": "Workflow started", "content": {"application/json": {"schema": {"$ref": "#/components/schemas/Workflow"}}}},
+          "400": {"$ref": "#/components/responses/Error"},
+          "409": {"$ref": "#/components/responses/Error"},
+          "422": {"$ref": "#/components/responses/Error"},
+          "504": {"$ref": "#/components/responses/Error"}
+        }
+      }
+    },
+    "/nodes/{id}/workflows/active": {
+      "parameters": [{"$ref": "#/components/parameters/id"}],
+      "delete": {
+        "summary": "Cancel the active workflow of a node",
+        "responses": {
+          "200": {"description": "Workflow cancelled", "content": {"application/json": {"schema": {"$ref": "#/components/schemas/Workflow"}}}},
+          "404": {"$ref": "#/components/responses/Error"},
+          "422": {"$ref": "#/components/responses/Error"},
+          "504": {"$ref": "#/components/responses/Error"}
+        }
+      }
+    },
+    "/nodes/{id}/power": {
+      "parameters": [{"$ref": "#/components/parameters/id"}],
+      "get": {
+        "summary": "Read the power state of a node from its BMC",
+        "responses": {
+          "200": {"description": "Power state", "content": {"application/json": {"schema": {"$ref": "#/components/schemas/PowerResult"}}}},
+          "422": {"$ref": "#/components/responses/Error"},
+          "429": {"$ref": "#/components/responses/Error"},
+          "502": {"$ref": "#/components/responses/Error"},
+          "504": {"$ref": "#/components/responses/Error"}
+        }
+      },
+      "post": {
+        "summary": "Run a power action on a node through its BMC, or read its power state",
+        "requestBody": {"required": true, "content": {"application/json": {"schema": {"$ref": "#/components/schemas/PowerRequest"}}}},
+        "responses": {
+          "200": {"description": "Action done", "content": {"application/json": {"schema": {"$ref": "#/components/schemas/PowerResult"}}}},
+          "400": {"$ref": "#/components/responses/Error"},
+          "422": {"$ref": "#/components/responses/Error"},
+          "429": {"$ref": "#/components/responses/Error"},
+          "502": {"$ref": "#/components/responses/Error"},
+          "504": {"$ref": "#/components/responses/Error"}
+        }
+      }
+    },
+    "/nodes/{id}/boot-device": {
+      "parameters": [{"$ref": "#/components/parameters/id"}],
+      "post": {
+        "summary": "Set the device a node boots from",
+        "requestBody": {"required": true, "content": {"application/json": {"schema": {"$ref": "#/components/schemas/BootDeviceRequest"}}}},
+        "responses": {
+          "200": {"description": "Boot device set", "content": {"application/json": {"schema": {"$ref": "#/components/schemas/BootDeviceResult"}}}},
+          "400": {"$ref": "#/components/responses/Error"},
+          "422": {"$ref": "#/components/responses/Error"},
+          "429": {"$ref": "#/components/responses/Error"},
+          "502": {"$ref": "#/components/responses/Error"},
+          "504": {"$ref": "#/components/responses/Error"}
+        }
+      }
+    },
+    "/sites": {
+      "get": {
+        "summary": "List sites",
+        "responses": {
+          "200": {"description": "Sites", "content": {"application/json": {"schema": {"type": "array", "items": {"$ref": "#/components/schemas/Site"}}}}}
+        }
+      },
+      "post": {
+        "summary": "Create a site",
+        "requestBody": {"required": true, "content": {"application/json": {"schema": {"$ref": "#/components/schemas/SiteRequest"}}}},
+        "responses": {
+          "201": {"description": "Site created", "content": {"application/json": {"schema": {"$ref": "#/components/schemas/Site"}}}},
+          "400": {"$ref": "#/components/responses/Error"},
+          "409": {"$ref": "#/components/responses/Error"}
+        }
+      }
+    },
+    "/sites/{id}": {
+      "parameters": [{"$ref": "#/components/parameters/id"}],
+      "get": {
+        "summary": "Get a site",
+        "responses": {
+          "200": {"description": "Site", "content": {"application/json": {"schema": {"$ref": "#/components/schemas/Site"}}}},
+          "404": {"$ref": "#/components/responses/Error"}
+        }
+      },
+      "put": {
+        "summary": "Update a site",
+        "requestBody": {"required": true, "content": {"application/json": {"schema": {"$ref": "#/components/schemas/SiteRequest"}}}},
+        "responses": {
+          "200": {"description": "Site updated", "content": {"application/json": {"schema": {"$ref": "#/components/schemas/Site"}}}},
+          "400": {"$ref": "#/components/responses/Error"},
+          "404": {"$ref": "#/components/responses/Error"},
+          "409": {"$ref": "#/components/responses/Error"}
+        }
+      },
+      "delete": {
+        "summary": "Delete a site without rooms",
+        "responses": {
+          "204": {"description": "Site deleted"},
+          "404": {"$ref": "#/components/responses/Error"},
+          "409": {"$ref": "#/components/responses/Error"}
+        }
+      }
+    },
+    "/rooms": {
+      "get": {
+        "summary": "List rooms",
+        "parameters": [{"name": "site", "in": "query", "description": "Only rooms in this site", "schema": {"type": "string"}}],
+        "responses": {
+          "200": {"description": "Rooms", "content": {"application/json": {"schema": {"type": "array", "items": {"$ref": "#/components/schemas/Room"}}}}}
+        }
+      },
+      "post": {
+        "summary": "Create a room",
+        "requestBody": {"required": true, "content": {"application/json": {"schema": {"$ref": "#/components/schemas/RoomRequest"}}}},
+        "responses": {
+          "201": {"description": "Room created", "content": {"application/json": {"schema": {"$ref": "#/components/schemas/Room"}}}},
+          "400": {"$ref": "#/components/responses/Error"},
+          "404": {"$ref": "#/components/responses/Error"},
+          "409": {"$ref": "#/components/responses/Error"}
+        }
+      }
+    },
+    "/rooms/{id}": {
+      "parameters": [{"$ref": "#/components/parameters/id"}],
+      "get": {
+        "summary": "Get a room",
+        "responses": {
+          "200": {"description": "Room", "content": {"application/json": {"schema": {"$ref": "#/components/schemas/Room"}}}},
+          "404": {"$ref": "#/components/responses/Error"}
+        }
+      },
+      "put": {
+        "summary": "Update a room",
+        "requestBody": {"required": true, "content": {"application/json": {"schema": {"$ref": "#/components/schemas/RoomRequest"}}}},
+        "responses": {
+          "200": {"description": "Room updated", "content": {"application/json": {"schema": {"$ref": "#/components/schemas/Room"}}}},
+          "400": {"$ref": "#/components/responses/Error"},
+          "404": {"$ref": "#/components/responses/Error"},
+          "409": {"$ref": "#/components/responses/Error"}
+        }
+      },
+      "delete": {
+        "summary": "Delete a room without racks",
+        "responses": {
+          "204": {"description": "Room deleted"},
+          "404": {"$ref": "#/components/responses/Error"},
+          "409": {"$ref": "#/components/responses/Error"}
+        }
+      }
+    },
+    "/racks": {
+      "get": {
+        "summary": "List racks",
+        "parameters": [{"name": "room", "in": "query", "description": "Only racks in this room", "schema": {"type": "string"}}],
+        "responses": {
+          "200": {"description": "Racks", "content": {"application/json": {"schema": {"type": "array", "items": {"$ref": "#/components/schemas/Rack"}}}}}
+        }
+      },
+      "post": {
+        "summary": "Create a rack",
+        "requestBody": {"required": true, "content": {"application/json": {"schema": {"$ref": "#/components/schemas/RackRequest"}}}},
+        "responses": {
+          "201": {"description": "Rack created", "content": {"application/json": {"schema": {"$ref": "#/components/schemas/Rack"}}}},
+          "400": {"$ref": "#/components/responses/Error"},
+          "404": {"$ref": "#/components/responses/Error"},
+          "409": {"$ref": "#/components/responses/Error"}
+        }
+      }
+    },
+    "/racks/{id}": {
+      "parameters": [{"$ref": "#/components/parameters/id"}],
+      "get": {
+        "summary": "Get a rack",
+        "responses": {
+          "200": {"description": "Rack", "content": {"application/json": {"schema": {"$ref": "#/components/schemas/Rack"}}}},
+          "404": {"$ref": "#/components/responses/Error"}
+        }
+      },
+      "put": {
+        "summary": "Update a rack",
+        "requestBody": {"required": true, "content": {"application/json": {"schema": {"$ref": "#/components/schemas/RackRequest"}}}},
+        "responses": {
+          "200": {"description": "Rack updated", "content": {"application/json": {"schema": {"$ref": "#/components/schemas/Rack"}}}},
+          "400": {"$ref": "#/components/responses/Error"},
+          "404": {"$ref": "#/components/responses/Error"},
+          "409": {"$ref": "#/components/responses/Error"}
+        }
+      },
+      "delete": {
+        "summary": "Delete a rack without nodes",
+        "responses": {
+          "204": {"description": "Rack deleted"},
+          "404": {"$ref": "#/components/responses/Error"},
+          "409": {"$ref": "#/components/responses/Error"}
+        }
+      }
+    },
+    "/racks/{id}/placements": {
+      "parameters": [{"$ref": "#/components/parameters/id"}],
+      "get": {
+        "summary": "List the nodes placed in a rack, from the bottom up",
+        "responses": {
+          "200": {"description": "Placements", "content": {"application/json": {"schema": {"type": "array", "items": {"$ref": "#/components/schemas/Placement"}}}}},
+          "404": {"$ref": "#/components/responses/Error"}
+        }
+      }
+    },
+    "/switches": {
+      "get": {
+        "summary": "List switches known to the inventory service",
+        "responses": {
+          "200": {"description": "Switches", "content": {"application/json": {"schema": {"type": "array", "items": {"$ref": "#/components/schemas/Switch"}}}}},
+          "502": {"$ref": "#/components/responses/Error"},
+          "504": {"$ref": "#/components/responses/Error"}
+        }
+      }
+    },
+    "/switches/{id}": {
+      "parameters": [{"$ref": "#/components/parameters/id"}],
+      "get": {
+        "summary": "Get a switch known to the inventory service",
+        "responses": {
+          "200": {"description": "Switch", "content": {"application/json": {"schema": {"$ref": "#/components/schemas/Switch"}}}},
+          "404": {"$ref": "#/components/responses/Error"},
+          "502": {"$ref": "#/components/responses/Error"},
+          "504": {"$ref": "#/components/responses/Error"}
+        }
+      }
+    },
+    "/switches/{id}/ports": {
+      "parameters": [{"$ref": "#/components/parameters/id"}],
+      "get": {
+        "summary": "List the ports of a switch, as the inventory service reports them",
+        "responses": {
+          "200": {"description": "Ports", "content": {"application/json": {"schema": {"type": "array", "items": {"$ref": "#/components/schemas/SwitchPort"}}}}},
+          "404": {"$ref": "#/components/responses/Error"},
+          "502": {"$ref": "#/components/responses/Error"},
+          "504": {"$ref": "#/components/responses/Error"}
+        }
+      }
+    },
+    "/switches/{id}/ports/{port}": {
+      "parameters": [
+        {"$ref": "#/components/parameters/id"},
+        {"name": "port", "in": "path", "required": true, "description": "Port name followed by /node, e.g. Ethernet1/1/node", "schema": {"type": "string"}}
+      ],
+      "get": {
+        "summary": "GET /switches/{id}/ports/{port}/node: get the node NIC connected to a port",
+        "responses": {
+          "200": {"description": "Connection", "content": {"application/json": {"schema": {"$ref": "#/components/schemas/Connection"}}}},
+          "404": {"$ref": "#/components/responses/Error"}
+        }
+      }
+    },
+    "/pools": {
+      "get": {
+        "summary": "List IP pools",
+        "responses": {
+          "200": {"description": "Pools", "content": {"application/json": {"schema": {"type": "array", "items": {"$ref": "#/components/schemas/Pool"}}}}}
+        }
+      },
+      "post": {
+        "summary": "Create an IP pool",
+        "parameters": [{"$ref": "#/components/parameters/prefer"}],
+        "requestBody": {"required": true, "content": {"application/json": {"schema": {"$ref": "#/components/schemas/CreatePoolRequest"}}}},
+        "responses": {
+          "201": {"description": "Pool created", "content": {"application/json": {"schema": {"$ref": "#/components/schemas/Created"}}}},
+          "202": {"$ref": "#/components/responses/Accepted"},
+          "400": {"$ref": "#/components/responses/Error"},
+          "422": {"$ref": "#/components/responses/Error"},
+          "504": {"$ref": "#/components/responses/Error"}
+        }
+      }
+    },
+    "/pools/{id}": {
+      "parameters": [{"$ref": "#/components/parameters/id"}],
+      "get": {
+        "summary": "Get an IP pool",
+        "responses": {
+          "200": {"description": "Pool", "content": {"application/json": {"schema": {"$ref": "#/components/schemas/Pool"}}}},
+          "404": {"$ref": "#/components/responses/Error"}
+        }
+      },
+      "delete": {
+        "summary": "Delete an IP pool without subnets",
+        "parameters": [{"$ref": "#/components/parameters/prefer"}],
+        "responses": {
+          "204": {"description": "Pool deleted"},
+          "202": {"$ref": "#/components/responses/Accepted"},
+          "404": {"$ref": "#/components/responses/Error"},
+          "409": {"$ref": "#/components/responses/Error"}
+        }
+      }
+    },
+    "/pools/{id}/usage": {
+      "parameters": [{"$ref": "#/components/parameters/id"}],
+      "get": {
+        "summary": "Count the allocated, reserved and free addresses of a pool's subnets",
+        "responses": {
+          "200": {"description": "Pool usage", "content": {"application/json": {"schema": {"$ref": "#/components/schemas/PoolUsage"}}}},
+          "404": {"$ref": "#/components/responses/Error"}
+        }
+      }
+    },
+    "/subnets": {
+      "get": {
+        "summary": "List subnets",
+        "parameters": [
+          {"name": "pool", "in": "query", "description": "Only subnets in this pool", "schema": {"type": "string"}},
+          {"name": "family", "in": "query", "description": "Only subnets of this address family", "schema": {"$ref": "#/components/schemas/Family"}}
+        ],
+        "responses": {
+          "200": {"description": "Subnets", "content": {"application/json": {"schema": {"type": "array", "items": {"$ref": "#/components/schemas/Subnet"}}}}}
+        }
+      },
+      "post": {
+        "summary": "Create a subnet in a pool",
+        "parameters": [{"$ref": "#/components/parameters/prefer"}],
+        "requestBody": {"required": true, "content": {"application/json": {"schema": {"$ref": "#/components/schemas/CreateSubnetRequest"}}}},
+        "responses": {
+          "201": {"description": "Subnet created", "content": {"application/json": {"schema": {"$ref": "#/components/schemas/Created"}}}},
+          "202": {"$ref": "#/components/responses/Accepted"},
+          "400": {"$ref": "#/components/responses/Error"},
+          "404": {"$ref": "#/components/responses/Error"},
+          "409": {"$ref": "#/components/responses/Error"},
+          "422": {"$ref": "#/components/responses/Error"},
+          "504": {"$ref": "#/components/responses/Error"}
+        }
+      }
+    },
+    "/subnets/{id}": {
+      "parameters": [{"$ref": "#/components/parameters/id"}],
+      "get": {
+        "summary": "Get a subnet",
+        "responses": {
+          "200": {"description": "Subnet", "content": {"application/json": {"schema": {"$ref": "#/components/schemas/Subnet"}}}},
+          "404": {"$ref": "#/components/responses/Error"}
+        }
+      },
+      "delete": {
+        "summary": "Delete a subnet without leases or reservations",
+        "parameters": [{"$ref": "#/components/parameters/prefer"}],
+        "responses": {
+          "204": {"description": "Subnet deleted"},
+          "202": {"$ref": "#/components/responses/Accepted"},
+          "404": {"$ref": "#/components/responses/Error"},
+          "409": {"$ref": "#/components/responses/Error"}
+        }
+      }
+    },
+    "/subnets/{id}/usage": {
+      "parameters": [{"$ref": "#/components/parameters/id"}],
+      "get": {
+        "summary": "Count the allocated, reserved and free addresses of a subnet",
+        "responses": {
+          "200": {"description": "Subnet usage", "content": {"application/json": {"schema": {"$ref": "#/components/schemas/SubnetUsage"}}}},
+          "404": {"$ref": "#/components/responses/Error"},
+          "409": {"$ref": "#/components/responses/Error"}
+        }
+      }
+    },
+    "/subnets/{id}/reservations": {
+      "parameters": [{"$ref": "#/components/parameters/id"}],
+      "get": {
+        "summary": "List the reserved addresses of a subnet",
+        "responses": {
+          "200": {"description": "Reservations", "content": {"application/json": {"schema": {"type": "array", "items": {"$ref": "#/components/schemas/Reservation"}}}}},
+          "404": {"$ref": "#/components/responses/Error"}
+        }
+      },
+      "post": {
+        "summary": "Reserve an address of a subnet for the node with a MAC address, or for a node",
+        "parameters": [{"$ref": "#/components/parameters/prefer"}],
+        "requestBody": {"required": true, "content": {"application/json": {"schema": {"$ref": "#/components/schemas/ReservationRequest"}}}},
+        "responses": {
+          "201": {"description": "Address reserved", "content": {"application/json": {"schema": {"$ref": "#/components/schemas/Reservation"}}}},
+          "202": {"$ref": "#/components/responses/Accepted"},
+          "400": {"$ref": "#/components/responses/Error"},
+          "404": {"$ref": "#/components/responses/Error"},
+          "409": {"$ref": "#/components/responses/Error"},
+          "422": {"$ref": "#/components/responses/Error"},
+          "504": {"$ref": "#/components/responses/Error"}
+        }
+      }
+    },
+    "/subnets/{id}/reservations/{address}": {
+      "parameters": [
+        {"$ref": "#/components/parameters/id"},
+        {"name": "address", "in": "path", "required": true, "description": "Reserved address", "schema": {"type": "string"}}
+      ],
+      "delete": {
+        "summary": "Release a reserved address, and its node's lease of it",
+        "parameters": [{"$ref": "#/components/parameters/prefer"}],
+        "responses": {
+          "204": {"description": "Reservation deleted"},
+          "202": {"$ref": "#/components/responses/Accepted"},
+          "400": {"$ref": "#/components/responses/Error"},
+          "404": {"$ref": "#/components/responses/Error"},
+          "504": {"$ref": "#/components/responses/Error"}
+        }
+      }
+    },
+    "/subnets/{id}/dhcp": {
+      "parameters": [{"$ref": "#/components/parameters/id"}],
+      "get": {
+        "summary": "Get the DHCP options of a subnet",
+        "responses": {
+          "200": {"description": "DHCP options", "content": {"application/json": {"schema": {"$ref": "#/components/schemas/DHCPOptions"}}}},
+          "404": {"$ref": "#/components/responses/Error"}
+        }
+      },
+      "put": {
+        "summary": "Replace the DHCP options of a subnet",
+        "requestBody": {"required": true, "content": {"application/json": {"schema": {"$ref": "#/components/schemas/DHCPOptions"}}}},
+        "responses": {
+          "200": {"description": "DHCP options", "content": {"application/json": {"schema": {"$ref": "#/components/schemas/DHCPOptions"}}}},
+          "400": {"$ref": "#/components/responses/Error"},
+          "404": {"$ref": "#/components/responses/Error"},
+          "409": {"$ref": "#/components/responses/Error"},
+          "422": {"$ref": "#/components/responses/Error"}
+        }
+      }
+    },
+    "/dhcp/config": {
+      "get": {
+        "summary": "Render a DHCP server config from the subnets, options, reservations and leases",
+        "parameters": [
+          {"name": "format", "in": "query", "description": "dnsmasq by default", "schema": {"type": "string", "enum": ["dnsmasq", "isc"]}},
+          {"name": "pool", "in": "query", "description": "Only subnets in this pool", "schema": {"type": "string"}},
+          {"name": "family", "in": "query", "description": "Only subnets of this address family. ISC dhcpd serves one family per config, so format=isc renders ipv4 by default.", "schema": {"$ref": "#/components/schemas/Family"}},
+          {"name": "If-None-Match", "in": "header", "description": "ETag of the config the caller has", "schema": {"type": "string"}}
+        ],
+        "responses": {
+          "200": {"description": "Config, with its ETag", "content": {"text/plain": {"schema": {"type": "string"}}}},
+          "304": {"description": "The config has not changed"},
+          "400": {"$ref": "#/components/responses/Error"},
+          "502": {"$ref": "#/components/responses/Error"},
+          "504": {"$ref": "#/components/responses/Error"}
+        }
+      }
+    },
+    "/addresses": {
+      "get": {
+        "summary": "List address leases",
+        "parameters": [
+          {"name": "subnet", "in": "query", "description": "Only leases in this subnet", "schema": {"type": "string"}},
+          {"name": "node", "in": "query", "description": "Only leases held by this node", "schema": {"type": "string"}},
+          {"name": "family", "in": "query", "description": "Only leases of this address family", "schema": {"$ref": "#/components/schemas/Family"}}
+        ],
+        "responses": {
+          "200": {"description": "Leases", "content": {"application/json": {"schema": {"type": "array", "items": {"$ref": "#/components/schemas/Lease"}}}}}
+        }
+      },
+      "post": {
+        "summary": "Allocate an address to a node",
+        "parameters": [{"$ref": "#/components/parameters/prefer"}],
+        "requestBody": {"required": true, "content": {"application/json": {"schema": {"$ref": "#/components/schemas/AllocateAddressRequest"}}}},
+        "responses": {
+          "201": {"description": "Address allocated", "content": {"application/json": {"schema": {"$ref": "#/components/schemas/Lease"}}}},
+          "202": {"$ref": "#/components/responses/Accepted"},
+          "400": {"$ref": "#/components/responses/Error"},
+          "404": {"$ref": "#/components/responses/Error"},
+          "504": {"$ref": "#/components/responses/Error"}
+        }
+      }
+    },
+    "/addresses/sweep": {
+      "get": {
+        "summary": "Report the leases the lease sweeper would release now, without releasing them",
+        "responses": {
+          "200": {"description": "Dry run report", "content": {"application/json": {"schema": {"$ref": "#/components/schemas/SweepReport"}}}}
+        }
+      },
+      "post": {
+        "summary": "Release expired leases and leases of nodes missing from inventory for longer than the grace period",
+        "responses": {
+          "200": {"description": "Sweep report", "content": {"application/json": {"schema": {"$ref": "#/components/schemas/SweepReport"}}}}
+        }
+      }
+    },
+    "/addresses/{id}": {
+      "parameters": [{"$ref": "#/components/parameters/id"}],
+      "delete": {
+        "summary": "Release an address",
+        "parameters": [{"$ref": "#/components/parameters/prefer"}],
+        "responses": {
+          "204": {"description": "Address released"},
+          "202": {"$ref": "#/components/responses/Accepted"},
+          "404": {"$ref": "#/components/responses/Error"}
+        }
+      }
+    },
+    "/ipam/export": {
+      "get": {
+        "summary": "Export every pool, subnet, reservation and lease as a versioned document",
+        "responses": {
+          "200": {"description": "Export", "content": {"application/json": {"schema": {"$ref": "#/components/schemas/IPAMExport"}}}}
+        }
+      }
+    },
+    "/ipam/import": {
+      "post": {
+        "summary": "Create the pools, subnets, reservations and leases of an export that do not exist yet",
+        "parameters": [
+          {"$ref": "#/components/parameters/prefer"},
+          {"name": "dry_run", "in": "query", "description": "Only report what the import would do", "schema": {"type": "boolean"}}
+        ],
+        "requestBody": {"required": true, "content": {"application/json": {"schema": {"$ref": "#/components/schemas/IPAMExport"}}}},
+        "responses": {
+          "200": {"description": "Import report", "content": {"application/json": {"schema": {"$ref": "#/components/schemas/ImportReport"}}}},
+          "202": {"$ref": "#/components/responses/Accepted"},
+          "400": {"$ref": "#/components/responses/Error"},
+          "504": {"$ref": "#/components/responses/Error"}
+        }
+      }
+    },
+    "/roles": {
+      "get": {
+        "summary": "List roles and the permissions they grant",
+        "responses": {
+          "200": {"description": "Roles", "content": {"application/json": {"schema": {"type": "object", "additionalProperties": {"type": "array", "items": {"type": "string"}}}}}}
+        }
+      }
+    },
+    "/rolebindings": {
+      "get": {
+        "summary": "List role bindings",
+        "responses": {
+          "200": {"description": "Role bindings", "content": {"application/json": {"schema": {"type": "array", "items": {"$ref": "#/components/schemas/RoleBinding"}}}}}
+        }
+      }
+    },
+    "/rolebindings/{subject}": {
+      "parameters": [{"name": "subject", "in": "path", "required": true, "description": "api-key:NAME or jwt:SUBJECT", "schema": {"type": "string"}}],
+      "put": {
+        "summary": "Bind a role to a subject",
+        "requestBody": {"required": true, "content": {"application/json": {"schema": {"$ref": "#/components/schemas/RoleBindingRequest"}}}},
+        "responses": {
+          "200": {"description": "Role bound", "content": {"application/json": {"schema": {"$ref": "#/components/schemas/RoleBinding"}}}},
+          "400": {"$ref": "#/components/responses/Error"}
+        }
+      },
+      "delete": {
+        "summary": "Remove a subject's role binding",
+        "responses": {
+          "204": {"description": "Role binding removed"},
+          "404": {"$ref": "#/components/responses/Error"}
+        }
+      }
+    },
+    "/audit": {
+      "get": {
+        "summary": "Query the audit log, newest first",
+        "parameters": [
+          {"name": "kind", "in": "query", "schema": {"type": "string", "enum": ["api", "command"]}},
+          {"name": "actor", "in": "query", "schema": {"type": "string"}},
+          {"name": "action", "in": "query", "schema": {"type": "string"}},
+          {"name": "target", "in": "query", "schema": {"type": "string"}},
+          {"name": "result", "in": "query", "schema": {"type": "string"}},
+          {"name": "correlation_id", "in": "query", "schema": {"type": "string"}},
+          {"name": "request_id", "in": "query", "schema": {"type": "string"}},
+          {"name": "since", "in": "query", "schema": {"type": "string", "format": "date-time"}},
+          {"name": "until", "in": "query", "schema": {"type": "string", "format": "date-time"}},
+          {"name": "limit", "in": "query", "schema": {"type": "integer", "minimum": 1, "maximum": 1000, "default": 100}},
+          {"name": "offset", "in": "query", "schema": {"type": "integer", "minimum": 0, "default": 0}}
+        ],
+        "responses": {
+          "200": {"description": "Audit entries", "content": {"application/json": {"schema": {"type": "array", "items": {"$ref": "#/components/schemas/AuditEntry"}}}}},
+          "400": {"$ref": "#/components/responses/Error"}
+        }
+      }
+    },
+    "/jobs": {
+      "get": {
+        "summary": "List jobs, newest first",
+        "parameters": [
+          {"name": "kind", "in": "query", "schema": {"type": "string"}},
+          {"name": "status", "in": "query", "schema": {"$ref": "#/components/schemas/JobStatus"}},
+          {"name": "actor", "in": "query", "schema": {"type": "string"}},
+          {"name": "request_id", "in": "query", "schema": {"type": "string"}},
+          {"name": "since", "in": "query", "schema": {"type": "string", "format": "date-time"}},
+          {"name": "until", "in": "query", "schema": {"type": "string", "format": "date-time"}},
+          {"name": "limit", "in": "query", "schema": {"type": "integer", "minimum": 1, "maximum": 1000, "default": 100}},
+          {"name": "offset", "in": "query", "schema": {"type": "integer", "minimum": 0, "default": 0}}
+        ],
+        "responses": {
+          "200": {"description": "Jobs", "content": {"application/json": {"schema": {"type": "array", "items": {"$ref": "#/components/schemas/Job"}}}}},
+          "400": {"$ref": "#/components/responses/Error"}
+        }
+      }
+    },
+    "/jobs/{id}": {
+      "parameters": [{"$ref": "#/components/parameters/id"}],
+      "get": {
+        "summary": "Get a job",
+        "responses": {
+          "200": {"description": "Job", "content": {"application/json": {"schema": {"$ref": "#/components/schemas/Job"}}}},
+          "404": {"$ref": "#/components/responses/Error"}
+        }
+      },
+      "delete": {
+        "summary": "Cancel a queued or running job",
+        "responses": {
+          "200": {"description": "Job canceled", "content": {"application/json": {"schema": {"$ref": "#/components/schemas/Job"}}}},
+          "404": {"$ref": "#/components/responses/Error"},
+          "409": {"$ref": "#/components/responses/Error"}
+        }
+      }
+    }
+  },
+  "components": {
+    "securitySchemes": {
+      "apiKey": {"type": "apiKey", "in": "header", "name": "X-API-Key"},
+      "bearer": {"type": "http", "scheme": "bearer", "bearerFormat": "JWT"}
+    },
+    "parameters": {
+      "id": {"name": "id", "in": "path", "required": true, "schema": {"type": "string"}},
+      "prefer": {"name": "Prefer", "in": "header", "description": "respond-async to run the operation as a job and get 202 Accepted", "schema": {"type": "string"}}
+    },
+    "responses": {
+      "Error": {"description": "The request failed", "content": {"application/json": {"schema": {"$ref": "#/components/schemas/Error"}}}},
+      "Accepted": {
+        "description": "The operation was started as a job, at the URL in the Location header",
+        "headers": {"Location": {"schema": {"type": "string"}}},
+        "content": {"application/json": {"schema": {"$ref": "#/components/schemas/Job"}}}
+      }
+    },
+    "schemas": {
+      "Error": {
+        "type": "object",
+        "required": ["error"],
+        "properties": {
+          "error": {
+            "type": "object",
+            "required": ["code", "message"],
+            "properties": {
+              "code": {
+                "type": "string",
+                "enum": ["BAD_REQUEST", "VALIDATION_FAILED", "UNAUTHENTICATED", "FORBIDDEN", "NOT_FOUND", "METHOD_NOT_ALLOWED", "REQUEST_TOO_LARGE", "CONFLICT", "INVALID_RANGE", "RATE_LIMITED", "UPSTREAM_BUSY", "UPSTREAM_UNAVAILABLE", "UPSTREAM_TIMEOUT", "UPSTREAM_REJECTED", "CANCELED", "INTERNAL"]
+              },
+              "message": {"type": "string"},
+              "details": {"description": "Further information depending on the code, e.g. the problems found by VALIDATION_FAILED"},
+              "request_id": {"type": "string"}
+            }
+          }
+        }
+      },
+      "Health": {
+        "type": "object",
+        "properties": {"status": {"type": "string"}}
+      },
+      "Houston": {
+        "type": "object",
+        "properties": {"name": {"type": "string"}}
+      },
+      "Identity": {
+        "type": "object",
+        "properties": {
+          "subject": {"type": "string"},
+          "method": {"type": "string", "enum": ["none", "api-key", "jwt"]},
+          "role": {"type": "string"}
+        }
+      },
+      "Node": {
+        "type": "object",
+        "description": "A node as reported by the inventory service, with its location if it is placed in a rack",
+        "properties": {
+          "location": {"$ref": "#/components/schemas/Location"}
+        }
+      },
+      "Location": {
+        "type": "object",
+        "properties": {
+          "site": {"type": "string"},
+          "room": {"type": "string"},
+          "rack": {"type": "string"},
+          "position": {"type": "integer", "description": "Lowest rack unit the node takes up, from 1 at the bottom"},
+          "height": {"type": "integer", "description": "Rack units the node takes up"}
+        }
+      },
+      "Switch": {
+        "type": "object",
+        "description": "A switch as reported by the inventory service"
+      },
+      "SwitchPort": {
+        "type": "object",
+        "description": "A switch port as reported by the inventory service"
+      },
+      "Connection": {
+        "type": "object",
+        "properties": {
+          "node": {"type": "string"},
+          "nic": {"type": "string"},
+          "switch": {"type": "string"},
+          "port": {"type": "string"},
+          "created_at": {"type": "string", "format": "date-time"},
+          "updated_at": {"type": "string", "format": "date-time"}
+        }
+      },
+      "ConnectionRequest": {
+        "type": "object",
+        "required": ["switch", "port"],
+        "additionalProperties": false,
+        "properties": {
+          "switch": {"type": "string", "minLength": 1},
+          "port": {"type": "string", "minLength": 1, "maxLength": 64}
+        }
+      },
+      "Site": {
+        "type": "object",
+        "properties": {
+          "id": {"type": "string"},
+          "name": {"type": "string"},
+          "description": {"type": "string"},
+          "created_at": {"type": "string", "format": "date-time"},
+          "updated_at": {"type": "string", "format": "date-time"}
+        }
+      },
+      "SiteRequest": {
+        "type": "object",
+        "required": ["name"],
+        "additionalProperties": false,
+        "properties": {
+          "name": {"type": "string", "minLength": 1},
+          "description": {"type": "string"}
+        }
+      },
+      "Room": {
+        "type": "object",
+        "properties": {
+          "id": {"type": "string"},
+          "site": {"type": "string"},
+          "name": {"type": "string"},
+          "created_at": {"type": "string", "format": "date-time"},
+          "updated_at": {"type": "string", "format": "date-time"}
+        }
+      },
+      "RoomRequest": {
+        "type": "object",
+        "required": ["site", "name"],
+        "additionalProperties": false,
+        "properties": {
+          "site": {"type": "string", "minLength": 1},
+          "name": {"type": "string", "minLength": 1}
+        }
+      },
+      "Rack": {
+        "type": "object",
+        "properties": {
+          "id": {"type": "string"},
+          "room": {"type": "string"},
+          "name": {"type": "string"},
+          "height": {"type": "integer", "description": "Height in rack units"},
+          "created_at": {"type": "string", "format": "date-time"},
+          "updated_at": {"type": "string", "format": "date-time"}
+        }
+      },
+      "RackRequest": {
+        "type": "object",
+        "required": ["room", "name"],
+        "additionalProperties": false,
+        "properties": {
+          "room": {"type": "string", "minLength": 1},
+          "name": {"type": "string", "minLength": 1},
+          "height": {"type": "integer", "minimum": 1, "maximum": 60, "description": "Height in rack units, 42 by default"}
+        }
+      },
+      "Placement": {
+        "type": "object",
+        "properties": {
+          "node": {"type": "string"},
+          "rack": {"type": "string"},
+          "position": {"type": "integer"},
+          "height": {"type": "integer"},
+          "created_at": {"type": "string", "format": "date-time"},
+          "updated_at": {"type": "string", "format": "date-time"}
+        }
+      },
+      "PlacementRequest": {
+        "type": "object",
+        "required": ["rack", "position"],
+        "additionalProperties": false,
+        "properties": {
+          "rack": {"type": "string", "minLength": 1},
+          "position": {"type": "integer", "minimum": 1, "description": "Lowest rack unit the node takes up, from 1 at the bottom"},
+          "height": {"type": "integer", "minimum": 1, "description": "Rack units the node takes up, 1 by default"}
+        }
+      },
+      "Labels": {
+        "type": "object",
+        "description": "Labels by key. Keys are letters and digits with - _ . / inside, up to 63 characters.",
+        "additionalProperties": {"$ref": "#/components/schemas/LabelValue"}
+      },
+      "LabelsPatch": {
+        "type": "object",
+        "description": "Labels to set by key, or null to remove them",
+        "additionalProperties": {"type": "string", "nullable": true, "maxLength": 63, "pattern": "^([A-Za-z0-9]([-A-Za-z0-9_.]*[A-Za-z0-9])?)?$"}
+      },
+      "LabelValue": {"type": "string", "maxLength": 63, "pattern": "^([A-Za-z0-9]([-A-Za-z0-9_.]*[A-Za-z0-9])?)?$"},
+      "Pool": {
+        "type": "object",
+        "description": "An IP pool as stored by the IPAM service, with the prefixes Houston recorded for it",
+        "properties": {
+          "prefixes": {"type": "array", "items": {"type": "string"}}
+        }
+      },
+      "Family": {"type": "string", "enum": ["ipv4", "ipv6"]},
+      "Subnet": {
+        "type": "object",
+        "description": "A subnet as stored by the IPAM service, with the family and range Houston recorded for it",
+        "properties": {
+          "family": {"$ref": "#/components/schemas/Family"},
+          "prefix": {"type": "string"},
+          "start": {"type": "string"},
+          "end": {"type": "string"}
+        }
+      },
+      "Lease": {
+        "type": "object",
+        "properties": {
+          "id": {"type": "string"},
+          "subnet": {"type": "string"},
+          "node": {"type": "string"},
+          "address": {"type": "string"},
+          "family": {"$ref": "#/components/schemas/Family"},
+          "created_at": {"type": "string", "format": "date-time"},
+          "expires_at": {"type": "string", "format": "date-time"},
+          "missing_since": {"type": "string", "format": "date-time", "description": "When the node was first found missing from inventory"}
+        }
+      },
+      "Reservation": {
+        "type": "object",
+        "description": "An address of a subnet held for the node with a MAC address, or for a node",
+        "properties": {
+          "subnet": {"type": "string"},
+          "address": {"type": "string"},
+          "mac": {"type": "string"},
+          "node": {"type": "string"},
+          "lease": {"type": "string", "description": "Lease holding the address in the IPAM service"},
+          "created_at": {"type": "string", "format": "date-time"},
+          "assigned_to": {"type": "string", "description": "Node the address is leased to, once it is known"}
+        }
+      },
+      "SubnetUsage": {
+        "type": "object",
+        "description": "Address counts of a subnet. Counts above 2^64-1 are given as 2^64-1.",
+        "properties": {
+          "subnet": {"type": "string"},
+          "pool": {"type": "string"},
+          "total": {"type": "integer", "description": "Addresses in the prefix, or in the range if there is no prefix"},
+          "allocated": {"type": "integer"},
+          "reserved": {"type": "integer", "description": "Addresses of the prefix outside the range, such as the network and broadcast addresses, and reserved addresses not leased yet"},
+          "free": {"type": "integer"},
+          "largest_free_block": {"type": "integer", "description": "Longest run of free addresses within one subnet"},
+          "largest_free_start": {"type": "string"}
+        }
+      },
+      "PoolUsage": {
+        "type": "object",
+        "description": "Address counts of a pool, added up over its subnets",
+        "properties": {
+          "pool": {"type": "string"},
+          "total": {"type": "integer", "description": "Addresses in the prefix, or in the range if there is no prefix"},
+          "allocated": {"type": "integer"},
+          "reserved": {"type": "integer", "description": "Addresses of the prefix outside the range, such as the network and broadcast addresses, and reserved addresses not leased yet"},
+          "free": {"type": "integer"},
+          "largest_free_block": {"type": "integer", "description": "Longest run of free addresses within one subnet"},
+          "largest_free_start": {"type": "string"},
+          "subnets": {"type": "array", "items": {"$ref": "#/components/schemas/SubnetUsage"}},
+          "unranged": {"type": "array", "items": {"type": "string"}, "description": "Subnets Houston has no range for, which are not counted"}
+        }
+      },
+      "IPAMExport": {
+        "type": "object",
+        "required": ["version"],
+        "properties": {
+          "version": {"type": "integer", "minimum": 1, "description": "Version of the document format, currently 1"},
+          "exported": {"type": "string", "format": "date-time"},
+          "pools": {
+            "type": "array",
+            "nullable": true,
+            "items": {
+              "type": "object",
+              "required": ["id", "name"],
+              "properties": {
+                "id": {"type": "string"},
+                "name": {"type": "string", "minLength": 1},
+                "prefixes": {"type": "array", "nullable": true, "items": {"type": "string"}}
+              }
+            }
+          },
+          "subnets": {
+            "type": "array",
+            "nullable": true,
+            "items": {
+              "type": "object",
+              "required": ["id", "name", "pool"],
+              "properties": {
+                "id": {"type": "string"},
+                "name": {"type": "string", "minLength": 1},
+                "pool": {"type": "string"},
+                "family": {"$ref": "#/components/schemas/Family"},
+                "prefix": {"type": "string"},
+                "start": {"type": "string"},
+                "end": {"type": "string"},
+                "dhcp": {"$ref": "#/components/schemas/DHCPOptions"}
+              }
+            }
+          },
+          "reservations": {
+            "type": "array",
+            "nullable": true,
+            "items": {
+              "type": "object",
+              "required": ["subnet", "address"],
+              "properties": {
+                "subnet": {"type": "string"},
+                "address": {"type": "string"},
+                "mac": {"type": "string"},
+                "node": {"type": "string"}
+              }
+            }
+          },
+          "leases": {
+            "type": "array",
+            "nullable": true,
+            "items": {
+              "type": "object",
+              "required": ["id", "subnet", "node", "address"],
+              "properties": {
+                "id": {"type": "string"},
+                "subnet": {"type": "string"},
+                "node": {"type": "string"},
+                "address": {"type": "string"},
+                "expires_at": {"type": "string", "format": "date-time"}
+              }
+            }
+          }
+        }
+      },
+      "ImportReport": {
+        "type": "object",
+        "properties": {
+          "dry_run": {"type": "boolean"},
+          "items": {
+            "type": "array",
+            "items": {
+              "type": "object",
+              "properties": {
+                "kind": {"type": "string", "enum": ["pool", "subnet", "reservation", "lease"]},
+                "id": {"type": "string", "description": "ID in the export, or subnet/address for a reservation"},
+                "outcome": {"type": "string", "enum": ["created", "exists", "updated", "conflict", "skipped", "failed"]},
+                "new_id": {"type": "string", "description": "ID here"},
+                "message": {"type": "string"}
+              }
+            }
+          },
+          "counts": {"type": "object", "additionalProperties": {"type": "integer"}, "description": "Items by outcome"}
+        }
+      },
+      "SweepReport": {
+        "type": "object",
+        "properties": {
+          "dry_run": {"type": "boolean"},
+          "time": {"type": "string", "format": "date-time"},
+          "inventory_error": {"type": "string", "description": "Why nodes could not be listed, in which case only expired leases are reclaimed"},
+          "node_gone_skipped": {"type": "string", "description": "Why leases of nodes gone from inventory were kept, when there were too many of them"},
+          "leases": {
+            "type": "array",
+            "items": {
+              "type": "object",
+              "properties": {
+                "lease": {"$ref": "#/components/schemas/Lease"},
+                "reason": {"type": "string", "enum": ["expired", "node_gone"]},
+                "since": {"type": "string", "format": "date-time"},
+                "error": {"type": "string"}
+              }
+            }
+          }
+        }
+      },
+      "Created": {
+        "type": "object",
+        "properties": {"id": {"type": "string"}}
+      },
+      "WorkflowStatus": {"type": "string", "enum": ["pending", "running", "succeeded", "failed", "cancelled"]},
+      "Workflow": {
+        "type": "object",
+        "properties": {
+          "id": {"type": "string", "description": "RackHD workflow instance ID"},
+          "node": {"type": "string"},
+          "graph": {"type": "string"},
+          "options": {"type": "object"},
+          "status": {"$ref": "#/components/schemas/WorkflowStatus"},
+          "actor": {"type": "string"},
+          "request_id": {"type": "string"},
+          "created_at": {"type": "string", "format": "date-time"},
+          "updated_at": {"type": "string", "format": "date-time"},
+          "finished_at": {"type": "string", "format": "date-time"}
+        }
+      },
+      "RunWorkflowRequest": {
+        "type": "object",
+        "required": ["graph"],
+        "additionalProperties": false,
+        "properties": {
+          "graph": {"type": "string", "minLength": 1, "description": "Name of the RackHD workflow graph, e.g. Graph.InstallCentOS"},
+          "options": {"type": "object", "description": "Graph options passed to RackHD"}
+        }
+      },
+      "PowerRequest": {
+        "type": "object",
+        "required": ["action"],
+        "additionalProperties": false,
+        "properties": {
+          "action": {"type": "string", "enum": ["on", "off", "reset", "cycle", "status"]}
+        }
+      },
+      "PowerResult": {
+        "type": "object",
+        "properties": {
+          "node": {"type": "string"},
+          "action": {"type": "string"},
+          "power_state": {"type": "string", "description": "Power state reported by the BMC after the action", "enum": ["on", "off"]}
+        }
+      },
+      "BootDeviceRequest": {
+        "type": "object",
+        "required": ["device"],
+        "additionalProperties": false,
+        "properties": {
+          "device": {"type": "string", "enum": ["pxe", "disk", "bios"]},
+          "persistent": {"type": "boolean", "description": "Boot from the device every time instead of only next time", "default": false}
+        }
+      },
+      "BootDeviceResult": {
+        "type": "object",
+        "properties": {
+          "node": {"type": "string"},
+          "device": {"type": "string"},
+          "persistent": {"type": "boolean"}
+        }
+      },
+      "NodeActionsRequest": {
+        "type": "object",
+        "required": ["selector", "action"],
+        "additionalProperties": false,
+        "properties": {
+          "selector": {
+            "type": "object",
+            "description": "Nodes matching every criterion given",
+            "additionalProperties": false,
+            "properties": {
+              "ids": {"type": "array", "items": {"type": "string"}},
+              "labels": {"type": "object", "additionalProperties": {"type": "string"}},
+              "filter": {"type": "object", "description": "Values of top-level node fields", "additionalProperties": {"type": "string"}}
+            }
+          },
+          "action": {
+            "type": "object",
+            "required": ["type"],
+            "additionalProperties": false,
+            "properties": {
+              "type": {"type": "string", "enum": ["power", "workflow", "allocate_address", "tag"]},
+              "power": {"type": "string", "enum": ["on", "off", "reset", "cycle"]},
+              "workflow": {"$ref": "#/components/schemas/RunWorkflowRequest"},
+              "subnet": {"type": "string"},
+              "tags": {"type": "array", "items": {"type": "string", "minLength": 1}}
+            }
+          },
+          "parallelism": {"type": "integer", "minimum": 1, "maximum": 64, "default": 8},
+          "stop_on_first_error": {"type": "boolean", "default": false},
+          "max_failures": {"type": "integer", "minimum": 0, "description": "Stop starting nodes after this many failures, 0 for no limit"}
+        }
+      },
+      "NodeActionsResult": {
+        "type": "object",
+        "description": "The result of a node_actions job",
+        "properties": {
+          "total": {"type": "integer"},
+          "succeeded": {"type": "integer"},
+          "failed": {"type": "integer"},
+          "skipped": {"type": "integer"},
+          "nodes": {
+            "type": "array",
+            "items": {
+              "type": "object",
+              "properties": {
+                "node": {"type": "string"},
+                "status": {"type": "string", "enum": ["succeeded", "failed", "skipped"]},
+                "result": {},
+                "error": {"type": "string"}
+              }
+            }
+          }
+        }
+      },
+      "CreatePoolRequest": {
+        "type": "object",
+        "required": ["name"],
+        "additionalProperties": false,
+        "properties": {
+          "name": {"type": "string", "minLength": 1},
+          "metadata": {"type": "string"},
+          "prefixes": {"type": "array", "items": {"type": "string", "minLength": 1}, "description": "CIDR prefixes the pool's subnets must lie within"}
+        }
+      },
+      "CreateSubnetRequest": {
+        "type": "object",
+        "description": "A subnet given by prefix, by start and end, or by both to use part of the prefix",
+        "required": ["name", "pool"],
+        "additionalProperties": false,
+        "properties": {
+          "name": {"type": "string", "minLength": 1},
+          "pool": {"type": "string", "minLength": 1},
+          "prefix": {"type": "string", "minLength": 1, "description": "CIDR prefix, e.g. 10.1.0.0/24 or 2001:db8:0:1::/64"},
+          "start": {"type": "string", "minLength": 1, "description": "First address to lease, by default the first usable address of the prefix"},
+          "end": {"type": "string", "minLength": 1, "description": "Last address to lease, by default the last usable address of the prefix"}
+        }
+      },
+      "DHCPOptions": {
+        "type": "object",
+        "additionalProperties": false,
+        "properties": {
+          "gateway": {"type": "string", "description": "Router handed out on an IPv4 subnet"},
+          "dns": {"type": "array", "nullable": true, "items": {"type": "string"}},
+          "domain": {"type": "string"},
+          "next_server": {"type": "string", "description": "TFTP server of an IPv4 subnet"},
+          "boot_file": {"type": "string", "description": "Boot file name, or boot file URL on an IPv6 subnet"}
+        }
+      },
+      "ReservationRequest": {
+        "type": "object",
+        "required": ["address"],
+        "additionalProperties": false,
+        "properties": {
+          "address": {"type": "string", "minLength": 1},
+          "mac": {"type": "string", "description": "MAC address of the node, which need not be discovered yet"},
+          "node": {"type": "string", "description": "Node ID, instead of a MAC address"}
+        }
+      },
+      "AllocateAddressRequest": {
+        "type": "object",
+        "required": ["subnet", "node"],
+        "additionalProperties": false,
+        "properties": {
+          "subnet": {"type": "string", "minLength": 1},
+          "node": {"type": "string", "minLength": 1},
+          "ttl": {"type": "string", "description": "How long the lease lasts, e.g. 72h. Leases without one do not expire."}
+        }
+      },
+      "AllocateAddressesRequest": {
+        "type": "object",
+        "required": ["subnets"],
+        "additionalProperties": false,
+        "properties": {
+          "subnets": {"type": "array", "minItems": 1, "maxItems": 2, "items": {"type": "string", "minLength": 1}, "description": "At most one subnet per address family"},
+          "ttl": {"type": "string", "description": "How long the leases last, e.g. 72h. Leases without one do not expire."}
+        }
+      },
+      "RoleBinding": {
+        "type": "object",
+        "properties": {
+          "subject": {"type": "string"},
+          "role": {"type": "string"},
+          "created_at": {"type": "string", "format": "date-time"},
+          "updated_at": {"type": "string", "format": "date-time"}
+        }
+      },
+      "RoleBindingRequest": {
+        "type": "object",
+        "required": ["role"],
+        "additionalProperties": false,
+        "properties": {"role": {"type": "string", "minLength": 1}}
+      },
+      "AuditEntry": {
+        "type": "object",
+        "properties": {
+          "id": {"type": "integer"},
+          "time": {"type": "string", "format": "date-time"},
+          "kind": {"type": "string", "enum": ["api", "command"]},
+          "actor": {"type": "string"},
+          "action": {"type": "string"},
+          "target": {"type": "string"},
+          "payload_hash": {"type": "string"},
+          "result": {"type": "string"},
+          "correlation_id": {"type": "string"},
+          "request_id": {"type": "string"}
+        }
+      },
+      "JobStatus": {"type": "string", "enum": ["queued", "running", "succeeded", "failed", "canceled"]},
+      "Job": {
+        "type": "object",
+        "properties": {
+          "id": {"type": "string"},
+          "kind": {"type": "string"},
+          "status": {"$ref": "#/components/schemas/JobStatus"},
+          "progress": {"type": "integer", "minimum": 0, "maximum": 100},
+          "message": {"type": "string"},
+          "request": {"description": "What the job was asked to do"},
+          "result": {"description": "What the job did, once it has succeeded"},
+          "error": {"type": "string"},
+          "actor": {"type": "string"},
+          "request_id": {"type": "string"},
+          "created_at": {"type": "string", "format": "date-time"},
+          "updated_at": {"type": "string", "format": "date-time"},
+          "started_at": {"type": "string", "format": "date-time"},
+          "finished_at": {"type": "string", "format": "date-time"}
+        }
+      }
+    }
+  }
+}`
@@ -0,0 +1,70 @@
+package server_test
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"regexp"
+	"strings"
+
+	. "github.com/RackHD/voyager-houston/server"
+	"github.com/gin-gonic/gin"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("OpenAPI", func() {
+	var router *gin.Engine
+
+	BeforeEach(func() {
+		gin.SetMode(gin.ReleaseMode)
+		gin.DefaultWriter = ioutil.Discard
+		router = (&Server{}).Router()
+	})
+
+	It("UNIT should describe every registered route", func() {
+		resp := get(router, "/api/v1/openapi.json", nil)
+		Expect(resp.Code).To(Equal(http.StatusOK))
+
+		spec := struct {
+			OpenAPI string                                `json:"openapi"`
+			Paths   map[string]map[string]json.RawMessage `json:"paths"`
+		}{}
+		Expect(json.Unmarshal(resp.Body.Bytes(), &spec)).To(Succeed())
+		Expect(spec.OpenAPI).To(HavePrefix("3."))
+
+		param := regexp.MustCompile(`[:*](\w+)`)
+		for _, route := range router.Routes() {
+			Expect(route.Path).To(HavePrefix("/api/v1/"))
+			path := param.ReplaceAllString(strings.TrimPrefix(route.Path, "/api/v1"), "{$1}")
+			Expect(spec.Paths).To(HaveKey(path), "%s %s is missing from the OpenAPI document", route.Method, route.Path)
+			Expect(spec.Paths[path]).To(HaveKey(strings.ToLower(route.Method)), "%s %s is missing from the OpenAPI document", route.Method, route.Path)
+		}
+	})
+
+	It("UNIT should no longer serve unversioned routes", func() {
+		Expect(get(router, "/info", nil).Code).To(Equal(http.StatusNotFound))
+	})
+
+	Context("Request validation", func() {
+		It("UNIT should reject bodies with missing or mistyped fields", func() {
+			resp := do(router, "POST", "/api/v1/pools", map[string]interface{}{"metadata": 5}, nil)
+			Expect(resp.Code).To(Equal(http.StatusBadRequest))
+			Expect(resp.Body.String()).To(ContainSubstring("body.name is required"))
+			Expect(resp.Body.String()).To(ContainSubstring("body.metadata must be a string"))
+		})
+
+		It("UNIT should reject unknown fields", func() {
+			resp := do(router, "PUT", "/api/v1/rolebindings/bob", map[string]interface{}{"role": "viewer", "admin": true}, nil)
+			Expect(resp.Code).To(Equal(http.StatusBadRequest))
+			Expect(resp.Body.String()).To(ContainSubstring("body.admin is not allowed"))
+		})
+
+		It("UNIT should reject query parameters outside their schema", func() {
+			Expect(get(router, "/api/v1/audit?limit=lots", nil).Code).To(Equal(http.StatusBadRequest))
+			Expect(get(router, "/api/v1/audit?limit=5000", nil).Code).To(Equal(http.StatusBadRequest))
+			Expect(get(router, "/api/v1/audit?kind=other", nil).Code).To(Equal(http.StatusBadRequest))
+		})
+	})
+})
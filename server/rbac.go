@@ -0,0 +1,339 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/RackHD/voyager-houston/model"
+	"github.com/gin-gonic/gin"
+	"github.com/jinzhu/gorm"
+)
+
+// Permission is the right to call a group of API routes
+type Permission string
+
+// Permissions checked by the API
+const (
+	PermNodesRead        Permission = "nodes:read"
+	PermWorkflowsRead    Permission = "workflows:read"
+	PermWorkflowsRun     Permission = "workflows:run"
+	PermNodesPower       Permission = "nodes:power"
+	PermNodesTag         Permission = "nodes:tag"
+	PermNodesLabel       Permission = "nodes:label"
+	PermTopologyRead     Permission = "topology:read"
+	PermTopologyWrite    Permission = "topology:write"
+	PermIPAMRead         Permission = "ipam:read"
+	PermIPAMWrite        Permission = "ipam:write"
+	PermAddressAllocate  Permission = "addresses:allocate"
+	PermAddressRelease   Permission = "addresses:release"
+	PermRoleBindingAdmin Permission = "rolebindings:admin"
+	PermAuditRead        Permission = "audit:read"
+	PermJobsRead         Permission = "jobs:read"
+	PermJobsCancel       Permission = "jobs:cancel"
+	PermMetricsRead      Permission = "metrics:read"
+)
+
+// Role is a named set of permissions granted to subjects through role bindings
+type Role string
+
+// Roles that can be bound to subjects
+const (
+	RoleViewer   Role = "viewer"
+	RoleOperator Role = "operator"
+	RoleAdmin    Role = "admin"
+)
+
+// rolePermissions lists what each role may do
+var rolePermissions = map[Role][]Permission{
+	RoleViewer: {
+		PermNodesRead,
+		PermWorkflowsRead,
+		PermTopologyRead,
+		PermIPAMRead,
+		PermJobsRead,
+		PermMetricsRead,
+	},
+	RoleOperator: {
+		PermNodesRead,
+		PermWorkflowsRead,
+		PermWorkflowsRun,
+		PermNodesPower,
+		PermNodesTag,
+		PermNodesLabel,
+		PermTopologyRead,
+		PermTopologyWrite,
+		PermIPAMRead,
+		PermAddressAllocate,
+		PermJobsRead,
+		PermJobsCancel,
+		PermMetricsRead,
+	},
+	RoleAdmin: {
+		PermNodesRead,
+		PermWorkflowsRead,
+		PermWorkflowsRun,
+		PermNodesPower,
+		PermNodesTag,
+		PermNodesLabel,
+		PermTopologyRead,
+		PermTopologyWrite,
+		PermIPAMRead,
+		PermIPAMWrite,
+		PermAddressAllocate,
+		PermAddressRelease,
+		PermRoleBindingAdmin,
+		PermAuditRead,
+		PermJobsRead,
+		PermJobsCancel,
+		PermMetricsRead,
+	},
+}
+
+// routePermissions maps each authenticated route to the permission needed to call it
+var routePermissions = map[string]Permission{
+	"GET /metrics": PermMetricsRead,
+
+	"GET /nodes": PermNodesRead,
+
+	// Selectors read the nodes; the handler also checks the permission of the action
+	"POST /node-actions": PermNodesRead,
+
+	"GET /nodes/:id/workflows":           PermWorkflowsRead,
+	"POST /nodes/:id/workflows":          PermWorkflowsRun,
+	"DELETE /nodes/:id/workflows/active": PermWorkflowsRun,
+	"GET /nodes/:id/power":               PermNodesRead,
+	// The status action reads; the handler checks nodes:power for the others
+	"POST /nodes/:id/power":              PermNodesRead,
+	"POST /nodes/:id/boot-device":        PermNodesPower,
+	"GET /nodes/:id/placement":           PermTopologyRead,
+	"PUT /nodes/:id/placement":           PermTopologyWrite,
+	"DELETE /nodes/:id/placement":        PermTopologyWrite,
+	"GET /nodes/:id/connections":         PermTopologyRead,
+	"PUT /nodes/:id/connections/:nic":    PermTopologyWrite,
+	"DELETE /nodes/:id/connections/:nic": PermTopologyWrite,
+	"GET /nodes/:id/labels":              PermNodesRead,
+	"PUT /nodes/:id/labels":              PermNodesLabel,
+	"PATCH /nodes/:id/labels":            PermNodesLabel,
+	"DELETE /nodes/:id/labels":           PermNodesLabel,
+
+	"GET /sites":        PermTopologyRead,
+	"GET /sites/:id":    PermTopologyRead,
+	"POST /sites":       PermTopologyWrite,
+	"PUT /sites/:id":    PermTopologyWrite,
+	"DELETE /sites/:id": PermTopologyWrite,
+
+	"GET /rooms":        PermTopologyRead,
+	"GET /rooms/:id":    PermTopologyRead,
+	"POST /rooms":       PermTopologyWrite,
+	"PUT /rooms/:id":    PermTopologyWrite,
+	"DELETE /rooms/:id": PermTopologyWrite,
+
+	"GET /racks":                PermTopologyRead,
+	"GET /racks/:id":            PermTopologyRead,
+	"GET /racks/:id/placements": PermTopologyRead,
+	"POST /racks":               PermTopologyWrite,
+	"PUT /racks/:id":            PermTopologyWrite,
+	"DELETE /racks/:id":         PermTopologyWrite,
+
+	"GET /switches":                 PermTopologyRead,
+	"GET /switches/:id":             PermTopologyRead,
+	"GET /switches/:id/ports":       PermTopologyRead,
+	"GET /switches/:id/ports/*port": PermTopologyRead,
+
+	"GET /pools":           PermIPAMRead,
+	"GET /pools/:id":       PermIPAMRead,
+	"GET /pools/:id/usage": PermIPAMRead,
+	"POST /pools":          PermIPAMWrite,
+	"DELETE /pools/:id":    PermIPAMWrite,
+
+	"GET /subnets":                              PermIPAMRead,
+	"GET /subnets/:id":                          PermIPAMRead,
+	"GET /subnets/:id/usage":                    PermIPAMRead,
+	"GET /subnets/:id/reservations":             PermIPAMRead,
+	"POST /subnets/:id/reservations":            PermIPAMWrite,
+	"DELETE /subnets/:id/reservations/:address": PermIPAMWrite,
+	"GET /subnets/:id/dhcp":                     PermIPAMRead,
+	"PUT /subnets/:id/dhcp":                     PermIPAMWrite,
+	"POST /subnets":                             PermIPAMWrite,
+	"DELETE /subnets/:id":                       PermIPAMWrite,
+
+	"GET /addresses":            PermIPAMRead,
+	"POST /addresses":           PermAddressAllocate,
+	"POST /nodes/:id/addresses": PermAddressAllocate,
+	"GET /addresses/sweep":      PermIPAMRead,
+	"POST /addresses/sweep":     PermAddressRelease,
+	"DELETE /addresses/:id":     PermAddressRelease,
+
+	"GET /ipam/export":  PermIPAMRead,
+	"POST /ipam/import": PermIPAMWrite,
+	"GET /dhcp/config":  PermIPAMRead,
+
+	"GET /roles":                    PermRoleBindingAdmin,
+	"GET /rolebindings":             PermRoleBindingAdmin,
+	"PUT /rolebindings/:subject":    PermRoleBindingAdmin,
+	"DELETE /rolebindings/:subject": PermRoleBindingAdmin,
+
+	"GET /audit": PermAuditRead,
+
+	"GET /jobs":        PermJobsRead,
+	"GET /jobs/:id":    PermJobsRead,
+	"DELETE /jobs/:id": PermJobsCancel,
+}
+
+// Allows reports whether the role grants permission
+func (r Role) Allows(permission Permission) bool {
+	for _, p := range rolePermissions[r] {
+		if p == permission {
+			return true
+		}
+	}
+	return false
+}
+
+// handle registers handler for an authenticated route, guarded by the
+// permission routePermissions lists for it and validated against the OpenAPI document
+func (s *Server) handle(group *gin.RouterGroup, method, path string, handler gin.HandlerFunc) {
+	name := method + " " + path
+	permission, ok := routePermissions[name]
+	if !ok {
+		panic(fmt.Sprintf("no permission defined for %s", name))
+	}
+
+	setRoute := func(c *gin.Context) {
+		c.Set(routeKey, name)
+	}
+	handlers := []gin.HandlerFunc{setRoute}
+	if limit := s.limitRate(name); limit != nil {
+		handlers = append(handlers, limit)
+	}
+	handlers = append(handlers, s.Authorize(permission))
+	if validate := validateRequest(method, path); validate != nil {
+		handlers = append(handlers, validate)
+	}
+	handlers = append(handlers, handler)
+	group.Handle(method, path, handlers...)
+}
+
+// Authorize returns middleware that rejects callers whose role does not grant
+// permission. Nothing is rejected when authentication is disabled.
+func (s *Server) Authorize(permission Permission) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		s.authorize(c, permission)
+	}
+}
+
+// authorize responds with 403 and returns false if the caller's role does not grant permission
+func (s *Server) authorize(c *gin.Context, permission Permission) bool {
+	if len(s.Authenticators) == 0 {
+		return true
+	}
+
+	if id := identity(c); id != nil && Role(id.Role).Allows(permission) {
+		return true
+	}
+	abortWithError(c, http.StatusForbidden, fmt.Errorf("Permission %s is required", permission))
+	return false
+}
+
+// bindingSubject returns the subject roles are bound to for a caller. It is
+// namespaced by authentication method, so an API key named alice does not get
+// the role of a token for alice.
+func bindingSubject(id *model.Identity) string {
+	return id.Method + ":" + id.Subject
+}
+
+// checkBindingSubject returns an error unless subject is namespaced by an
+// authentication method, as bindingSubject returns it
+func checkBindingSubject(subject string) error {
+	for _, method := range []string{"api-key:", "jwt:"} {
+		if strings.HasPrefix(subject, method) && len(subject) > len(method) {
+			return nil
+		}
+	}
+	return fmt.Errorf("Invalid subject %q, expected api-key:NAME or jwt:SUBJECT", subject)
+}
+
+// roleOf returns the role bound to the caller, or an empty role if there is none
+func (s *Server) roleOf(id *model.Identity) (string, error) {
+	if s.MySQL == nil {
+		return "", nil
+	}
+
+	binding := model.RoleBindingEntity{}
+	err := s.MySQL.DB.Where("subject = ?", bindingSubject(id)).First(&binding).Error
+	if err == gorm.ErrRecordNotFound {
+		return "", nil
+	}
+	return binding.Role, err
+}
+
+// BindRole grants role to subject, an api-key:NAME or jwt:SUBJECT, replacing
+// any role it had
+func (s *Server) BindRole(subject, role string) (model.RoleBindingEntity, error) {
+	binding := model.RoleBindingEntity{}
+	if _, ok := rolePermissions[Role(role)]; !ok {
+		return binding, fmt.Errorf("Unknown role %q", role)
+	}
+	if err := checkBindingSubject(subject); err != nil {
+		return binding, err
+	}
+
+	err := s.MySQL.DB.Where(model.RoleBindingEntity{Subject: subject}).
+		Assign(model.RoleBindingEntity{Role: role}).
+		FirstOrCreate(&binding).Error
+	return binding, err
+}
+
+// ListRolesHandler Serves GET /roles
+func (s *Server) ListRolesHandler(c *gin.Context) {
+	c.JSON(http.StatusOK, rolePermissions)
+}
+
+// ListRoleBindingsHandler Serves GET /rolebindings
+func (s *Server) ListRoleBindingsHandler(c *gin.Context) {
+	bindings := []model.RoleBindingEntity{}
+	if err := s.MySQL.DB.Order("subject").Find(&bindings).Error; err != nil {
+		abortWithError(c, http.StatusInternalServerError, err)
+		return
+	}
+	c.JSON(http.StatusOK, bindings)
+}
+
+// PutRoleBindingHandler Serves PUT /rolebindings/:subject
+func (s *Server) PutRoleBindingHandler(c *gin.Context) {
+	request := model.RoleBindingRequest{}
+	if !bindJSON(c, &request) {
+		return
+	}
+
+	if _, ok := rolePermissions[Role(request.Role)]; !ok {
+		abortWithError(c, http.StatusBadRequest, fmt.Errorf("Unknown role %q", request.Role))
+		return
+	}
+	if err := checkBindingSubject(c.Param("subject")); err != nil {
+		abortWithError(c, http.StatusBadRequest, err)
+		return
+	}
+
+	binding, err := s.BindRole(c.Param("subject"), request.Role)
+	if err != nil {
+		abortWithError(c, http.StatusInternalServerError, err)
+		return
+	}
+	c.JSON(http.StatusOK, binding)
+}
+
+// DeleteRoleBindingHandler Serves DELETE /rolebindings/:subject
+func (s *Server) DeleteRoleBindingHandler(c *gin.Context) {
+	query := s.MySQL.DB.Where("subject = ?", c.Param("subject")).Delete(&model.RoleBindingEntity{})
+	if query.Error != nil {
+		abortWithError(c, http.StatusInternalServerError, query.Error)
+		return
+	}
+	if query.RowsAffected == 0 {
+		abortWithError(c, http.StatusNotFound, fmt.Errorf("No role is bound to %s", c.Param("subject")))
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
@@ -0,0 +1,397 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"sort"
+
+	"github.com/RackHD/voyager-houston/model"
+	"github.com/RackHD/voyager-utilities/models"
+	"github.com/gin-gonic/gin"
+	"github.com/jinzhu/gorm"
+	log "github.com/sirupsen/logrus"
+)
+
+// reservationAssigner is the actor node discovery assigns reservations as
+const reservationAssigner = "reservation-assigner"
+
+// normalizeMAC parses an Ethernet MAC address into lower case, colon separated form
+func normalizeMAC(text string) (string, error) {
+	mac, err := net.ParseMAC(text)
+	if err != nil || len(mac) != 6 {
+		return "", fmt.Errorf("Invalid MAC address %q", text)
+	}
+	return mac.String(), nil
+}
+
+// nodeMACs adds the MAC addresses of a node's NICs to macs. Other MAC
+// addresses in the inventory record, such as its BMC's or its LLDP
+// neighbours', are not the node's.
+func nodeMACs(node model.Node, macs map[string]bool) {
+	for _, text := range node.MACs() {
+		if mac, err := normalizeMAC(text); err == nil {
+			macs[mac] = true
+		}
+	}
+}
+
+// reservationHolder is who IPAM holds a reserved address for
+func reservationHolder(r model.ReservationEntity) string {
+	if r.NodeID != "" {
+		return r.NodeID
+	}
+	return "mac:" + r.MAC
+}
+
+// reservationOwner names who a reservation is for in messages
+func reservationOwner(r model.ReservationEntity) string {
+	if r.NodeID != "" {
+		return "node " + r.NodeID
+	}
+	return "MAC " + r.MAC
+}
+
+// parseReservation checks a reservation request and returns the reservation
+// it asks for, with its address and MAC address in canonical form
+func parseReservation(subnetID string, request model.ReservationRequest) (model.ReservationEntity, error) {
+	r := model.ReservationEntity{SubnetID: subnetID, NodeID: request.Node}
+	if (request.MAC == "") == (request.Node == "") {
+		return r, errors.New("A reservation needs a mac or a node, but not both")
+	}
+	if request.MAC != "" {
+		mac, err := normalizeMAC(request.MAC)
+		if err != nil {
+			return r, err
+		}
+		r.MAC = mac
+	}
+	addr, err := parseAddr("reserved", request.Address)
+	if err != nil {
+		return r, err
+	}
+	r.Address = addr.String()
+	return r, nil
+}
+
+// Reserve sets an address of a subnet aside for a node, by MAC address or by
+// ID. The address is held in IPAM straight away so that it is never leased
+// to another node, and is leased to the node as soon as Houston knows it.
+func (s *Server) Reserve(ctx context.Context, subnetID string, request model.ReservationRequest) (model.ReservationEntity, error) {
+	reservation, err := parseReservation(subnetID, request)
+	if err != nil {
+		return reservation, newAPIError(http.StatusBadRequest, err)
+	}
+
+	s.reservationsMu.Lock()
+	defer s.reservationsMu.Unlock()
+
+	subnets, err := s.subnetRanges(models.SubnetEntity{ID: subnetID})
+	if err != nil {
+		return reservation, err
+	}
+	r := subnets[0].SubnetRangeEntity
+	if r == nil {
+		return reservation, newAPIError(http.StatusConflict, fmt.Errorf("Houston has no range recorded for subnet %s", subnetID))
+	}
+	addr, first, last := netip.MustParseAddr(reservation.Address), netip.MustParseAddr(r.Start), netip.MustParseAddr(r.End)
+	if addr.Is4() != first.Is4() || addr.Less(first) || last.Less(addr) {
+		return reservation, invalidRangeError("Address %s is not in the range %s-%s of subnet %s", addr, first, last, subnetID)
+	}
+
+	existing := []model.ReservationEntity{}
+	err = s.MySQL.DB.Where("subnet_id = ? AND (address = ? OR (mac <> '' AND mac = ?) OR (node_id <> '' AND node_id = ?))",
+		subnetID, reservation.Address, reservation.MAC, reservation.NodeID).Find(&existing).Error
+	if err != nil {
+		return reservation, err
+	}
+	for _, other := range existing {
+		message := fmt.Sprintf("%s already has address %s reserved in subnet %s", reservationOwner(reservation), other.Address, subnetID)
+		if other.Address == reservation.Address {
+			message = fmt.Sprintf("Address %s of subnet %s is already reserved for %s", other.Address, subnetID, reservationOwner(other))
+		}
+		return reservation, &APIError{
+			Status:  http.StatusConflict,
+			Code:    CodeConflict,
+			Message: message,
+			Details: map[string]string{"address": other.Address},
+		}
+	}
+
+	leases := []model.LeaseEntity{}
+	if err = s.MySQL.DB.Where("subnet_id = ? AND address = ?", subnetID, reservation.Address).Find(&leases).Error; err != nil {
+		return reservation, err
+	}
+	switch {
+	case len(leases) > 0 && reservation.NodeID != "" && leases[0].NodeID == reservation.NodeID:
+		// The node already has the address, so its lease becomes the reservation's
+		reservation.LeaseID = leases[0].ID
+		if err = s.MySQL.DB.Model(&leases[0]).Update("expires_at", nil).Error; err != nil {
+			return reservation, err
+		}
+	case len(leases) > 0:
+		return reservation, newAPIError(http.StatusConflict, fmt.Errorf("Address %s is leased to node %s, release it first", reservation.Address, leases[0].NodeID))
+	default:
+		if reservation.LeaseID, err = s.holdAddress(ctx, subnetID, reservationHolder(reservation), reservation.Address); err != nil {
+			return reservation, err
+		}
+	}
+
+	if err = s.MySQL.DB.Create(&reservation).Error; err != nil {
+		if len(leases) == 0 {
+			if releaseErr := s.releaseLease(ctx, reservation.LeaseID); releaseErr != nil {
+				log.Warnf("Could not release %s after failing to record its reservation: %s", reservation.Address, releaseErr)
+			}
+		}
+		return reservation, err
+	}
+	log.Infof("Reserved %s in subnet %s for %s", reservation.Address, subnetID, reservationOwner(reservation))
+
+	switch {
+	case len(leases) > 0:
+		reservation.AssignedTo = reservation.NodeID
+	case reservation.NodeID != "":
+		err = s.assignReservation(&reservation, reservation.NodeID)
+	default:
+		// The node with the MAC may not be discovered yet, which is fine
+		if nodeID, findErr := s.macOwner(ctx, reservation.MAC); findErr != nil {
+			log.Warnf("Could not look for the node with MAC %s: %s", reservation.MAC, findErr)
+		} else if nodeID != "" {
+			err = s.assignReservation(&reservation, nodeID)
+		}
+	}
+	return reservation, err
+}
+
+// holdAddress leases an address in IPAM for holder without recording a lease,
+// failing if IPAM offers any other address
+func (s *Server) holdAddress(ctx context.Context, subnetID, holder, address string) (string, error) {
+	reply := model.IPAMLeaseMsg{}
+	err := s.ipamRequest(ctx, "reserve address", model.IPAMLeaseMsg{
+		Action:     models.CreateAction,
+		ObjectType: model.LeaseType,
+		Subnet:     subnetID,
+		Node:       holder,
+		Address:    address,
+	}, &reply)
+	if err != nil {
+		return "", err
+	}
+	if reply.ID == "" {
+		return "", fmt.Errorf("voyager-ipam-service did not return a lease")
+	}
+	if leased, err := parseAddr("leased", reply.Address); err != nil || leased.String() != address {
+		if releaseErr := s.releaseLease(ctx, reply.ID); releaseErr != nil {
+			log.Warnf("Could not release %s after asking for %s: %s", reply.Address, address, releaseErr)
+		}
+		return "", newAPIError(http.StatusConflict, fmt.Errorf("voyager-ipam-service did not reserve %s, it offered %q", address, reply.Address))
+	}
+	return reply.ID, nil
+}
+
+// assignReservation records the lease holding a reserved address as nodeID's
+func (s *Server) assignReservation(r *model.ReservationEntity, nodeID string) error {
+	addr := netip.MustParseAddr(r.Address)
+	lease := model.LeaseEntity{}
+	err := s.MySQL.DB.Where(model.LeaseEntity{ID: r.LeaseID}).
+		Assign(model.LeaseEntity{SubnetID: r.SubnetID, NodeID: nodeID, Address: r.Address, Family: family(addr)}).
+		FirstOrCreate(&lease).Error
+	if err != nil {
+		return err
+	}
+	r.AssignedTo = nodeID
+	log.Infof("Leased reserved address %s to node %s", r.Address, nodeID)
+	return nil
+}
+
+// macOwner returns the ID of the node with a MAC address, or "" if the
+// inventory service reports none
+func (s *Server) macOwner(ctx context.Context, mac string) (string, error) {
+	nodes, err := s.inventoryNodes(ctx)
+	if err != nil {
+		return "", err
+	}
+	for _, node := range nodes {
+		macs := map[string]bool{}
+		nodeMACs(node, macs)
+		if macs[mac] {
+			return node.ID(), nil
+		}
+	}
+	return "", nil
+}
+
+// AssignReservations leases a node the addresses reserved for it, by ID or
+// for any of its MAC addresses, once the inventory service reports it. The
+// nodes are asked for afresh, as the cached ones may predate the node.
+func (s *Server) AssignReservations(ctx context.Context, nodeID string) ([]model.ReservationEntity, error) {
+	body, err := s.fetchNodes(ctx, false)
+	if err != nil {
+		return nil, err
+	}
+	nodes := []model.Node{}
+	if err = json.Unmarshal(body, &nodes); err != nil {
+		return nil, fmt.Errorf("Error unmarshaling voyager-inventory-service response: %s", err)
+	}
+	macs := map[string]bool{}
+	for _, node := range nodes {
+		if node.ID() == nodeID {
+			nodeMACs(node, macs)
+		}
+	}
+	list := []string{}
+	for mac := range macs {
+		list = append(list, mac)
+	}
+
+	s.reservationsMu.Lock()
+	defer s.reservationsMu.Unlock()
+
+	query := s.MySQL.DB.Where("node_id = ?", nodeID)
+	if len(list) > 0 {
+		query = query.Or("mac IN (?)", list)
+	}
+	reservations := []model.ReservationEntity{}
+	if err = query.Find(&reservations).Error; err != nil {
+		return nil, err
+	}
+
+	assigned := []model.ReservationEntity{}
+	for _, reservation := range reservations {
+		if err = s.assignReservation(&reservation, nodeID); err != nil {
+			return assigned, err
+		}
+		assigned = append(assigned, reservation)
+	}
+	return assigned, nil
+}
+
+// assignDiscoveredNode assigns the reservations of a node the inventory
+// service reported created or updated, logging rather than returning errors
+func (s *Server) assignDiscoveredNode(nodeID string) {
+	ctx := context.WithValue(context.Background(), requestInfoKey{}, requestInfo{Actor: reservationAssigner})
+	if _, err := s.AssignReservations(ctx, nodeID); err != nil {
+		log.Warnf("Could not assign the reservations of node %s: %s", nodeID, err)
+	}
+}
+
+// Unreserve releases a reserved address, along with its node's lease of it
+func (s *Server) Unreserve(ctx context.Context, subnetID, address string) error {
+	s.reservationsMu.Lock()
+	defer s.reservationsMu.Unlock()
+
+	reservation := model.ReservationEntity{}
+	if err := s.MySQL.DB.Where("subnet_id = ? AND address = ?", subnetID, address).First(&reservation).Error; err != nil {
+		return err
+	}
+	if err := s.releaseLease(ctx, reservation.LeaseID); err != nil {
+		return err
+	}
+	if err := s.MySQL.DB.Where("id = ?", reservation.LeaseID).Delete(&model.LeaseEntity{}).Error; err != nil {
+		return err
+	}
+	log.Infof("Unreserved %s in subnet %s", address, subnetID)
+	return s.MySQL.DB.Where("subnet_id = ? AND address = ?", subnetID, address).Delete(&model.ReservationEntity{}).Error
+}
+
+// reservations returns the reservations of a subnet by address, with the
+// node each one is leased to
+func (s *Server) reservations(subnetID string) ([]model.ReservationEntity, error) {
+	reservations := []model.ReservationEntity{}
+	if err := s.MySQL.DB.Where("subnet_id = ?", subnetID).Find(&reservations).Error; err != nil {
+		return nil, err
+	}
+	leases := []model.LeaseEntity{}
+	if err := s.MySQL.DB.Where("subnet_id = ?", subnetID).Find(&leases).Error; err != nil {
+		return nil, err
+	}
+	nodes := map[string]string{}
+	for _, lease := range leases {
+		nodes[lease.ID] = lease.NodeID
+	}
+	for i := range reservations {
+		reservations[i].AssignedTo = nodes[reservations[i].LeaseID]
+	}
+	sort.Slice(reservations, func(i, j int) bool {
+		return netip.MustParseAddr(reservations[i].Address).Less(netip.MustParseAddr(reservations[j].Address))
+	})
+	return reservations, nil
+}
+
+// ListReservationsHandler Serves GET /subnets/:id/reservations
+func (s *Server) ListReservationsHandler(c *gin.Context) {
+	subnet := models.SubnetEntity{}
+	if !s.find(c, &subnet, c.Param("id")) {
+		return
+	}
+	reservations, err := s.reservations(subnet.ID)
+	if err != nil {
+		abortWithError(c, http.StatusInternalServerError, err)
+		return
+	}
+	c.JSON(http.StatusOK, reservations)
+}
+
+// ReserveAddressHandler Serves POST /subnets/:id/reservations
+func (s *Server) ReserveAddressHandler(c *gin.Context) {
+	request := model.ReservationRequest{}
+	if !bindJSON(c, &request) {
+		return
+	}
+	if _, err := parseReservation(c.Param("id"), request); err != nil {
+		abortWithError(c, http.StatusBadRequest, err)
+		return
+	}
+
+	subnet := models.SubnetEntity{}
+	if !s.find(c, &subnet, c.Param("id")) {
+		return
+	}
+
+	if preferAsync(c) {
+		s.startJob(c, jobReserveAddress, subnetReservationRequest{Subnet: subnet.ID, ReservationRequest: request})
+		return
+	}
+
+	reservation, err := s.Reserve(requestContext(c), subnet.ID, request)
+	if err != nil {
+		abortWithRPCError(c, err)
+		return
+	}
+	c.JSON(http.StatusCreated, reservation)
+}
+
+// UnreserveAddressHandler Serves DELETE /subnets/:id/reservations/:address
+func (s *Server) UnreserveAddressHandler(c *gin.Context) {
+	addr, err := parseAddr("reserved", c.Param("address"))
+	if err != nil {
+		abortWithError(c, http.StatusBadRequest, err)
+		return
+	}
+
+	reservation := model.ReservationEntity{}
+	err = s.MySQL.DB.Where("subnet_id = ? AND address = ?", c.Param("id"), addr.String()).First(&reservation).Error
+	if err == gorm.ErrRecordNotFound {
+		abortWithError(c, http.StatusNotFound, fmt.Errorf("No reservation of %s in subnet %s", addr, c.Param("id")))
+		return
+	}
+	if err != nil {
+		abortWithError(c, http.StatusInternalServerError, err)
+		return
+	}
+
+	if preferAsync(c) {
+		s.startJob(c, jobUnreserveAddress, subnetReservationRequest{Subnet: reservation.SubnetID, ReservationRequest: model.ReservationRequest{Address: reservation.Address}})
+		return
+	}
+
+	if err = s.Unreserve(requestContext(c), reservation.SubnetID, reservation.Address); err != nil {
+		abortWithRPCError(c, err)
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
@@ -0,0 +1,110 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/RackHD/voyager-utilities/random"
+	log "github.com/sirupsen/logrus"
+)
+
+// rpcTimeout is how long Houston waits for a reply from another Voyager service
+const rpcTimeout = 5 * time.Second
+
+// errRPCTimeout is returned when a Voyager service does not reply within rpcTimeout
+var errRPCTimeout = errors.New("Request timed out after 5s")
+
+// rpcRequest is a command sent to another Voyager service
+type rpcRequest struct {
+	Exchange     string
+	ExchangeType string
+	RoutingKey   string
+	// ReplyKey is the routing key the service publishes its reply with
+	ReplyKey string
+	// Command names the request in the audit log
+	Command string
+	Message []byte
+	// Coalesce lets identical requests in flight at the same time share one
+	// reply. Only set it for requests that do not change anything.
+	Coalesce bool
+}
+
+// call sends a request to a Voyager service and waits for the reply with the
+// same correlation ID, or until ctx is done
+func (s *Server) call(ctx context.Context, req rpcRequest) ([]byte, error) {
+	if req.Coalesce {
+		return s.coalesce(ctx, req)
+	}
+	return s.roundTrip(ctx, req)
+}
+
+// roundTrip sends one request over AMQP and waits for its reply
+func (s *Server) roundTrip(ctx context.Context, req rpcRequest) (reply []byte, err error) {
+	queueName := random.RandQueue()
+	correlationID := random.RandQueue()
+	consumerTag := random.RandQueue()
+
+	defer func() {
+		s.auditCommand(ctx, req, correlationID, err)
+	}()
+
+	if !s.acquire(req.Exchange) {
+		return nil, errTooManyInFlight
+	}
+	defer s.release(req.Exchange)
+
+	mqChannel, deliveries, err := s.MQ.Listen(req.Exchange, req.ExchangeType, queueName, req.ReplyKey, consumerTag)
+	if err != nil {
+		log.Infof("Error listening: %s", err)
+		return nil, &upstreamError{err}
+	}
+
+	defer func() {
+		if mqChannel == nil {
+			// The broker does not use AMQP channels, e.g. a fake one in tests
+			return
+		}
+		if err := mqChannel.Cancel(consumerTag, false); err != nil {
+			log.Infof("Consumer cancel failed: %s", err)
+		}
+
+		if _, err := mqChannel.QueueDelete(queueName, false, false, true); err != nil {
+			log.Infof("Queue Delete failed: %s", err)
+		}
+
+		if err := mqChannel.Close(); err != nil {
+			log.Info("Closing channel failed: ", err)
+		}
+	}()
+
+	s.metrics.add("houston_rpc_requests_total", "Requests sent to Voyager services",
+		labels{"exchange": req.Exchange, "command": req.Command}, 1)
+	if err = s.MQ.Send(req.Exchange, req.ExchangeType, req.RoutingKey, string(req.Message), correlationID, req.ReplyKey); err != nil {
+		log.Infof("Error sending to %s: %s", req.Exchange, err)
+		return nil, &upstreamError{err}
+	}
+
+	timeout := time.After(rpcTimeout)
+	for {
+		select {
+		case d, ok := <-deliveries:
+			if !ok {
+				return nil, &replyLostError{errors.New("Reply queue was closed before the reply arrived")}
+			}
+			if d.CorrelationId != correlationID {
+				continue
+			}
+
+			d.Ack(false)
+			return d.Body, nil
+
+		case <-timeout:
+			log.Infof("Request %s to %s timed out", req.Command, req.Exchange)
+			return nil, errRPCTimeout
+
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+}
@@ -0,0 +1,209 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/RackHD/voyager-houston/model"
+)
+
+// selectNodes returns the IDs of the inventory nodes the selector matches,
+// and the IDs it names that the inventory does not know
+func (s *Server) selectNodes(ctx context.Context, selector model.NodeSelector) (ids, missing []string, err error) {
+	nodes, err := s.inventoryNodes(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	known := map[string]bool{}
+	for _, node := range nodes {
+		known[node.ID()] = true
+		if selects(selector, node) {
+			ids = append(ids, node.ID())
+		}
+	}
+	for _, id := range selector.IDs {
+		if !known[id] {
+			missing = append(missing, id)
+		}
+	}
+	return ids, missing, nil
+}
+
+// selects reports whether node matches every criterion of selector
+func selects(selector model.NodeSelector, node model.Node) bool {
+	if len(selector.IDs) > 0 && !contains(selector.IDs, node.ID()) {
+		return false
+	}
+
+	labels := nodeLabels(node)
+	for key, value := range selector.Labels {
+		if actual, ok := labels[key]; !ok || actual != value {
+			return false
+		}
+	}
+	for key, value := range selector.Filter {
+		if actual, ok := node[key]; !ok || text(actual) != value {
+			return false
+		}
+	}
+	return true
+}
+
+// nodeLabels returns a copy of the labels of a node
+func nodeLabels(node model.Node) model.Labels {
+	labels := model.Labels{}
+	switch v := node["labels"].(type) {
+	case map[string]interface{}:
+		for key, value := range v {
+			labels[key] = text(value)
+		}
+	case model.Labels:
+		for key, value := range v {
+			labels[key] = value
+		}
+	}
+	return labels
+}
+
+// Operators of label selector requirements
+const (
+	selectEquals    = "="
+	selectNotEquals = "!="
+	selectIn        = "in"
+	selectNotIn     = "notin"
+	selectExists    = "exists"
+	selectNotExists = "!"
+)
+
+// labelRequirement is one comma-separated term of a label selector
+type labelRequirement struct {
+	key      string
+	operator string
+	values   []string
+}
+
+// labelSelector is a Kubernetes-style label selector, such as
+// "env=prod,role!=gpu,tier in (a,b),!retired". A node must meet every requirement.
+type labelSelector []labelRequirement
+
+// setRequirementPattern matches "key in (a,b)" and "key notin (a,b)"
+var setRequirementPattern = regexp.MustCompile(`^(\S+)\s+(in|notin)\s*\(([^()]*)\)$`)
+
+// parseLabelSelector parses a label selector. An empty selector matches every node.
+func parseLabelSelector(selector string) (labelSelector, error) {
+	parsed := labelSelector{}
+	for _, term := range splitTerms(selector) {
+		requirement, err := parseRequirement(strings.TrimSpace(term))
+		if err != nil {
+			return nil, err
+		}
+		parsed = append(parsed, requirement)
+	}
+	return parsed, nil
+}
+
+// splitTerms splits a label selector on the commas outside parentheses
+func splitTerms(selector string) []string {
+	if strings.TrimSpace(selector) == "" {
+		return nil
+	}
+
+	terms := []string{}
+	depth, start := 0, 0
+	for i, r := range selector {
+		switch r {
+		case '(':
+			depth++
+		case ')':
+			depth--
+		case ',':
+			if depth == 0 {
+				terms = append(terms, selector[start:i])
+				start = i + 1
+			}
+		}
+	}
+	return append(terms, selector[start:])
+}
+
+func parseRequirement(term string) (labelRequirement, error) {
+	r := labelRequirement{}
+	if match := setRequirementPattern.FindStringSubmatch(term); match != nil {
+		r.key, r.operator = match[1], match[2]
+		for _, value := range strings.Split(match[3], ",") {
+			r.values = append(r.values, strings.TrimSpace(value))
+		}
+	} else if strings.HasPrefix(term, "!") && !strings.Contains(term, "=") {
+		r.key, r.operator = strings.TrimSpace(term[1:]), selectNotExists
+	} else if i := strings.Index(term, "!="); i >= 0 {
+		r.key, r.operator, r.values = term[:i], selectNotEquals, []string{term[i+2:]}
+	} else if i := strings.Index(term, "=="); i >= 0 {
+		r.key, r.operator, r.values = term[:i], selectEquals, []string{term[i+2:]}
+	} else if i := strings.Index(term, "="); i >= 0 {
+		r.key, r.operator, r.values = term[:i], selectEquals, []string{term[i+1:]}
+	} else {
+		r.key, r.operator = term, selectExists
+	}
+
+	r.key = strings.TrimSpace(r.key)
+	if !labelKeyPattern.MatchString(r.key) {
+		return r, fmt.Errorf("Invalid label selector term %q", term)
+	}
+	for i, value := range r.values {
+		r.values[i] = strings.TrimSpace(value)
+		if !labelValuePattern.MatchString(r.values[i]) {
+			return r, fmt.Errorf("Invalid value %q in label selector term %q", value, term)
+		}
+	}
+	return r, nil
+}
+
+// matches reports whether labels meet every requirement of the selector.
+// As in Kubernetes, != and notin match nodes without the label.
+func (selector labelSelector) matches(labels model.Labels) bool {
+	for _, r := range selector {
+		value, ok := labels[r.key]
+		switch r.operator {
+		case selectEquals, selectIn:
+			if !ok || !contains(r.values, value) {
+				return false
+			}
+		case selectNotEquals, selectNotIn:
+			if ok && contains(r.values, value) {
+				return false
+			}
+		case selectExists:
+			if !ok {
+				return false
+			}
+		case selectNotExists:
+			if ok {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+func contains(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
+
+// text returns a value decoded from JSON as a string, strings as they are
+// and anything else as JSON
+func text(v interface{}) string {
+	if s, ok := v.(string); ok {
+		return s
+	}
+	data, _ := json.Marshal(v)
+	return string(data)
+}
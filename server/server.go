@@ -1,10 +1,12 @@
 package server
 
 import (
+	"encoding/json"
 	"fmt"
 	"math/rand"
 	"net/http"
 	"os"
+	"sync"
 	"time"
 
 	"github.com/gin-gonic/gin"
@@ -17,20 +19,115 @@ import (
 	samqp "github.com/streadway/amqp"
 )
 
+// Exchange and routing keys of voyager-inventory-service
+const (
+	inventoryExchange     = "voyager-inventory-service"
+	inventoryExchangeType = "topic"
+	inventoryRequestKey   = "requests"
+	inventoryReplyKey     = "replies"
+)
+
 // Server is a Voyager server
 type Server struct {
-	MQ    *amqp.Client
-	MySQL *mysql.DBconn
+	MQ     Broker
+	MySQL  *mysql.DBconn
+	Config Config
+
+	// Authenticators identify API callers. The API is open when there are none.
+	Authenticators []Authenticator
+
+	inFlightMu sync.Mutex
+	inFlight   map[string]chan struct{}
+
+	// sweepMu keeps lease sweeps from releasing the same leases twice
+	sweepMu sync.Mutex
+	// subnetsMu keeps two new subnets from passing the overlap check together
+	subnetsMu sync.Mutex
+	// importMu keeps two IPAM imports from creating the same objects
+	importMu sync.Mutex
+	// reservationsMu keeps a reserved address from being reserved or assigned twice
+	reservationsMu sync.Mutex
+	// usageMu keeps concurrent scrapes from computing the usage gauges together
+	usageMu      sync.Mutex
+	usageUpdated time.Time
+
+	inventory inventoryCache
+	nodeData  nodeDataCache
+	flights   flights
+	metrics   registry
+	jobs      jobs
+}
+
+// Config holds the optional settings of a Server
+type Config struct {
+	// Certificate and key for serving the API over HTTPS. Plain HTTP is served when empty.
+	TLSCertFile string
+	TLSKeyFile  string
+	// CA bundle to verify API client certificates against. Client certificates are not requested when empty.
+	TLSClientCAFile string
+
+	// CA bundle, certificate and key for connecting to RabbitMQ over amqps://
+	AMQPCAFile   string
+	AMQPCertFile string
+	AMQPKeyFile  string
+
+	// Accept API keys stored in MySQL
+	AuthAPIKeys bool
+	// Accept JWT bearer tokens signed with the secret in JWTSecretFile or a key in JWKSFile
+	JWTSecretFile string
+	JWKSFile      string
+	// Required iss and aud claims of JWT bearer tokens, if set
+	JWTIssuer   string
+	JWTAudience string
+
+	// RateLimits limit each client's requests per route, e.g. "GET /nodes". "*" applies to all other routes.
+	RateLimits map[string]RateLimit
+	// MaxInFlight caps concurrent requests to each Voyager service by exchange name. "*" applies to all other services.
+	MaxInFlight map[string]int
+
+	// InventoryCacheTTL is how long GET /nodes is answered from the cache. Zero disables the cache.
+	InventoryCacheTTL time.Duration
+	// InventoryCacheMaxStale is the oldest cached reply served when the inventory service is slow or down. Zero means no limit.
+	InventoryCacheMaxStale time.Duration
+
+	// LeaseGracePeriod is how long a node may be missing from inventory before the lease sweeper releases its leases
+	LeaseGracePeriod time.Duration
+	// LeaseSweepMaxGone is the largest fraction of the leases a sweep releases because their nodes are gone.
+	// Above it, inventory is assumed to be wrong and none are released. Zero means no limit.
+	LeaseSweepMaxGone float64
+
+	// UsageGaugeMaxAge is how long /metrics serves the address usage gauges before computing them again. Zero computes them on every scrape.
+	UsageGaugeMaxAge time.Duration
 }
 
 // NewServer connects to AMQP and returns the server object
 func NewServer(amqpAddress, dbAddress string) *Server {
+	return NewServerWithConfig(amqpAddress, dbAddress, Config{})
+}
+
+// NewServerWithConfig connects to AMQP using the TLS settings in config and returns the server object
+func NewServerWithConfig(amqpAddress, dbAddress string, config Config) *Server {
 	rand.Seed(time.Now().UTC().UnixNano())
 
-	server := Server{}
-	server.MQ = amqp.NewClient(amqpAddress)
-	if server.MQ == nil {
-		log.Fatalf("Could not connect to RabbitMQ at %s\n", amqpAddress)
+	server := Server{Config: config}
+	if config.AMQPCAFile != "" || config.AMQPCertFile != "" {
+		certs, err := NewCertReloader(config.AMQPCertFile, config.AMQPKeyFile, config.AMQPCAFile)
+		if err != nil {
+			log.Fatalf("Could not load RabbitMQ certificates: %s\n", err)
+		}
+		go certs.Watch(certReloadInterval)
+
+		broker, err := newTLSBroker(amqpAddress, certs)
+		if err != nil {
+			log.Fatalf("Could not connect to RabbitMQ at %s: %s\n", amqpAddress, err)
+		}
+		server.MQ = broker
+	} else {
+		client := amqp.NewClient(amqpAddress)
+		if client == nil {
+			log.Fatalf("Could not connect to RabbitMQ at %s\n", amqpAddress)
+		}
+		server.MQ = plainBroker{client}
 	}
 
 	server.MySQL = &mysql.DBconn{}
@@ -39,21 +136,144 @@ func NewServer(amqpAddress, dbAddress string) *Server {
 		log.Fatalf("Error connecting to DB: %s\n", err)
 	}
 
+	server.Authenticators, err = server.newAuthenticators(config)
+	if err != nil {
+		log.Fatalf("Could not configure authentication: %s\n", err)
+	}
+
 	return &server
 }
 
+// Router returns the HTTP handler serving the API
+func (s *Server) Router() *gin.Engine {
+	router := gin.New()
+	router.Use(gin.Logger(), s.RequestID, s.Errors)
+	router.NoRoute(s.NotFoundHandler)
+
+	v1 := router.Group(apiPrefix)
+	v1.GET("/healthz", s.HealthHandler)
+	v1.GET("/info", s.InfoHandler)
+	v1.GET("/openapi.json", s.OpenAPIHandler)
+
+	api := v1.Group("/", s.Audit, s.Authenticate)
+	api.GET("/whoami", s.WhoAmIHandler)
+	s.handle(api, "GET", "/metrics", s.MetricsHandler)
+	s.handle(api, "GET", "/nodes", s.NodesHandler)
+	s.handle(api, "GET", "/nodes/:id/workflows", s.ListWorkflowsHandler)
+	s.handle(api, "POST", "/nodes/:id/workflows", s.RunWorkflowHandler)
+	s.handle(api, "DELETE", "/nodes/:id/workflows/active", s.CancelWorkflowHandler)
+	s.handle(api, "GET", "/nodes/:id/power", s.PowerStateHandler)
+	s.handle(api, "POST", "/nodes/:id/power", s.PowerHandler)
+	s.handle(api, "POST", "/nodes/:id/boot-device", s.BootDeviceHandler)
+	s.handle(api, "GET", "/nodes/:id/labels", s.GetLabelsHandler)
+	s.handle(api, "PUT", "/nodes/:id/labels", s.PutLabelsHandler)
+	s.handle(api, "PATCH", "/nodes/:id/labels", s.PatchLabelsHandler)
+	s.handle(api, "DELETE", "/nodes/:id/labels", s.DeleteLabelsHandler)
+	s.handle(api, "GET", "/nodes/:id/placement", s.GetPlacementHandler)
+	s.handle(api, "PUT", "/nodes/:id/placement", s.PutPlacementHandler)
+	s.handle(api, "DELETE", "/nodes/:id/placement", s.DeletePlacementHandler)
+	s.handle(api, "GET", "/nodes/:id/connections", s.ListConnectionsHandler)
+	s.handle(api, "PUT", "/nodes/:id/connections/:nic", s.PutConnectionHandler)
+	s.handle(api, "DELETE", "/nodes/:id/connections/:nic", s.DeleteConnectionHandler)
+	s.handle(api, "POST", "/nodes/:id/addresses", s.AllocateAddressesHandler)
+	s.handle(api, "POST", "/node-actions", s.NodeActionsHandler)
+
+	s.handle(api, "GET", "/sites", s.ListSitesHandler)
+	s.handle(api, "GET", "/sites/:id", s.GetSiteHandler)
+	s.handle(api, "POST", "/sites", s.CreateSiteHandler)
+	s.handle(api, "PUT", "/sites/:id", s.UpdateSiteHandler)
+	s.handle(api, "DELETE", "/sites/:id", s.DeleteSiteHandler)
+
+	s.handle(api, "GET", "/rooms", s.ListRoomsHandler)
+	s.handle(api, "GET", "/rooms/:id", s.GetRoomHandler)
+	s.handle(api, "POST", "/rooms", s.CreateRoomHandler)
+	s.handle(api, "PUT", "/rooms/:id", s.UpdateRoomHandler)
+	s.handle(api, "DELETE", "/rooms/:id", s.DeleteRoomHandler)
+
+	s.handle(api, "GET", "/racks", s.ListRacksHandler)
+	s.handle(api, "GET", "/racks/:id", s.GetRackHandler)
+	s.handle(api, "GET", "/racks/:id/placements", s.ListRackPlacementsHandler)
+	s.handle(api, "POST", "/racks", s.CreateRackHandler)
+	s.handle(api, "PUT", "/racks/:id", s.UpdateRackHandler)
+	s.handle(api, "DELETE", "/racks/:id", s.DeleteRackHandler)
+
+	s.handle(api, "GET", "/switches", s.ListSwitchesHandler)
+	s.handle(api, "GET", "/switches/:id", s.GetSwitchHandler)
+	s.handle(api, "GET", "/switches/:id/ports", s.ListSwitchPortsHandler)
+	// GET /switches/:id/ports/:port/node, as port names contain slashes
+	s.handle(api, "GET", "/switches/:id/ports/*port", s.PortNodeHandler)
+
+	s.handle(api, "GET", "/pools", s.ListPoolsHandler)
+	s.handle(api, "GET", "/pools/:id", s.GetPoolHandler)
+	s.handle(api, "GET", "/pools/:id/usage", s.PoolUsageHandler)
+	s.handle(api, "POST", "/pools", s.CreatePoolHandler)
+	s.handle(api, "DELETE", "/pools/:id", s.DeletePoolHandler)
+
+	s.handle(api, "GET", "/subnets", s.ListSubnetsHandler)
+	s.handle(api, "GET", "/subnets/:id", s.GetSubnetHandler)
+	s.handle(api, "GET", "/subnets/:id/usage", s.SubnetUsageHandler)
+	s.handle(api, "GET", "/subnets/:id/reservations", s.ListReservationsHandler)
+	s.handle(api, "POST", "/subnets/:id/reservations", s.ReserveAddressHandler)
+	s.handle(api, "DELETE", "/subnets/:id/reservations/:address", s.UnreserveAddressHandler)
+	s.handle(api, "GET", "/subnets/:id/dhcp", s.DHCPOptionsHandler)
+	s.handle(api, "PUT", "/subnets/:id/dhcp", s.PutDHCPOptionsHandler)
+	s.handle(api, "POST", "/subnets", s.CreateSubnetHandler)
+	s.handle(api, "DELETE", "/subnets/:id", s.DeleteSubnetHandler)
+
+	s.handle(api, "GET", "/addresses", s.ListAddressesHandler)
+	s.handle(api, "POST", "/addresses", s.AllocateAddressHandler)
+	s.handle(api, "GET", "/addresses/sweep", s.SweepReportHandler)
+	s.handle(api, "POST", "/addresses/sweep", s.SweepHandler)
+	s.handle(api, "DELETE", "/addresses/:id", s.ReleaseAddressHandler)
+	s.handle(api, "GET", "/ipam/export", s.ExportIPAMHandler)
+	s.handle(api, "POST", "/ipam/import", s.ImportIPAMHandler)
+	s.handle(api, "GET", "/dhcp/config", s.DHCPConfigHandler)
+
+	s.handle(api, "GET", "/roles", s.ListRolesHandler)
+	s.handle(api, "GET", "/rolebindings", s.ListRoleBindingsHandler)
+	s.handle(api, "PUT", "/rolebindings/:subject", s.PutRoleBindingHandler)
+	s.handle(api, "DELETE", "/rolebindings/:subject", s.DeleteRoleBindingHandler)
+
+	s.handle(api, "GET", "/audit", s.AuditHandler)
+
+	s.handle(api, "GET", "/jobs", s.ListJobsHandler)
+	s.handle(api, "GET", "/jobs/:id", s.GetJobHandler)
+	s.handle(api, "DELETE", "/jobs/:id", s.CancelJobHandler)
+
+	return router
+}
+
 // Run it
 func (s *Server) Run() {
 
 	port := os.Getenv("PORT")
-	server := gin.Default()
+	server := s.Router()
+
+	if s.Config.TLSCertFile == "" {
+		log.Info("Starting Voyager at Port ", port)
+		server.Run(":" + port)
+		return
+	}
+
+	certs, err := NewCertReloader(s.Config.TLSCertFile, s.Config.TLSKeyFile, s.Config.TLSClientCAFile)
+	if err != nil {
+		log.Fatalf("Could not load TLS certificates: %s\n", err)
+	}
+	go certs.Watch(certReloadInterval)
 
-	server.GET("/info", s.InfoHandler)
-	server.GET("/nodes", s.NodesHandler)
+	httpServer := &http.Server{
+		Addr:      ":" + port,
+		Handler:   server,
+		TLSConfig: certs.ServerConfig(),
+	}
 
-	log.Info("Starting Voyager at Port ", port)
-	server.Run(":" + port)
+	log.Info("Starting Voyager with TLS at Port ", port)
+	log.Fatal(httpServer.ListenAndServeTLS("", ""))
+}
 
+// HealthHandler Serves /healthz
+func (s *Server) HealthHandler(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{"status": "ok"})
 }
 
 // InfoHandler Serves /info
@@ -63,70 +283,67 @@ func (s *Server) InfoHandler(c *gin.Context) {
 	})
 }
 
-// NodesHandler Serves /nodes
+// NodesHandler Serves /nodes, from the inventory cache when it is enabled.
+// Houston's labels and locations are added to the nodes, which can be
+// filtered with a labelSelector and by site, room and rack.
 func (s *Server) NodesHandler(c *gin.Context) {
-	exchangeName := "voyager-inventory-service"
-	exchangeType := "topic"
-	queueName := random.RandQueue()
-	receieveQueue := "replies"
-	sendQueue := "requests"
-	corID := random.RandQueue()
-	consumerTag := random.RandQueue()
-	requestMessage := `{"command": "get_nodes", "options":""}`
-	finish := make(chan struct{})
-
-	mqChannel, deliveries, err := s.MQ.Listen(exchangeName, exchangeType, queueName, receieveQueue, consumerTag)
+	selector, err := parseLabelSelector(c.Query("labelSelector"))
 	if err != nil {
-		c.String(http.StatusInternalServerError, fmt.Sprintf("%s", err))
-		log.Info("Error listening: %s\n", err)
-		c.JSON(500, "Error Listening")
-		c.Abort()
+		abortWithError(c, http.StatusBadRequest, err)
 		return
 	}
 
-	defer func() {
-		log.Info("Trying to close the channel\n")
-		if err = mqChannel.Cancel(consumerTag, false); err != nil {
-			log.Info("Consumer cancel failed: %s\n", err)
+	var reply []byte
+	if s.Config.InventoryCacheTTL <= 0 {
+		reply, err = s.fetchNodes(requestContext(c), true)
+	} else {
+		var state string
+		var age time.Duration
+		if reply, state, age, err = s.cachedNodes(requestContext(c)); err == nil {
+			setCacheHeaders(c, state, age)
 		}
+	}
+	if err != nil {
+		abortWithRPCError(c, err)
+		return
+	}
 
-		log.Info("Trying to delete the queue: %s\n", queueName)
-		if _, err = mqChannel.QueueDelete(queueName, false, false, true); err != nil {
-			log.Info("Queue Delete failed: %s\n", err)
-		}
-
-		if err = mqChannel.Close(); err != nil {
-			log.Info("Closing channel failed: ", err)
-		}
-		close(finish)
-	}()
-
-	go func() {
-		for d := range deliveries {
-			if d.CorrelationId == corID {
-
-				d.Ack(true)
-				c.String(http.StatusOK, string(d.Body))
-				finish <- struct{}{}
-				return
-			}
+	nodes, err := s.decodeNodes(reply)
+	if err != nil {
+		abortWithError(c, http.StatusInternalServerError, err)
+		return
+	}
+	found := []model.Node{}
+	for _, node := range nodes {
+		if selector.matches(nodeLabels(node)) && locatedIn(node, c.Query("site"), c.Query("room"), c.Query("rack")) {
+			found = append(found, node)
 		}
-	}()
-
-	err = s.MQ.Send(exchangeName, exchangeType, sendQueue, requestMessage, corID, receieveQueue)
+	}
+	body, err := json.Marshal(found)
 	if err != nil {
-		c.String(http.StatusInternalServerError, fmt.Sprintf("%s", err))
+		abortWithError(c, http.StatusInternalServerError, err)
 		return
 	}
+	c.Data(http.StatusOK, "application/json; charset=utf-8", body)
+}
 
-	// Time out after 5 seconds
-	select {
-	case <-time.After(5 * time.Second):
-		c.JSON(504, "Request Timed Out")
-		c.Abort()
-	case <-finish:
+// processEvent handles an event published to the Houston exchange according
+// to the type of object it is about
+func (s *Server) processEvent(body []byte) error {
+	event := struct {
+		ObjectType string `json:"objectType"`
+	}{}
+	if err := json.Unmarshal(body, &event); err != nil {
+		return fmt.Errorf("invalid event: %s", err)
 	}
 
+	switch event.ObjectType {
+	case model.NodeType:
+		return s.processNodeEvent(body)
+	case model.WorkflowType:
+		return s.processWorkflowEvent(body)
+	}
+	return fmt.Errorf("unknown object type %q", event.ObjectType)
 }
 
 // ListenOnHoustonReceiveQueue is ...
@@ -138,10 +355,23 @@ func (s *Server) ListenOnHoustonReceiveQueue() error {
 	}
 
 	go func() {
-		for m := range deliveries {
-			log.Info("Got ", len(m.Body), "B delivery on exchange ", m.Exchange, ": ", string(m.Body))
-			m.Ack(true)
-			go s.ProcessAMQPMessage(&m)
+		for {
+			for m := range deliveries {
+				log.Info("Got ", len(m.Body), "B delivery on exchange ", m.Exchange, ": ", string(m.Body))
+				m.Ack(true)
+				go s.ProcessAMQPMessage(&m)
+			}
+
+			// The connection was replaced or dropped, listen again
+			log.Info("Houston receive queue closed, listening again")
+			for {
+				_, deliveries, err = s.MQ.Listen(models.HoustonExchange, models.HoustonExchangeType, houstonQueueName, models.HoustonReceiveQueue, "")
+				if err == nil {
+					break
+				}
+				log.Warnf("Could not listen on Houston receive queue: %s", err)
+				time.Sleep(connectionGrace)
+			}
 		}
 	}()
 
@@ -153,7 +383,10 @@ func (s *Server) ProcessAMQPMessage(m *samqp.Delivery) error {
 	switch m.Exchange {
 
 	case "Houston":
-		log.Info("Not yet implemented")
+		if err := s.processEvent(m.Body); err != nil {
+			log.Warnf("Ignoring message on %s: %s", m.Exchange, err)
+			return err
+		}
 
 	default:
 		log.Warnf("Unknown exchange: %s\n", m.Exchange)
@@ -46,7 +46,7 @@ var _ = Describe("Server", func() {
 			go s.Run()
 			time.Sleep(time.Millisecond * 500)
 
-			req, err := http.NewRequest("GET", serverURL+"/info", nil)
+			req, err := http.NewRequest("GET", serverURL+"/api/v1/info", nil)
 			Expect(err).ToNot(HaveOccurred())
 			resp, err := (&http.Client{}).Do(req)
 			Expect(err).ToNot(HaveOccurred())
@@ -55,10 +55,10 @@ var _ = Describe("Server", func() {
 			body, err := ioutil.ReadAll(resp.Body)
 			Expect(err).ToNot(HaveOccurred())
 
-			var voyager-houston model.Houston
-			err = json.Unmarshal(body, &voyager-houston)
+			var houston model.Houston
+			err = json.Unmarshal(body, &houston)
 			Expect(err).ToNot(HaveOccurred())
-			Expect(voyager-houston.Name).To(Equal("voyager"))
+			Expect(houston.Name).To(Equal("voyager"))
 		})
 		Context("When handling AMQP messages", func() {
 			BeforeEach(func() {
@@ -83,7 +83,7 @@ var _ = Describe("Server", func() {
 				time.Sleep(time.Millisecond * 500)
 
 				start := time.Now()
-				req, err := http.NewRequest("GET", serverURL+"/nodes", nil)
+				req, err := http.NewRequest("GET", serverURL+"/api/v1/nodes", nil)
 				Expect(err).ToNot(HaveOccurred())
 				resp, _ := (&http.Client{}).Do(req)
 				end := time.Now()
@@ -120,7 +120,7 @@ var _ = Describe("Server", func() {
 				Expect(err).ToNot(HaveOccurred())
 
 				// Call Houston's 	'/nodes' API
-				req, err := http.NewRequest("GET", serverURL+"/nodes", nil)
+				req, err := http.NewRequest("GET", serverURL+"/api/v1/nodes", nil)
 				Expect(err).ToNot(HaveOccurred())
 				_, err = (&http.Client{}).Do(req)
 				Expect(err).ToNot(HaveOccurred())
@@ -0,0 +1,208 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/RackHD/voyager-houston/model"
+	"github.com/gin-gonic/gin"
+	"github.com/jinzhu/gorm"
+)
+
+// inventoryRequest sends a read-only command to the inventory service and
+// unmarshals its reply into out
+func (s *Server) inventoryRequest(ctx context.Context, command model.InventoryCommand, out interface{}) error {
+	body, err := json.Marshal(command)
+	if err != nil {
+		return err
+	}
+
+	reply, err := s.call(ctx, rpcRequest{
+		Exchange:     inventoryExchange,
+		ExchangeType: inventoryExchangeType,
+		RoutingKey:   inventoryRequestKey,
+		ReplyKey:     inventoryReplyKey,
+		Command:      command.Command,
+		Message:      body,
+		Coalesce:     true,
+	})
+	if err != nil {
+		return err
+	}
+	if err = json.Unmarshal(reply, out); err != nil {
+		return fmt.Errorf("Error unmarshaling voyager-inventory-service response: %s", err)
+	}
+	return nil
+}
+
+// Switches returns the switches known to the inventory service
+func (s *Server) Switches(ctx context.Context) ([]model.Switch, error) {
+	switches := []model.Switch{}
+	err := s.inventoryRequest(ctx, model.InventoryCommand{Command: "get_switches"}, &switches)
+	return switches, err
+}
+
+// Switch returns a switch known to the inventory service, failing with a 404 if there is none
+func (s *Server) Switch(ctx context.Context, id string) (model.Switch, error) {
+	switches, err := s.Switches(ctx)
+	if err != nil {
+		return nil, err
+	}
+	for _, sw := range switches {
+		if sw.ID() == id {
+			return sw, nil
+		}
+	}
+	return nil, newAPIError(http.StatusNotFound, fmt.Errorf("Switch %s not found", id))
+}
+
+// SwitchPorts returns the ports of a switch known to the inventory service
+func (s *Server) SwitchPorts(ctx context.Context, id string) ([]model.SwitchPort, error) {
+	if _, err := s.Switch(ctx, id); err != nil {
+		return nil, err
+	}
+
+	ports := []model.SwitchPort{}
+	err := s.inventoryRequest(ctx, model.InventoryCommand{Command: "get_switch_ports", Options: id}, &ports)
+	return ports, err
+}
+
+// Connect records that a NIC of a node is cabled to a switch port, replacing
+// what the NIC was connected to. It fails with a 404 if the switch does not
+// have the port, and a 409 if another NIC is connected to the port.
+func (s *Server) Connect(ctx context.Context, nodeID, nic string, request model.ConnectionRequest) (model.ConnectionEntity, error) {
+	connection := model.ConnectionEntity{}
+	ports, err := s.SwitchPorts(ctx, request.Switch)
+	if err != nil {
+		return connection, err
+	}
+	if !hasPort(ports, request.Port) {
+		return connection, newAPIError(http.StatusNotFound, fmt.Errorf("Port %s not found on switch %s", request.Port, request.Switch))
+	}
+
+	err = s.MySQL.DB.Where("switch_id = ? AND port = ? AND NOT (node_id = ? AND nic = ?)", request.Switch, request.Port, nodeID, nic).
+		First(&connection).Error
+	if err == nil {
+		return connection, newAPIError(http.StatusConflict, fmt.Errorf("Port %s of switch %s is connected to %s of node %s", request.Port, request.Switch, connection.NIC, connection.NodeID))
+	}
+	if err != gorm.ErrRecordNotFound {
+		return connection, err
+	}
+
+	connection = model.ConnectionEntity{}
+	err = s.MySQL.DB.Where(model.ConnectionEntity{NodeID: nodeID, NIC: nic}).
+		Assign(model.ConnectionEntity{SwitchID: request.Switch, Port: request.Port}).
+		FirstOrCreate(&connection).Error
+	if isDuplicateKey(err, "idx_switch_port") {
+		// Another NIC was connected to the port since it was checked
+		return connection, newAPIError(http.StatusConflict, fmt.Errorf("Port %s of switch %s is connected to another NIC", request.Port, request.Switch))
+	}
+	return connection, err
+}
+
+// hasPort reports whether ports include one named name
+func hasPort(ports []model.SwitchPort, name string) bool {
+	for _, port := range ports {
+		if port.Name() == name {
+			return true
+		}
+	}
+	return false
+}
+
+// ListSwitchesHandler Serves GET /switches
+func (s *Server) ListSwitchesHandler(c *gin.Context) {
+	switches, err := s.Switches(requestContext(c))
+	if err != nil {
+		abortWithRPCError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, switches)
+}
+
+// GetSwitchHandler Serves GET /switches/:id
+func (s *Server) GetSwitchHandler(c *gin.Context) {
+	sw, err := s.Switch(requestContext(c), c.Param("id"))
+	if err != nil {
+		abortWithRPCError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, sw)
+}
+
+// ListSwitchPortsHandler Serves GET /switches/:id/ports
+func (s *Server) ListSwitchPortsHandler(c *gin.Context) {
+	ports, err := s.SwitchPorts(requestContext(c), c.Param("id"))
+	if err != nil {
+		abortWithRPCError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, ports)
+}
+
+// PortNodeHandler Serves GET /switches/:id/ports/:port/node, the node NIC
+// connected to a port. Port names often contain slashes, so the route is
+// registered as /switches/:id/ports/*port and answers 404 unless the path
+// ends with /node.
+func (s *Server) PortNodeHandler(c *gin.Context) {
+	path := strings.TrimPrefix(c.Param("port"), "/")
+	if !strings.HasSuffix(path, "/node") {
+		s.NotFoundHandler(c)
+		return
+	}
+	port := strings.TrimSuffix(path, "/node")
+
+	connection := model.ConnectionEntity{}
+	err := s.MySQL.DB.Where("switch_id = ? AND port = ?", c.Param("id"), port).First(&connection).Error
+	if err == gorm.ErrRecordNotFound {
+		abortWithError(c, http.StatusNotFound, fmt.Errorf("Nothing is connected to port %s of switch %s", port, c.Param("id")))
+		return
+	}
+	if err != nil {
+		abortWithError(c, http.StatusInternalServerError, err)
+		return
+	}
+	c.JSON(http.StatusOK, connection)
+}
+
+// ListConnectionsHandler Serves GET /nodes/:id/connections
+func (s *Server) ListConnectionsHandler(c *gin.Context) {
+	connections := []model.ConnectionEntity{}
+	if err := s.MySQL.DB.Where("node_id = ?", c.Param("id")).Order("nic").Find(&connections).Error; err != nil {
+		abortWithError(c, http.StatusInternalServerError, err)
+		return
+	}
+	c.JSON(http.StatusOK, connections)
+}
+
+// PutConnectionHandler Serves PUT /nodes/:id/connections/:nic
+func (s *Server) PutConnectionHandler(c *gin.Context) {
+	request := model.ConnectionRequest{}
+	if !bindJSON(c, &request) {
+		return
+	}
+
+	connection, err := s.Connect(requestContext(c), c.Param("id"), c.Param("nic"), request)
+	if err != nil {
+		abortWithRPCError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, connection)
+}
+
+// DeleteConnectionHandler Serves DELETE /nodes/:id/connections/:nic
+func (s *Server) DeleteConnectionHandler(c *gin.Context) {
+	query := s.MySQL.DB.Where("node_id = ? AND nic = ?", c.Param("id"), c.Param("nic")).Delete(&model.ConnectionEntity{})
+	if query.Error != nil {
+		abortWithError(c, http.StatusInternalServerError, query.Error)
+		return
+	}
+	if query.RowsAffected == 0 {
+		abortWithError(c, http.StatusNotFound, fmt.Errorf("%s of node %s is not connected", c.Param("nic"), c.Param("id")))
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
@@ -0,0 +1,203 @@
+package server
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// certReloadInterval is how often certificate files are checked for changes
+const certReloadInterval = 10 * time.Second
+
+// CertReloader holds a certificate/key pair and an optional CA bundle loaded
+// from disk, and reloads them when the files change.
+type CertReloader struct {
+	certFile string
+	keyFile  string
+	caFile   string
+
+	mu       sync.RWMutex
+	cert     *tls.Certificate
+	caPool   *x509.CertPool
+	modTimes map[string]time.Time
+	onReload []func()
+}
+
+// NewCertReloader loads the given files. certFile/keyFile and caFile are each optional.
+func NewCertReloader(certFile, keyFile, caFile string) (*CertReloader, error) {
+	if (certFile == "") != (keyFile == "") {
+		return nil, fmt.Errorf("certificate and key must be given together")
+	}
+
+	r := &CertReloader{
+		certFile: certFile,
+		keyFile:  keyFile,
+		caFile:   caFile,
+	}
+	if err := r.Reload(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// Reload reads the files from disk. The previous certificates are kept if any file is invalid.
+func (r *CertReloader) Reload() error {
+	modTimes, err := r.stat()
+	if err != nil {
+		return err
+	}
+
+	var cert *tls.Certificate
+	if r.certFile != "" {
+		pair, err := tls.LoadX509KeyPair(r.certFile, r.keyFile)
+		if err != nil {
+			return err
+		}
+		cert = &pair
+	}
+
+	var caPool *x509.CertPool
+	if r.caFile != "" {
+		pem, err := ioutil.ReadFile(r.caFile)
+		if err != nil {
+			return err
+		}
+		caPool = x509.NewCertPool()
+		if !caPool.AppendCertsFromPEM(pem) {
+			return fmt.Errorf("no certificates found in %s", r.caFile)
+		}
+	}
+
+	r.mu.Lock()
+	r.cert = cert
+	r.caPool = caPool
+	r.modTimes = modTimes
+	r.mu.Unlock()
+	return nil
+}
+
+// Changed reports whether any of the files were modified since they were last loaded
+func (r *CertReloader) Changed() bool {
+	modTimes, err := r.stat()
+	if err != nil {
+		return false
+	}
+
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	for name, modTime := range modTimes {
+		if !modTime.Equal(r.modTimes[name]) {
+			return true
+		}
+	}
+	return false
+}
+
+// OnReload registers f to be called after the files have been reloaded by Watch
+func (r *CertReloader) OnReload(f func()) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.onReload = append(r.onReload, f)
+}
+
+// Watch checks the files for changes every interval and reloads them. It never returns.
+func (r *CertReloader) Watch(interval time.Duration) {
+	for range time.Tick(interval) {
+		if !r.Changed() {
+			continue
+		}
+
+		if err := r.Reload(); err != nil {
+			log.Warnf("Could not reload certificates: %s", err)
+			continue
+		}
+		log.Info("Reloaded certificates")
+
+		r.mu.RLock()
+		callbacks := r.onReload
+		r.mu.RUnlock()
+		for _, f := range callbacks {
+			f()
+		}
+	}
+}
+
+// GetCertificate returns the current certificate, for use in tls.Config
+func (r *CertReloader) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	if r.cert == nil {
+		return nil, fmt.Errorf("no certificate loaded")
+	}
+	return r.cert, nil
+}
+
+// GetClientCertificate returns the current certificate, or an empty one when
+// none is configured so the handshake proceeds without a client certificate.
+func (r *CertReloader) GetClientCertificate(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	if r.cert == nil {
+		return &tls.Certificate{}, nil
+	}
+	return r.cert, nil
+}
+
+// CAPool returns the current CA bundle, or nil when none is configured
+func (r *CertReloader) CAPool() *x509.CertPool {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.caPool
+}
+
+// ServerConfig returns a tls.Config for the HTTP API. Client certificates are
+// required and verified against the CA bundle when one is configured.
+func (r *CertReloader) ServerConfig() *tls.Config {
+	config := &tls.Config{
+		MinVersion:     tls.VersionTLS12,
+		GetCertificate: r.GetCertificate,
+	}
+
+	if r.caFile != "" {
+		config.GetConfigForClient = func(*tls.ClientHelloInfo) (*tls.Config, error) {
+			return &tls.Config{
+				MinVersion:     tls.VersionTLS12,
+				GetCertificate: r.GetCertificate,
+				ClientAuth:     tls.RequireAndVerifyClientCert,
+				ClientCAs:      r.CAPool(),
+			}, nil
+		}
+	}
+	return config
+}
+
+// ClientConfig returns a tls.Config for connecting to RabbitMQ with the
+// current CA bundle and client certificate
+func (r *CertReloader) ClientConfig() *tls.Config {
+	return &tls.Config{
+		MinVersion:           tls.VersionTLS12,
+		RootCAs:              r.CAPool(),
+		GetClientCertificate: r.GetClientCertificate,
+	}
+}
+
+func (r *CertReloader) stat() (map[string]time.Time, error) {
+	modTimes := map[string]time.Time{}
+	for _, name := range []string{r.certFile, r.keyFile, r.caFile} {
+		if name == "" {
+			continue
+		}
+		info, err := os.Stat(name)
+		if err != nil {
+			return nil, err
+		}
+		modTimes[name] = info.ModTime()
+	}
+	return modTimes, nil
+}
@@ -0,0 +1,120 @@
+package server_test
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"path/filepath"
+	"time"
+
+	. "github.com/RackHD/voyager-houston/server"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+// writeCert writes a self-signed certificate and its key to dir and returns the file names
+func writeCert(dir, commonName string) (string, string) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	Expect(err).ToNot(HaveOccurred())
+
+	template := x509.Certificate{
+		SerialNumber:          big.NewInt(time.Now().UnixNano()),
+		Subject:               pkix.Name{CommonName: commonName},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, &template, &template, &key.PublicKey, key)
+	Expect(err).ToNot(HaveOccurred())
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	Expect(err).ToNot(HaveOccurred())
+
+	certFile := filepath.Join(dir, "cert.pem")
+	keyFile := filepath.Join(dir, "key.pem")
+	err = ioutil.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600)
+	Expect(err).ToNot(HaveOccurred())
+	err = ioutil.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0600)
+	Expect(err).ToNot(HaveOccurred())
+	return certFile, keyFile
+}
+
+var _ = Describe("CertReloader", func() {
+	var dir string
+
+	BeforeEach(func() {
+		var err error
+		dir, err = ioutil.TempDir("", "houston-tls")
+		Expect(err).ToNot(HaveOccurred())
+	})
+
+	AfterEach(func() {
+		os.RemoveAll(dir)
+	})
+
+	It("UNIT should load a certificate, key and CA bundle", func() {
+		certFile, keyFile := writeCert(dir, "houston")
+
+		certs, err := NewCertReloader(certFile, keyFile, certFile)
+		Expect(err).ToNot(HaveOccurred())
+
+		cert, err := certs.GetCertificate(nil)
+		Expect(err).ToNot(HaveOccurred())
+		leaf, err := x509.ParseCertificate(cert.Certificate[0])
+		Expect(err).ToNot(HaveOccurred())
+		Expect(leaf.Subject.CommonName).To(Equal("houston"))
+		Expect(certs.CAPool()).ToNot(BeNil())
+		Expect(certs.ServerConfig().GetConfigForClient).ToNot(BeNil())
+	})
+
+	It("UNIT should require the certificate and key together", func() {
+		certFile, _ := writeCert(dir, "houston")
+
+		_, err := NewCertReloader(certFile, "", "")
+		Expect(err).To(HaveOccurred())
+	})
+
+	It("UNIT should pick up a replaced certificate", func() {
+		certFile, keyFile := writeCert(dir, "before")
+		certs, err := NewCertReloader(certFile, keyFile, "")
+		Expect(err).ToNot(HaveOccurred())
+		Expect(certs.Changed()).To(BeFalse())
+
+		writeCert(dir, "after")
+		later := time.Now().Add(time.Minute)
+		Expect(os.Chtimes(certFile, later, later)).To(Succeed())
+		Expect(os.Chtimes(keyFile, later, later)).To(Succeed())
+		Expect(certs.Changed()).To(BeTrue())
+
+		Expect(certs.Reload()).To(Succeed())
+		Expect(certs.Changed()).To(BeFalse())
+		cert, err := certs.GetCertificate(nil)
+		Expect(err).ToNot(HaveOccurred())
+		leaf, err := x509.ParseCertificate(cert.Certificate[0])
+		Expect(err).ToNot(HaveOccurred())
+		Expect(leaf.Subject.CommonName).To(Equal("after"))
+	})
+
+	It("UNIT should keep the previous certificate when the new one is invalid", func() {
+		certFile, keyFile := writeCert(dir, "before")
+		certs, err := NewCertReloader(certFile, keyFile, "")
+		Expect(err).ToNot(HaveOccurred())
+
+		Expect(ioutil.WriteFile(certFile, []byte("garbage"), 0600)).To(Succeed())
+		Expect(certs.Reload()).ToNot(Succeed())
+
+		cert, err := certs.GetCertificate(nil)
+		Expect(err).ToNot(HaveOccurred())
+		leaf, err := x509.ParseCertificate(cert.Certificate[0])
+		Expect(err).ToNot(HaveOccurred())
+		Expect(leaf.Subject.CommonName).To(Equal("before"))
+	})
+})
@@ -0,0 +1,471 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/RackHD/voyager-houston/model"
+	"github.com/RackHD/voyager-utilities/random"
+	"github.com/gin-gonic/gin"
+	"github.com/jinzhu/gorm"
+)
+
+// count returns the number of entities of value's type matching query. It
+// responds with a 500 and returns false if that fails.
+func (s *Server) count(c *gin.Context, value interface{}, query string, args ...interface{}) (int, bool) {
+	n := 0
+	if err := s.MySQL.DB.Model(value).Where(query, args...).Count(&n).Error; err != nil {
+		abortWithError(c, http.StatusInternalServerError, err)
+		return 0, false
+	}
+	return n, true
+}
+
+// store inserts value if it was just created and updates it otherwise. It responds
+// with a 500 and returns false if that fails.
+func (s *Server) store(c *gin.Context, value interface{}, created bool) bool {
+	query := s.MySQL.DB.Save
+	if created {
+		// Save would update the entity, as its ID is set
+		query = s.MySQL.DB.Create
+	}
+	if err := query(value).Error; err != nil {
+		abortWithError(c, http.StatusInternalServerError, err)
+		return false
+	}
+	// Moving a rack or room moves the nodes in it
+	s.nodeData.invalidate()
+	return true
+}
+
+// ListSitesHandler Serves GET /sites
+func (s *Server) ListSitesHandler(c *gin.Context) {
+	sites := []model.SiteEntity{}
+	if err := s.MySQL.DB.Order("name").Find(&sites).Error; err != nil {
+		abortWithError(c, http.StatusInternalServerError, err)
+		return
+	}
+	c.JSON(http.StatusOK, sites)
+}
+
+// GetSiteHandler Serves GET /sites/:id
+func (s *Server) GetSiteHandler(c *gin.Context) {
+	site := model.SiteEntity{}
+	if !s.find(c, &site, c.Param("id")) {
+		return
+	}
+	c.JSON(http.StatusOK, site)
+}
+
+// CreateSiteHandler Serves POST /sites
+func (s *Server) CreateSiteHandler(c *gin.Context) {
+	request := model.SiteRequest{}
+	if !bindJSON(c, &request) {
+		return
+	}
+	s.saveSite(c, model.SiteEntity{ID: random.RandQueue()}, request, http.StatusCreated)
+}
+
+// UpdateSiteHandler Serves PUT /sites/:id
+func (s *Server) UpdateSiteHandler(c *gin.Context) {
+	request := model.SiteRequest{}
+	if !bindJSON(c, &request) {
+		return
+	}
+	site := model.SiteEntity{}
+	if !s.find(c, &site, c.Param("id")) {
+		return
+	}
+	s.saveSite(c, site, request, http.StatusOK)
+}
+
+// saveSite stores site as request describes it, unless another site has its name
+func (s *Server) saveSite(c *gin.Context, site model.SiteEntity, request model.SiteRequest, status int) {
+	taken, ok := s.count(c, &model.SiteEntity{}, "name = ? AND id <> ?", request.Name, site.ID)
+	if !ok {
+		return
+	}
+	if taken > 0 {
+		abortWithError(c, http.StatusConflict, fmt.Errorf("A site named %s already exists", request.Name))
+		return
+	}
+
+	site.Name, site.Description = request.Name, request.Description
+	if !s.store(c, &site, status == http.StatusCreated) {
+		return
+	}
+	c.JSON(status, site)
+}
+
+// DeleteSiteHandler Serves DELETE /sites/:id. Sites with rooms cannot be deleted.
+func (s *Server) DeleteSiteHandler(c *gin.Context) {
+	site := model.SiteEntity{}
+	if !s.find(c, &site, c.Param("id")) {
+		return
+	}
+	rooms, ok := s.count(c, &model.RoomEntity{}, "site_id = ?", site.ID)
+	if !ok {
+		return
+	}
+	if rooms > 0 {
+		abortWithError(c, http.StatusConflict, fmt.Errorf("Site %s still has %d rooms", site.ID, rooms))
+		return
+	}
+
+	if err := s.MySQL.DB.Delete(&site).Error; err != nil {
+		abortWithError(c, http.StatusInternalServerError, err)
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
+
+// ListRoomsHandler Serves GET /rooms, optionally filtered by ?site=
+func (s *Server) ListRoomsHandler(c *gin.Context) {
+	query := s.MySQL.DB
+	if site := c.Query("site"); site != "" {
+		query = query.Where("site_id = ?", site)
+	}
+
+	rooms := []model.RoomEntity{}
+	if err := query.Order("name").Find(&rooms).Error; err != nil {
+		abortWithError(c, http.StatusInternalServerError, err)
+		return
+	}
+	c.JSON(http.StatusOK, rooms)
+}
+
+// GetRoomHandler Serves GET /rooms/:id
+func (s *Server) GetRoomHandler(c *gin.Context) {
+	room := model.RoomEntity{}
+	if !s.find(c, &room, c.Param("id")) {
+		return
+	}
+	c.JSON(http.StatusOK, room)
+}
+
+// CreateRoomHandler Serves POST /rooms
+func (s *Server) CreateRoomHandler(c *gin.Context) {
+	request := model.RoomRequest{}
+	if !bindJSON(c, &request) {
+		return
+	}
+	s.saveRoom(c, model.RoomEntity{ID: random.RandQueue()}, request, http.StatusCreated)
+}
+
+// UpdateRoomHandler Serves PUT /rooms/:id
+func (s *Server) UpdateRoomHandler(c *gin.Context) {
+	request := model.RoomRequest{}
+	if !bindJSON(c, &request) {
+		return
+	}
+	room := model.RoomEntity{}
+	if !s.find(c, &room, c.Param("id")) {
+		return
+	}
+	s.saveRoom(c, room, request, http.StatusOK)
+}
+
+// saveRoom stores room as request describes it, unless its site does not
+// exist or has another room with its name
+func (s *Server) saveRoom(c *gin.Context, room model.RoomEntity, request model.RoomRequest, status int) {
+	site := model.SiteEntity{}
+	if !s.find(c, &site, request.Site) {
+		return
+	}
+	taken, ok := s.count(c, &model.RoomEntity{}, "site_id = ? AND name = ? AND id <> ?", site.ID, request.Name, room.ID)
+	if !ok {
+		return
+	}
+	if taken > 0 {
+		abortWithError(c, http.StatusConflict, fmt.Errorf("Site %s already has a room named %s", site.ID, request.Name))
+		return
+	}
+
+	room.SiteID, room.Name = site.ID, request.Name
+	if !s.store(c, &room, status == http.StatusCreated) {
+		return
+	}
+	c.JSON(status, room)
+}
+
+// DeleteRoomHandler Serves DELETE /rooms/:id. Rooms with racks cannot be deleted.
+func (s *Server) DeleteRoomHandler(c *gin.Context) {
+	room := model.RoomEntity{}
+	if !s.find(c, &room, c.Param("id")) {
+		return
+	}
+	racks, ok := s.count(c, &model.RackEntity{}, "room_id = ?", room.ID)
+	if !ok {
+		return
+	}
+	if racks > 0 {
+		abortWithError(c, http.StatusConflict, fmt.Errorf("Room %s still has %d racks", room.ID, racks))
+		return
+	}
+
+	if err := s.MySQL.DB.Delete(&room).Error; err != nil {
+		abortWithError(c, http.StatusInternalServerError, err)
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
+
+// ListRacksHandler Serves GET /racks, optionally filtered by ?room=
+func (s *Server) ListRacksHandler(c *gin.Context) {
+	query := s.MySQL.DB
+	if room := c.Query("room"); room != "" {
+		query = query.Where("room_id = ?", room)
+	}
+
+	racks := []model.RackEntity{}
+	if err := query.Order("name").Find(&racks).Error; err != nil {
+		abortWithError(c, http.StatusInternalServerError, err)
+		return
+	}
+	c.JSON(http.StatusOK, racks)
+}
+
+// GetRackHandler Serves GET /racks/:id
+func (s *Server) GetRackHandler(c *gin.Context) {
+	rack := model.RackEntity{}
+	if !s.find(c, &rack, c.Param("id")) {
+		return
+	}
+	c.JSON(http.StatusOK, rack)
+}
+
+// CreateRackHandler Serves POST /racks
+func (s *Server) CreateRackHandler(c *gin.Context) {
+	request := model.RackRequest{}
+	if !bindJSON(c, &request) {
+		return
+	}
+	s.saveRack(c, model.RackEntity{ID: random.RandQueue()}, request, http.StatusCreated)
+}
+
+// UpdateRackHandler Serves PUT /racks/:id
+func (s *Server) UpdateRackHandler(c *gin.Context) {
+	request := model.RackRequest{}
+	if !bindJSON(c, &request) {
+		return
+	}
+	rack := model.RackEntity{}
+	if !s.find(c, &rack, c.Param("id")) {
+		return
+	}
+	s.saveRack(c, rack, request, http.StatusOK)
+}
+
+// saveRack stores rack as request describes it, unless its room does not
+// exist or has another rack with its name, or nodes sit above its new height
+func (s *Server) saveRack(c *gin.Context, rack model.RackEntity, request model.RackRequest, status int) {
+	if request.Height == 0 {
+		request.Height = model.DefaultRackHeight
+	}
+
+	room := model.RoomEntity{}
+	if !s.find(c, &room, request.Room) {
+		return
+	}
+	taken, ok := s.count(c, &model.RackEntity{}, "room_id = ? AND name = ? AND id <> ?", room.ID, request.Name, rack.ID)
+	if !ok {
+		return
+	}
+	if taken > 0 {
+		abortWithError(c, http.StatusConflict, fmt.Errorf("Room %s already has a rack named %s", room.ID, request.Name))
+		return
+	}
+	above, ok := s.count(c, &model.PlacementEntity{}, "rack_id = ? AND position + height - 1 > ?", rack.ID, request.Height)
+	if !ok {
+		return
+	}
+	if above > 0 {
+		abortWithError(c, http.StatusConflict, fmt.Errorf("Rack %s has %d nodes above unit %d", rack.ID, above, request.Height))
+		return
+	}
+
+	rack.RoomID, rack.Name, rack.Height = room.ID, request.Name, request.Height
+	if !s.store(c, &rack, status == http.StatusCreated) {
+		return
+	}
+	c.JSON(status, rack)
+}
+
+// DeleteRackHandler Serves DELETE /racks/:id. Racks with nodes cannot be deleted.
+func (s *Server) DeleteRackHandler(c *gin.Context) {
+	rack := model.RackEntity{}
+	if !s.find(c, &rack, c.Param("id")) {
+		return
+	}
+	nodes, ok := s.count(c, &model.PlacementEntity{}, "rack_id = ?", rack.ID)
+	if !ok {
+		return
+	}
+	if nodes > 0 {
+		abortWithError(c, http.StatusConflict, fmt.Errorf("Rack %s still has %d nodes", rack.ID, nodes))
+		return
+	}
+
+	if err := s.MySQL.DB.Delete(&rack).Error; err != nil {
+		abortWithError(c, http.StatusInternalServerError, err)
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
+
+// ListRackPlacementsHandler Serves GET /racks/:id/placements, the nodes in a
+// rack from the bottom up
+func (s *Server) ListRackPlacementsHandler(c *gin.Context) {
+	rack := model.RackEntity{}
+	if !s.find(c, &rack, c.Param("id")) {
+		return
+	}
+
+	placements := []model.PlacementEntity{}
+	if err := s.MySQL.DB.Where("rack_id = ?", rack.ID).Order("position").Find(&placements).Error; err != nil {
+		abortWithError(c, http.StatusInternalServerError, err)
+		return
+	}
+	c.JSON(http.StatusOK, placements)
+}
+
+// PlaceNode puts a node in a rack, moving it if it was placed elsewhere. It
+// fails with a 404 if the rack does not exist, a 400 if the units are not in
+// the rack and a 409 if another node takes up any of them.
+func (s *Server) PlaceNode(nodeID string, request model.PlacementRequest) (model.PlacementEntity, error) {
+	if request.Height == 0 {
+		request.Height = 1
+	}
+	placement := model.PlacementEntity{NodeID: nodeID, RackID: request.Rack, Position: request.Position, Height: request.Height}
+
+	defer s.nodeData.invalidate()
+	tx := s.MySQL.DB.Begin()
+	// Locking the rack checks concurrent placements in it one at a time
+	rack := model.RackEntity{}
+	err := tx.Set("gorm:query_option", "FOR UPDATE").Where("id = ?", request.Rack).First(&rack).Error
+	if err == gorm.ErrRecordNotFound {
+		tx.Rollback()
+		return placement, newAPIError(http.StatusNotFound, fmt.Errorf("%s not found", request.Rack))
+	}
+	if err != nil {
+		tx.Rollback()
+		return placement, err
+	}
+	if placement.Top() > rack.Height {
+		tx.Rollback()
+		return placement, newAPIError(http.StatusBadRequest, fmt.Errorf("Units %d-%d are not in rack %s, which is %dU high", placement.Position, placement.Top(), rack.ID, rack.Height))
+	}
+
+	occupant := model.PlacementEntity{}
+	err = tx.Where("rack_id = ? AND node_id <> ? AND position <= ? AND position + height - 1 >= ?", rack.ID, nodeID, placement.Top(), placement.Position).
+		First(&occupant).Error
+	if err == nil {
+		tx.Rollback()
+		return placement, newAPIError(http.StatusConflict, fmt.Errorf("Node %s takes up units %d-%d of rack %s", occupant.NodeID, occupant.Position, occupant.Top(), rack.ID))
+	}
+	if err != gorm.ErrRecordNotFound {
+		tx.Rollback()
+		return placement, err
+	}
+
+	err = tx.Where(model.PlacementEntity{NodeID: nodeID}).
+		Assign(model.PlacementEntity{RackID: rack.ID, Position: placement.Position, Height: placement.Height}).
+		FirstOrCreate(&placement).Error
+	if err != nil {
+		tx.Rollback()
+		return placement, err
+	}
+	return placement, tx.Commit().Error
+}
+
+// locateNodes adds the location of each node placed in a rack to it
+func (s *Server) locateNodes(nodes []model.Node) error {
+	if s.MySQL == nil {
+		return nil
+	}
+
+	placements := []model.PlacementEntity{}
+	racks := []model.RackEntity{}
+	rooms := []model.RoomEntity{}
+	for _, table := range []interface{}{&placements, &racks, &rooms} {
+		if err := s.MySQL.DB.Find(table).Error; err != nil {
+			return err
+		}
+	}
+
+	siteOf := map[string]string{}
+	for _, room := range rooms {
+		siteOf[room.ID] = room.SiteID
+	}
+	roomOf := map[string]string{}
+	for _, rack := range racks {
+		roomOf[rack.ID] = rack.RoomID
+	}
+	locations := map[string]model.Location{}
+	for _, p := range placements {
+		room := roomOf[p.RackID]
+		locations[p.NodeID] = model.Location{Site: siteOf[room], Room: room, Rack: p.RackID, Position: p.Position, Height: p.Height}
+	}
+
+	for _, node := range nodes {
+		if location, ok := locations[node.ID()]; ok {
+			node["location"] = location
+		}
+	}
+	return nil
+}
+
+// locatedIn reports whether a node is in the site, room and rack given,
+// ignoring those that are empty
+func locatedIn(node model.Node, site, room, rack string) bool {
+	if site == "" && room == "" && rack == "" {
+		return true
+	}
+	location, ok := node["location"].(model.Location)
+	return ok && (site == "" || location.Site == site) && (room == "" || location.Room == room) && (rack == "" || location.Rack == rack)
+}
+
+// GetPlacementHandler Serves GET /nodes/:id/placement
+func (s *Server) GetPlacementHandler(c *gin.Context) {
+	placement := model.PlacementEntity{}
+	err := s.MySQL.DB.Where("node_id = ?", c.Param("id")).First(&placement).Error
+	if err == gorm.ErrRecordNotFound {
+		abortWithError(c, http.StatusNotFound, fmt.Errorf("Node %s is not placed in a rack", c.Param("id")))
+		return
+	}
+	if err != nil {
+		abortWithError(c, http.StatusInternalServerError, err)
+		return
+	}
+	c.JSON(http.StatusOK, placement)
+}
+
+// PutPlacementHandler Serves PUT /nodes/:id/placement
+func (s *Server) PutPlacementHandler(c *gin.Context) {
+	request := model.PlacementRequest{}
+	if !bindJSON(c, &request) {
+		return
+	}
+
+	placement, err := s.PlaceNode(c.Param("id"), request)
+	if err != nil {
+		abortWithRPCError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, placement)
+}
+
+// DeletePlacementHandler Serves DELETE /nodes/:id/placement, taking the node out of its rack
+func (s *Server) DeletePlacementHandler(c *gin.Context) {
+	query := s.MySQL.DB.Where("node_id = ?", c.Param("id")).Delete(&model.PlacementEntity{})
+	if query.Error != nil {
+		abortWithError(c, http.StatusInternalServerError, query.Error)
+		return
+	}
+	if query.RowsAffected == 0 {
+		abortWithError(c, http.StatusNotFound, fmt.Errorf("Node %s is not placed in a rack", c.Param("id")))
+		return
+	}
+	s.nodeData.invalidate()
+	c.Status(http.StatusNoContent)
+}
@@ -0,0 +1,315 @@
+package server
+
+import (
+	"fmt"
+	"math"
+	"math/big"
+	"net/http"
+	"net/netip"
+	"sort"
+	"time"
+
+	"github.com/RackHD/voyager-houston/model"
+	"github.com/RackHD/voyager-utilities/models"
+	"github.com/gin-gonic/gin"
+	log "github.com/sirupsen/logrus"
+)
+
+// Usage gauges, refreshed when /metrics is scraped
+const (
+	subnetAddressesGauge  = "houston_subnet_addresses"
+	subnetFreeBlockGauge  = "houston_subnet_largest_free_block"
+	poolAddressesGauge    = "houston_pool_addresses"
+	poolFreeBlockGauge    = "houston_pool_largest_free_block"
+	subnetAddressesHelp   = "Addresses of a subnet, by state"
+	subnetFreeBlockHelp   = "Longest run of free addresses in a subnet"
+	poolAddressesHelp     = "Addresses of the subnets of a pool, by state"
+	poolFreeBlockHelp     = "Longest run of free addresses in any subnet of a pool"
+	allocatedAddressState = "allocated"
+	reservedAddressState  = "reserved"
+	freeAddressState      = "free"
+)
+
+// addrInt returns addr as an integer
+func addrInt(addr netip.Addr) *big.Int {
+	return new(big.Int).SetBytes(addr.AsSlice())
+}
+
+// intAddr returns the address of the given family that n is the integer of
+func intAddr(n *big.Int, is4 bool) netip.Addr {
+	b := make([]byte, 16)
+	if is4 {
+		b = b[:4]
+	}
+	addr, _ := netip.AddrFromSlice(n.FillBytes(b))
+	return addr
+}
+
+// saturatingCount returns n as a uint64, or the largest uint64 if it is larger
+func saturatingCount(n *big.Int) uint64 {
+	if !n.IsUint64() {
+		return math.MaxUint64
+	}
+	return n.Uint64()
+}
+
+// addCounts adds a and b, or returns the largest uint64 if the sum is larger
+func addCounts(a, b uint64) uint64 {
+	if a > math.MaxUint64-b {
+		return math.MaxUint64
+	}
+	return a + b
+}
+
+// subnetUsage counts the addresses of a subnet's range that leases hold, and
+// those reservations hold before their node is known
+func subnetUsage(r model.SubnetRangeEntity, leases []model.LeaseEntity, reservations []model.ReservationEntity) (model.Usage, error) {
+	first, err := netip.ParseAddr(r.Start)
+	if err != nil {
+		return model.Usage{}, err
+	}
+	last, err := netip.ParseAddr(r.End)
+	if err != nil {
+		return model.Usage{}, err
+	}
+	start, end := addrInt(first), addrInt(last)
+	size := new(big.Int).Sub(end, start)
+	size.Add(size, big.NewInt(1))
+
+	total := size
+	if r.Prefix != "" {
+		p, err := netip.ParsePrefix(r.Prefix)
+		if err != nil {
+			return model.Usage{}, err
+		}
+		total = new(big.Int).Lsh(big.NewInt(1), uint(p.Addr().BitLen()-p.Bits()))
+	}
+
+	// Addresses held in the range, each once, in order
+	held := []*big.Int{}
+	seen := map[netip.Addr]bool{}
+	hold := func(address string) bool {
+		addr, err := netip.ParseAddr(address)
+		if err != nil {
+			return false
+		}
+		addr = addr.Unmap()
+		if seen[addr] || addr.Less(first) || last.Less(addr) {
+			return false
+		}
+		seen[addr] = true
+		held = append(held, addrInt(addr))
+		return true
+	}
+	allocated := new(big.Int)
+	for _, lease := range leases {
+		if hold(lease.Address) {
+			allocated.Add(allocated, big.NewInt(1))
+		}
+	}
+	unassigned := new(big.Int)
+	for _, reservation := range reservations {
+		if hold(reservation.Address) {
+			unassigned.Add(unassigned, big.NewInt(1))
+		}
+	}
+	sort.Slice(held, func(i, j int) bool { return held[i].Cmp(held[j]) < 0 })
+
+	largest, largestStart := new(big.Int), (*big.Int)(nil)
+	next := start
+	for _, addr := range append(held, new(big.Int).Add(end, big.NewInt(1))) {
+		if gap := new(big.Int).Sub(addr, next); gap.Cmp(largest) > 0 {
+			largest, largestStart = gap, next
+		}
+		next = new(big.Int).Add(addr, big.NewInt(1))
+	}
+
+	reserved := new(big.Int).Sub(total, size)
+	free := new(big.Int).Sub(size, allocated)
+	usage := model.Usage{
+		Total:            saturatingCount(total),
+		Allocated:        saturatingCount(allocated),
+		Reserved:         saturatingCount(reserved.Add(reserved, unassigned)),
+		Free:             saturatingCount(free.Sub(free, unassigned)),
+		LargestFreeBlock: saturatingCount(largest),
+	}
+	if largestStart != nil {
+		usage.LargestFreeStart = intAddr(largestStart, first.Is4()).String()
+	}
+	return usage, nil
+}
+
+// usage counts the addresses of subnets, returning the IDs of subnets
+// Houston recorded no range for separately
+func (s *Server) usage(entities ...models.SubnetEntity) ([]model.SubnetUsage, []string, error) {
+	subnets, err := s.subnetRanges(entities...)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	ids := []string{}
+	for _, subnet := range subnets {
+		ids = append(ids, subnet.ID)
+	}
+	leases := []model.LeaseEntity{}
+	if len(ids) > 0 {
+		if err = s.MySQL.DB.Where("subnet_id IN (?)", ids).Find(&leases).Error; err != nil {
+			return nil, nil, err
+		}
+	}
+	reservations := []model.ReservationEntity{}
+	if len(ids) > 0 {
+		if err = s.MySQL.DB.Where("subnet_id IN (?)", ids).Find(&reservations).Error; err != nil {
+			return nil, nil, err
+		}
+	}
+	bySubnet, leased := map[string][]model.LeaseEntity{}, map[string]bool{}
+	for _, lease := range leases {
+		bySubnet[lease.SubnetID] = append(bySubnet[lease.SubnetID], lease)
+		leased[lease.ID] = true
+	}
+	unassigned := map[string][]model.ReservationEntity{}
+	for _, reservation := range reservations {
+		if !leased[reservation.LeaseID] {
+			unassigned[reservation.SubnetID] = append(unassigned[reservation.SubnetID], reservation)
+		}
+	}
+
+	usages, unranged := []model.SubnetUsage{}, []string{}
+	for _, subnet := range subnets {
+		if subnet.SubnetRangeEntity == nil {
+			unranged = append(unranged, subnet.ID)
+			continue
+		}
+		usage, err := subnetUsage(*subnet.SubnetRangeEntity, bySubnet[subnet.ID], unassigned[subnet.ID])
+		if err != nil {
+			return nil, nil, fmt.Errorf("Subnet %s: %s", subnet.ID, err)
+		}
+		usages = append(usages, model.SubnetUsage{Subnet: subnet.ID, Pool: subnet.PoolID, Usage: usage})
+	}
+	return usages, unranged, nil
+}
+
+// poolUsage adds up the usage of the subnets of a pool
+func poolUsage(pool string, subnets []model.SubnetUsage, unranged []string) model.PoolUsage {
+	usage := model.PoolUsage{Pool: pool, Subnets: []model.SubnetUsage{}}
+	for _, subnet := range subnets {
+		if subnet.Pool != pool {
+			continue
+		}
+		usage.Subnets = append(usage.Subnets, subnet)
+		usage.Total = addCounts(usage.Total, subnet.Total)
+		usage.Allocated = addCounts(usage.Allocated, subnet.Allocated)
+		usage.Reserved = addCounts(usage.Reserved, subnet.Reserved)
+		usage.Free = addCounts(usage.Free, subnet.Free)
+		if subnet.LargestFreeBlock > usage.LargestFreeBlock {
+			usage.LargestFreeBlock, usage.LargestFreeStart = subnet.LargestFreeBlock, subnet.LargestFreeStart
+		}
+	}
+	if len(unranged) > 0 {
+		usage.Unranged = unranged
+	}
+	return usage
+}
+
+// PoolUsageHandler Serves GET /pools/:id/usage
+func (s *Server) PoolUsageHandler(c *gin.Context) {
+	pool := models.PoolEntity{}
+	if !s.find(c, &pool, c.Param("id")) {
+		return
+	}
+
+	entities := []models.SubnetEntity{}
+	if err := s.MySQL.DB.Where("pool_id = ?", pool.ID).Find(&entities).Error; err != nil {
+		abortWithError(c, http.StatusInternalServerError, err)
+		return
+	}
+	subnets, unranged, err := s.usage(entities...)
+	if err != nil {
+		abortWithError(c, http.StatusInternalServerError, err)
+		return
+	}
+	c.JSON(http.StatusOK, poolUsage(pool.ID, subnets, unranged))
+}
+
+// SubnetUsageHandler Serves GET /subnets/:id/usage
+func (s *Server) SubnetUsageHandler(c *gin.Context) {
+	entity := models.SubnetEntity{}
+	if !s.find(c, &entity, c.Param("id")) {
+		return
+	}
+
+	subnets, _, err := s.usage(entity)
+	if err != nil {
+		abortWithError(c, http.StatusInternalServerError, err)
+		return
+	}
+	if len(subnets) == 0 {
+		abortWithError(c, http.StatusConflict, fmt.Errorf("Houston has no range recorded for subnet %s", entity.ID))
+		return
+	}
+	c.JSON(http.StatusOK, subnets[0])
+}
+
+// updateUsageGauges sets the usage gauges to the usage of every subnet and pool
+func (s *Server) updateUsageGauges() error {
+	entities := []models.SubnetEntity{}
+	if err := s.MySQL.DB.Find(&entities).Error; err != nil {
+		return err
+	}
+	subnets, _, err := s.usage(entities...)
+	if err != nil {
+		return err
+	}
+
+	for _, name := range []string{subnetAddressesGauge, subnetFreeBlockGauge, poolAddressesGauge, poolFreeBlockGauge} {
+		s.metrics.reset(name)
+	}
+	pools := map[string]bool{}
+	for _, subnet := range subnets {
+		pools[subnet.Pool] = true
+		setAddressGauges(&s.metrics, subnetAddressesGauge, subnetAddressesHelp, labels{"pool": subnet.Pool, "subnet": subnet.Subnet}, subnet.Usage)
+		s.metrics.set(subnetFreeBlockGauge, subnetFreeBlockHelp, labels{"pool": subnet.Pool, "subnet": subnet.Subnet}, float64(subnet.LargestFreeBlock))
+	}
+	for pool := range pools {
+		usage := poolUsage(pool, subnets, nil)
+		setAddressGauges(&s.metrics, poolAddressesGauge, poolAddressesHelp, labels{"pool": pool}, usage.Usage)
+		s.metrics.set(poolFreeBlockGauge, poolFreeBlockHelp, labels{"pool": pool}, float64(usage.LargestFreeBlock))
+	}
+	return nil
+}
+
+// setAddressGauges sets one series of an address gauge for each state
+func setAddressGauges(r *registry, name, help string, l labels, usage model.Usage) {
+	for state, value := range map[string]uint64{
+		allocatedAddressState: usage.Allocated,
+		reservedAddressState:  usage.Reserved,
+		freeAddressState:      usage.Free,
+	} {
+		series := labels{"state": state}
+		for k, v := range l {
+			series[k] = v
+		}
+		r.set(name, help, series, float64(value))
+	}
+}
+
+// refreshUsageGauges updates the usage gauges before /metrics is rendered if
+// they are older than UsageGaugeMaxAge, keeping the last values if MySQL
+// cannot be read
+func (s *Server) refreshUsageGauges() {
+	if s.MySQL == nil || s.MySQL.DB == nil {
+		return
+	}
+
+	s.usageMu.Lock()
+	defer s.usageMu.Unlock()
+	if !s.usageUpdated.IsZero() && time.Since(s.usageUpdated) < s.Config.UsageGaugeMaxAge {
+		return
+	}
+	if err := s.updateUsageGauges(); err != nil {
+		log.Warnf("Could not update address usage gauges: %s", err)
+		return
+	}
+	s.usageUpdated = time.Now()
+}
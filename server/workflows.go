@@ -0,0 +1,221 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/RackHD/voyager-houston/model"
+	"github.com/gin-gonic/gin"
+	"github.com/jinzhu/gorm"
+	log "github.com/sirupsen/logrus"
+)
+
+// Exchange and routing keys of voyager-rackhd-service, which talks to RackHD
+const (
+	rackhdExchange     = "voyager-rackhd-service"
+	rackhdExchangeType = "topic"
+	rackhdRequestKey   = "requests"
+	rackhdReplyKey     = "replies"
+)
+
+// RunWorkflow asks RackHD to run the workflow graph on a node and records it.
+// It fails with a 409 if the node already has an active workflow.
+func (s *Server) RunWorkflow(ctx context.Context, nodeID string, request model.RunWorkflowRequest) (model.WorkflowEntity, error) {
+	workflow := model.WorkflowEntity{}
+	found, err := s.activeWorkflow(nodeID, &workflow)
+	if err != nil {
+		return workflow, err
+	}
+	if found {
+		return workflow, newAPIError(http.StatusConflict, fmt.Errorf("Node %s is already running workflow %s (%s)", nodeID, workflow.ID, workflow.Graph))
+	}
+
+	reply, err := s.rackhdRequest(ctx, "run_workflow", model.WorkflowCommand{
+		Command: "run_workflow",
+		NodeID:  nodeID,
+		Graph:   request.Graph,
+		Options: request.Options,
+	})
+	if err != nil {
+		return workflow, err
+	}
+	if reply.ID == "" {
+		return workflow, fmt.Errorf("voyager-rackhd-service did not return a workflow ID")
+	}
+	if reply.Status == "" {
+		reply.Status = model.WorkflowRunning
+	}
+
+	options, err := json.Marshal(request.Options)
+	if err != nil {
+		return workflow, err
+	}
+	info, _ := ctx.Value(requestInfoKey{}).(requestInfo)
+
+	// A status event may have recorded the workflow already
+	err = s.MySQL.DB.Where(model.WorkflowEntity{ID: reply.ID}).
+		Assign(model.WorkflowEntity{
+			NodeID:    nodeID,
+			Graph:     request.Graph,
+			Options:   model.JSONText(options),
+			Actor:     info.Actor,
+			RequestID: info.RequestID,
+		}).
+		Attrs(model.WorkflowEntity{Status: reply.Status}).
+		FirstOrCreate(&workflow).Error
+	return workflow, err
+}
+
+// CancelWorkflow asks RackHD to cancel the active workflow of a node and records it as cancelled
+func (s *Server) CancelWorkflow(ctx context.Context, workflow model.WorkflowEntity) (model.WorkflowEntity, error) {
+	_, err := s.rackhdRequest(ctx, "cancel_workflow", model.WorkflowCommand{Command: "cancel_workflow", NodeID: workflow.NodeID})
+	if err != nil {
+		return workflow, err
+	}
+
+	setWorkflowStatus(&workflow, model.WorkflowCancelled)
+	err = s.MySQL.DB.Model(&workflow).Updates(map[string]interface{}{
+		"status":      workflow.Status,
+		"finished_at": workflow.FinishedAt,
+	}).Error
+	return workflow, err
+}
+
+// rackhdRequest sends a command message to voyager-rackhd-service. A command
+// RackHD refuses fails with a 422 UPSTREAM_REJECTED.
+func (s *Server) rackhdRequest(ctx context.Context, command string, message interface{}) (model.WorkflowReply, error) {
+	reply := model.WorkflowReply{}
+	body, err := json.Marshal(message)
+	if err != nil {
+		return reply, err
+	}
+
+	replyBody, err := s.call(ctx, rpcRequest{
+		Exchange:     rackhdExchange,
+		ExchangeType: rackhdExchangeType,
+		RoutingKey:   rackhdRequestKey,
+		ReplyKey:     rackhdReplyKey,
+		Command:      command,
+		Message:      body,
+	})
+	if err != nil {
+		return reply, err
+	}
+	if err = json.Unmarshal(replyBody, &reply); err != nil {
+		return reply, fmt.Errorf("Error unmarshaling voyager-rackhd-service response: %s", err)
+	}
+	if reply.Error != "" {
+		return reply, rejectedError("RackHD", command, reply.Error)
+	}
+	return reply, nil
+}
+
+// setWorkflowStatus sets the status of workflow, and when it finished if the status is final
+func setWorkflowStatus(workflow *model.WorkflowEntity, status string) {
+	workflow.Status = status
+	if !workflow.Active() && workflow.FinishedAt == nil {
+		now := time.Now()
+		workflow.FinishedAt = &now
+	}
+}
+
+// processWorkflowEvent records the status of a workflow reported by
+// voyager-rackhd-service, including workflows not started through Houston
+func (s *Server) processWorkflowEvent(body []byte) error {
+	event := model.WorkflowEvent{}
+	if err := json.Unmarshal(body, &event); err != nil {
+		return fmt.Errorf("invalid workflow event: %s", err)
+	}
+	if event.ID == "" || event.Status == "" {
+		return fmt.Errorf("workflow event without instanceId or status")
+	}
+
+	workflow := model.WorkflowEntity{}
+	err := s.MySQL.DB.Where("id = ?", event.ID).First(&workflow).Error
+	if err == gorm.ErrRecordNotFound {
+		workflow = model.WorkflowEntity{ID: event.ID, NodeID: event.NodeID, Graph: event.Graph}
+		setWorkflowStatus(&workflow, event.Status)
+		return s.MySQL.DB.Create(&workflow).Error
+	}
+	if err != nil {
+		return err
+	}
+
+	if !workflow.Active() {
+		log.Infof("Ignoring %s status of workflow %s, which is already %s", event.Status, event.ID, workflow.Status)
+		return nil
+	}
+	log.Infof("Workflow %s on node %s: %s", event.ID, workflow.NodeID, event.Status)
+	setWorkflowStatus(&workflow, event.Status)
+	return s.MySQL.DB.Model(&workflow).Updates(map[string]interface{}{
+		"status":      workflow.Status,
+		"finished_at": workflow.FinishedAt,
+	}).Error
+}
+
+// activeWorkflow loads the pending or running workflow of a node into out,
+// and reports whether there is one
+func (s *Server) activeWorkflow(nodeID string, out *model.WorkflowEntity) (bool, error) {
+	err := s.MySQL.DB.Where("node_id = ? AND status IN (?)", nodeID, []string{model.WorkflowPending, model.WorkflowRunning}).
+		Order("created_at desc").First(out).Error
+	if err == gorm.ErrRecordNotFound {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// RunWorkflowHandler Serves POST /nodes/:id/workflows
+func (s *Server) RunWorkflowHandler(c *gin.Context) {
+	request := model.RunWorkflowRequest{}
+	if !bindJSON(c, &request) {
+		return
+	}
+
+	workflow, err := s.RunWorkflow(requestContext(c), c.Param("id"), request)
+	if err != nil {
+		abortWithRPCError(c, err)
+		return
+	}
+	c.JSON(http.StatusCreated, workflow)
+}
+
+// ListWorkflowsHandler Serves GET /nodes/:id/workflows, newest first,
+// optionally filtered by ?status=. It does not ask RackHD.
+func (s *Server) ListWorkflowsHandler(c *gin.Context) {
+	query := s.MySQL.DB.Where("node_id = ?", c.Param("id"))
+	if status := c.Query("status"); status != "" {
+		query = query.Where("status = ?", status)
+	}
+
+	workflows := []model.WorkflowEntity{}
+	if err := query.Order("created_at desc").Find(&workflows).Error; err != nil {
+		abortWithError(c, http.StatusInternalServerError, err)
+		return
+	}
+	c.JSON(http.StatusOK, workflows)
+}
+
+// CancelWorkflowHandler Serves DELETE /nodes/:id/workflows/active
+func (s *Server) CancelWorkflowHandler(c *gin.Context) {
+	nodeID := c.Param("id")
+	active := model.WorkflowEntity{}
+	found, err := s.activeWorkflow(nodeID, &active)
+	if err != nil {
+		abortWithError(c, http.StatusInternalServerError, err)
+		return
+	}
+	if !found {
+		abortWithError(c, http.StatusNotFound, fmt.Errorf("Node %s has no active workflow", nodeID))
+		return
+	}
+
+	workflow, err := s.CancelWorkflow(requestContext(c), active)
+	if err != nil {
+		abortWithRPCError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, workflow)
+}